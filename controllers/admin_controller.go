@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type AdminController interface {
+	UnlockUser(context *gin.Context)
+	UnlockIP(context *gin.Context)
+	Impersonate(context *gin.Context)
+	GetAuditEvents(context *gin.Context)
+}
+
+type adminController struct {
+	userService          services.UserService
+	loginThrottleService services.LoginThrottleService
+	impersonationService services.ImpersonationService
+	auditService         services.AuditService
+}
+
+func NewAdminController(
+	userService services.UserService,
+	loginThrottleService services.LoginThrottleService,
+	impersonationService services.ImpersonationService,
+	auditService services.AuditService,
+) AdminController {
+	return &adminController{
+		userService:          userService,
+		loginThrottleService: loginThrottleService,
+		impersonationService: impersonationService,
+		auditService:         auditService,
+	}
+}
+
+// UnlockUser lifts a login lockout on a user account
+func (adc *adminController) UnlockUser(context *gin.Context) {
+	id, ok := parseUserID(context)
+	if !ok {
+		return
+	}
+
+	user, err := adc.userService.GetUserByID(id)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError("User not found"))
+		return
+	}
+
+	if err := adc.loginThrottleService.UnlockUser(user.Username); err != nil {
+		log.Printf("Error unlocking user %d: %v", user.ID, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to unlock user"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("User unlocked successfully", nil))
+}
+
+// UnlockIP lifts a login lockout on a client address
+func (adc *adminController) UnlockIP(context *gin.Context) {
+	var unlockIPDto dto.UnlockIPDto
+	if err := context.ShouldBindJSON(&unlockIPDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	if err := adc.loginThrottleService.UnlockIP(unlockIPDto.IP); err != nil {
+		log.Printf("Error unlocking address %s: %v", unlockIPDto.IP, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to unlock address"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Address unlocked successfully", nil))
+}
+
+// Impersonate issues a short-lived token acting as a user, given the reason for the audit log
+func (adc *adminController) Impersonate(context *gin.Context) {
+	id, ok := parseUserID(context)
+	if !ok {
+		return
+	}
+	var impersonateDto dto.ImpersonateDto
+	if err := context.ShouldBindJSON(&impersonateDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("A reason is required"))
+		return
+	}
+
+	admin, _ := currentUser(context)
+	result, err := adc.impersonationService.Impersonate(admin.ID, id, impersonateDto.Reason, clientInfo(context))
+	if errors.Is(err, services.ErrImpersonationTargetNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError("User not found"))
+		return
+	}
+	if errors.Is(err, services.ErrImpersonationNotAllowed) || errors.Is(err, services.ErrAccountDeactivated) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error impersonating user %d: %v", id, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to impersonate user"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Impersonation started", result))
+}
+
+// GetAuditEvents lists the audit log, newest first, optionally filtered by action, actorId and
+// subjectId and paged with limit and offset
+func (adc *adminController) GetAuditEvents(context *gin.Context) {
+	query := postgres.AuditEventQuery{Action: context.Query("action")}
+	for name, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if value := context.Query(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid "+name))
+				return
+			}
+			*target = parsed
+		}
+	}
+	for name, target := range map[string]*uint{"actorId": &query.ActorID, "subjectId": &query.SubjectID} {
+		if value := context.Query(name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid "+name))
+				return
+			}
+			*target = uint(parsed)
+		}
+	}
+
+	events, err := adc.auditService.GetEvents(query)
+	if err != nil {
+		log.Printf("Error listing audit events: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list audit events"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", events))
+}
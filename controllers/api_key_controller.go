@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type APIKeyController interface {
+	CreateAPIKey(context *gin.Context)
+	GetAPIKeys(context *gin.Context)
+	RevokeAPIKey(context *gin.Context)
+}
+
+type apiKeyController struct {
+	apiKeyService services.APIKeyService
+}
+
+func NewAPIKeyController(apiKeyService services.APIKeyService) APIKeyController {
+	return &apiKeyController{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// CreateAPIKey creates a key for the current user. The key is only ever shown in this response.
+func (akc *apiKeyController) CreateAPIKey(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	var createAPIKeyDto dto.CreateAPIKeyDto
+	if err := context.ShouldBindJSON(&createAPIKeyDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	apiKey, key, err := akc.apiKeyService.CreateAPIKey(user.ID, createAPIKeyDto.Name, createAPIKeyDto.Scopes, createAPIKeyDto.ExpiresAt)
+	if errors.Is(err, services.ErrInvalidAPIKeyScopes) {
+		apiErr := responses.InvalidRequestData(map[string]string{"scopes": err.Error()})
+		responses.WriteJson(context.Writer, apiErr.StatusCode, apiErr)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKeyExpiry) {
+		apiErr := responses.InvalidRequestData(map[string]string{"expiresAt": err.Error()})
+		responses.WriteJson(context.Writer, apiErr.StatusCode, apiErr)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to create API key"))
+		return
+	}
+
+	responseData := map[string]any{"apiKey": apiKey, "key": key}
+	responses.WriteJson(context.Writer, http.StatusCreated, responses.ResponseSuccess("Store the key now, it will not be shown again", responseData))
+}
+
+func (akc *apiKeyController) GetAPIKeys(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	apiKeys, err := akc.apiKeyService.GetAPIKeysForUser(user.ID)
+	if err != nil {
+		log.Printf("Error listing API keys: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list API keys"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", apiKeys))
+}
+
+func (akc *apiKeyController) RevokeAPIKey(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	id, err := strconv.ParseUint(context.Param("id"), 10, 32)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid API key ID"))
+		return
+	}
+
+	err = akc.apiKeyService.RevokeAPIKey(user.ID, uint(id))
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError("API key not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API key: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to revoke API key"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("API key revoked successfully", nil))
+}
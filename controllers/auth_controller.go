@@ -1,10 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
 	"github.com/vladimirteddy/go-authentication/dto"
 	"github.com/vladimirteddy/go-authentication/entities"
 	"github.com/vladimirteddy/go-authentication/responses"
@@ -14,16 +18,28 @@ import (
 type AuthController interface {
 	CreateUser(context *gin.Context)
 	Login(context *gin.Context)
+	Logout(context *gin.Context)
 	GetUserProfile(context *gin.Context)
 }
 
 type authController struct {
-	userService services.UserService
+	userService          services.UserService
+	loginThrottleService services.LoginThrottleService
+	sessionService       services.SessionService
+	sessionCookies       cookies.Manager
 }
 
-func NewAuthController(userService services.UserService) AuthController {
+func NewAuthController(
+	userService services.UserService,
+	loginThrottleService services.LoginThrottleService,
+	sessionService services.SessionService,
+	sessionCookies cookies.Manager,
+) AuthController {
 	return &authController{
-		userService: userService,
+		userService:          userService,
+		loginThrottleService: loginThrottleService,
+		sessionService:       sessionService,
+		sessionCookies:       sessionCookies,
 	}
 }
 
@@ -37,35 +53,134 @@ func (ac *authController) CreateUser(context *gin.Context) {
 	user, err := ac.userService.CreateUser(&entities.User{
 		Username: authRequestDto.Username,
 		Password: authRequestDto.Password,
+		Email:    authRequestDto.Email,
 	})
+	if errors.Is(err, services.ErrUserAlreadyExists) {
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError("user already exists"))
+		return
+	}
+	if errors.Is(err, services.ErrSignupDisabled) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrInvalidEmail) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError(err.Error()))
+		return
+	}
+	var rejectedErr *services.SignupRejectedError
+	if errors.As(err, &rejectedErr) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(rejectedErr.Error()))
+		return
+	}
+	if writePasswordPolicyError(context, err, "password") {
+		return
+	}
 	if err != nil {
+		log.Println("error", err)
 		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("something went wrong"))
+		return
 	}
 	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("user created successfully", user))
 
 }
 
 func (ac *authController) Login(context *gin.Context) {
-	var authRequestDto dto.AuthRequestDto
+	var loginDto dto.LoginDto
 
-	if err := context.ShouldBindJSON(&authRequestDto); err != nil {
+	if err := context.ShouldBindJSON(&loginDto); err != nil {
 		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("not match"))
 		return
 	}
 
+	clientIP := context.ClientIP()
+	if err := ac.loginThrottleService.Check(loginDto.Username, clientIP); err != nil {
+		writeLockedOut(context, err)
+		return
+	}
+
 	userEntity := &entities.User{
-		Username: authRequestDto.Username,
-		Password: authRequestDto.Password,
+		Username: loginDto.Username,
+		Password: loginDto.Password,
+	}
+	result, err := ac.userService.Login(userEntity, clientInfo(context))
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		if err := ac.loginThrottleService.RecordFailure(loginDto.Username, clientIP); err != nil {
+			log.Printf("Error recording failed login: %v", err)
+		}
+	} else if err == nil && result.Token != "" {
+		// With an MFA challenge the counter is only reset once the second factor is passed
+		if err := ac.loginThrottleService.RecordSuccess(loginDto.Username); err != nil {
+			log.Printf("Error resetting failed logins: %v", err)
+		}
+	}
+	if errors.Is(err, services.ErrEmailNotVerified) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError("email not verified"))
+		return
+	}
+	if errors.Is(err, services.ErrAccountDeactivated) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	// A directory login whose account cannot be linked or created
+	if errors.Is(err, services.ErrFederatedAccountConflict) {
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrFederatedEmailNotVerified) || errors.Is(err, services.ErrFederatedSignupDisabled) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	var rejectedErr *services.SignupRejectedError
+	if errors.As(err, &rejectedErr) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(rejectedErr.Error()))
+		return
 	}
-	token, err := ac.userService.Login(userEntity)
 	if err != nil {
+		if !errors.Is(err, services.ErrInvalidCredentials) {
+			log.Printf("Error during login: %v", err)
+		}
 		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError("invalid password"))
 		return
 	}
-	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("OK", token))
+	if result.ChallengeToken != "" {
+		// The client has to call /auth/mfa/verify (or enroll first) to receive a token
+		responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("MFA required", result))
+		return
+	}
+	ac.sessionCookies.SetSession(context, result.Token)
+	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("OK", result.Token))
+}
+
+// Logout ends the session of the current request and clears the session cookies
+func (ac *authController) Logout(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	err := ac.sessionService.Revoke(user.ID, currentSessionID(context))
+	if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		log.Printf("Error revoking session: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("something went wrong"))
+		return
+	}
+
+	ac.sessionCookies.Clear(context)
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("logged out", nil))
 }
 
 func (ac *authController) GetUserProfile(context *gin.Context) {
 	user, _ := context.Get("currentUser")
 	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", user))
 }
+
+// writeLockedOut answers 429 with a Retry-After header while a login is locked out
+func writeLockedOut(context *gin.Context, err error) {
+	var lockedOut *services.LockedOutError
+	if !errors.As(err, &lockedOut) {
+		log.Printf("Error checking login throttle: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("something went wrong"))
+		return
+	}
+
+	retryAfter := int(math.Ceil(lockedOut.RetryAfter.Seconds()))
+	context.Header("Retry-After", strconv.Itoa(retryAfter))
+	responses.WriteJson(context.Writer, http.StatusTooManyRequests, responses.ResponseError("too many failed login attempts, try again later"))
+}
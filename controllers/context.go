@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// currentUser returns the user stored in the context by the CheckAuth middleware
+func currentUser(context *gin.Context) (entities.User, bool) {
+	value, exists := context.Get("currentUser")
+	if !exists {
+		return entities.User{}, false
+	}
+	user, ok := value.(entities.User)
+	return user, ok
+}
+
+// currentSessionID returns the session of the current request, set by the CheckAuth middleware
+func currentSessionID(context *gin.Context) string {
+	return context.GetString("sessionID")
+}
+
+// clientInfo describes the device making the request, recorded on the sessions it starts
+func clientInfo(context *gin.Context) services.ClientInfo {
+	return services.ClientInfo{
+		UserAgent: context.Request.UserAgent(),
+		IPAddress: context.ClientIP(),
+	}
+}
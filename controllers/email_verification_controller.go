@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type EmailVerificationController interface {
+	VerifyEmail(context *gin.Context)
+	ResendVerification(context *gin.Context)
+}
+
+type emailVerificationController struct {
+	emailVerificationService services.EmailVerificationService
+}
+
+func NewEmailVerificationController(emailVerificationService services.EmailVerificationService) EmailVerificationController {
+	return &emailVerificationController{
+		emailVerificationService: emailVerificationService,
+	}
+}
+
+// VerifyEmail accepts the token either as a query parameter (GET, from the emailed link)
+// or in a JSON body (POST, from a frontend page)
+func (ec *emailVerificationController) VerifyEmail(context *gin.Context) {
+	var verifyEmailDto dto.VerifyEmailDto
+	var err error
+	if context.Request.Method == http.MethodGet {
+		err = context.ShouldBindQuery(&verifyEmailDto)
+	} else {
+		err = context.ShouldBindJSON(&verifyEmailDto)
+	}
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Verification token is required"))
+		return
+	}
+
+	err = ec.emailVerificationService.VerifyEmail(verifyEmailDto.Token)
+	if errors.Is(err, services.ErrInvalidVerificationToken) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid or expired verification token"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to verify email"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Email verified successfully", nil))
+}
+
+func (ec *emailVerificationController) ResendVerification(context *gin.Context) {
+	var resendDto dto.ResendVerificationDto
+	if err := context.ShouldBindJSON(&resendDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	err := ec.emailVerificationService.ResendVerification(resendDto.Email)
+	if err != nil {
+		log.Printf("Error resending verification email: %v", err)
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("If the email is registered and unverified, a verification link has been sent", nil))
+}
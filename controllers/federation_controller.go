@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type FederationController interface {
+	GetProviders(context *gin.Context)
+	Login(context *gin.Context)
+	Callback(context *gin.Context)
+}
+
+type federationController struct {
+	federationService services.FederationService
+	sessionCookies    cookies.Manager
+}
+
+func NewFederationController(
+	federationService services.FederationService,
+	sessionCookies cookies.Manager,
+) FederationController {
+	return &federationController{
+		federationService: federationService,
+		sessionCookies:    sessionCookies,
+	}
+}
+
+// GetProviders lists the identity providers users can log in with
+func (fc *federationController) GetProviders(context *gin.Context) {
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", fc.federationService.Providers()))
+}
+
+// Login sends the browser to an identity provider. return_to names where it goes once logged in.
+func (fc *federationController) Login(context *gin.Context) {
+	start, err := fc.federationService.BeginLogin(context.Param("provider"), context.Query("return_to"))
+	if err != nil {
+		writeFederationError(context, err)
+		return
+	}
+
+	fc.sessionCookies.SetLoginState(context, start.State, time.Until(start.ExpiresAt))
+	context.Header("Cache-Control", "no-store")
+	context.Redirect(http.StatusFound, start.AuthURL)
+}
+
+// Callback completes a login when the identity provider sends the browser back. With cookie
+// sessions the browser continues to the return URL logged in; otherwise the token is returned.
+func (fc *federationController) Callback(context *gin.Context) {
+	state := context.Query("state")
+	browserState := fc.sessionCookies.LoginState(context)
+	fc.sessionCookies.ClearLoginState(context)
+
+	if upstreamError := context.Query("error"); upstreamError != "" {
+		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError("identity provider login failed: "+upstreamError))
+		return
+	}
+	if state == "" || state != browserState {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError(services.ErrInvalidFederationState.Error()))
+		return
+	}
+
+	login, err := fc.federationService.CompleteLogin(context.Param("provider"), state, context.Query("code"), clientInfo(context))
+	if err != nil {
+		writeFederationError(context, err)
+		return
+	}
+
+	context.Header("Cache-Control", "no-store")
+	if fc.sessionCookies.Enabled() {
+		fc.sessionCookies.SetSession(context, login.Token)
+		context.Redirect(http.StatusFound, login.ReturnTo)
+		return
+	}
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("OK", login.Token))
+}
+
+func writeFederationError(context *gin.Context, err error) {
+	var rejectedErr *services.SignupRejectedError
+	switch {
+	case errors.Is(err, services.ErrFederationProviderNotFound):
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrInvalidFederationState), errors.Is(err, services.ErrInvalidReturnURL),
+		errors.Is(err, services.ErrInvalidEmail):
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrFederationFailed):
+		log.Printf("Federated login failed: %v", err)
+		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError(services.ErrFederationFailed.Error()))
+	case errors.Is(err, services.ErrFederatedAccountConflict):
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrFederatedEmailNotVerified), errors.Is(err, services.ErrFederatedSignupDisabled):
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+	case errors.As(err, &rejectedErr):
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(rejectedErr.Error()))
+	case errors.Is(err, services.ErrEmailNotVerified):
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError("email not verified"))
+	case errors.Is(err, services.ErrAccountDeactivated):
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+	default:
+		log.Printf("Error during federated login: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("something went wrong"))
+	}
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type InvitationController interface {
+	Invite(context *gin.Context)
+	GetInvitations(context *gin.Context)
+	RevokeInvitation(context *gin.Context)
+	AcceptInvitation(context *gin.Context)
+}
+
+type invitationController struct {
+	invitationService services.InvitationService
+}
+
+func NewInvitationController(invitationService services.InvitationService) InvitationController {
+	return &invitationController{
+		invitationService: invitationService,
+	}
+}
+
+// Invite emails an invitation with the roles the new user will hold
+func (ic *invitationController) Invite(context *gin.Context) {
+	var inviteDto dto.InviteDto
+	if err := context.ShouldBindJSON(&inviteDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	admin, _ := currentUser(context)
+	invitation, err := ic.invitationService.Invite(admin.ID, inviteDto.Email, inviteDto.Tenant, inviteDto.RoleIDs, inviteDto.ExpiresAt)
+	if errors.Is(err, services.ErrUserAlreadyExists) {
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError("A user with this email already exists"))
+		return
+	}
+	if errors.Is(err, services.ErrInvitationRoleNotFound) || errors.Is(err, services.ErrInvalidInvitationExpiry) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrInvitationNotAllowed) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to create invitation"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusCreated, responses.ResponseSuccess("Invitation sent", invitation))
+}
+
+// GetInvitations lists invitations, newest first, optionally filtered by email and pending=true and
+// paged with limit and offset
+func (ic *invitationController) GetInvitations(context *gin.Context) {
+	query := postgres.InvitationQuery{
+		Email:   context.Query("email"),
+		Pending: context.Query("pending") == "true",
+	}
+	for name, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if value := context.Query(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid "+name))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	invitations, err := ic.invitationService.GetInvitations(query)
+	if err != nil {
+		log.Printf("Error listing invitations: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list invitations"))
+		return
+	}
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", invitations))
+}
+
+func (ic *invitationController) RevokeInvitation(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 32)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid invitation ID"))
+		return
+	}
+
+	err = ic.invitationService.RevokeInvitation(uint(id))
+	if errors.Is(err, services.ErrInvitationNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError("Pending invitation not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking invitation %d: %v", id, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to revoke invitation"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Invitation revoked successfully", nil))
+}
+
+// AcceptInvitation creates the invited user's account with the username and password they chose
+func (ic *invitationController) AcceptInvitation(context *gin.Context) {
+	var acceptDto dto.AcceptInvitationDto
+	if err := context.ShouldBindJSON(&acceptDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	user, err := ic.invitationService.AcceptInvitation(acceptDto.Token, acceptDto.Username, acceptDto.Password)
+	if errors.Is(err, services.ErrInvalidInvitation) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid or expired invitation"))
+		return
+	}
+	if errors.Is(err, services.ErrUserAlreadyExists) {
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError("user already exists"))
+		return
+	}
+	if writePasswordPolicyError(context, err, "password") {
+		return
+	}
+	if err != nil {
+		log.Printf("Error accepting invitation: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to accept invitation"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("user created successfully", user))
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type MagicLinkController interface {
+	RequestMagicLink(context *gin.Context)
+	Login(context *gin.Context)
+}
+
+type magicLinkController struct {
+	magicLinkService services.MagicLinkService
+	sessionCookies   cookies.Manager
+}
+
+func NewMagicLinkController(magicLinkService services.MagicLinkService, sessionCookies cookies.Manager) MagicLinkController {
+	return &magicLinkController{
+		magicLinkService: magicLinkService,
+		sessionCookies:   sessionCookies,
+	}
+}
+
+// RequestMagicLink emails a login link bound to the requesting browser through an HttpOnly cookie
+func (mc *magicLinkController) RequestMagicLink(context *gin.Context) {
+	var requestDto dto.MagicLinkRequestDto
+	if err := context.ShouldBindJSON(&requestDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	deviceSecret, err := mc.magicLinkService.RequestMagicLink(requestDto.Email, mc.sessionCookies.MagicLinkDevice(context))
+	if err != nil {
+		log.Printf("Error sending magic link: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to send login link"))
+		return
+	}
+
+	mc.sessionCookies.SetMagicLinkDevice(context, deviceSecret, mc.magicLinkService.TokenTTL())
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("If the email is registered, a login link has been sent", nil))
+}
+
+// Login accepts the token either as a query parameter (GET, from the emailed link) or in a JSON
+// body (POST, from a frontend page), and answers like /auth/login
+func (mc *magicLinkController) Login(context *gin.Context) {
+	var loginDto dto.MagicLinkLoginDto
+	var err error
+	if context.Request.Method == http.MethodGet {
+		err = context.ShouldBindQuery(&loginDto)
+	} else {
+		err = context.ShouldBindJSON(&loginDto)
+	}
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Login token is required"))
+		return
+	}
+
+	result, err := mc.magicLinkService.Login(loginDto.Token, mc.sessionCookies.MagicLinkDevice(context), clientInfo(context))
+	if errors.Is(err, services.ErrInvalidMagicLink) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid or expired login link"))
+		return
+	}
+	if errors.Is(err, services.ErrMagicLinkWrongDevice) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrAccountDeactivated) || errors.Is(err, services.ErrEmailNotVerified) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error during magic link login: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to log in"))
+		return
+	}
+
+	mc.sessionCookies.ClearMagicLinkDevice(context)
+	if result.ChallengeToken != "" {
+		// The client has to call /auth/mfa/verify (or enroll first) to receive a token
+		responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("MFA required", result))
+		return
+	}
+	mc.sessionCookies.SetSession(context, result.Token)
+	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("OK", result.Token))
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type MFAController interface {
+	VerifyLogin(context *gin.Context)
+	BeginEnrollmentWithChallenge(context *gin.Context)
+	ConfirmEnrollmentWithChallenge(context *gin.Context)
+	GetStatus(context *gin.Context)
+	BeginEnrollment(context *gin.Context)
+	ConfirmEnrollment(context *gin.Context)
+	DisableTOTP(context *gin.Context)
+	RegenerateRecoveryCodes(context *gin.Context)
+	StepUp(context *gin.Context)
+}
+
+type mfaController struct {
+	mfaService           services.MFAService
+	userService          services.UserService
+	loginThrottleService services.LoginThrottleService
+	sessionCookies       cookies.Manager
+}
+
+func NewMFAController(
+	mfaService services.MFAService,
+	userService services.UserService,
+	loginThrottleService services.LoginThrottleService,
+	sessionCookies cookies.Manager,
+) MFAController {
+	return &mfaController{
+		mfaService:           mfaService,
+		userService:          userService,
+		loginThrottleService: loginThrottleService,
+		sessionCookies:       sessionCookies,
+	}
+}
+
+// VerifyLogin completes a login that returned an MFA challenge
+func (mc *mfaController) VerifyLogin(context *gin.Context) {
+	var mfaLoginDto dto.MFALoginDto
+	if err := context.ShouldBindJSON(&mfaLoginDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	// Wrong codes count against the same lockout as wrong passwords, so logging in again for a
+	// fresh challenge does not give fresh guesses
+	challenge, err := mc.mfaService.ResolveChallenge(mfaLoginDto.ChallengeToken)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+	user, err := mc.userService.GetUserByID(challenge.UserID)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+	clientIP := context.ClientIP()
+	if err := mc.loginThrottleService.Check(user.Username, clientIP); err != nil {
+		writeLockedOut(context, err)
+		return
+	}
+
+	token, err := mc.userService.CompleteMFALogin(mfaLoginDto.ChallengeToken, mfaLoginDto.Code, clientInfo(context))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			if err := mc.loginThrottleService.RecordFailure(user.Username, clientIP); err != nil {
+				log.Printf("Error recording failed second factor: %v", err)
+			}
+		}
+		writeMFAError(context, err)
+		return
+	}
+	if err := mc.loginThrottleService.RecordSuccess(user.Username); err != nil {
+		log.Printf("Error resetting failed logins: %v", err)
+	}
+
+	mc.sessionCookies.SetSession(context, token)
+	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("OK", token))
+}
+
+// BeginEnrollmentWithChallenge starts TOTP enrollment for a user whose role requires MFA
+// but who has not enrolled yet, using the enrollment challenge token returned by login
+func (mc *mfaController) BeginEnrollmentWithChallenge(context *gin.Context) {
+	var challengeDto dto.MFAChallengeDto
+	if err := context.ShouldBindJSON(&challengeDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	challenge, err := mc.mfaService.ResolveEnrollmentChallenge(challengeDto.ChallengeToken)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	enrollment, err := mc.mfaService.BeginTOTPEnrollment(challenge.UserID)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Scan the QR code with your authenticator app", enrollment))
+}
+
+func (mc *mfaController) ConfirmEnrollmentWithChallenge(context *gin.Context) {
+	var mfaLoginDto dto.MFALoginDto
+	if err := context.ShouldBindJSON(&mfaLoginDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	token, recoveryCodes, err := mc.userService.CompleteMFAEnrollment(mfaLoginDto.ChallengeToken, mfaLoginDto.Code, clientInfo(context))
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	mc.sessionCookies.SetSession(context, token)
+	responseData := map[string]any{"token": token, "recoveryCodes": recoveryCodes}
+	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("Two-factor authentication enabled", responseData))
+}
+
+func (mc *mfaController) GetStatus(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	status, err := mc.mfaService.GetStatus(user.ID)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", status))
+}
+
+func (mc *mfaController) BeginEnrollment(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	enrollment, err := mc.mfaService.BeginTOTPEnrollment(user.ID)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Scan the QR code with your authenticator app", enrollment))
+}
+
+func (mc *mfaController) ConfirmEnrollment(context *gin.Context) {
+	var codeDto dto.MFACodeDto
+	if err := context.ShouldBindJSON(&codeDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	recoveryCodes, err := mc.mfaService.ConfirmTOTPEnrollment(user.ID, codeDto.Code)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	responseData := map[string]any{"recoveryCodes": recoveryCodes}
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Two-factor authentication enabled", responseData))
+}
+
+func (mc *mfaController) DisableTOTP(context *gin.Context) {
+	var codeDto dto.MFACodeDto
+	if err := context.ShouldBindJSON(&codeDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	if err := mc.mfaService.DisableTOTP(user.ID, codeDto.Code); err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Two-factor authentication disabled", nil))
+}
+
+func (mc *mfaController) RegenerateRecoveryCodes(context *gin.Context) {
+	var codeDto dto.MFACodeDto
+	if err := context.ShouldBindJSON(&codeDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	recoveryCodes, err := mc.mfaService.RegenerateRecoveryCodes(user.ID, codeDto.Code)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	responseData := map[string]any{"recoveryCodes": recoveryCodes}
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Recovery codes regenerated", responseData))
+}
+
+// StepUp re-authenticates the user of the current session with a TOTP or recovery code, e.g. after
+// a step-up challenge, and returns a new token of the session. Wrong codes count towards the login
+// lockout of the account.
+func (mc *mfaController) StepUp(context *gin.Context) {
+	var codeDto dto.MFACodeDto
+	if err := context.ShouldBindJSON(&codeDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	clientIP := context.ClientIP()
+	if err := mc.loginThrottleService.Check(user.Username, clientIP); err != nil {
+		writeLockedOut(context, err)
+		return
+	}
+	method, err := mc.mfaService.VerifyCode(user.ID, codeDto.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			if err := mc.loginThrottleService.RecordFailure(user.Username, clientIP); err != nil {
+				log.Printf("Error recording failed step-up: %v", err)
+			}
+		}
+		writeMFAError(context, err)
+		return
+	}
+	if err := mc.loginThrottleService.RecordSuccess(user.Username); err != nil {
+		log.Printf("Error resetting failed logins: %v", err)
+	}
+
+	token, err := mc.userService.StepUp(user.ID, currentSessionID(context), []string{method, services.AuthMethodMFA})
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	mc.sessionCookies.SetSession(context, token)
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("OK", token))
+}
+
+func writeMFAError(context *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrInvalidMFAChallenge), errors.Is(err, services.ErrInvalidSession):
+		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrMFANotEnrolled), errors.Is(err, services.ErrMFAAlreadyEnrolled):
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrMFARequiredByRole), errors.Is(err, services.ErrAccountDeactivated):
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+	default:
+		log.Printf("MFA error: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to process two-factor authentication request"))
+	}
+}
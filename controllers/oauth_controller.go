@@ -0,0 +1,519 @@
+package controllers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/middlewares"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type OAuthController interface {
+	Authorize(context *gin.Context)
+	Token(context *gin.Context)
+	Introspect(context *gin.Context)
+	Revoke(context *gin.Context)
+	DeviceAuthorization(context *gin.Context)
+	Device(context *gin.Context)
+}
+
+type oauthController struct {
+	oauthService              services.OAuthService
+	tokenIntrospectionService services.TokenIntrospectionService
+	authenticationService     services.AuthenticationService
+	sessionCookies            cookies.Manager
+}
+
+func NewOAuthController(
+	oauthService services.OAuthService,
+	tokenIntrospectionService services.TokenIntrospectionService,
+	authenticationService services.AuthenticationService,
+	sessionCookies cookies.Manager,
+) OAuthController {
+	return &oauthController{
+		oauthService:              oauthService,
+		tokenIntrospectionService: tokenIntrospectionService,
+		authenticationService:     authenticationService,
+		sessionCookies:            sessionCookies,
+	}
+}
+
+// consentPage is the consent screen; submitting it posts the authorization request back with the decision
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize {{.ClientName}}</title>
+<style>
+body { font-family: sans-serif; max-width: 28rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+li { margin: .25rem 0; }
+button { padding: .5rem 1rem; margin-right: .5rem; }
+</style>
+</head>
+<body>
+<h1>Authorize {{.ClientName}}</h1>
+<p>Signed in as <strong>{{.Username}}</strong>. {{.ClientName}} is requesting access to your account:</p>
+<ul>
+{{range .Scopes}}<li><code>{{.}}</code></li>
+{{else}}<li>Everything your account is permitted to do</li>
+{{end}}</ul>
+<p>You will be redirected to <code>{{.RedirectURI}}</code>.</p>
+<form method="post" action="">
+{{range $name, $value := .Fields}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// devicePage lets a signed-in user enter the code shown by a device and approve or deny it
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device</title>
+<style>
+body { font-family: sans-serif; max-width: 28rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+li { margin: .25rem 0; }
+input[name=user_code] { font-size: 1.25rem; letter-spacing: .1rem; text-transform: uppercase; }
+button { padding: .5rem 1rem; margin-right: .5rem; }
+</style>
+</head>
+<body>
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>
+{{end}}{{if .Authorization}}<p>Signed in as <strong>{{.Username}}</strong>. {{.Authorization.Client.Name}} on your device is requesting access to your account:</p>
+<ul>
+{{range .Authorization.Scopes}}<li><code>{{.}}</code></li>
+{{else}}<li>Everything your account is permitted to do</li>
+{{end}}</ul>
+<p>Only continue if your device shows the code <strong>{{.Authorization.UserCode}}</strong>.</p>
+<form method="post" action="">
+<input type="hidden" name="user_code" value="{{.Authorization.UserCode}}">
+{{if .CSRFField}}<input type="hidden" name="{{.CSRFField}}" value="{{.CSRFToken}}">
+{{end}}<button type="submit" name="decision" value="approve">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{else if .AskCode}}<form method="get" action="">
+<p><label>Enter the code shown on your device:<br><input name="user_code" autocomplete="off" autofocus></label></p>
+<button type="submit">Continue</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// errorPage explains authorization requests that cannot be answered through the redirect URI
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Authorization failed</title></head>
+<body><h1>Authorization failed</h1><p>{{.}}</p></body>
+</html>
+`))
+
+// Authorize implements the authorization endpoint. GET shows the consent screen to a signed-in user
+// (or skips it when consent was given before); POST receives the user's decision from that screen.
+func (oc *oauthController) Authorize(context *gin.Context) {
+	request := authorizationRequestFromContext(context)
+	authorization, err := oc.oauthService.PrepareAuthorization(request)
+	if errors.Is(err, services.ErrInvalidAuthorizationRequest) {
+		renderPage(context, http.StatusBadRequest, errorPage, err.Error())
+		return
+	}
+	var oauthErr *services.OAuthError
+	if errors.As(err, &oauthErr) {
+		context.Redirect(http.StatusFound, authorization.ErrorRedirect(oauthErr))
+		return
+	}
+	if err != nil {
+		log.Printf("Error validating authorization request: %v", err)
+		renderPage(context, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+		return
+	}
+
+	principal, credential, fromCookie := browserPrincipal(context, oc.authenticationService, oc.sessionCookies)
+	if principal == nil {
+		if authorization.PromptNone {
+			context.Redirect(http.StatusFound, authorization.LoginRequired())
+			return
+		}
+		if loginURL := oc.oauthService.LoginURL("/oauth/authorize?" + authorizationQuery(request)); loginURL != "" && context.Request.Method == http.MethodGet {
+			context.Redirect(http.StatusFound, loginURL)
+			return
+		}
+		renderPage(context, http.StatusUnauthorized, errorPage, "Please sign in and try again.")
+		return
+	}
+
+	if context.Request.Method == http.MethodPost {
+		if fromCookie && !oc.sessionCookies.ValidCSRF(context, http.MethodPost, credential) {
+			renderPage(context, http.StatusForbidden, errorPage, "The consent form has expired, please try again.")
+			return
+		}
+		if context.PostForm("decision") != "approve" {
+			context.Redirect(http.StatusSeeOther, authorization.Deny())
+			return
+		}
+		oc.approve(context, principal, authorization)
+		return
+	}
+
+	consentRequired, err := oc.oauthService.ConsentRequired(principal.User.ID, authorization)
+	if err != nil {
+		log.Printf("Error checking OAuth consent: %v", err)
+		renderPage(context, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+		return
+	}
+	if !consentRequired {
+		oc.approve(context, principal, authorization)
+		return
+	}
+	if authorization.PromptNone {
+		context.Redirect(http.StatusFound, authorization.ConsentRequired())
+		return
+	}
+
+	fields := map[string]string{
+		"response_type":         request.ResponseType,
+		"client_id":             request.ClientID,
+		"redirect_uri":          authorization.RedirectURI,
+		"scope":                 request.Scope,
+		"state":                 request.State,
+		"code_challenge":        request.CodeChallenge,
+		"code_challenge_method": request.CodeChallengeMethod,
+		"nonce":                 request.Nonce,
+	}
+	if fromCookie {
+		fields[oc.sessionCookies.CSRFFieldName()] = oc.sessionCookies.CSRFToken(credential)
+	}
+	renderPage(context, http.StatusOK, consentPage, map[string]any{
+		"ClientName":  authorization.Client.Name,
+		"Username":    principal.User.Username,
+		"Scopes":      authorization.Scopes,
+		"RedirectURI": authorization.RedirectURI,
+		"Fields":      fields,
+	})
+}
+
+func (oc *oauthController) approve(context *gin.Context, principal *services.Principal, authorization *services.Authorization) {
+	redirectURL, err := oc.oauthService.Approve(principal, authorization)
+	if err != nil {
+		log.Printf("Error issuing authorization code: %v", err)
+		renderPage(context, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+		return
+	}
+	context.Redirect(http.StatusSeeOther, redirectURL)
+}
+
+// browserPrincipal returns the signed-in user of a browser request, with the credential it used and
+// whether it came from the session cookie. Only interactive sessions count: API keys, tokens of
+// OAuth clients and impersonation tokens cannot authorize clients or end sessions.
+func browserPrincipal(
+	context *gin.Context,
+	authenticationService services.AuthenticationService,
+	sessionCookies cookies.Manager,
+) (*services.Principal, string, bool) {
+	credential, fromCookie, err := middlewares.ExtractCredential(context, sessionCookies)
+	if err != nil || credential == "" {
+		return nil, "", false
+	}
+	principal, err := authenticationService.Authenticate(credential)
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidToken) && !errors.Is(err, services.ErrInvalidSession) && !errors.Is(err, services.ErrInvalidAPIKey) {
+			log.Printf("Error authenticating browser session: %v", err)
+		}
+		return nil, "", false
+	}
+	if principal.SessionID == "" || principal.ClientID != "" || principal.ImpersonatorID != 0 {
+		return nil, "", false
+	}
+	return principal, credential, fromCookie
+}
+
+// authorizationRequestFromContext reads an authorization request from the query string, or from
+// the form posted by the consent screen
+func authorizationRequestFromContext(context *gin.Context) *services.AuthorizationRequest {
+	param := context.Query
+	if context.Request.Method == http.MethodPost {
+		param = context.PostForm
+	}
+	return &services.AuthorizationRequest{
+		ResponseType:        param("response_type"),
+		ClientID:            param("client_id"),
+		RedirectURI:         param("redirect_uri"),
+		Scope:               param("scope"),
+		State:               param("state"),
+		CodeChallenge:       param("code_challenge"),
+		CodeChallengeMethod: param("code_challenge_method"),
+		Nonce:               param("nonce"),
+		Prompt:              param("prompt"),
+	}
+}
+
+// authorizationQuery encodes an authorization request to resume it after logging in
+func authorizationQuery(request *services.AuthorizationRequest) string {
+	query := url.Values{}
+	for name, value := range map[string]string{
+		"response_type":         request.ResponseType,
+		"client_id":             request.ClientID,
+		"redirect_uri":          request.RedirectURI,
+		"scope":                 request.Scope,
+		"state":                 request.State,
+		"code_challenge":        request.CodeChallenge,
+		"code_challenge_method": request.CodeChallengeMethod,
+		"nonce":                 request.Nonce,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return query.Encode()
+}
+
+// renderPage writes an HTML page that may not be framed, guarding the consent screen against clickjacking
+func renderPage(context *gin.Context, status int, page *template.Template, data any) {
+	context.Header("Content-Type", "text/html; charset=utf-8")
+	context.Header("Cache-Control", "no-store")
+	context.Header("X-Frame-Options", "DENY")
+	context.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	context.Status(status)
+	if err := page.Execute(context.Writer, data); err != nil {
+		log.Printf("Error rendering page: %v", err)
+	}
+}
+
+// Token implements the OAuth 2.0 token endpoint. Requests are form encoded and responses
+// follow RFC 6749 rather than the usual response envelope, so standard clients work unchanged.
+func (oc *oauthController) Token(context *gin.Context) {
+	credentials, err := clientCredentialsFromRequest(context)
+	if err != nil {
+		writeOAuthError(context, err, false)
+		return
+	}
+
+	response, err := oc.oauthService.Token(&services.TokenRequest{
+		GrantType:          context.PostForm("grant_type"),
+		Scope:              context.PostForm("scope"),
+		Code:               context.PostForm("code"),
+		RedirectURI:        context.PostForm("redirect_uri"),
+		CodeVerifier:       context.PostForm("code_verifier"),
+		RefreshToken:       context.PostForm("refresh_token"),
+		DeviceCode:         context.PostForm("device_code"),
+		SubjectToken:       context.PostForm("subject_token"),
+		SubjectTokenType:   context.PostForm("subject_token_type"),
+		ActorToken:         context.PostForm("actor_token"),
+		RequestedTokenType: context.PostForm("requested_token_type"),
+		Audiences:          context.PostFormArray("audience"),
+		Client:             credentials,
+		ClientInfo:         clientInfo(context),
+	})
+	if err != nil {
+		writeOAuthError(context, err, credentials.UsedBasicAuth)
+		return
+	}
+
+	context.Header("Cache-Control", "no-store")
+	context.Header("Pragma", "no-cache")
+	context.JSON(http.StatusOK, response)
+}
+
+// Introspect implements token introspection (RFC 7662) for access tokens, refresh tokens and API keys
+func (oc *oauthController) Introspect(context *gin.Context) {
+	credentials, err := clientCredentialsFromRequest(context)
+	if err != nil {
+		writeOAuthError(context, err, false)
+		return
+	}
+
+	response, err := oc.tokenIntrospectionService.Introspect(&services.TokenIntrospectionRequest{
+		Token:         context.PostForm("token"),
+		TokenTypeHint: context.PostForm("token_type_hint"),
+		Client:        credentials,
+	})
+	if err != nil {
+		writeOAuthError(context, err, credentials.UsedBasicAuth)
+		return
+	}
+
+	context.Header("Cache-Control", "no-store")
+	context.JSON(http.StatusOK, response)
+}
+
+// Revoke implements token revocation (RFC 7009). It succeeds for unknown tokens too.
+func (oc *oauthController) Revoke(context *gin.Context) {
+	credentials, err := clientCredentialsFromRequest(context)
+	if err != nil {
+		writeOAuthError(context, err, false)
+		return
+	}
+
+	err = oc.tokenIntrospectionService.Revoke(&services.TokenIntrospectionRequest{
+		Token:         context.PostForm("token"),
+		TokenTypeHint: context.PostForm("token_type_hint"),
+		Client:        credentials,
+	})
+	if err != nil {
+		writeOAuthError(context, err, credentials.UsedBasicAuth)
+		return
+	}
+
+	context.Status(http.StatusOK)
+}
+
+// DeviceAuthorization implements the device authorization endpoint (RFC 8628 section 3.1)
+func (oc *oauthController) DeviceAuthorization(context *gin.Context) {
+	credentials, err := clientCredentialsFromRequest(context)
+	if err != nil {
+		writeOAuthError(context, err, false)
+		return
+	}
+
+	response, err := oc.oauthService.DeviceAuthorization(&services.DeviceAuthorizationRequest{
+		Scope:  context.PostForm("scope"),
+		Client: credentials,
+	})
+	if err != nil {
+		writeOAuthError(context, err, credentials.UsedBasicAuth)
+		return
+	}
+
+	context.Header("Cache-Control", "no-store")
+	context.JSON(http.StatusOK, response)
+}
+
+// Device is the verification page of the device flow. GET asks for the user code (or takes it from
+// the verification_uri_complete link) and shows what the device requests; POST records the decision.
+func (oc *oauthController) Device(context *gin.Context) {
+	userCode := context.Query("user_code")
+	if context.Request.Method == http.MethodPost {
+		userCode = context.PostForm("user_code")
+	}
+
+	principal, credential, fromCookie := browserPrincipal(context, oc.authenticationService, oc.sessionCookies)
+	if principal == nil {
+		path := "/oauth/device"
+		if userCode != "" {
+			path += "?" + url.Values{"user_code": {userCode}}.Encode()
+		}
+		if loginURL := oc.oauthService.LoginURL(path); loginURL != "" && context.Request.Method == http.MethodGet {
+			context.Redirect(http.StatusFound, loginURL)
+			return
+		}
+		renderPage(context, http.StatusUnauthorized, errorPage, "Please sign in and try again.")
+		return
+	}
+
+	if userCode == "" {
+		renderPage(context, http.StatusOK, devicePage, map[string]any{"AskCode": true})
+		return
+	}
+
+	if context.Request.Method == http.MethodPost {
+		if fromCookie && !oc.sessionCookies.ValidCSRF(context, http.MethodPost, credential) {
+			renderPage(context, http.StatusForbidden, errorPage, "The form has expired, please try again.")
+			return
+		}
+		approved := context.PostForm("decision") == "approve"
+		err := oc.oauthService.DecideDevice(principal, userCode, approved)
+		if errors.Is(err, services.ErrDeviceCodeNotFound) {
+			renderPage(context, http.StatusNotFound, devicePage, map[string]any{"AskCode": true, "Message": "This code is invalid or has expired."})
+			return
+		}
+		if err != nil {
+			log.Printf("Error deciding device authorization: %v", err)
+			renderPage(context, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+			return
+		}
+		message := "Access denied. You can close this page."
+		if approved {
+			message = "Your device is connected. You can close this page and return to it."
+		}
+		renderPage(context, http.StatusOK, devicePage, map[string]any{"Message": message})
+		return
+	}
+
+	authorization, err := oc.oauthService.GetDeviceAuthorization(userCode)
+	if errors.Is(err, services.ErrDeviceCodeNotFound) {
+		renderPage(context, http.StatusNotFound, devicePage, map[string]any{"AskCode": true, "Message": "This code is invalid or has expired."})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading device authorization: %v", err)
+		renderPage(context, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+		return
+	}
+
+	data := map[string]any{
+		"Authorization": authorization,
+		"Username":      principal.User.Username,
+	}
+	if fromCookie {
+		data["CSRFField"] = oc.sessionCookies.CSRFFieldName()
+		data["CSRFToken"] = oc.sessionCookies.CSRFToken(credential)
+	}
+	renderPage(context, http.StatusOK, devicePage, data)
+}
+
+// clientCredentialsFromRequest reads client authentication from an HTTP Basic header or the form body
+func clientCredentialsFromRequest(context *gin.Context) (services.ClientCredentials, error) {
+	credentials := services.ClientCredentials{
+		ClientID:            context.PostForm("client_id"),
+		ClientSecret:        context.PostForm("client_secret"),
+		ClientAssertionType: context.PostForm("client_assertion_type"),
+		ClientAssertion:     context.PostForm("client_assertion"),
+	}
+
+	username, password, ok := context.Request.BasicAuth()
+	if !ok {
+		return credentials, nil
+	}
+	if credentials.ClientSecret != "" || credentials.ClientAssertion != "" {
+		return credentials, &services.OAuthError{Code: "invalid_request", Description: "use only one client authentication method", StatusCode: http.StatusBadRequest}
+	}
+
+	// Basic credentials are form-encoded before being base64 encoded (RFC 6749 section 2.3.1)
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return credentials, &services.OAuthError{Code: "invalid_client", Description: "malformed client credentials", StatusCode: http.StatusUnauthorized}
+	}
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return credentials, &services.OAuthError{Code: "invalid_client", Description: "malformed client credentials", StatusCode: http.StatusUnauthorized}
+	}
+	if credentials.ClientID != "" && credentials.ClientID != clientID {
+		return credentials, &services.OAuthError{Code: "invalid_request", Description: "client_id does not match the Authorization header", StatusCode: http.StatusBadRequest}
+	}
+
+	credentials.ClientID = clientID
+	credentials.ClientSecret = clientSecret
+	credentials.UsedBasicAuth = true
+	return credentials, nil
+}
+
+// writeOAuthError answers with an RFC 6749 error response
+func writeOAuthError(context *gin.Context, err error, usedBasicAuth bool) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		log.Printf("Error handling OAuth request: %v", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	if oauthErr.StatusCode == http.StatusUnauthorized && usedBasicAuth {
+		context.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	context.Header("Cache-Control", "no-store")
+
+	body := gin.H{"error": oauthErr.Code}
+	if oauthErr.Description != "" {
+		body["error_description"] = oauthErr.Description
+	}
+	context.JSON(oauthErr.StatusCode, body)
+}
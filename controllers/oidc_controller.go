@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type OIDCController interface {
+	Discovery(context *gin.Context)
+	JWKS(context *gin.Context)
+	UserInfo(context *gin.Context)
+	Logout(context *gin.Context)
+}
+
+type oidcController struct {
+	oidcService           services.OIDCService
+	authenticationService services.AuthenticationService
+	sessionCookies        cookies.Manager
+}
+
+func NewOIDCController(
+	oidcService services.OIDCService,
+	authenticationService services.AuthenticationService,
+	sessionCookies cookies.Manager,
+) OIDCController {
+	return &oidcController{
+		oidcService:           oidcService,
+		authenticationService: authenticationService,
+		sessionCookies:        sessionCookies,
+	}
+}
+
+// loggedOutPage is shown after a logout that did not ask to return to the client
+var loggedOutPage = template.Must(template.New("logged-out").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Signed out</title></head>
+<body><h1>Signed out</h1><p>{{.}}</p></body>
+</html>
+`))
+
+func (oic *oidcController) Discovery(context *gin.Context) {
+	context.JSON(http.StatusOK, oic.oidcService.Discovery())
+}
+
+func (oic *oidcController) JWKS(context *gin.Context) {
+	context.JSON(http.StatusOK, oic.oidcService.JWKS())
+}
+
+// UserInfo returns the claims about the user a token with the openid scope was issued for.
+// Like the other OpenID Connect endpoints it answers without the usual response envelope.
+func (oic *oidcController) UserInfo(context *gin.Context) {
+	principal := context.MustGet("principal").(*services.Principal)
+
+	claims, err := oic.oidcService.UserInfo(principal)
+	if errors.Is(err, services.ErrInsufficientScope) {
+		context.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		context.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading user info: %v", err)
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	context.Header("Cache-Control", "no-store")
+	context.JSON(http.StatusOK, claims)
+}
+
+// Logout implements RP-initiated logout: a relying party sends the user here with the ID token it
+// holds to end the user's session with it and, in this browser, with this service
+func (oic *oidcController) Logout(context *gin.Context) {
+	param := context.Query
+	if context.Request.Method == http.MethodPost {
+		param = context.PostForm
+	}
+
+	browserSession, _, _ := browserPrincipal(context, oic.authenticationService, oic.sessionCookies)
+	redirectURL, endedBrowserSession, err := oic.oidcService.EndSession(&services.LogoutRequest{
+		IDTokenHint:           param("id_token_hint"),
+		PostLogoutRedirectURI: param("post_logout_redirect_uri"),
+		State:                 param("state"),
+	}, browserSession)
+	if errors.Is(err, services.ErrInvalidLogoutRequest) {
+		renderPage(context, http.StatusBadRequest, errorPage, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error ending session: %v", err)
+		renderPage(context, http.StatusInternalServerError, errorPage, "Something went wrong, please try again.")
+		return
+	}
+
+	if endedBrowserSession {
+		oic.sessionCookies.Clear(context)
+	}
+	if redirectURL != "" {
+		context.Redirect(http.StatusFound, redirectURL)
+		return
+	}
+	renderPage(context, http.StatusOK, loggedOutPage, "You have been signed out.")
+}
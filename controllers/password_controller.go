@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type PasswordController interface {
+	ForgotPassword(context *gin.Context)
+	ResetPassword(context *gin.Context)
+	ChangePassword(context *gin.Context)
+}
+
+type passwordController struct {
+	passwordResetService services.PasswordResetService
+	userService          services.UserService
+}
+
+func NewPasswordController(passwordResetService services.PasswordResetService, userService services.UserService) PasswordController {
+	return &passwordController{
+		passwordResetService: passwordResetService,
+		userService:          userService,
+	}
+}
+
+func (pc *passwordController) ForgotPassword(context *gin.Context) {
+	var forgotPasswordDto dto.ForgotPasswordDto
+	if err := context.ShouldBindJSON(&forgotPasswordDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	// Always answer the same way so the endpoint cannot be used to discover registered emails
+	if err := pc.passwordResetService.RequestPasswordReset(forgotPasswordDto.Email); err != nil {
+		log.Printf("Error requesting password reset: %v", err)
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("If the email is registered, a reset link has been sent", nil))
+}
+
+func (pc *passwordController) ResetPassword(context *gin.Context) {
+	var resetPasswordDto dto.ResetPasswordDto
+	if err := context.ShouldBindJSON(&resetPasswordDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	err := pc.passwordResetService.ResetPassword(resetPasswordDto.Token, resetPasswordDto.Password)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid or expired reset token"))
+		return
+	}
+	if writePasswordPolicyError(context, err, "password") {
+		return
+	}
+	if err != nil {
+		log.Printf("Error resetting password: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to reset password"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Password reset successfully", nil))
+}
+
+func (pc *passwordController) ChangePassword(context *gin.Context) {
+	user, ok := currentUser(context)
+	if !ok {
+		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError("User not authenticated"))
+		return
+	}
+
+	var changePasswordDto dto.ChangePasswordDto
+	if err := context.ShouldBindJSON(&changePasswordDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	err := pc.userService.ChangePassword(user.ID, currentSessionID(context), changePasswordDto.CurrentPassword, changePasswordDto.NewPassword)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Current password is incorrect"))
+		return
+	}
+	if writePasswordPolicyError(context, err, "newPassword") {
+		return
+	}
+	if err != nil {
+		log.Printf("Error changing password: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to change password"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Password changed successfully", nil))
+}
+
+// writePasswordPolicyError answers 422 with the policy violations keyed by the request field
+// holding the password. It reports whether err was a policy error.
+func writePasswordPolicyError(context *gin.Context, err error, field string) bool {
+	var policyErr *services.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	apiErr := responses.InvalidRequestData(map[string]string{field: strings.Join(policyErr.Violations, "; ")})
+	responses.WriteJson(context.Writer, apiErr.StatusCode, apiErr)
+	return true
+}
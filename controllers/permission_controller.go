@@ -41,9 +41,10 @@ func (pc *permissionController) CreatePermission(context *gin.Context) {
 	}
 
 	permission := &entities.Permission{
-		Resource:    permissionDto.Resource,
-		Action:      permissionDto.Action,
-		Description: permissionDto.Description,
+		Resource:         permissionDto.Resource,
+		Action:           permissionDto.Action,
+		Description:      permissionDto.Description,
+		MFAMaxAgeMinutes: permissionDto.MFAMaxAgeMinutes,
 	}
 
 	createdPermission, err := pc.permissionService.CreatePermission(permission)
@@ -123,6 +124,7 @@ func (pc *permissionController) UpdatePermission(context *gin.Context) {
 	existingPermission.Resource = permissionDto.Resource
 	existingPermission.Action = permissionDto.Action
 	existingPermission.Description = permissionDto.Description
+	existingPermission.MFAMaxAgeMinutes = permissionDto.MFAMaxAgeMinutes
 
 	err = pc.permissionService.UpdatePermission(existingPermission)
 	if err != nil {
@@ -43,6 +43,7 @@ func (rc *roleController) CreateRole(context *gin.Context) {
 	role := &entities.Role{
 		Name:        roleDto.Name,
 		Description: roleDto.Description,
+		RequireMFA:  roleDto.RequireMFA,
 	}
 
 	createdRole, err := rc.roleService.CreateRole(role)
@@ -105,6 +106,7 @@ func (rc *roleController) UpdateRole(context *gin.Context) {
 	// Update role properties
 	existingRole.Name = roleDto.Name
 	existingRole.Description = roleDto.Description
+	existingRole.RequireMFA = roleDto.RequireMFA
 
 	err = rc.roleService.UpdateRole(existingRole)
 	if err != nil {
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type SAMLController interface {
+	GetProviders(context *gin.Context)
+	Metadata(context *gin.Context)
+	Login(context *gin.Context)
+	ACS(context *gin.Context)
+}
+
+type samlController struct {
+	samlService    services.SAMLService
+	sessionCookies cookies.Manager
+}
+
+func NewSAMLController(
+	samlService services.SAMLService,
+	sessionCookies cookies.Manager,
+) SAMLController {
+	return &samlController{
+		samlService:    samlService,
+		sessionCookies: sessionCookies,
+	}
+}
+
+// GetProviders lists the SAML identity providers users can log in with
+func (sc *samlController) GetProviders(context *gin.Context) {
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", sc.samlService.Providers()))
+}
+
+// Metadata serves the service provider metadata to register with an identity provider
+func (sc *samlController) Metadata(context *gin.Context) {
+	metadata, err := sc.samlService.Metadata(context.Param("provider"))
+	if err != nil {
+		writeFederationError(context, err)
+		return
+	}
+	context.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// Login sends the browser to an identity provider with a signed AuthnRequest. return_to names where
+// it goes once logged in.
+func (sc *samlController) Login(context *gin.Context) {
+	start, err := sc.samlService.BeginLogin(context.Param("provider"), context.Query("return_to"))
+	if err != nil {
+		writeFederationError(context, err)
+		return
+	}
+
+	// The provider posts the response back from its own site
+	sc.sessionCookies.SetCrossSiteLoginState(context, start.State, time.Until(start.ExpiresAt))
+	context.Header("Cache-Control", "no-store")
+	context.Redirect(http.StatusFound, start.AuthURL)
+}
+
+// ACS is the assertion consumer service the identity provider posts its response to. With cookie
+// sessions the browser continues to the return URL logged in; otherwise the token is returned.
+func (sc *samlController) ACS(context *gin.Context) {
+	relayState := context.PostForm("RelayState")
+	browserState := sc.sessionCookies.LoginState(context)
+	sc.sessionCookies.ClearLoginState(context)
+
+	if relayState == "" || relayState != browserState {
+		log.Printf("SAML response for provider %s without a matching login state", context.Param("provider"))
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError(services.ErrInvalidFederationState.Error()))
+		return
+	}
+
+	login, err := sc.samlService.CompleteLogin(context.Param("provider"), relayState, context.PostForm("SAMLResponse"), clientInfo(context))
+	if err != nil {
+		writeFederationError(context, err)
+		return
+	}
+
+	context.Header("Cache-Control", "no-store")
+	if sc.sessionCookies.Enabled() {
+		sc.sessionCookies.SetSession(context, login.Token)
+		// 303 turns the POST into a GET of the return URL
+		context.Redirect(http.StatusSeeOther, login.ReturnTo)
+		return
+	}
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("OK", login.Token))
+}
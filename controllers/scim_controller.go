@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/scim"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// SCIMController serves the SCIM 2.0 endpoints identity providers provision users and groups with.
+// Responses use the SCIM media type and error format instead of the usual response envelope.
+type SCIMController interface {
+	GetServiceProviderConfig(context *gin.Context)
+	GetUsers(context *gin.Context)
+	GetUser(context *gin.Context)
+	CreateUser(context *gin.Context)
+	ReplaceUser(context *gin.Context)
+	PatchUser(context *gin.Context)
+	DeleteUser(context *gin.Context)
+	GetGroups(context *gin.Context)
+	GetGroup(context *gin.Context)
+	CreateGroup(context *gin.Context)
+	ReplaceGroup(context *gin.Context)
+	PatchGroup(context *gin.Context)
+	DeleteGroup(context *gin.Context)
+}
+
+type scimController struct {
+	scimService services.SCIMService
+}
+
+func NewSCIMController(scimService services.SCIMService) SCIMController {
+	return &scimController{
+		scimService: scimService,
+	}
+}
+
+func (sc *scimController) GetServiceProviderConfig(context *gin.Context) {
+	writeSCIM(context, http.StatusOK, scim.NewServiceProviderConfig(sc.scimService.MaxResults()))
+}
+
+// GetUsers queries users with the filter, startIndex and count parameters
+func (sc *scimController) GetUsers(context *gin.Context) {
+	startIndex, count, ok := scimPage(context)
+	if !ok {
+		return
+	}
+	list, err := sc.scimService.ListUsers(context.Query("filter"), startIndex, count)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIM(context, http.StatusOK, list)
+}
+
+func (sc *scimController) GetUser(context *gin.Context) {
+	user, err := sc.scimService.GetUser(context.Param("id"))
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusOK, user, user.Meta)
+}
+
+func (sc *scimController) CreateUser(context *gin.Context) {
+	var user scim.User
+	if !bindSCIM(context, &user) {
+		return
+	}
+	created, err := sc.scimService.CreateUser(&user)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusCreated, created, created.Meta)
+}
+
+func (sc *scimController) ReplaceUser(context *gin.Context) {
+	var user scim.User
+	if !bindSCIM(context, &user) {
+		return
+	}
+	replaced, err := sc.scimService.ReplaceUser(context.Param("id"), context.GetHeader("If-Match"), &user)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusOK, replaced, replaced.Meta)
+}
+
+func (sc *scimController) PatchUser(context *gin.Context) {
+	var patch scim.PatchRequest
+	if !bindSCIM(context, &patch) {
+		return
+	}
+	patched, err := sc.scimService.PatchUser(context.Param("id"), context.GetHeader("If-Match"), &patch)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusOK, patched, patched.Meta)
+}
+
+func (sc *scimController) DeleteUser(context *gin.Context) {
+	if err := sc.scimService.DeleteUser(context.Param("id"), context.GetHeader("If-Match")); err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	context.Status(http.StatusNoContent)
+}
+
+// GetGroups queries groups with the filter, startIndex and count parameters
+func (sc *scimController) GetGroups(context *gin.Context) {
+	startIndex, count, ok := scimPage(context)
+	if !ok {
+		return
+	}
+	list, err := sc.scimService.ListGroups(context.Query("filter"), startIndex, count)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIM(context, http.StatusOK, list)
+}
+
+func (sc *scimController) GetGroup(context *gin.Context) {
+	group, err := sc.scimService.GetGroup(context.Param("id"))
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusOK, group, group.Meta)
+}
+
+func (sc *scimController) CreateGroup(context *gin.Context) {
+	var group scim.Group
+	if !bindSCIM(context, &group) {
+		return
+	}
+	created, err := sc.scimService.CreateGroup(&group)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusCreated, created, created.Meta)
+}
+
+func (sc *scimController) ReplaceGroup(context *gin.Context) {
+	var group scim.Group
+	if !bindSCIM(context, &group) {
+		return
+	}
+	replaced, err := sc.scimService.ReplaceGroup(context.Param("id"), context.GetHeader("If-Match"), &group)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusOK, replaced, replaced.Meta)
+}
+
+func (sc *scimController) PatchGroup(context *gin.Context) {
+	var patch scim.PatchRequest
+	if !bindSCIM(context, &patch) {
+		return
+	}
+	patched, err := sc.scimService.PatchGroup(context.Param("id"), context.GetHeader("If-Match"), &patch)
+	if err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	writeSCIMResource(context, http.StatusOK, patched, patched.Meta)
+}
+
+func (sc *scimController) DeleteGroup(context *gin.Context) {
+	if err := sc.scimService.DeleteGroup(context.Param("id"), context.GetHeader("If-Match")); err != nil {
+		writeSCIMError(context, err)
+		return
+	}
+	context.Status(http.StatusNoContent)
+}
+
+// scimPage reads the 1-based startIndex and the count of a query; count is -1 when absent
+func scimPage(context *gin.Context) (int, int, bool) {
+	startIndex, count := 1, -1
+	var err error
+	if value := context.Query("startIndex"); value != "" {
+		if startIndex, err = strconv.Atoi(value); err != nil {
+			writeSCIMError(context, scim.BadRequest(scim.ErrorInvalidValue, "invalid startIndex"))
+			return 0, 0, false
+		}
+	}
+	if value := context.Query("count"); value != "" {
+		if count, err = strconv.Atoi(value); err != nil || count < 0 {
+			writeSCIMError(context, scim.BadRequest(scim.ErrorInvalidValue, "invalid count"))
+			return 0, 0, false
+		}
+	}
+	return startIndex, count, true
+}
+
+func bindSCIM(context *gin.Context, target any) bool {
+	if err := json.NewDecoder(context.Request.Body).Decode(target); err != nil {
+		writeSCIMError(context, scim.BadRequest(scim.ErrorInvalidSyntax, "invalid request body"))
+		return false
+	}
+	return true
+}
+
+// writeSCIMResource writes a single resource with its ETag and, once created, its location. A GET
+// whose If-None-Match names the current version is answered with 304.
+func writeSCIMResource(context *gin.Context, status int, resource any, meta *scim.Meta) {
+	context.Header("ETag", meta.Version)
+	if status == http.StatusCreated {
+		context.Header("Location", meta.Location)
+	}
+	if context.Request.Method == http.MethodGet && context.GetHeader("If-None-Match") == meta.Version {
+		context.Status(http.StatusNotModified)
+		return
+	}
+	writeSCIM(context, status, resource)
+}
+
+func writeSCIM(context *gin.Context, status int, body any) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Error encoding SCIM response: %v", err)
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+	context.Data(status, scim.ContentType, encoded)
+}
+
+func writeSCIMError(context *gin.Context, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		log.Printf("Error handling SCIM request: %v", err)
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "something went wrong")
+	}
+	writeSCIM(context, scimErr.StatusCode(), scimErr)
+}
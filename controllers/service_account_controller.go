@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type ServiceAccountController interface {
+	CreateServiceAccount(context *gin.Context)
+	GetServiceAccounts(context *gin.Context)
+	CreateAPIKey(context *gin.Context)
+	GetAPIKeys(context *gin.Context)
+	RevokeAPIKey(context *gin.Context)
+	CreateOAuthClient(context *gin.Context)
+	GetOAuthClients(context *gin.Context)
+	DeleteOAuthClient(context *gin.Context)
+}
+
+type serviceAccountController struct {
+	serviceAccountService services.ServiceAccountService
+	oauthClientService    services.OAuthClientService
+}
+
+func NewServiceAccountController(
+	serviceAccountService services.ServiceAccountService,
+	oauthClientService services.OAuthClientService,
+) ServiceAccountController {
+	return &serviceAccountController{
+		serviceAccountService: serviceAccountService,
+		oauthClientService:    oauthClientService,
+	}
+}
+
+// CreateServiceAccount creates a non-human account; assign roles to it with /roles/assign
+func (sac *serviceAccountController) CreateServiceAccount(context *gin.Context) {
+	var createServiceAccountDto dto.CreateServiceAccountDto
+	if err := context.ShouldBindJSON(&createServiceAccountDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	account, err := sac.serviceAccountService.CreateServiceAccount(createServiceAccountDto.Name)
+	if errors.Is(err, services.ErrUserAlreadyExists) {
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError("An account with this name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating service account: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to create service account"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusCreated, responses.ResponseSuccess("Service account created successfully", account))
+}
+
+func (sac *serviceAccountController) GetServiceAccounts(context *gin.Context) {
+	accounts, err := sac.serviceAccountService.GetServiceAccounts()
+	if err != nil {
+		log.Printf("Error listing service accounts: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list service accounts"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", accounts))
+}
+
+// CreateAPIKey creates an API key for a service account. The key is only ever shown in this response.
+func (sac *serviceAccountController) CreateAPIKey(context *gin.Context) {
+	accountID, ok := serviceAccountID(context)
+	if !ok {
+		return
+	}
+
+	var createAPIKeyDto dto.CreateAPIKeyDto
+	if err := context.ShouldBindJSON(&createAPIKeyDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	admin, _ := currentUser(context)
+	apiKey, key, err := sac.serviceAccountService.CreateAPIKey(admin.ID, accountID, createAPIKeyDto.Name, createAPIKeyDto.Scopes, createAPIKeyDto.ExpiresAt)
+	if errors.Is(err, services.ErrServiceAccountNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrPermissionNotDelegable) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKeyScopes) {
+		apiErr := responses.InvalidRequestData(map[string]string{"scopes": err.Error()})
+		responses.WriteJson(context.Writer, apiErr.StatusCode, apiErr)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKeyExpiry) {
+		apiErr := responses.InvalidRequestData(map[string]string{"expiresAt": err.Error()})
+		responses.WriteJson(context.Writer, apiErr.StatusCode, apiErr)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating API key for service account %d: %v", accountID, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to create API key"))
+		return
+	}
+
+	responseData := map[string]any{"apiKey": apiKey, "key": key}
+	responses.WriteJson(context.Writer, http.StatusCreated, responses.ResponseSuccess("Store the key now, it will not be shown again", responseData))
+}
+
+func (sac *serviceAccountController) GetAPIKeys(context *gin.Context) {
+	accountID, ok := serviceAccountID(context)
+	if !ok {
+		return
+	}
+
+	apiKeys, err := sac.serviceAccountService.GetAPIKeys(accountID)
+	if errors.Is(err, services.ErrServiceAccountNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing API keys of service account %d: %v", accountID, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list API keys"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", apiKeys))
+}
+
+func (sac *serviceAccountController) RevokeAPIKey(context *gin.Context) {
+	accountID, ok := serviceAccountID(context)
+	if !ok {
+		return
+	}
+	keyID, err := strconv.ParseUint(context.Param("keyId"), 10, 32)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid API key ID"))
+		return
+	}
+
+	err = sac.serviceAccountService.RevokeAPIKey(accountID, uint(keyID))
+	if errors.Is(err, services.ErrServiceAccountNotFound) || errors.Is(err, services.ErrAPIKeyNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API key of service account %d: %v", accountID, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to revoke API key"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("API key revoked successfully", nil))
+}
+
+// CreateOAuthClient registers a client. A generated secret is only ever shown in this response.
+func (sac *serviceAccountController) CreateOAuthClient(context *gin.Context) {
+	var createOAuthClientDto dto.CreateOAuthClientDto
+	if err := context.ShouldBindJSON(&createOAuthClientDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	authMethod := createOAuthClientDto.TokenEndpointAuthMethod
+	if authMethod == "" {
+		authMethod = entities.ClientAuthMethodSecretBasic
+	}
+
+	admin, _ := currentUser(context)
+	client, secret, err := sac.oauthClientService.CreateClient(admin.ID, &entities.OAuthClient{
+		Name:                   createOAuthClientDto.Name,
+		AuthMethod:             authMethod,
+		PublicKey:              createOAuthClientDto.PublicKey,
+		GrantTypes:             joinList(createOAuthClientDto.GrantTypes),
+		Scopes:                 joinList(createOAuthClientDto.Scopes),
+		RedirectURIs:           joinList(createOAuthClientDto.RedirectURIs),
+		PostLogoutRedirectURIs: joinList(createOAuthClientDto.PostLogoutRedirectURIs),
+		TokenExchangeAudiences: joinList(createOAuthClientDto.TokenExchangeAudiences),
+		ServiceAccountID:       createOAuthClientDto.ServiceAccountID,
+	})
+	if errors.Is(err, services.ErrInvalidOAuthClient) {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError(err.Error()))
+		return
+	}
+	if errors.Is(err, services.ErrPermissionNotDelegable) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating OAuth client: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to create OAuth client"))
+		return
+	}
+
+	responseData := map[string]any{"client": client}
+	if secret != "" {
+		responseData["clientSecret"] = secret
+	}
+	responses.WriteJson(context.Writer, http.StatusCreated, responses.ResponseSuccess("OAuth client created successfully", responseData))
+}
+
+func (sac *serviceAccountController) GetOAuthClients(context *gin.Context) {
+	clients, err := sac.oauthClientService.GetClients()
+	if err != nil {
+		log.Printf("Error listing OAuth clients: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list OAuth clients"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", clients))
+}
+
+func (sac *serviceAccountController) DeleteOAuthClient(context *gin.Context) {
+	err := sac.oauthClientService.DeleteClient(context.Param("clientId"))
+	if errors.Is(err, services.ErrOAuthClientNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError("OAuth client not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting OAuth client: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to delete OAuth client"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("OAuth client deleted successfully", nil))
+}
+
+// serviceAccountID reads the service account ID from the path, answering 400 when it is malformed
+func serviceAccountID(context *gin.Context) (uint, bool) {
+	accountID, err := strconv.ParseUint(context.Param("id"), 10, 32)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid service account ID"))
+		return 0, false
+	}
+	return uint(accountID), true
+}
+
+// joinList turns a JSON list into the space-separated form stored on entities
+func joinList(items []string) string {
+	return strings.Join(items, " ")
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+type SessionController interface {
+	GetSessions(context *gin.Context)
+	DeleteSession(context *gin.Context)
+	GetUserSessions(context *gin.Context)
+	DeleteUserSession(context *gin.Context)
+	DeleteAllUserSessions(context *gin.Context)
+}
+
+type sessionController struct {
+	sessionService services.SessionService
+}
+
+func NewSessionController(sessionService services.SessionService) SessionController {
+	return &sessionController{
+		sessionService: sessionService,
+	}
+}
+
+// GetSessions lists the devices the current user is logged in on
+func (sc *sessionController) GetSessions(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	sessions, err := sc.sessionService.GetSessionsForUser(user.ID, currentSessionID(context))
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list sessions"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", sessions))
+}
+
+// DeleteSession logs the current user out of one of their sessions, possibly the current one
+func (sc *sessionController) DeleteSession(context *gin.Context) {
+	user, _ := currentUser(context)
+	sc.revoke(context, user.ID, context.Param("id"))
+}
+
+// GetUserSessions lists the sessions of any user
+func (sc *sessionController) GetUserSessions(context *gin.Context) {
+	userID, ok := parseUserID(context)
+	if !ok {
+		return
+	}
+
+	sessions, err := sc.sessionService.GetSessionsForUser(userID, "")
+	if err != nil {
+		log.Printf("Error listing sessions of user %d: %v", userID, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to list sessions"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", sessions))
+}
+
+// DeleteUserSession terminates one session of any user
+func (sc *sessionController) DeleteUserSession(context *gin.Context) {
+	userID, ok := parseUserID(context)
+	if !ok {
+		return
+	}
+	sc.revoke(context, userID, context.Param("sessionId"))
+}
+
+// DeleteAllUserSessions logs a user out everywhere
+func (sc *sessionController) DeleteAllUserSessions(context *gin.Context) {
+	userID, ok := parseUserID(context)
+	if !ok {
+		return
+	}
+
+	if err := sc.sessionService.RevokeAll(userID, ""); err != nil {
+		log.Printf("Error revoking sessions of user %d: %v", userID, err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to revoke sessions"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Sessions revoked successfully", nil))
+}
+
+func (sc *sessionController) revoke(context *gin.Context, userID uint, sessionID string) {
+	err := sc.sessionService.Revoke(userID, sessionID)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		responses.WriteJson(context.Writer, http.StatusNotFound, responses.ResponseError("Session not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to revoke session"))
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Session revoked successfully", nil))
+}
+
+// parseUserID reads the :id path parameter, answering 400 when it is not a valid user ID
+func parseUserID(context *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 32)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid user ID"))
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -1,14 +1,15 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/middlewares"
 	"github.com/vladimirteddy/go-authentication/services"
 )
 
@@ -17,19 +18,31 @@ type TraefikController interface {
 }
 
 type traefikController struct {
-	userService       services.UserService
-	permissionService services.PermissionService
+	userService           services.UserService
+	permissionService     services.PermissionService
+	authenticationService services.AuthenticationService
+	auditService          services.AuditService
+	sessionCookies        cookies.Manager
 }
 
-func NewTraefikController(userService services.UserService, permissionService services.PermissionService) TraefikController {
+func NewTraefikController(
+	userService services.UserService,
+	permissionService services.PermissionService,
+	authenticationService services.AuthenticationService,
+	auditService services.AuditService,
+	sessionCookies cookies.Manager,
+) TraefikController {
 	return &traefikController{
-		userService:       userService,
-		permissionService: permissionService,
+		userService:           userService,
+		permissionService:     permissionService,
+		authenticationService: authenticationService,
+		auditService:          auditService,
+		sessionCookies:        sessionCookies,
 	}
 }
 
 // AuthorizeRequest handles authorization requests from Traefik ForwardAuth middleware
-// It extracts the JWT token from the Authorization header, validates it,
+// It extracts the JWT token from the Authorization header, validates it and its session,
 // and checks if the user has the required permissions for the requested resource.
 func (tc *traefikController) AuthorizeRequest(context *gin.Context) {
 	// Get the original URL and method from X-Forwarded headers
@@ -44,55 +57,61 @@ func (tc *traefikController) AuthorizeRequest(context *gin.Context) {
 	// Map HTTP method to action (GET -> read, POST -> create, etc.)
 	action := mapMethodToAction(originalMethod)
 
-	// Get the JWT token from the Authorization header
-	authHeader := context.GetHeader("Authorization")
-	if authHeader == "" {
+	// Get the JWT token or API key from the request headers, falling back to the session cookie
+	credential, fromCookie, err := middlewares.ExtractCredential(context, tc.sessionCookies)
+	if err != nil || credential == "" {
 		context.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// Parse and validate the JWT token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Verify the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		// Return the secret key used for signing
-		return []byte(context.GetHeader("X-Secret-Key")), nil
-	})
-
-	if err != nil || !token.Valid {
+	// Validate the credential, rejecting tokens whose session has been terminated and revoked keys
+	principal, err := tc.authenticationService.Authenticate(credential)
+	if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrInvalidSession) || errors.Is(err, services.ErrInvalidAPIKey) {
 		log.Printf("Invalid token: %v", err)
 		context.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
+	if err != nil {
+		log.Printf("Error authenticating token: %v", err)
+		context.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
-	// Extract user ID from token claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		context.AbortWithStatus(http.StatusUnauthorized)
+	// Requests of an administrator impersonating a user are audited with the decision
+	defer func() {
+		tc.auditService.RecordImpersonatedRequest(principal, originalMethod+" "+originalHost+originalURL, context.Writer.Status(), clientInfo(context))
+	}()
+
+	// Exchanged tokens only grant access to the host they were issued for
+	if principal.Audience != "" && principal.Audience != originalHost {
+		log.Printf("Token for %s presented to %s", principal.Audience, originalHost)
+		context.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	userID, err := getUserIDFromClaims(claims)
-	if err != nil {
-		log.Printf("Error extracting user ID: %v", err)
-		context.AbortWithStatus(http.StatusUnauthorized)
+	// Cookie-authenticated requests are checked for CSRF using the method of the original request
+	if fromCookie && !tc.sessionCookies.ValidCSRF(context, originalMethod, credential) {
+		log.Printf("Invalid CSRF token for %s %s", originalMethod, originalURL)
+		context.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
+	userID := principal.User.ID
+
 	// Skip permission check for public resources (if needed)
 	if isPublicResource(resource, originalURL) {
 		// Set user info in response headers for the upstream service
-		tc.setUserInfoHeaders(context, userID, claims)
+		tc.setUserInfoHeaders(context, principal)
 		context.Status(http.StatusOK)
 		return
 	}
 
-	// Check if the user has the required permissions
-	hasPermission, err := tc.permissionService.CheckUserPermission(userID, resource, action)
+	// Check if the user has the required permissions (honours the email verification policy)
+	// and that the credential, e.g. a scoped API key, allows them
+	hasPermission := false
+	if principal.Allows(resource, action) {
+		hasPermission, err = tc.userService.HasPermission(userID, resource, action)
+	}
 	if err != nil {
 		log.Printf("Error checking permission: %v", err)
 		context.AbortWithStatus(http.StatusInternalServerError)
@@ -105,8 +124,15 @@ func (tc *traefikController) AuthorizeRequest(context *gin.Context) {
 		return
 	}
 
+	// Permissions requiring a recent second factor tell the client to step up
+	if err := tc.userService.CheckStepUp(principal, resource, action); err != nil {
+		log.Printf("Step-up required for user %d to %s %s: %v", userID, action, resource, err)
+		middlewares.AbortWithStepUp(context, err)
+		return
+	}
+
 	// User is authorized, set user info in response headers for the upstream service
-	tc.setUserInfoHeaders(context, userID, claims)
+	tc.setUserInfoHeaders(context, principal)
 
 	// Return 200 OK to allow the request
 	context.Status(http.StatusOK)
@@ -153,25 +179,6 @@ func mapMethodToAction(method string) string {
 	}
 }
 
-// getUserIDFromClaims extracts the user ID from JWT claims
-func getUserIDFromClaims(claims jwt.MapClaims) (uint, error) {
-	// Handle different formats of the ID claim
-	switch id := claims["id"].(type) {
-	case float64:
-		return uint(id), nil
-	case int:
-		return uint(id), nil
-	case string:
-		idInt, err := strconv.ParseUint(id, 10, 32)
-		if err != nil {
-			return 0, err
-		}
-		return uint(idInt), nil
-	default:
-		return 0, fmt.Errorf("invalid ID type in token")
-	}
-}
-
 // isPublicResource checks if the resource/URL is publicly accessible
 func isPublicResource(resource, url string) bool {
 	// Define your public resources here
@@ -192,17 +199,23 @@ func isPublicResource(resource, url string) bool {
 }
 
 // setUserInfoHeaders sets user information in response headers for the upstream service
-func (tc *traefikController) setUserInfoHeaders(ctx *gin.Context, userID uint, claims jwt.MapClaims) {
-	// Set user ID header
-	ctx.Header("X-User-ID", fmt.Sprintf("%d", userID))
+func (tc *traefikController) setUserInfoHeaders(ctx *gin.Context, principal *services.Principal) {
+	// Set user ID and username headers
+	ctx.Header("X-User-ID", fmt.Sprintf("%d", principal.User.ID))
+	ctx.Header("X-Username", principal.User.Username)
+
+	// Tell the upstream service which client is calling on behalf of the user
+	if principal.Audience != "" {
+		ctx.Header("X-Acting-Client-ID", principal.ClientID)
+	}
 
-	// Set username header if available
-	if username, ok := claims["username"].(string); ok {
-		ctx.Header("X-Username", username)
+	// Tell the upstream service an administrator is acting as the user
+	if principal.ImpersonatorID != 0 {
+		ctx.Header("X-Impersonator-ID", fmt.Sprintf("%d", principal.ImpersonatorID))
 	}
 
-	// Set roles header if available
-	if roles, ok := claims["roles"].([]interface{}); ok {
+	// Set roles header if available (API keys carry no token claims)
+	if roles, ok := principal.Claims["roles"].([]interface{}); ok {
 		roleStrings := make([]string, len(roles))
 		for i, role := range roles {
 			roleStrings[i] = role.(string)
@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/dto"
+	"github.com/vladimirteddy/go-authentication/responses"
+	"github.com/vladimirteddy/go-authentication/services"
+	"github.com/vladimirteddy/go-authentication/webauthn"
+)
+
+type WebAuthnController interface {
+	BeginRegistration(context *gin.Context)
+	FinishRegistration(context *gin.Context)
+	GetCredentials(context *gin.Context)
+	DeleteCredential(context *gin.Context)
+	BeginLogin(context *gin.Context)
+	FinishLogin(context *gin.Context)
+	BeginSecondFactor(context *gin.Context)
+	FinishSecondFactor(context *gin.Context)
+	BeginStepUp(context *gin.Context)
+	FinishStepUp(context *gin.Context)
+}
+
+type webAuthnController struct {
+	webAuthnService      services.WebAuthnService
+	mfaService           services.MFAService
+	userService          services.UserService
+	loginThrottleService services.LoginThrottleService
+	sessionCookies       cookies.Manager
+}
+
+func NewWebAuthnController(
+	webAuthnService services.WebAuthnService,
+	mfaService services.MFAService,
+	userService services.UserService,
+	loginThrottleService services.LoginThrottleService,
+	sessionCookies cookies.Manager,
+) WebAuthnController {
+	return &webAuthnController{
+		webAuthnService:      webAuthnService,
+		mfaService:           mfaService,
+		userService:          userService,
+		loginThrottleService: loginThrottleService,
+		sessionCookies:       sessionCookies,
+	}
+}
+
+func (wc *webAuthnController) BeginRegistration(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	options, err := wc.webAuthnService.BeginRegistration(user.ID)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", map[string]any{"publicKey": options}))
+}
+
+func (wc *webAuthnController) FinishRegistration(context *gin.Context) {
+	var registrationDto dto.WebAuthnRegistrationDto
+	if err := context.ShouldBindJSON(&registrationDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	clientDataJSON, err1 := webauthn.Base64.DecodeString(registrationDto.Response.ClientDataJSON)
+	attestationObject, err2 := webauthn.Base64.DecodeString(registrationDto.Response.AttestationObject)
+	if err1 != nil || err2 != nil || registrationDto.Type != "public-key" {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid credential encoding"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	credential, err := wc.webAuthnService.FinishRegistration(user.ID, registrationDto.Name, clientDataJSON, attestationObject)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusCreated, responses.ResponseSuccess("Credential registered successfully", credential))
+}
+
+func (wc *webAuthnController) GetCredentials(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	credentials, err := wc.webAuthnService.GetCredentialsForUser(user.ID)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Credentials retrieved successfully", credentials))
+}
+
+func (wc *webAuthnController) DeleteCredential(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 32)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid credential ID"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	if err := wc.webAuthnService.DeleteCredential(user.ID, uint(id)); err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("Credential deleted successfully", nil))
+}
+
+func (wc *webAuthnController) BeginLogin(context *gin.Context) {
+	var beginDto dto.WebAuthnLoginBeginDto
+	// The body is optional: without a username any discoverable credential may be used
+	if context.Request.ContentLength > 0 {
+		if err := context.ShouldBindJSON(&beginDto); err != nil {
+			responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+			return
+		}
+	}
+
+	options, err := wc.webAuthnService.BeginLogin(beginDto.Username)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", map[string]any{"publicKey": options}))
+}
+
+// FinishLogin completes a passwordless login and returns the same token as /auth/login
+func (wc *webAuthnController) FinishLogin(context *gin.Context) {
+	var assertionDto dto.WebAuthnAssertionDto
+	if err := context.ShouldBindJSON(&assertionDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	assertion, err := decodeAssertion(&assertionDto)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid credential encoding"))
+		return
+	}
+
+	userID, err := wc.webAuthnService.FinishLogin(assertion)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	// Passwordless logins require user verification, so the passkey counts as two factors
+	token, err := wc.userService.IssueToken(userID, []string{services.AuthMethodHardwareKey, services.AuthMethodMFA}, clientInfo(context))
+	if errors.Is(err, services.ErrEmailNotVerified) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError("email not verified"))
+		return
+	}
+	if errors.Is(err, services.ErrAccountDeactivated) {
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+		return
+	}
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	wc.sessionCookies.SetSession(context, token)
+	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("OK", token))
+}
+
+// BeginSecondFactor starts a security key assertion for a login that returned an MFA challenge
+func (wc *webAuthnController) BeginSecondFactor(context *gin.Context) {
+	var challengeDto dto.MFAChallengeDto
+	if err := context.ShouldBindJSON(&challengeDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+
+	challenge, err := wc.mfaService.ResolveChallenge(challengeDto.ChallengeToken)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	options, err := wc.webAuthnService.BeginSecondFactor(challenge.UserID)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", map[string]any{"publicKey": options}))
+}
+
+func (wc *webAuthnController) FinishSecondFactor(context *gin.Context) {
+	var mfaDto dto.WebAuthnMFADto
+	if err := context.ShouldBindJSON(&mfaDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	assertion, err := decodeAssertion(&mfaDto.Credential)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid credential encoding"))
+		return
+	}
+
+	challenge, err := wc.mfaService.ResolveChallenge(mfaDto.ChallengeToken)
+	if err != nil {
+		writeMFAError(context, err)
+		return
+	}
+	user, err := wc.userService.GetUserByID(challenge.UserID)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+	clientIP := context.ClientIP()
+	if err := wc.loginThrottleService.Check(user.Username, clientIP); err != nil {
+		writeLockedOut(context, err)
+		return
+	}
+	if err := wc.webAuthnService.FinishSecondFactor(challenge.UserID, assertion); err != nil {
+		if errors.Is(err, services.ErrWebAuthnVerificationFailed) || errors.Is(err, services.ErrWebAuthnCredentialNotFound) {
+			if err := wc.loginThrottleService.RecordFailure(user.Username, clientIP); err != nil {
+				log.Printf("Error recording failed second factor: %v", err)
+			}
+		}
+		writeWebAuthnError(context, err)
+		return
+	}
+	if err := wc.mfaService.ConsumeChallenge(mfaDto.ChallengeToken); err != nil {
+		writeMFAError(context, err)
+		return
+	}
+
+	token, err := wc.userService.IssueToken(challenge.UserID, challenge.AuthMethodsWith(services.AuthMethodHardwareKey), clientInfo(context))
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+	if err := wc.loginThrottleService.RecordSuccess(user.Username); err != nil {
+		log.Printf("Error resetting failed logins: %v", err)
+	}
+
+	wc.sessionCookies.SetSession(context, token)
+	responses.WriteJson(context.Writer, http.StatusAccepted, responses.ResponseSuccess("OK", token))
+}
+
+// BeginStepUp starts a security key assertion re-authenticating the user of the current session
+func (wc *webAuthnController) BeginStepUp(context *gin.Context) {
+	user, _ := currentUser(context)
+
+	options, err := wc.webAuthnService.BeginSecondFactor(user.ID)
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("ok", map[string]any{"publicKey": options}))
+}
+
+// FinishStepUp verifies the assertion and returns a new token of the session reporting the step-up
+func (wc *webAuthnController) FinishStepUp(context *gin.Context) {
+	var assertionDto dto.WebAuthnAssertionDto
+	if err := context.ShouldBindJSON(&assertionDto); err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid request body"))
+		return
+	}
+	assertion, err := decodeAssertion(&assertionDto)
+	if err != nil {
+		responses.WriteJson(context.Writer, http.StatusBadRequest, responses.ResponseError("Invalid credential encoding"))
+		return
+	}
+	user, _ := currentUser(context)
+
+	if err := wc.webAuthnService.FinishSecondFactor(user.ID, assertion); err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	token, err := wc.userService.StepUp(user.ID, currentSessionID(context), []string{services.AuthMethodHardwareKey, services.AuthMethodMFA})
+	if err != nil {
+		writeWebAuthnError(context, err)
+		return
+	}
+
+	wc.sessionCookies.SetSession(context, token)
+	responses.WriteJson(context.Writer, http.StatusOK, responses.ResponseSuccess("OK", token))
+}
+
+func decodeAssertion(assertionDto *dto.WebAuthnAssertionDto) (*services.WebAuthnAssertion, error) {
+	if assertionDto.Type != "public-key" {
+		return nil, errors.New("unexpected credential type")
+	}
+
+	assertion := &services.WebAuthnAssertion{}
+	fields := []struct {
+		encoded string
+		target  *[]byte
+	}{
+		{assertionDto.ID, &assertion.CredentialID},
+		{assertionDto.Response.ClientDataJSON, &assertion.ClientDataJSON},
+		{assertionDto.Response.AuthenticatorData, &assertion.AuthenticatorData},
+		{assertionDto.Response.Signature, &assertion.Signature},
+		{assertionDto.Response.UserHandle, &assertion.UserHandle},
+	}
+	for _, field := range fields {
+		decoded, err := webauthn.Base64.DecodeString(field.encoded)
+		if err != nil {
+			return nil, err
+		}
+		*field.target = decoded
+	}
+	return assertion, nil
+}
+
+func writeWebAuthnError(context *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebAuthnChallenge),
+		errors.Is(err, services.ErrWebAuthnCredentialNotFound),
+		errors.Is(err, services.ErrWebAuthnVerificationFailed):
+		log.Printf("WebAuthn ceremony rejected: %v", err)
+		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError("WebAuthn verification failed"))
+	case errors.Is(err, services.ErrInvalidSession):
+		responses.WriteJson(context.Writer, http.StatusUnauthorized, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		responses.WriteJson(context.Writer, http.StatusConflict, responses.ResponseError(err.Error()))
+	case errors.Is(err, services.ErrMFARequiredByRole), errors.Is(err, services.ErrAccountDeactivated):
+		responses.WriteJson(context.Writer, http.StatusForbidden, responses.ResponseError(err.Error()))
+	default:
+		log.Printf("WebAuthn error: %v", err)
+		responses.WriteJson(context.Writer, http.StatusInternalServerError, responses.ResponseError("Failed to process WebAuthn request"))
+	}
+}
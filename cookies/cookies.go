@@ -0,0 +1,224 @@
+// Package cookies implements browser sessions carried in cookies instead of a Bearer header,
+// with double-submit CSRF protection for state-changing requests.
+package cookies
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config configures the session and CSRF cookies
+type Config struct {
+	Enabled bool
+	// Name is the HttpOnly cookie holding the access token
+	Name string
+	// CSRFName is the cookie, readable by scripts, holding the CSRF token
+	CSRFName string
+	// CSRFHeader is the request header the CSRF token must be echoed in
+	CSRFHeader string
+	Domain     string
+	Path       string
+	Secure     bool
+	SameSite   http.SameSite
+	// TTL is the cookie lifetime, matching the session lifetime
+	TTL time.Duration
+	// Secret keys the CSRF tokens derived from access tokens
+	Secret []byte
+}
+
+// Manager issues, reads and clears session cookies
+type Manager interface {
+	Enabled() bool
+	SetSession(context *gin.Context, token string)
+	Clear(context *gin.Context)
+	Token(context *gin.Context) string
+	ValidCSRF(context *gin.Context, method, token string) bool
+	CSRFToken(token string) string
+	CSRFFieldName() string
+	SetLoginState(context *gin.Context, state string, ttl time.Duration)
+	SetCrossSiteLoginState(context *gin.Context, state string, ttl time.Duration)
+	LoginState(context *gin.Context) string
+	ClearLoginState(context *gin.Context)
+	SetMagicLinkDevice(context *gin.Context, secret string, ttl time.Duration)
+	MagicLinkDevice(context *gin.Context) string
+	ClearMagicLinkDevice(context *gin.Context)
+}
+
+type manager struct {
+	config Config
+}
+
+func NewManager(config Config) Manager {
+	return &manager{
+		config: config,
+	}
+}
+
+func (m *manager) Enabled() bool {
+	return m.config.Enabled
+}
+
+// SetSession stores the access token in an HttpOnly cookie, next to a CSRF token scripts can read
+func (m *manager) SetSession(context *gin.Context, token string) {
+	if !m.config.Enabled {
+		return
+	}
+
+	maxAge := int(m.config.TTL.Seconds())
+	m.setCookie(context, m.config.Name, token, maxAge, true)
+	m.setCookie(context, m.config.CSRFName, m.csrfToken(token), maxAge, false)
+}
+
+// Clear removes both cookies, e.g. on logout
+func (m *manager) Clear(context *gin.Context) {
+	if !m.config.Enabled {
+		return
+	}
+
+	m.setCookie(context, m.config.Name, "", -1, true)
+	m.setCookie(context, m.config.CSRFName, "", -1, false)
+}
+
+// Token returns the access token from the session cookie, or "" when there is none
+func (m *manager) Token(context *gin.Context) string {
+	if !m.config.Enabled {
+		return ""
+	}
+
+	token, err := context.Cookie(m.config.Name)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// ValidCSRF checks a request authenticated with the session cookie. Safe methods always pass;
+// others must echo the CSRF cookie in the CSRF header, or for HTML forms in a form field named
+// like the cookie, and it must belong to token.
+// method is passed explicitly so forwarded requests can be checked with their original method.
+func (m *manager) ValidCSRF(context *gin.Context, method, token string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	header := context.GetHeader(m.config.CSRFHeader)
+	if header == "" {
+		header = context.PostForm(m.config.CSRFName)
+	}
+	cookie, err := context.Cookie(m.config.CSRFName)
+	if err != nil || header == "" {
+		return false
+	}
+
+	// Deriving the token from the access token stops an attacker who can plant cookies
+	// from pairing the victim's session with a CSRF token of their own
+	expected := []byte(m.csrfToken(token))
+	return hmac.Equal([]byte(header), expected) && hmac.Equal([]byte(cookie), expected)
+}
+
+// CSRFToken returns the CSRF token belonging to a session token, for embedding in HTML forms
+func (m *manager) CSRFToken(token string) string {
+	return m.csrfToken(token)
+}
+
+// CSRFFieldName is the form field HTML forms carry the CSRF token in
+func (m *manager) CSRFFieldName() string {
+	return m.config.CSRFName
+}
+
+// SetLoginState remembers a login started at an external identity provider in the browser that
+// started it. It is set even without cookie sessions, and is Lax so the provider's redirect back
+// carries it.
+func (m *manager) SetLoginState(context *gin.Context, state string, ttl time.Duration) {
+	m.setStateCookie(context, m.loginStateName(), state, int(ttl.Seconds()), http.SameSiteLaxMode)
+}
+
+// SetCrossSiteLoginState is SetLoginState for providers that come back with a cross-site POST, like
+// the SAML HTTP-POST binding, which Lax cookies are not sent with. Browsers only accept SameSite=None
+// on secure cookies, so without Secure it falls back to Lax, which still works on localhost.
+func (m *manager) SetCrossSiteLoginState(context *gin.Context, state string, ttl time.Duration) {
+	sameSite := http.SameSiteLaxMode
+	if m.config.Secure {
+		sameSite = http.SameSiteNoneMode
+	}
+	m.setStateCookie(context, m.loginStateName(), state, int(ttl.Seconds()), sameSite)
+}
+
+// LoginState returns the state of the login the browser started, or "" when there is none
+func (m *manager) LoginState(context *gin.Context) string {
+	state, err := context.Cookie(m.loginStateName())
+	if err != nil {
+		return ""
+	}
+	return state
+}
+
+func (m *manager) ClearLoginState(context *gin.Context) {
+	m.setStateCookie(context, m.loginStateName(), "", -1, http.SameSiteLaxMode)
+}
+
+func (m *manager) loginStateName() string {
+	return m.config.Name + "_login_state"
+}
+
+// SetMagicLinkDevice binds a requested magic link to the browser that asked for it. Like the login
+// state it is set even without cookie sessions, in its own cookie so a federated login started in
+// the meantime does not replace it.
+func (m *manager) SetMagicLinkDevice(context *gin.Context, secret string, ttl time.Duration) {
+	m.setStateCookie(context, m.magicLinkDeviceName(), secret, int(ttl.Seconds()), http.SameSiteLaxMode)
+}
+
+// MagicLinkDevice returns the secret of the magic link the browser requested, or "" when there is none
+func (m *manager) MagicLinkDevice(context *gin.Context) string {
+	secret, err := context.Cookie(m.magicLinkDeviceName())
+	if err != nil {
+		return ""
+	}
+	return secret
+}
+
+func (m *manager) ClearMagicLinkDevice(context *gin.Context) {
+	m.setStateCookie(context, m.magicLinkDeviceName(), "", -1, http.SameSiteLaxMode)
+}
+
+func (m *manager) magicLinkDeviceName() string {
+	return m.config.Name + "_magic_link"
+}
+
+func (m *manager) setStateCookie(context *gin.Context, name, value string, maxAge int, sameSite http.SameSite) {
+	http.SetCookie(context.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     m.config.Path,
+		Domain:   m.config.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+func (m *manager) csrfToken(token string) string {
+	mac := hmac.New(sha256.New, m.config.Secret)
+	mac.Write([]byte("csrf:" + token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *manager) setCookie(context *gin.Context, name, value string, maxAge int, httpOnly bool) {
+	http.SetCookie(context.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     m.config.Path,
+		Domain:   m.config.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.config.Secure,
+		HttpOnly: httpOnly,
+		SameSite: m.config.SameSite,
+	})
+}
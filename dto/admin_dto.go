@@ -0,0 +1,22 @@
+package dto
+
+import "time"
+
+// UnlockIPDto represents the client address whose login lockout should be lifted
+type UnlockIPDto struct {
+	IP string `json:"ip" binding:"required,ip"`
+}
+
+// ImpersonateDto represents why an administrator acts as a user, recorded in the audit log
+type ImpersonateDto struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// InviteDto represents an invitation to create an account with pre-assigned roles
+type InviteDto struct {
+	Email   string `json:"email" binding:"required,email"`
+	Tenant  string `json:"tenant" binding:"max=255"`
+	RoleIDs []uint `json:"roleIds"`
+	// ExpiresAt defaults to INVITATION_TTL from now
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
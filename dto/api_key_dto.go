@@ -0,0 +1,11 @@
+package dto
+
+import "time"
+
+// CreateAPIKeyDto represents the data needed to create a personal API key
+type CreateAPIKeyDto struct {
+	Name string `json:"name" binding:"required,max=100"`
+	// Scopes are "resource:action" permissions the key is limited to
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
@@ -3,5 +3,38 @@ package dto
 type AuthRequestDto struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
-	Email    string `json:"email" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+}
+
+// LoginDto represents the credentials submitted to log in
+type LoginDto struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// VerifyEmailDto represents the data needed to confirm an email address
+type VerifyEmailDto struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// ResendVerificationDto represents the data needed to request a new verification email
+type ResendVerificationDto struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// MagicLinkRequestDto represents the data needed to request a login link by email
+type MagicLinkRequestDto struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// MagicLinkLoginDto represents the token of an emailed login link
+type MagicLinkLoginDto struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// AcceptInvitationDto represents the account an invited user chooses
+type AcceptInvitationDto struct {
+	Token    string `json:"token" binding:"required"`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
@@ -0,0 +1,17 @@
+package dto
+
+// MFAChallengeDto identifies a pending login that still needs a second factor
+type MFAChallengeDto struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+}
+
+// MFALoginDto represents the second step of a login: a TOTP code or a recovery code
+type MFALoginDto struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// MFACodeDto represents a TOTP or recovery code submitted by a logged-in user
+type MFACodeDto struct {
+	Code string `json:"code" binding:"required"`
+}
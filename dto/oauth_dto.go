@@ -0,0 +1,24 @@
+package dto
+
+// CreateServiceAccountDto represents the data needed to create a service account
+type CreateServiceAccountDto struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// CreateOAuthClientDto represents the data needed to register an OAuth client
+type CreateOAuthClientDto struct {
+	Name string `json:"name" binding:"required,max=100"`
+	// TokenEndpointAuthMethod is client_secret_basic (default), client_secret_post, private_key_jwt
+	// or none for public clients such as SPAs
+	TokenEndpointAuthMethod string `json:"tokenEndpointAuthMethod"`
+	// PublicKey is the PEM encoded public key verifying private_key_jwt assertions
+	PublicKey    string   `json:"publicKey"`
+	GrantTypes   []string `json:"grantTypes" binding:"required,min=1"`
+	Scopes       []string `json:"scopes"`
+	RedirectURIs []string `json:"redirectUris"`
+	// PostLogoutRedirectURIs are where relying parties may send users after RP-initiated logout
+	PostLogoutRedirectURIs []string `json:"postLogoutRedirectUris"`
+	// TokenExchangeAudiences are the services the client may exchange user tokens for
+	TokenExchangeAudiences []string `json:"tokenExchangeAudiences"`
+	ServiceAccountID       *uint    `json:"serviceAccountId"`
+}
@@ -0,0 +1,18 @@
+package dto
+
+// ForgotPasswordDto represents the data needed to request a password reset email
+type ForgotPasswordDto struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordDto represents the data needed to set a new password with a reset token
+type ResetPasswordDto struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ChangePasswordDto represents the data needed for a logged-in user to change their password
+type ChangePasswordDto struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
@@ -5,6 +5,8 @@ type PermissionDto struct {
 	Resource    string `json:"resource" binding:"required"`
 	Action      string `json:"action" binding:"required"`
 	Description string `json:"description"`
+	// MFAMaxAgeMinutes requires a two-factor authentication within this many minutes (0 for none)
+	MFAMaxAgeMinutes int `json:"mfaMaxAgeMinutes" binding:"min=0"`
 }
 
 // AssignPermissionDto represents the data needed to assign a permission to a role
@@ -4,6 +4,7 @@ package dto
 type RoleDto struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
+	RequireMFA  bool   `json:"requireMfa"`
 }
 
 // AssignRoleDto represents the data needed to assign a role to a user
@@ -0,0 +1,43 @@
+package dto
+
+// WebAuthnAttestationResponseDto is the response part of a PublicKeyCredential returned by
+// navigator.credentials.create(). Binary fields are base64url encoded.
+type WebAuthnAttestationResponseDto struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AttestationObject string `json:"attestationObject" binding:"required"`
+}
+
+// WebAuthnRegistrationDto represents the data needed to finish registering a credential
+type WebAuthnRegistrationDto struct {
+	Name     string                         `json:"name"`
+	ID       string                         `json:"id" binding:"required"`
+	Type     string                         `json:"type" binding:"required"`
+	Response WebAuthnAttestationResponseDto `json:"response" binding:"required"`
+}
+
+// WebAuthnAssertionResponseDto is the response part of a PublicKeyCredential returned by
+// navigator.credentials.get(). Binary fields are base64url encoded.
+type WebAuthnAssertionResponseDto struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// WebAuthnAssertionDto represents a credential assertion
+type WebAuthnAssertionDto struct {
+	ID       string                       `json:"id" binding:"required"`
+	Type     string                       `json:"type" binding:"required"`
+	Response WebAuthnAssertionResponseDto `json:"response" binding:"required"`
+}
+
+// WebAuthnLoginBeginDto optionally names the user logging in; omit it for passkey login
+type WebAuthnLoginBeginDto struct {
+	Username string `json:"username"`
+}
+
+// WebAuthnMFADto represents a credential assertion answering an MFA challenge
+type WebAuthnMFADto struct {
+	ChallengeToken string               `json:"challengeToken" binding:"required"`
+	Credential     WebAuthnAssertionDto `json:"credential" binding:"required"`
+}
@@ -0,0 +1,24 @@
+package entities
+
+import "time"
+
+// APIKey is a personal access token letting scripts act as its owner, limited to Scopes
+type APIKey struct {
+	ID     uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	UserID uint   `json:"userId"`
+	Name   string `json:"name"`
+	// Prefix is the start of the key, kept in clear text so users can recognise their keys
+	Prefix  string `json:"prefix"`
+	KeyHash string `json:"-" gorm:"uniqueIndex"`
+	// Scopes is a space-separated list of "resource:action" permissions
+	Scopes     string     `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the APIKey model
+func (APIKey) TableName() string {
+	return "api_keys"
+}
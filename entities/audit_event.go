@@ -0,0 +1,24 @@
+package entities
+
+import "time"
+
+// AuditEvent records a sensitive action, who performed it and whom it concerned
+type AuditEvent struct {
+	ID     uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	Action string `json:"action"`
+	// ActorID is the user who performed the action; nil for anonymous or system actions
+	ActorID *uint `json:"actorId,omitempty"`
+	// SubjectID is the user the action concerned
+	SubjectID *uint  `json:"subjectId,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	IPAddress string `json:"ipAddress,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// Detail is free text, e.g. the reason given or the request made
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the AuditEvent model
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
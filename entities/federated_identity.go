@@ -0,0 +1,45 @@
+package entities
+
+import "time"
+
+// FederatedIdentity links a user to their account at an external identity provider or directory
+type FederatedIdentity struct {
+	ID     uint `json:"id" gorm:"primary_key;autoIncrement"`
+	UserID uint `json:"userId"`
+	// Provider is the configured name of the identity provider, e.g. "google", "saml:okta" or "ldap"
+	Provider string `json:"provider" gorm:"uniqueIndex:idx_federated_identities_provider_subject"`
+	// Subject is the user's stable identifier at the provider, such as the sub claim of its ID
+	// tokens or the persistent NameID of its SAML assertions
+	Subject string `json:"subject" gorm:"uniqueIndex:idx_federated_identities_provider_subject"`
+	// Email is the address the provider last reported
+	Email       string    `json:"email"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the FederatedIdentity model
+func (FederatedIdentity) TableName() string {
+	return "federated_identities"
+}
+
+// FederationLoginState is a login sent to an external identity provider, waiting for its callback.
+// Only the hash of the state parameter is stored.
+type FederationLoginState struct {
+	ID        uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	Provider  string `json:"provider"`
+	StateHash string `json:"-" gorm:"unique"`
+	// Nonce must be echoed in the provider's ID token. For SAML logins it is the ID of the
+	// AuthnRequest, which the response must answer.
+	Nonce        string `json:"-"`
+	CodeVerifier string `json:"-"`
+	// ReturnTo is where the browser goes once logged in
+	ReturnTo  string     `json:"returnTo"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the FederationLoginState model
+func (FederationLoginState) TableName() string {
+	return "federation_login_states"
+}
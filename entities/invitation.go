@@ -0,0 +1,37 @@
+package entities
+
+import "time"
+
+// Invitation lets someone create an account for Email and receive Roles, without open signup.
+// Only the SHA-256 hash of the emailed token is stored.
+type Invitation struct {
+	ID    uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	Email string `json:"email"`
+	// Tenant is recorded on the user created from the invitation
+	Tenant    string `json:"tenant,omitempty"`
+	TokenHash string `json:"-" gorm:"unique"`
+	// InvitedBy is the administrator who sent the invitation
+	InvitedBy      *uint      `json:"invitedBy,omitempty"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	AcceptedAt     *time.Time `json:"acceptedAt,omitempty"`
+	AcceptedUserID *uint      `json:"acceptedUserId,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	Roles          []Role     `json:"roles" gorm:"many2many:invitation_roles;"`
+}
+
+// TableName specifies the table name for the Invitation model
+func (Invitation) TableName() string {
+	return "invitations"
+}
+
+// InvitationRole represents a role an invited user receives when accepting
+type InvitationRole struct {
+	InvitationID uint `json:"invitationId" gorm:"primaryKey"`
+	RoleID       uint `json:"roleId" gorm:"primaryKey"`
+}
+
+// TableName specifies the table name for the InvitationRole model
+func (InvitationRole) TableName() string {
+	return "invitation_roles"
+}
@@ -0,0 +1,18 @@
+package entities
+
+import "time"
+
+// LoginAttempt tracks consecutive failed logins for a throttling key,
+// such as a username or a client IP address
+type LoginAttempt struct {
+	Key          string     `json:"key" gorm:"primaryKey"`
+	Failures     int        `json:"failures"`
+	LastFailedAt time.Time  `json:"lastFailedAt"`
+	LockedUntil  *time.Time `json:"lockedUntil,omitempty"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// TableName specifies the table name for the LoginAttempt model
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
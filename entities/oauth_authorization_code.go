@@ -0,0 +1,29 @@
+package entities
+
+import "time"
+
+// OAuthAuthorizationCode is a one-time code handed to a client through its redirect URI,
+// exchanged with the matching PKCE verifier for tokens
+type OAuthAuthorizationCode struct {
+	ID            uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	CodeHash      string `json:"-" gorm:"uniqueIndex"`
+	ClientID      string `json:"clientId"`
+	UserID        uint   `json:"userId"`
+	RedirectURI   string `json:"redirectUri"`
+	Scopes        string `json:"scopes"`
+	CodeChallenge string `json:"-"`
+	// Nonce is echoed in the ID token issued for the code (OpenID Connect)
+	Nonce string `json:"-"`
+	// AuthTime is when the user authenticated, reported as the auth_time ID token claim
+	AuthTime time.Time `json:"authTime"`
+	// SessionID is the session created when the code was exchanged, revoked if the code is replayed
+	SessionID string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the OAuthAuthorizationCode model
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
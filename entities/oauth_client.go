@@ -0,0 +1,49 @@
+package entities
+
+import "time"
+
+const (
+	ClientAuthMethodSecretBasic   = "client_secret_basic"
+	ClientAuthMethodSecretPost    = "client_secret_post"
+	ClientAuthMethodPrivateKeyJWT = "private_key_jwt"
+	// ClientAuthMethodNone is used by public clients (SPAs, native apps) that cannot keep a secret
+	ClientAuthMethodNone = "none"
+
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// OAuthClient is an application allowed to request tokens from the OAuth endpoints
+type OAuthClient struct {
+	ID         uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	ClientID   string `json:"clientId" gorm:"uniqueIndex"`
+	Name       string `json:"name"`
+	SecretHash string `json:"-"`
+	// AuthMethod is how the client authenticates at the token endpoint
+	AuthMethod string `json:"tokenEndpointAuthMethod"`
+	// PublicKey is the PEM encoded key verifying private_key_jwt client assertions
+	PublicKey string `json:"publicKey,omitempty"`
+	// GrantTypes is a space-separated list of the grants the client may use
+	GrantTypes string `json:"grantTypes"`
+	// Scopes is a space-separated list of "resource:action" permissions the client is limited to;
+	// empty means the client is limited only by the permissions of the account it acts for
+	Scopes string `json:"scopes"`
+	// RedirectURIs is a space-separated list of the exact URIs authorization responses may be sent to
+	RedirectURIs string `json:"redirectUris,omitempty"`
+	// PostLogoutRedirectURIs is a space-separated list of the URIs users may be sent to after logging out
+	PostLogoutRedirectURIs string `json:"postLogoutRedirectUris,omitempty"`
+	// TokenExchangeAudiences is a space-separated list of the audiences the client may exchange
+	// user tokens for with the token exchange grant
+	TokenExchangeAudiences string `json:"tokenExchangeAudiences,omitempty"`
+	// ServiceAccountID is the account client_credentials tokens are issued for
+	ServiceAccountID *uint     `json:"serviceAccountId,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the OAuthClient model
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
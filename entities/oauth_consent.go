@@ -0,0 +1,18 @@
+package entities
+
+import "time"
+
+// OAuthConsent records the scopes a user has agreed to grant a client, so the consent
+// screen is only shown again when the client asks for more
+type OAuthConsent struct {
+	UserID    uint      `json:"userId" gorm:"primaryKey"`
+	ClientID  string    `json:"clientId" gorm:"primaryKey"`
+	Scopes    string    `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TableName specifies the table name for the OAuthConsent model
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
@@ -0,0 +1,34 @@
+package entities
+
+import "time"
+
+const (
+	DeviceCodeStatusPending  = "pending"
+	DeviceCodeStatusApproved = "approved"
+	DeviceCodeStatusDenied   = "denied"
+)
+
+// OAuthDeviceCode is a device authorization request (RFC 8628). The device polls with the device code
+// while the user approves the request in a browser by entering the user code.
+type OAuthDeviceCode struct {
+	ID             uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	DeviceCodeHash string `json:"-" gorm:"uniqueIndex"`
+	UserCodeHash   string `json:"-" gorm:"uniqueIndex"`
+	ClientID       string `json:"clientId"`
+	Scopes         string `json:"scopes"`
+	Status         string `json:"status"`
+	// UserID and AuthTime are set once a user approved the request
+	UserID   *uint      `json:"userId,omitempty"`
+	AuthTime *time.Time `json:"authTime,omitempty"`
+	// PollInterval is the minimum number of seconds between polls, raised when the device polls too fast
+	PollInterval int        `json:"pollInterval"`
+	LastPolledAt *time.Time `json:"lastPolledAt,omitempty"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	UsedAt       *time.Time `json:"usedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the OAuthDeviceCode model
+func (OAuthDeviceCode) TableName() string {
+	return "oauth_device_codes"
+}
@@ -0,0 +1,24 @@
+package entities
+
+import "time"
+
+// OAuthRefreshToken lets a client obtain new access tokens for the session it was issued for.
+// Refresh tokens are single use: each refresh rotates it for a new one.
+type OAuthRefreshToken struct {
+	ID        uint   `json:"id" gorm:"primary_key;autoIncrement"`
+	TokenHash string `json:"-" gorm:"uniqueIndex"`
+	ClientID  string `json:"clientId"`
+	UserID    uint   `json:"userId"`
+	SessionID string `json:"sessionId"`
+	Scopes    string `json:"scopes"`
+	// AuthTime is when the user authenticated for the authorization the token belongs to
+	AuthTime  time.Time  `json:"authTime"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the OAuthRefreshToken model
+func (OAuthRefreshToken) TableName() string {
+	return "oauth_refresh_tokens"
+}
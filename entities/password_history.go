@@ -0,0 +1,16 @@
+package entities
+
+import "time"
+
+// PasswordHistory is a previous password hash kept to prevent password reuse
+type PasswordHistory struct {
+	ID           uint      `json:"id" gorm:"primary_key;autoIncrement"`
+	UserID       uint      `json:"userId"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the PasswordHistory model
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
@@ -0,0 +1,17 @@
+package entities
+
+import "time"
+
+// RecoveryCode is a hashed one-time code a user can use instead of a TOTP code
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primary_key;autoIncrement"`
+	UserID    uint       `json:"userId"`
+	CodeHash  string     `json:"-"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the RecoveryCode model
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+// ReplayCacheEntry remembers a one-time identifier, such as the jti of a client assertion,
+// until it expires so the same message cannot be accepted twice
+type ReplayCacheEntry struct {
+	Key       string `gorm:"primaryKey"`
+	ExpiresAt time.Time
+}
+
+// TableName specifies the table name for the ReplayCacheEntry model
+func (ReplayCacheEntry) TableName() string {
+	return "replay_cache"
+}
@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+// RevokedToken is the ID of an access token revoked before its expiry. Entries are only
+// needed until the token would have expired anyway.
+type RevokedToken struct {
+	TokenID   string    `json:"tokenId" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TableName specifies the table name for the RevokedToken model
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
@@ -0,0 +1,33 @@
+package entities
+
+import "time"
+
+// Session is a login of a user on one device. Access tokens carry its ID, so revoking
+// the session invalidates every token issued for it.
+type Session struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"userId"`
+	UserAgent  string     `json:"userAgent"`
+	IPAddress  string     `json:"ipAddress"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastSeenAt time.Time  `json:"lastSeenAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	// AuthMethods are the space-separated authentication methods (RFC 8176) of the latest
+	// authentication, e.g. "pwd otp mfa", and AuthenticatedAt is when it happened. Both start with
+	// the login and are updated by a step-up.
+	AuthMethods     string    `json:"authMethods,omitempty"`
+	AuthenticatedAt time.Time `json:"authenticatedAt"`
+	// ClientID is set for sessions created by authorizing an OAuth client; revoking such a
+	// session revokes the client's access and refresh tokens
+	ClientID string `json:"clientId,omitempty"`
+	// ImpersonatorID is set for sessions an administrator started to act as the user
+	ImpersonatorID *uint `json:"impersonatorId,omitempty"`
+	// Current marks the session of the request listing the sessions
+	Current bool `json:"current" gorm:"-"`
+}
+
+// TableName specifies the table name for the Session model
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,20 @@
+package entities
+
+import "time"
+
+// TOTPCredential holds a user's authenticator app secret.
+// The secret is encrypted at rest and only active once ConfirmedAt is set.
+type TOTPCredential struct {
+	UserID          uint       `json:"userId" gorm:"primaryKey"`
+	SecretEncrypted string     `json:"-"`
+	ConfirmedAt     *time.Time `json:"confirmedAt,omitempty"`
+	// LastUsedStep is the last accepted 30-second time step, used to reject replayed codes
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// TableName specifies the table name for the TOTPCredential model
+func (TOTPCredential) TableName() string {
+	return "user_totp_credentials"
+}
@@ -9,7 +9,18 @@ type User struct {
 	CreatedAt time.Time `json:"createdAt" gorm:"column:createdAt"`
 	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updatedAt"`
 	Email     string    `json:"email" gorm:"unique"`
-	Roles     []Role    `json:"roles,omitempty" gorm:"many2many:user_roles;"`
+	// EmailVerified is set once the user follows the link sent to Email
+	EmailVerified   bool       `json:"emailVerified"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	// IsServiceAccount marks non-human principals that authenticate through OAuth clients, never with a password
+	IsServiceAccount bool `json:"isServiceAccount"`
+	// ExternalID is the identifier a provisioning identity provider knows the user by
+	ExternalID string `json:"externalId,omitempty"`
+	// DeactivatedAt is set while the user may not log in, e.g. after leaving the organisation
+	DeactivatedAt *time.Time `json:"deactivatedAt,omitempty"`
+	// Tenant is the tenant the user was invited into, if any
+	Tenant string `json:"tenant,omitempty"`
+	Roles  []Role `json:"roles,omitempty" gorm:"many2many:user_roles;"`
 }
 
 // TableName specifies the table name for the User model
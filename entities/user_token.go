@@ -0,0 +1,38 @@
+package entities
+
+import "time"
+
+// Purposes a UserToken can be issued for
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeMFAChallenge      = "mfa_challenge"
+	// TokenPurposeMFAEnrollment is an MFA challenge that only allows enrolling a first second factor
+	TokenPurposeMFAEnrollment = "mfa_enrollment"
+	TokenPurposeMagicLink     = "magic_link"
+)
+
+// UserToken represents a single-use token issued to a user for a specific purpose.
+// Only the SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primary_key;autoIncrement"`
+	UserID    uint       `json:"userId"`
+	Purpose   string     `json:"purpose"`
+	TokenHash string     `json:"-" gorm:"unique"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	// Attempts counts failed redemptions for tokens that accept a second secret (e.g. MFA challenges)
+	Attempts int `json:"attempts"`
+	// BindingHash is the hash of a secret held by the device the token was requested from, for
+	// tokens that may only be redeemed there (e.g. magic links)
+	BindingHash string `json:"-"`
+	// AuthMethods are the space-separated methods (RFC 8176) a login had passed when the token was
+	// issued, for MFA challenges
+	AuthMethods string    `json:"authMethods,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for the UserToken model
+func (UserToken) TableName() string {
+	return "user_tokens"
+}
@@ -0,0 +1,47 @@
+package entities
+
+import "time"
+
+// WebAuthn ceremonies a challenge can be issued for
+const (
+	WebAuthnCeremonyRegistration   = "registration"
+	WebAuthnCeremonyAuthentication = "authentication"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user
+type WebAuthnCredential struct {
+	ID uint `json:"id" gorm:"primary_key;autoIncrement"`
+	// CredentialID is the base64url encoded credential ID chosen by the authenticator
+	CredentialID string `json:"credentialId" gorm:"unique"`
+	UserID       uint   `json:"userId"`
+	Name         string `json:"name"`
+	// PublicKey is the COSE encoded credential public key
+	PublicKey         []byte     `json:"-"`
+	SignCount         int64      `json:"signCount"`
+	AAGUID            string     `json:"aaguid" gorm:"column:aaguid"`
+	AttestationFormat string     `json:"attestationFormat"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	LastUsedAt        *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// TableName specifies the table name for the WebAuthnCredential model
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// WebAuthnChallenge is a pending registration or authentication ceremony.
+// UserID is empty for usernameless (discoverable credential) logins.
+type WebAuthnChallenge struct {
+	ID            uint       `json:"id" gorm:"primary_key;autoIncrement"`
+	UserID        *uint      `json:"userId,omitempty"`
+	Ceremony      string     `json:"ceremony"`
+	ChallengeHash string     `json:"-" gorm:"unique"`
+	ExpiresAt     time.Time  `json:"expiresAt"`
+	UsedAt        *time.Time `json:"usedAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for the WebAuthnChallenge model
+func (WebAuthnChallenge) TableName() string {
+	return "webauthn_challenges"
+}
@@ -0,0 +1,48 @@
+package initializers
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// GetFederationProviders reads the identity providers named in FEDERATION_PROVIDERS. Each provider
+// "name" is configured with FEDERATION_NAME_* variables, e.g. FEDERATION_GOOGLE_ISSUER.
+func GetFederationProviders() []services.FederationProviderConfig {
+	var providers []services.FederationProviderConfig
+	for _, name := range GetEnvAsList("FEDERATION_PROVIDERS", nil) {
+		// Directory accounts are linked under the same table, so the name cannot be shared
+		if name == services.LDAPIdentitySource {
+			log.Fatalf("Identity provider name %q is reserved", name)
+		}
+		prefix := "FEDERATION_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		provider := services.FederationProviderConfig{
+			Name:          name,
+			Issuer:        os.Getenv(prefix + "ISSUER"),
+			ClientID:      os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:  os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:        GetEnvAsList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+			AutoProvision: GetEnvAsBool(prefix+"AUTO_PROVISION", false),
+			TrustEmail:    GetEnvAsBool(prefix+"TRUST_EMAIL", false),
+			GroupsClaim:   GetEnvWithDefault(prefix+"GROUPS_CLAIM", "groups"),
+			GroupRoles:    make(map[string][]string),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Fatalf("Identity provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		// Mappings are "group=role" pairs; a group mapped to several roles is listed once per role
+		for _, mapping := range GetEnvAsList(prefix+"GROUP_ROLES", nil) {
+			group, role, found := strings.Cut(mapping, "=")
+			if !found || group == "" || role == "" {
+				log.Fatalf("Invalid group mapping %q for identity provider %s, expected group=role", mapping, name)
+			}
+			provider.GroupRoles[group] = append(provider.GroupRoles[group], role)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
@@ -0,0 +1,68 @@
+package initializers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// GetLDAPConfig reads the LDAP directory configuration from LDAP_* variables
+func GetLDAPConfig() services.LDAPConfig {
+	config := services.LDAPConfig{
+		URL:               os.Getenv("LDAP_URL"),
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+		BindDN:            os.Getenv("LDAP_BIND_DN"),
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:            os.Getenv("LDAP_BASE_DN"),
+		UserFilter:        GetEnvWithDefault("LDAP_USER_FILTER", "(uid=%s)"),
+		UsernameAttribute: GetEnvWithDefault("LDAP_USERNAME_ATTRIBUTE", "uid"),
+		EmailAttribute:    GetEnvWithDefault("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		IDAttribute:       GetEnvWithDefault("LDAP_ID_ATTRIBUTE", "entryUUID"),
+		GroupAttribute:    GetEnvWithDefault("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		GroupRoles:        make(map[string][]string),
+		AutoProvision:     GetEnvAsBool("LDAP_AUTO_PROVISION", true),
+		PoolSize:          GetEnvAsInt("LDAP_POOL_SIZE", 4),
+		Timeout:           GetEnvAsDuration("LDAP_TIMEOUT", 5*time.Second),
+	}
+	if config.URL == "" || config.BaseDN == "" || config.BindDN == "" {
+		log.Fatal("The ldap authenticator needs LDAP_URL, LDAP_BASE_DN and LDAP_BIND_DN")
+	}
+	if strings.Count(config.UserFilter, "%s") != 1 {
+		log.Fatalf("LDAP_USER_FILTER %q must contain %%s exactly once", config.UserFilter)
+	}
+
+	// ldap:// connections are upgraded with StartTLS unless TLS is turned off explicitly, since the
+	// passwords of users are sent with every login
+	ldaps := strings.HasPrefix(strings.ToLower(config.URL), "ldaps://")
+	config.StartTLS = GetEnvAsBool("LDAP_START_TLS", !ldaps)
+	if !ldaps && !config.StartTLS && !GetEnvAsBool("LDAP_INSECURE", false) {
+		log.Fatal("LDAP_START_TLS=false would send passwords to the directory in the clear; use ldaps:// or set LDAP_INSECURE=true")
+	}
+
+	if caFile := os.Getenv("LDAP_CA_FILE"); caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Error reading LDAP_CA_FILE: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			log.Fatalf("LDAP_CA_FILE %s contains no certificates", caFile)
+		}
+		config.TLSConfig.RootCAs = roots
+	}
+
+	// Mappings are "group=role" pairs like FEDERATION_<NAME>_GROUP_ROLES; groups are named by cn
+	for _, mapping := range GetEnvAsList("LDAP_GROUP_ROLES", nil) {
+		group, role, found := strings.Cut(mapping, "=")
+		if !found || group == "" || role == "" {
+			log.Fatalf("Invalid LDAP group mapping %q, expected group=role", mapping)
+		}
+		config.GroupRoles[group] = append(config.GroupRoles[group], role)
+	}
+	return config
+}
@@ -2,7 +2,11 @@ package initializers
 
 import (
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,3 +25,79 @@ func GetEnvWithDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// GetEnvAsInt returns environment variable value as an int or default if not set or invalid
+func GetEnvAsInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %v, using default %d", key, err, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// GetEnvAsBool returns environment variable value as a bool or default if not set or invalid
+func GetEnvAsBool(key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s: %v, using default %t", key, err, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// GetEnvAsDuration returns environment variable value as a duration (e.g. "30m") or default if not set or invalid
+func GetEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %v, using default %s", key, err, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// GetEnvAsList returns a comma-separated environment variable as a trimmed list, skipping empty items
+func GetEnvAsList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetEnvAsSameSite returns a cookie SameSite mode ("lax", "strict" or "none") or default if not set or invalid
+func GetEnvAsSameSite(key string, defaultValue http.SameSite) http.SameSite {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		log.Printf("Invalid SameSite mode for %s: %s, using default", key, value)
+		return defaultValue
+	}
+}
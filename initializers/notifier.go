@@ -0,0 +1,33 @@
+package initializers
+
+import (
+	"log"
+	"os"
+
+	"github.com/vladimirteddy/go-authentication/notifiers"
+)
+
+// NewNotifier builds the notifier selected by the NOTIFIER environment variable.
+// Supported values are "smtp" and "log" (the default), which writes to NOTIFIER_LOG_FILE or stdout.
+func NewNotifier() notifiers.Notifier {
+	switch GetEnvWithDefault("NOTIFIER", "log") {
+	case "smtp":
+		return notifiers.NewSMTPNotifier(notifiers.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     GetEnvWithDefault("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	default:
+		path := os.Getenv("NOTIFIER_LOG_FILE")
+		if path == "" {
+			return notifiers.NewLogNotifier(os.Stdout)
+		}
+		notifier, err := notifiers.NewFileNotifier(path)
+		if err != nil {
+			log.Fatal("Failed to open notifier log file: ", err)
+		}
+		return notifier
+	}
+}
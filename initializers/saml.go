@@ -0,0 +1,127 @@
+package initializers
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vladimirteddy/go-authentication/saml"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// GetSAMLProviders reads the SAML identity providers named in SAML_PROVIDERS. Each provider "name"
+// is configured with SAML_NAME_* variables, either from its metadata in SAML_NAME_METADATA_FILE or
+// from SAML_NAME_ENTITY_ID, SAML_NAME_SSO_URL and SAML_NAME_CERT_FILE.
+func GetSAMLProviders() []services.SAMLProviderConfig {
+	var providers []services.SAMLProviderConfig
+	for _, name := range GetEnvAsList("SAML_PROVIDERS", nil) {
+		prefix := "SAML_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		provider := services.SAMLProviderConfig{
+			Name:              name,
+			IdentityProvider:  getSAMLIdentityProvider(name, prefix),
+			NameIDFormat:      GetEnvWithDefault(prefix+"NAME_ID_FORMAT", saml.NameIDFormatPersistent),
+			AutoProvision:     GetEnvAsBool(prefix+"AUTO_PROVISION", false),
+			TrustEmail:        GetEnvAsBool(prefix+"TRUST_EMAIL", false),
+			EmailAttribute:    GetEnvWithDefault(prefix+"EMAIL_ATTRIBUTE", "email"),
+			UsernameAttribute: GetEnvWithDefault(prefix+"USERNAME_ATTRIBUTE", ""),
+			RoleAttribute:     GetEnvWithDefault(prefix+"ROLE_ATTRIBUTE", ""),
+			AttributeRoles:    make(map[string][]string),
+		}
+		if provider.NameIDFormat == saml.NameIDFormatTransient {
+			log.Fatalf("SAML provider %s cannot use transient NameIDs, which do not identify returning users", name)
+		}
+
+		// Mappings are "value=role" pairs of the role attribute, like FEDERATION_<NAME>_GROUP_ROLES
+		for _, mapping := range GetEnvAsList(prefix+"ATTRIBUTE_ROLES", nil) {
+			value, role, found := strings.Cut(mapping, "=")
+			if !found || value == "" || role == "" {
+				log.Fatalf("Invalid attribute mapping %q for SAML provider %s, expected value=role", mapping, name)
+			}
+			provider.AttributeRoles[value] = append(provider.AttributeRoles[value], role)
+		}
+		if len(provider.AttributeRoles) > 0 && provider.RoleAttribute == "" {
+			log.Fatalf("SAML provider %s maps %sATTRIBUTE_ROLES but sets no %sROLE_ATTRIBUTE", name, prefix, prefix)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func getSAMLIdentityProvider(name, prefix string) *saml.IdentityProvider {
+	if metadataFile := os.Getenv(prefix + "METADATA_FILE"); metadataFile != "" {
+		metadata, err := os.ReadFile(metadataFile)
+		if err != nil {
+			log.Fatalf("Error reading %sMETADATA_FILE: %v", prefix, err)
+		}
+		provider, err := saml.ParseIdentityProviderMetadata(metadata)
+		if err != nil {
+			log.Fatalf("Invalid metadata for SAML provider %s: %v", name, err)
+		}
+		return provider
+	}
+
+	provider := &saml.IdentityProvider{
+		EntityID: os.Getenv(prefix + "ENTITY_ID"),
+		SSOURL:   os.Getenv(prefix + "SSO_URL"),
+	}
+	certFile := os.Getenv(prefix + "CERT_FILE")
+	if provider.EntityID == "" || provider.SSOURL == "" || certFile == "" {
+		log.Fatalf("SAML provider %s needs %sMETADATA_FILE, or %sENTITY_ID, %sSSO_URL and %sCERT_FILE", name, prefix, prefix, prefix, prefix)
+	}
+	provider.Certificates = readCertificates(certFile)
+	return provider
+}
+
+// GetSAMLCertificate returns the certificate identity providers verify AuthnRequests with. It is
+// read from SAML_SP_CERT_FILE, or else issued for key itself, which providers that check the
+// certificate chain will not accept.
+func GetSAMLCertificate(key *rsa.PrivateKey, entityID string) *x509.Certificate {
+	certFile := os.Getenv("SAML_SP_CERT_FILE")
+	if certFile == "" {
+		certificate, err := saml.NewCertificate(key, entityID)
+		if err != nil {
+			log.Fatalf("Error creating SAML certificate: %v", err)
+		}
+		return certificate
+	}
+
+	certificate := readCertificates(certFile)[0]
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok || !publicKey.Equal(&key.PublicKey) {
+		log.Fatalf("SAML_SP_CERT_FILE %s does not match SAML_SP_KEY_FILE", certFile)
+	}
+	return certificate
+}
+
+// readCertificates reads the PEM encoded certificates in a file, exiting when there are none
+func readCertificates(path string) []*x509.Certificate {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error reading certificate file %s: %v", path, err)
+	}
+
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			log.Fatalf("Invalid certificate in %s: %v", path, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		log.Fatalf("Certificate file %s contains no certificates", path)
+	}
+	return certificates
+}
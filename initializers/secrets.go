@@ -0,0 +1,19 @@
+package initializers
+
+import (
+	"crypto/sha256"
+	"log"
+	"os"
+)
+
+// GetEncryptionKey returns the AES-256 key used to encrypt secrets at rest (e.g. TOTP secrets).
+// It is derived from ENCRYPTION_KEY, falling back to SECRET_JWT when that is not set.
+func GetEncryptionKey() []byte {
+	passphrase := os.Getenv("ENCRYPTION_KEY")
+	if passphrase == "" {
+		log.Println("ENCRYPTION_KEY is not set, deriving the encryption key from SECRET_JWT")
+		passphrase = os.Getenv("SECRET_JWT")
+	}
+	sum := sha256.Sum256([]byte(passphrase))
+	return sum[:]
+}
@@ -0,0 +1,29 @@
+package initializers
+
+import (
+	"os"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// GetSignupConfig reads the signup policy from SIGNUP_* variables. The email domain
+// check runs before the webhook, so rejected signups are never posted.
+func GetSignupConfig() services.SignupConfig {
+	config := services.SignupConfig{
+		Enabled:      GetEnvAsBool("SIGNUP_ENABLED", true),
+		DefaultRoles: GetEnvAsList("SIGNUP_DEFAULT_ROLES", []string{"user"}),
+	}
+
+	if domains := GetEnvAsList("SIGNUP_ALLOWED_EMAIL_DOMAINS", nil); len(domains) > 0 {
+		config.Hooks = append(config.Hooks, services.NewEmailDomainHook(domains))
+	}
+	if webhookURL := os.Getenv("SIGNUP_WEBHOOK_URL"); webhookURL != "" {
+		config.Hooks = append(config.Hooks, services.NewSignupWebhook(services.SignupWebhookConfig{
+			URL:     webhookURL,
+			Secret:  os.Getenv("SIGNUP_WEBHOOK_SECRET"),
+			Timeout: GetEnvAsDuration("SIGNUP_WEBHOOK_TIMEOUT", 5*time.Second),
+		}, nil))
+	}
+	return config
+}
@@ -0,0 +1,222 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// BER classes and the universal tags LDAP uses (X.690)
+const (
+	classUniversal   byte = 0x00
+	classApplication byte = 0x40
+	classContext     byte = 0x80
+
+	tagBoolean     byte = 1
+	tagInteger     byte = 2
+	tagOctetString byte = 4
+	tagNull        byte = 5
+	tagEnumerated  byte = 10
+	tagSequence    byte = 16
+	tagSet         byte = 17
+)
+
+// maxPacketSize bounds a single LDAP message
+const maxPacketSize = 1 << 20
+
+var errMalformedPacket = errors.New("ldap: malformed BER packet")
+
+// packet is a BER element. Constructed elements have children, primitive ones a value.
+type packet struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*packet
+}
+
+func newSequence(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSequence, children: children}
+}
+
+func newSet(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSet, children: children}
+}
+
+func newConstructed(class, tag byte, children ...*packet) *packet {
+	return &packet{class: class, constructed: true, tag: tag, children: children}
+}
+
+func newPrimitive(class, tag byte, value []byte) *packet {
+	return &packet{class: class, tag: tag, value: value}
+}
+
+func newOctetString(value string) *packet {
+	return newPrimitive(classUniversal, tagOctetString, []byte(value))
+}
+
+func newInteger(value int64) *packet {
+	return newPrimitive(classUniversal, tagInteger, encodeInt(value))
+}
+
+func newEnumerated(value int64) *packet {
+	return newPrimitive(classUniversal, tagEnumerated, encodeInt(value))
+}
+
+func newBoolean(value bool) *packet {
+	if value {
+		return newPrimitive(classUniversal, tagBoolean, []byte{0xff})
+	}
+	return newPrimitive(classUniversal, tagBoolean, []byte{0x00})
+}
+
+func (p *packet) is(class, tag byte) bool {
+	return p.class == class && p.tag == tag
+}
+
+// int decodes an INTEGER or ENUMERATED value
+func (p *packet) int() (int64, error) {
+	if p.constructed || len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errMalformedPacket
+	}
+	var value int64
+	if p.value[0]&0x80 != 0 {
+		value = -1
+	}
+	for _, b := range p.value {
+		value = value<<8 | int64(b)
+	}
+	return value, nil
+}
+
+func (p *packet) string() string {
+	return string(p.value)
+}
+
+// bytes encodes the packet with definite lengths
+func (p *packet) bytes() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+
+	identifier := p.class | p.tag
+	if p.constructed {
+		identifier |= 0x20
+	}
+	encoded := append([]byte{identifier}, encodeLength(len(content))...)
+	return append(encoded, content...)
+}
+
+// readPacket reads one complete element from r
+func readPacket(r *bufio.Reader) (*packet, error) {
+	identifier, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{identifier, first}
+	if first&0x80 != 0 {
+		lengthBytes := make([]byte, first&0x7f)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		header = append(header, lengthBytes...)
+	}
+	length, headerSize, err := decodeHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxPacketSize {
+		return nil, errors.New("ldap: message too large")
+	}
+
+	data := make([]byte, headerSize+length)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[headerSize:]); err != nil {
+		return nil, err
+	}
+	p, _, err := decodePacket(data)
+	return p, err
+}
+
+// decodePacket decodes the element at the start of data and returns its encoded size
+func decodePacket(data []byte) (*packet, int, error) {
+	length, headerSize, err := decodeHeader(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	end := headerSize + length
+	if end > len(data) {
+		return nil, 0, errMalformedPacket
+	}
+
+	p := &packet{
+		class:       data[0] & 0xc0,
+		constructed: data[0]&0x20 != 0,
+		tag:         data[0] & 0x1f,
+	}
+	content := data[headerSize:end]
+	if !p.constructed {
+		p.value = content
+		return p, end, nil
+	}
+	for len(content) > 0 {
+		child, size, err := decodePacket(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		p.children = append(p.children, child)
+		content = content[size:]
+	}
+	return p, end, nil
+}
+
+// decodeHeader returns the content length and header size of the element at the start of data.
+// Multi-byte tags and indefinite lengths are not used by LDAP and are rejected.
+func decodeHeader(data []byte) (int, int, error) {
+	if len(data) < 2 || data[0]&0x1f == 0x1f {
+		return 0, 0, errMalformedPacket
+	}
+	first := data[1]
+	if first&0x80 == 0 {
+		return int(first), 2, nil
+	}
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 || len(data) < 2+count {
+		return 0, 0, errMalformedPacket
+	}
+	length := 0
+	for _, b := range data[2 : 2+count] {
+		length = length<<8 | int(b)
+	}
+	return length, 2 + count, nil
+}
+
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var encoded []byte
+	for length > 0 {
+		encoded = append([]byte{byte(length)}, encoded...)
+		length >>= 8
+	}
+	return append([]byte{0x80 | byte(len(encoded))}, encoded...)
+}
+
+// encodeInt encodes a two's complement integer in the fewest bytes
+func encodeInt(value int64) []byte {
+	encoded := []byte{byte(value)}
+	for value > 127 || value < -128 {
+		value >>= 8
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+	return encoded
+}
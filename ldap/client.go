@@ -0,0 +1,354 @@
+// Package ldap implements the subset of LDAPv3 (RFC 4511) needed to authenticate users against
+// a directory such as OpenLDAP or Active Directory: simple binds, searches and StartTLS.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result codes (RFC 4511 appendix A)
+const (
+	ResultSuccess            = 0
+	ResultProtocolError      = 2
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+	ResultUnwillingToPerform = 53
+)
+
+// Protocol operation tags
+const (
+	opBindRequest       byte = 0
+	opBindResponse      byte = 1
+	opUnbindRequest     byte = 2
+	opSearchRequest     byte = 3
+	opSearchEntry       byte = 4
+	opSearchDone        byte = 5
+	opSearchReference   byte = 19
+	opExtendedRequest   byte = 23
+	opExtendedResponse  byte = 24
+	startTLSRequestName      = "1.3.6.1.4.1.1466.20037"
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// ResultError is a non-success result returned by the server
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// IsResultCode reports whether err is a ResultError with code
+func IsResultCode(err error, code int64) bool {
+	var resultError *ResultError
+	return errors.As(err, &resultError) && resultError.Code == code
+}
+
+// Entry is a directory entry returned by a search
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// GetAll returns the values of an attribute, matching its name case-insensitively
+func (e *Entry) GetAll(attribute string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Get returns the first value of an attribute, or "" when it has none
+func (e *Entry) Get(attribute string) string {
+	if values := e.GetAll(attribute); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// SearchRequest describes a search
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     *Filter
+	Attributes []string
+	// SizeLimit caps the number of entries the server returns; 0 means no limit
+	SizeLimit int
+}
+
+// Conn is a connection to a directory server. It is not safe for concurrent use.
+type Conn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	host      string
+	messageID int64
+	timeout   time.Duration
+	// broken is set after a network or protocol failure; the connection must not be reused
+	broken bool
+}
+
+// Dial connects to an ldap:// or ldaps:// URL. tlsConfig is used for ldaps; its ServerName
+// defaults to the host of the URL.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	serverURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid server URL: %w", err)
+	}
+
+	host := serverURL.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch serverURL.Scheme {
+	case "ldap":
+		if serverURL.Port() == "" {
+			host = net.JoinHostPort(serverURL.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if serverURL.Port() == "" {
+			host = net.JoinHostPort(serverURL.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, withServerName(tlsConfig, serverURL.Hostname()))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", serverURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		host:    serverURL.Hostname(),
+		timeout: timeout,
+	}, nil
+}
+
+// StartTLS upgrades the connection to TLS (RFC 4511 section 4.14)
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	request := newConstructed(classApplication, opExtendedRequest,
+		newPrimitive(classContext, 0, []byte(startTLSRequestName)),
+	)
+	response, err := c.roundTrip(request, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultError(response); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	if err := c.setDeadline(); err != nil {
+		return err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		c.broken = true
+		return fmt.Errorf("ldap: TLS handshake failed: %w", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind authenticates the connection with a DN and password. Empty passwords are rejected, as
+// servers treat them as an unauthenticated bind that always succeeds (RFC 4513 section 5.1.2).
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &ResultError{Code: ResultInvalidCredentials, Message: "empty password"}
+	}
+
+	request := newConstructed(classApplication, opBindRequest,
+		newInteger(3),
+		newOctetString(dn),
+		newPrimitive(classContext, 0, []byte(password)),
+	)
+	response, err := c.roundTrip(request, opBindResponse)
+	if err != nil {
+		return err
+	}
+	return resultError(response)
+}
+
+// Search returns the entries matching a request
+func (c *Conn) Search(request *SearchRequest) ([]*Entry, error) {
+	filter, err := request.Filter.encode()
+	if err != nil {
+		return nil, err
+	}
+	attributes := newSequence()
+	for _, attribute := range request.Attributes {
+		attributes.children = append(attributes.children, newOctetString(attribute))
+	}
+	message := newConstructed(classApplication, opSearchRequest,
+		newOctetString(request.BaseDN),
+		newEnumerated(int64(request.Scope)),
+		newEnumerated(0),
+		newInteger(int64(request.SizeLimit)),
+		newInteger(int64(c.timeout.Seconds())),
+		newBoolean(false),
+		filter,
+		attributes,
+	)
+
+	messageID, err := c.send(message)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for {
+		op, err := c.receive(messageID)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case op.is(classApplication, opSearchEntry):
+			entry, err := decodeEntry(op)
+			if err != nil {
+				c.broken = true
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case op.is(classApplication, opSearchReference):
+			// Referrals to other servers are not followed
+		case op.is(classApplication, opSearchDone):
+			if err := resultError(op); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			c.broken = true
+			return nil, errors.New("ldap: unexpected response to search")
+		}
+	}
+}
+
+// Close ends the session and closes the connection
+func (c *Conn) Close() error {
+	if !c.broken {
+		_, _ = c.send(newPrimitive(classApplication, opUnbindRequest, nil))
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) roundTrip(request *packet, responseTag byte) (*packet, error) {
+	messageID, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.receive(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if !response.is(classApplication, responseTag) {
+		c.broken = true
+		return nil, errors.New("ldap: unexpected response")
+	}
+	return response, nil
+}
+
+func (c *Conn) send(operation *packet) (int64, error) {
+	c.messageID++
+	message := newSequence(newInteger(c.messageID), operation)
+	if err := c.setDeadline(); err != nil {
+		return 0, err
+	}
+	if _, err := c.conn.Write(message.bytes()); err != nil {
+		c.broken = true
+		return 0, err
+	}
+	return c.messageID, nil
+}
+
+// receive reads the next message, which must belong to messageID, and returns its operation
+func (c *Conn) receive(messageID int64) (*packet, error) {
+	if err := c.setDeadline(); err != nil {
+		return nil, err
+	}
+	message, err := readPacket(c.reader)
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	if len(message.children) < 2 {
+		c.broken = true
+		return nil, errMalformedPacket
+	}
+	id, err := message.children[0].int()
+	if err != nil || id != messageID {
+		c.broken = true
+		return nil, errors.New("ldap: response to an unknown message")
+	}
+	return message.children[1], nil
+}
+
+func (c *Conn) setDeadline() error {
+	if c.timeout == 0 {
+		return nil
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		c.broken = true
+		return err
+	}
+	return nil
+}
+
+// resultError reads the LDAPResult at the start of a response
+func resultError(response *packet) error {
+	if len(response.children) < 3 {
+		return errMalformedPacket
+	}
+	code, err := response.children[0].int()
+	if err != nil {
+		return err
+	}
+	if code != ResultSuccess {
+		return &ResultError{Code: code, Message: response.children[2].string()}
+	}
+	return nil
+}
+
+func decodeEntry(op *packet) (*Entry, error) {
+	if len(op.children) != 2 {
+		return nil, errMalformedPacket
+	}
+	entry := &Entry{DN: op.children[0].string(), Attributes: make(map[string][]string)}
+	for _, attribute := range op.children[1].children {
+		if len(attribute.children) != 2 {
+			return nil, errMalformedPacket
+		}
+		name := attribute.children[0].string()
+		for _, value := range attribute.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.string())
+		}
+	}
+	return entry, nil
+}
+
+func withServerName(tlsConfig *tls.Config, host string) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig
+}
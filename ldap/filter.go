@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Filter operators
+const (
+	FilterAnd      = '&'
+	FilterOr       = '|'
+	FilterNot      = '!'
+	FilterEquality = '='
+	FilterPresent  = '*'
+)
+
+// Filter context tags (RFC 4511 section 4.5.1)
+const (
+	filterTagAnd      byte = 0
+	filterTagOr       byte = 1
+	filterTagNot      byte = 2
+	filterTagEquality byte = 3
+	filterTagPresent  byte = 7
+)
+
+// Filter is a search filter. Only the operators directory logins need are supported:
+// and, or, not, equality and presence.
+type Filter struct {
+	Op        byte
+	Attribute string
+	Value     string
+	Children  []*Filter
+}
+
+// EscapeFilter escapes a value for use in a filter string (RFC 4515 section 3), so user input
+// cannot change the structure of a filter
+func EscapeFilter(value string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&escaped, "\\%02x", c)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+	return escaped.String()
+}
+
+// ParseFilter parses the string representation of a filter, e.g. "(&(objectClass=person)(uid=alice))"
+func ParseFilter(filter string) (*Filter, error) {
+	parsed, rest, err := parseFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return parsed, nil
+}
+
+func parseFilter(input string) (*Filter, string, error) {
+	if !strings.HasPrefix(input, "(") {
+		return nil, "", errors.New("ldap: filter must start with (")
+	}
+	input = input[1:]
+	if input == "" {
+		return nil, "", errors.New("ldap: unterminated filter")
+	}
+
+	filter := &Filter{}
+	switch input[0] {
+	case FilterAnd, FilterOr:
+		filter.Op = input[0]
+		input = input[1:]
+		for strings.HasPrefix(input, "(") {
+			child, rest, err := parseFilter(input)
+			if err != nil {
+				return nil, "", err
+			}
+			filter.Children = append(filter.Children, child)
+			input = rest
+		}
+	case FilterNot:
+		filter.Op = FilterNot
+		child, rest, err := parseFilter(input[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		filter.Children = []*Filter{child}
+		input = rest
+	default:
+		end := strings.IndexByte(input, ')')
+		if end < 0 {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		attribute, value, found := strings.Cut(input[:end], "=")
+		if !found || attribute == "" || strings.ContainsAny(attribute, "<>~:") {
+			return nil, "", fmt.Errorf("ldap: unsupported filter item %q", input[:end])
+		}
+		if value == "*" {
+			filter.Op = FilterPresent
+		} else {
+			if strings.Contains(value, "*") {
+				return nil, "", fmt.Errorf("ldap: substring filters are not supported: %q", input[:end])
+			}
+			unescaped, err := unescapeFilterValue(value)
+			if err != nil {
+				return nil, "", err
+			}
+			filter.Op = FilterEquality
+			filter.Value = unescaped
+		}
+		filter.Attribute = attribute
+		input = input[end:]
+	}
+
+	if !strings.HasPrefix(input, ")") {
+		return nil, "", errors.New("ldap: unterminated filter")
+	}
+	return filter, input[1:], nil
+}
+
+func unescapeFilterValue(value string) (string, error) {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", errors.New("ldap: invalid escape in filter value")
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", errors.New("ldap: invalid escape in filter value")
+		}
+		unescaped.Write(decoded)
+		i += 2
+	}
+	return unescaped.String(), nil
+}
+
+// Matches evaluates the filter against an entry. Attribute names and values compare case-insensitively.
+func (f *Filter) Matches(entry *Entry) bool {
+	switch f.Op {
+	case FilterAnd:
+		for _, child := range f.Children {
+			if !child.Matches(entry) {
+				return false
+			}
+		}
+		return true
+	case FilterOr:
+		for _, child := range f.Children {
+			if child.Matches(entry) {
+				return true
+			}
+		}
+		return false
+	case FilterNot:
+		return len(f.Children) == 1 && !f.Children[0].Matches(entry)
+	case FilterPresent:
+		return len(entry.GetAll(f.Attribute)) > 0
+	case FilterEquality:
+		for _, value := range entry.GetAll(f.Attribute) {
+			if strings.EqualFold(value, f.Value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func (f *Filter) encode() (*packet, error) {
+	switch f.Op {
+	case FilterAnd, FilterOr:
+		tag := filterTagAnd
+		if f.Op == FilterOr {
+			tag = filterTagOr
+		}
+		set := newConstructed(classContext, tag)
+		for _, child := range f.Children {
+			encoded, err := child.encode()
+			if err != nil {
+				return nil, err
+			}
+			set.children = append(set.children, encoded)
+		}
+		return set, nil
+	case FilterNot:
+		if len(f.Children) != 1 {
+			return nil, errors.New("ldap: not filter needs exactly one operand")
+		}
+		encoded, err := f.Children[0].encode()
+		if err != nil {
+			return nil, err
+		}
+		return newConstructed(classContext, filterTagNot, encoded), nil
+	case FilterEquality:
+		return newConstructed(classContext, filterTagEquality, newOctetString(f.Attribute), newOctetString(f.Value)), nil
+	case FilterPresent:
+		return newPrimitive(classContext, filterTagPresent, []byte(f.Attribute)), nil
+	default:
+		return nil, fmt.Errorf("ldap: unsupported filter operator %q", f.Op)
+	}
+}
+
+func decodeFilter(p *packet) (*Filter, error) {
+	if p.class != classContext {
+		return nil, errMalformedPacket
+	}
+	switch p.tag {
+	case filterTagAnd, filterTagOr:
+		filter := &Filter{Op: FilterAnd}
+		if p.tag == filterTagOr {
+			filter.Op = FilterOr
+		}
+		for _, child := range p.children {
+			decoded, err := decodeFilter(child)
+			if err != nil {
+				return nil, err
+			}
+			filter.Children = append(filter.Children, decoded)
+		}
+		return filter, nil
+	case filterTagNot:
+		if len(p.children) != 1 {
+			return nil, errMalformedPacket
+		}
+		child, err := decodeFilter(p.children[0])
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Op: FilterNot, Children: []*Filter{child}}, nil
+	case filterTagEquality:
+		if len(p.children) != 2 {
+			return nil, errMalformedPacket
+		}
+		return &Filter{Op: FilterEquality, Attribute: p.children[0].string(), Value: p.children[1].string()}, nil
+	case filterTagPresent:
+		return &Filter{Op: FilterPresent, Attribute: p.string()}, nil
+	default:
+		return nil, fmt.Errorf("ldap: unsupported filter type %d", p.tag)
+	}
+}
@@ -0,0 +1,39 @@
+package ldap
+
+// Pool keeps idle connections to a directory for reuse. Connections come back in whatever state
+// their last bind left them, so callers bind before every use.
+type Pool struct {
+	dial func() (*Conn, error)
+	idle chan *Conn
+}
+
+// NewPool creates a pool keeping up to size idle connections opened with dial
+func NewPool(size int, dial func() (*Conn, error)) *Pool {
+	return &Pool{
+		dial: dial,
+		idle: make(chan *Conn, size),
+	}
+}
+
+// Get returns an idle connection, or a new one when there is none
+func (p *Pool) Get() (*Conn, error) {
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+		return p.dial()
+	}
+}
+
+// Put returns a connection to the pool. Broken connections, and any beyond the pool size, are closed.
+func (p *Pool) Put(conn *Conn) {
+	if conn.broken {
+		conn.Close()
+		return
+	}
+	select {
+	case p.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TestEntry is an entry served by a TestServer. Entries with a Password accept simple binds.
+type TestEntry struct {
+	DN         string
+	Attributes map[string][]string
+	Password   string
+}
+
+// TestServer is a minimal in-process directory for tests and local development. It answers
+// simple binds, searches and StartTLS; everything else is refused.
+type TestServer struct {
+	listener  net.Listener
+	entries   []TestEntry
+	tlsConfig *tls.Config
+	wg        sync.WaitGroup
+	accepted  atomic.Int64
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// NewTestServer starts a server on a random local port. StartTLS is offered when tlsConfig is set.
+func NewTestServer(entries []TestEntry, tlsConfig *tls.Config) (*TestServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &TestServer{listener: listener, entries: entries, tlsConfig: tlsConfig, conns: make(map[net.Conn]bool)}
+	server.wg.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// URL returns the ldap:// URL of the server
+func (s *TestServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Connections returns the number of connections accepted so far
+func (s *TestServer) Connections() int {
+	return int(s.accepted.Load())
+}
+
+// Close stops the server, closing the connections clients still hold open, e.g. in a Pool
+func (s *TestServer) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *TestServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.accepted.Add(1)
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *TestServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := readPacket(reader)
+		if err != nil || len(message.children) < 2 {
+			return
+		}
+		messageID := message.children[0]
+		op := message.children[1]
+
+		reply := func(response *packet) error {
+			_, err := conn.Write(newSequence(messageID, response).bytes())
+			return err
+		}
+
+		switch {
+		case op.is(classApplication, opBindRequest):
+			if reply(s.bind(op)) != nil {
+				return
+			}
+		case op.is(classApplication, opSearchRequest):
+			for _, response := range s.search(op) {
+				if reply(response) != nil {
+					return
+				}
+			}
+		case op.is(classApplication, opExtendedRequest):
+			if len(op.children) == 0 || op.children[0].string() != startTLSRequestName || s.tlsConfig == nil {
+				_ = reply(result(opExtendedResponse, ResultProtocolError, "unsupported extended operation"))
+				continue
+			}
+			if reply(result(opExtendedResponse, ResultSuccess, "")) != nil {
+				return
+			}
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(tlsConn)
+		case op.is(classApplication, opUnbindRequest):
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (s *TestServer) bind(op *packet) *packet {
+	if len(op.children) != 3 || !op.children[2].is(classContext, 0) {
+		return result(opBindResponse, ResultUnwillingToPerform, "only simple binds are supported")
+	}
+	dn := op.children[1].string()
+	password := op.children[2].string()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) {
+			if entry.Password != "" && entry.Password == password {
+				return result(opBindResponse, ResultSuccess, "")
+			}
+			break
+		}
+	}
+	return result(opBindResponse, ResultInvalidCredentials, "invalid credentials")
+}
+
+func (s *TestServer) search(op *packet) []*packet {
+	if len(op.children) != 8 {
+		return []*packet{result(opSearchDone, ResultProtocolError, "malformed search")}
+	}
+	baseDN := op.children[0].string()
+	scope, err := op.children[1].int()
+	if err != nil {
+		return []*packet{result(opSearchDone, ResultProtocolError, "malformed search")}
+	}
+	filter, err := decodeFilter(op.children[6])
+	if err != nil {
+		return []*packet{result(opSearchDone, ResultProtocolError, err.Error())}
+	}
+	var requested []string
+	for _, attribute := range op.children[7].children {
+		requested = append(requested, attribute.string())
+	}
+
+	var responses []*packet
+	baseFound := false
+	for _, testEntry := range s.entries {
+		if strings.EqualFold(testEntry.DN, baseDN) {
+			baseFound = true
+		}
+		if !inScope(testEntry.DN, baseDN, scope) {
+			continue
+		}
+		entry := &Entry{DN: testEntry.DN, Attributes: testEntry.Attributes}
+		if !filter.Matches(entry) {
+			continue
+		}
+		responses = append(responses, encodeEntry(entry, requested))
+	}
+	if !baseFound {
+		return []*packet{result(opSearchDone, ResultNoSuchObject, "no such object")}
+	}
+	return append(responses, result(opSearchDone, ResultSuccess, ""))
+}
+
+func inScope(dn, baseDN string, scope int64) bool {
+	dn = strings.ToLower(dn)
+	baseDN = strings.ToLower(baseDN)
+	switch scope {
+	case ScopeBaseObject:
+		return dn == baseDN
+	case ScopeSingleLevel:
+		parent, found := parentDN(dn)
+		return found && parent == baseDN
+	case ScopeWholeSubtree:
+		return dn == baseDN || strings.HasSuffix(dn, ","+baseDN)
+	default:
+		return false
+	}
+}
+
+// parentDN drops the first RDN of a DN. Escaped commas are not handled, which is enough for tests.
+func parentDN(dn string) (string, bool) {
+	_, parent, found := strings.Cut(dn, ",")
+	return parent, found
+}
+
+func encodeEntry(entry *Entry, requested []string) *packet {
+	attributes := newSequence()
+	for name, values := range entry.Attributes {
+		if !attributeRequested(name, requested) {
+			continue
+		}
+		set := newSet()
+		for _, value := range values {
+			set.children = append(set.children, newOctetString(value))
+		}
+		attributes.children = append(attributes.children, newSequence(newOctetString(name), set))
+	}
+	return newConstructed(classApplication, opSearchEntry, newOctetString(entry.DN), attributes)
+}
+
+// attributeRequested applies the attribute list of a search; an empty list or "*" selects all
+func attributeRequested(name string, requested []string) bool {
+	if len(requested) == 0 {
+		return true
+	}
+	for _, attribute := range requested {
+		if attribute == "*" || strings.EqualFold(attribute, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func result(tag byte, code int64, message string) *packet {
+	return newConstructed(classApplication, tag,
+		newEnumerated(code),
+		newOctetString(""),
+		newOctetString(message),
+	)
+}
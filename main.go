@@ -1,14 +1,18 @@
 package main
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vladimirteddy/go-authentication/controllers"
+	"github.com/vladimirteddy/go-authentication/cookies"
 	"github.com/vladimirteddy/go-authentication/initializers"
 	"github.com/vladimirteddy/go-authentication/middlewares"
 	"github.com/vladimirteddy/go-authentication/repositories/postgres"
 	"github.com/vladimirteddy/go-authentication/services"
+	"github.com/vladimirteddy/go-authentication/webauthn"
 )
 
 func init() {
@@ -18,22 +22,243 @@ func init() {
 
 func main() {
 	router := gin.Default()
+	// Only honour X-Forwarded-For from known proxies, otherwise clients could spoof their address
+	if err := router.SetTrustedProxies(initializers.GetEnvAsList("TRUSTED_PROXIES", nil)); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
 
 	// Initialize repositories
 	userRepo := postgres.NewUserRepository(initializers.DB)
 	roleRepo := postgres.NewRoleRepository(initializers.DB)
 	permissionRepo := postgres.NewPermissionRepository(initializers.DB)
+	userTokenRepo := postgres.NewUserTokenRepository(initializers.DB)
+	mfaRepo := postgres.NewMFARepository(initializers.DB)
+	webAuthnRepo := postgres.NewWebAuthnRepository(initializers.DB)
+	loginAttemptRepo := postgres.NewLoginAttemptRepository(initializers.DB)
+	passwordHistoryRepo := postgres.NewPasswordHistoryRepository(initializers.DB)
+	sessionRepo := postgres.NewSessionRepository(initializers.DB)
+	apiKeyRepo := postgres.NewAPIKeyRepository(initializers.DB)
+	oauthClientRepo := postgres.NewOAuthClientRepository(initializers.DB)
+	replayCacheRepo := postgres.NewReplayCacheRepository(initializers.DB)
+	oauthAuthorizationCodeRepo := postgres.NewOAuthAuthorizationCodeRepository(initializers.DB)
+	oauthRefreshTokenRepo := postgres.NewOAuthRefreshTokenRepository(initializers.DB)
+	oauthConsentRepo := postgres.NewOAuthConsentRepository(initializers.DB)
+	revokedTokenRepo := postgres.NewRevokedTokenRepository(initializers.DB)
+	oauthDeviceCodeRepo := postgres.NewOAuthDeviceCodeRepository(initializers.DB)
+	federationRepo := postgres.NewFederationRepository(initializers.DB)
+	auditEventRepo := postgres.NewAuditEventRepository(initializers.DB)
+	invitationRepo := postgres.NewInvitationRepository(initializers.DB)
+
+	// Initialize notifier used for outbound emails
+	notifier := initializers.NewNotifier()
 
 	// Initialize services
-	userService := services.NewUserService(userRepo, roleRepo, permissionRepo)
+	passwordHasher, err := services.NewPasswordHasher(services.PasswordHashConfig{
+		Algorithm:         initializers.GetEnvWithDefault("PASSWORD_HASH_ALGORITHM", services.PasswordHashArgon2id),
+		BcryptCost:        initializers.GetEnvAsInt("PASSWORD_BCRYPT_COST", 12),
+		Argon2Memory:      uint32(initializers.GetEnvAsInt("PASSWORD_ARGON2_MEMORY", 64*1024)),
+		Argon2Iterations:  uint32(initializers.GetEnvAsInt("PASSWORD_ARGON2_ITERATIONS", 3)),
+		Argon2Parallelism: uint8(initializers.GetEnvAsInt("PASSWORD_ARGON2_PARALLELISM", 2)),
+		Argon2SaltLength:  16,
+		Argon2KeyLength:   32,
+	})
+	if err != nil {
+		log.Fatal("Invalid password hash configuration: ", err)
+	}
+	jwtSecret := initializers.GetEnvWithDefault("SECRET_JWT", "")
+	if jwtSecret == "" {
+		log.Fatal("SECRET_JWT must be set")
+	}
+	tokenService := services.NewTokenService(services.TokenConfig{
+		Secret: []byte(jwtSecret),
+	})
+	sessionService := services.NewSessionService(sessionRepo, userRepo, services.SessionConfig{
+		TTL:           initializers.GetEnvAsDuration("SESSION_TTL", 4*time.Hour),
+		TouchInterval: initializers.GetEnvAsDuration("SESSION_TOUCH_INTERVAL", time.Minute),
+	})
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo, userRepo, permissionRepo, services.APIKeyConfig{
+		TouchInterval: initializers.GetEnvAsDuration("SESSION_TOUCH_INTERVAL", time.Minute),
+	})
+	sessionCookies := cookies.NewManager(cookies.Config{
+		Enabled:    initializers.GetEnvAsBool("SESSION_COOKIE_ENABLED", false),
+		Name:       initializers.GetEnvWithDefault("SESSION_COOKIE_NAME", "session"),
+		CSRFName:   initializers.GetEnvWithDefault("CSRF_COOKIE_NAME", "csrf_token"),
+		CSRFHeader: initializers.GetEnvWithDefault("CSRF_HEADER_NAME", "X-CSRF-Token"),
+		Domain:     initializers.GetEnvWithDefault("SESSION_COOKIE_DOMAIN", ""),
+		Path:       "/",
+		Secure:     initializers.GetEnvAsBool("SESSION_COOKIE_SECURE", true),
+		SameSite:   initializers.GetEnvAsSameSite("SESSION_COOKIE_SAMESITE", http.SameSiteLaxMode),
+		TTL:        initializers.GetEnvAsDuration("SESSION_TTL", 4*time.Hour),
+		Secret:     []byte(jwtSecret),
+	})
+	var breachedPasswordChecker services.BreachedPasswordChecker
+	if path := initializers.GetEnvWithDefault("BREACHED_PASSWORDS_FILE", ""); path != "" {
+		checker, err := services.LoadBreachedPasswordFile(path, initializers.GetEnvAsInt("BREACHED_PASSWORDS_MIN_COUNT", 1))
+		if err != nil {
+			log.Fatal("Failed to load breached password list: ", err)
+		}
+		breachedPasswordChecker = checker
+	}
+	passwordPolicyService := services.NewPasswordPolicyService(userRepo, passwordHistoryRepo, breachedPasswordChecker, passwordHasher, services.PasswordPolicyConfig{
+		MinLength:        initializers.GetEnvAsInt("PASSWORD_MIN_LENGTH", 12),
+		MaxLength:        initializers.GetEnvAsInt("PASSWORD_MAX_LENGTH", 64),
+		RequireUppercase: initializers.GetEnvAsBool("PASSWORD_REQUIRE_UPPERCASE", false),
+		RequireLowercase: initializers.GetEnvAsBool("PASSWORD_REQUIRE_LOWERCASE", false),
+		RequireDigit:     initializers.GetEnvAsBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:    initializers.GetEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false),
+		DisallowUserInfo: initializers.GetEnvAsBool("PASSWORD_DISALLOW_USER_INFO", true),
+		HistorySize:      initializers.GetEnvAsInt("PASSWORD_HISTORY_SIZE", 5),
+	})
+	emailVerificationPolicy, err := services.ParseEmailVerificationPolicy(initializers.GetEnvWithDefault("EMAIL_VERIFICATION_POLICY", string(services.EmailVerificationOptional)))
+	if err != nil {
+		log.Fatal("Invalid EMAIL_VERIFICATION_POLICY: ", err)
+	}
+	emailVerificationService := services.NewEmailVerificationService(userRepo, userTokenRepo, notifier, services.EmailVerificationConfig{
+		Policy:          emailVerificationPolicy,
+		TokenTTL:        initializers.GetEnvAsDuration("EMAIL_VERIFICATION_TOKEN_TTL", 24*time.Hour),
+		VerifyURL:       initializers.GetEnvWithDefault("EMAIL_VERIFICATION_URL", "http://localhost:8080/auth/verify-email"),
+		ResendInterval:  initializers.GetEnvAsDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute),
+		MaxSendsPerHour: initializers.GetEnvAsInt("EMAIL_VERIFICATION_MAX_PER_HOUR", 5),
+	})
+	mfaService := services.NewMFAService(userRepo, roleRepo, mfaRepo, webAuthnRepo, userTokenRepo, services.MFAConfig{
+		Issuer:               initializers.GetEnvWithDefault("MFA_ISSUER", "go-authentication"),
+		EncryptionKey:        initializers.GetEncryptionKey(),
+		ChallengeTTL:         initializers.GetEnvAsDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+		MaxChallengeAttempts: initializers.GetEnvAsInt("MFA_MAX_CHALLENGE_ATTEMPTS", 5),
+		RecoveryCodeCount:    initializers.GetEnvAsInt("MFA_RECOVERY_CODE_COUNT", 10),
+	})
+	webAuthnService := services.NewWebAuthnService(userRepo, webAuthnRepo, mfaService, services.WebAuthnConfig{
+		RelyingParty: webauthn.RelyingParty{
+			ID:               initializers.GetEnvWithDefault("WEBAUTHN_RP_ID", "localhost"),
+			Name:             initializers.GetEnvWithDefault("WEBAUTHN_RP_NAME", "go-authentication"),
+			Origins:          initializers.GetEnvAsList("WEBAUTHN_ORIGINS", []string{"http://localhost:3000"}),
+			UserVerification: initializers.GetEnvWithDefault("WEBAUTHN_USER_VERIFICATION", webauthn.UserVerificationPreferred),
+		},
+		ChallengeTTL: initializers.GetEnvAsDuration("WEBAUTHN_CHALLENGE_TTL", 5*time.Minute),
+	})
+	signupConfig := initializers.GetSignupConfig()
+	var authenticators []services.Authenticator
+	for _, name := range initializers.GetEnvAsList("AUTHENTICATORS", []string{"local"}) {
+		switch name {
+		case "local":
+			authenticators = append(authenticators, services.NewLocalAuthenticator(userRepo, passwordHasher))
+		case "ldap":
+			authenticators = append(authenticators, services.NewLDAPAuthenticator(userRepo, roleRepo, federationRepo, signupConfig, initializers.GetLDAPConfig()))
+		default:
+			log.Fatalf("Unknown authenticator %q in AUTHENTICATORS", name)
+		}
+	}
+	userService := services.NewUserService(userRepo, roleRepo, permissionRepo, emailVerificationService, mfaService, passwordPolicyService, passwordHasher, sessionService, tokenService, authenticators, signupConfig)
+	oauthIssuer := initializers.GetEnvWithDefault("OAUTH_ISSUER", "http://localhost:8080")
+	oauthClientService := services.NewOAuthClientService(oauthClientRepo, userRepo, permissionRepo, sessionRepo, replayCacheRepo)
+	signingKeyFile := initializers.GetEnvWithDefault("OIDC_SIGNING_KEY_FILE", "")
+	if signingKeyFile == "" {
+		log.Println("OIDC_SIGNING_KEY_FILE is not set, ID tokens are signed with an ephemeral key")
+	}
+	signingKey, err := services.LoadSigningKey(signingKeyFile)
+	if err != nil {
+		log.Fatal("Invalid OIDC signing key: ", err)
+	}
+	oidcService := services.NewOIDCService(userService, oauthClientService, sessionRepo, services.OIDCConfig{
+		Issuer:     oauthIssuer,
+		SigningKey: signingKey,
+		IDTokenTTL: initializers.GetEnvAsDuration("OAUTH_ACCESS_TOKEN_TTL", time.Hour),
+	})
+	oauthService := services.NewOAuthService(oauthClientService, sessionService, oidcService, userRepo, roleRepo, sessionRepo, oauthAuthorizationCodeRepo, oauthRefreshTokenRepo, oauthConsentRepo, oauthDeviceCodeRepo, revokedTokenRepo, tokenService, services.OAuthConfig{
+		Issuer:           oauthIssuer,
+		AccessTokenTTL:   initializers.GetEnvAsDuration("OAUTH_ACCESS_TOKEN_TTL", time.Hour),
+		RefreshTokenTTL:  initializers.GetEnvAsDuration("OAUTH_REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		TokenExchangeTTL: initializers.GetEnvAsDuration("OAUTH_TOKEN_EXCHANGE_TTL", 5*time.Minute),
+		LoginURL:         initializers.GetEnvWithDefault("OAUTH_LOGIN_URL", ""),
+	})
+	serviceAccountService := services.NewServiceAccountService(userRepo, permissionRepo, apiKeyService)
+	authenticationService := services.NewAuthenticationService(tokenService, sessionService, apiKeyService, oauthService, revokedTokenRepo)
+	tokenIntrospectionService := services.NewTokenIntrospectionService(authenticationService, oauthClientService, tokenService, roleRepo, sessionRepo, oauthRefreshTokenRepo, revokedTokenRepo, services.TokenIntrospectionConfig{
+		Issuer: oauthIssuer,
+	})
+	loginAttemptStore := services.NewPostgresLoginAttemptStore(loginAttemptRepo)
+	if initializers.GetEnvWithDefault("LOGIN_ATTEMPT_STORE", "postgres") == "memory" {
+		loginAttemptStore = services.NewMemoryLoginAttemptStore()
+	}
+	loginThrottleService := services.NewLoginThrottleService(loginAttemptStore, services.LoginThrottleConfig{
+		MaxFailuresPerUser: initializers.GetEnvAsInt("LOGIN_MAX_FAILURES_PER_USER", 5),
+		MaxFailuresPerIP:   initializers.GetEnvAsInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		BaseLockout:        initializers.GetEnvAsDuration("LOGIN_BASE_LOCKOUT", time.Minute),
+		MaxLockout:         initializers.GetEnvAsDuration("LOGIN_MAX_LOCKOUT", time.Hour),
+		FailureWindow:      initializers.GetEnvAsDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+	})
+	federationService := services.NewFederationService(userService, userRepo, roleRepo, federationRepo, signupConfig, services.FederationConfig{
+		Providers:        initializers.GetFederationProviders(),
+		BaseURL:          oauthIssuer,
+		StateTTL:         initializers.GetEnvAsDuration("FEDERATION_STATE_TTL", 10*time.Minute),
+		DefaultReturnURL: initializers.GetEnvWithDefault("FEDERATION_DEFAULT_RETURN_URL", "/user/profile"),
+		ReturnOrigins:    initializers.GetEnvAsList("FEDERATION_RETURN_ORIGINS", nil),
+	})
+	samlKeyFile := initializers.GetEnvWithDefault("SAML_SP_KEY_FILE", "")
+	if samlKeyFile == "" {
+		log.Println("SAML_SP_KEY_FILE is not set, AuthnRequests are signed with an ephemeral key")
+	}
+	samlKey, err := services.LoadSigningKey(samlKeyFile)
+	if err != nil {
+		log.Fatal("Invalid SAML signing key: ", err)
+	}
+	samlService := services.NewSAMLService(userService, userRepo, roleRepo, federationRepo, replayCacheRepo, signupConfig, services.SAMLConfig{
+		Providers:        initializers.GetSAMLProviders(),
+		BaseURL:          oauthIssuer,
+		SigningKey:       samlKey,
+		Certificate:      initializers.GetSAMLCertificate(samlKey, oauthIssuer),
+		StateTTL:         initializers.GetEnvAsDuration("SAML_STATE_TTL", 10*time.Minute),
+		DefaultReturnURL: initializers.GetEnvWithDefault("FEDERATION_DEFAULT_RETURN_URL", "/user/profile"),
+		ReturnOrigins:    initializers.GetEnvAsList("FEDERATION_RETURN_ORIGINS", nil),
+	})
+	scimService := services.NewSCIMService(userRepo, roleRepo, passwordPolicyService, passwordHasher, sessionService, signupConfig, services.SCIMConfig{
+		BaseURL:    oauthIssuer,
+		TrustEmail: initializers.GetEnvAsBool("SCIM_TRUST_EMAIL", true),
+		MaxResults: initializers.GetEnvAsInt("SCIM_MAX_RESULTS", 100),
+	})
+	auditService := services.NewAuditService(auditEventRepo)
+	impersonationService := services.NewImpersonationService(userRepo, roleRepo, permissionRepo, sessionService, tokenService, auditService, services.ImpersonationConfig{
+		TTL: initializers.GetEnvAsDuration("IMPERSONATION_TTL", 15*time.Minute),
+	})
 	roleService := services.NewRoleService(roleRepo)
 	permissionService := services.NewPermissionService(permissionRepo)
+	passwordResetService := services.NewPasswordResetService(userRepo, userTokenRepo, passwordPolicyService, passwordHasher, sessionService, notifier, services.PasswordResetConfig{
+		TokenTTL: initializers.GetEnvAsDuration("PASSWORD_RESET_TOKEN_TTL", 30*time.Minute),
+		ResetURL: initializers.GetEnvWithDefault("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+	})
+	invitationService := services.NewInvitationService(invitationRepo, userRepo, roleRepo, permissionRepo, passwordPolicyService, passwordHasher, notifier, services.InvitationConfig{
+		TTL:       initializers.GetEnvAsDuration("INVITATION_TTL", 7*24*time.Hour),
+		AcceptURL: initializers.GetEnvWithDefault("INVITATION_URL", "http://localhost:3000/accept-invitation"),
+	})
+	magicLinkService := services.NewMagicLinkService(userRepo, userTokenRepo, userService, notifier, services.MagicLinkConfig{
+		TokenTTL:        initializers.GetEnvAsDuration("MAGIC_LINK_TOKEN_TTL", 15*time.Minute),
+		LoginURL:        initializers.GetEnvWithDefault("MAGIC_LINK_URL", "http://localhost:8080/auth/magic-link/callback"),
+		ResendInterval:  initializers.GetEnvAsDuration("MAGIC_LINK_RESEND_INTERVAL", time.Minute),
+		MaxSendsPerHour: initializers.GetEnvAsInt("MAGIC_LINK_MAX_PER_HOUR", 5),
+	})
 
 	// Initialize controllers
-	authController := controllers.NewAuthController(userService)
+	authController := controllers.NewAuthController(userService, loginThrottleService, sessionService, sessionCookies)
 	roleController := controllers.NewRoleController(roleService, userService)
 	permissionController := controllers.NewPermissionController(permissionService)
-	traefikController := controllers.NewTraefikController(userService, permissionService)
+	traefikController := controllers.NewTraefikController(userService, permissionService, authenticationService, auditService, sessionCookies)
+	passwordController := controllers.NewPasswordController(passwordResetService, userService)
+	emailVerificationController := controllers.NewEmailVerificationController(emailVerificationService)
+	magicLinkController := controllers.NewMagicLinkController(magicLinkService, sessionCookies)
+	invitationController := controllers.NewInvitationController(invitationService)
+	mfaController := controllers.NewMFAController(mfaService, userService, loginThrottleService, sessionCookies)
+	webAuthnController := controllers.NewWebAuthnController(webAuthnService, mfaService, userService, loginThrottleService, sessionCookies)
+	adminController := controllers.NewAdminController(userService, loginThrottleService, impersonationService, auditService)
+	sessionController := controllers.NewSessionController(sessionService)
+	apiKeyController := controllers.NewAPIKeyController(apiKeyService)
+	oauthController := controllers.NewOAuthController(oauthService, tokenIntrospectionService, authenticationService, sessionCookies)
+	oidcController := controllers.NewOIDCController(oidcService, authenticationService, sessionCookies)
+	serviceAccountController := controllers.NewServiceAccountController(serviceAccountService, oauthClientService)
+	federationController := controllers.NewFederationController(federationService, sessionCookies)
+	samlController := controllers.NewSAMLController(samlService, sessionCookies)
+	scimController := controllers.NewSCIMController(scimService)
+
+	checkAuth := middlewares.CheckAuth(authenticationService, sessionCookies, auditService)
 
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
@@ -45,43 +270,156 @@ func main() {
 	{
 		auth.POST("/signup", authController.CreateUser)
 		auth.POST("/login", authController.Login)
+		auth.POST("/logout", checkAuth, authController.Logout)
+		auth.POST("/password/forgot", passwordController.ForgotPassword)
+		auth.POST("/password/reset", passwordController.ResetPassword)
+		auth.GET("/verify-email", emailVerificationController.VerifyEmail)
+		auth.POST("/verify-email", emailVerificationController.VerifyEmail)
+		auth.POST("/verify-email/resend", emailVerificationController.ResendVerification)
+		auth.POST("/magic-link", magicLinkController.RequestMagicLink)
+		auth.GET("/magic-link/callback", magicLinkController.Login)
+		auth.POST("/magic-link/callback", magicLinkController.Login)
+		auth.POST("/invitations/accept", invitationController.AcceptInvitation)
+		auth.POST("/mfa/verify", mfaController.VerifyLogin)
+		auth.POST("/mfa/totp/enroll", mfaController.BeginEnrollmentWithChallenge)
+		auth.POST("/mfa/totp/confirm", mfaController.ConfirmEnrollmentWithChallenge)
+		auth.POST("/mfa/webauthn/begin", webAuthnController.BeginSecondFactor)
+		auth.POST("/mfa/webauthn/finish", webAuthnController.FinishSecondFactor)
+		auth.POST("/webauthn/login/begin", webAuthnController.BeginLogin)
+		auth.POST("/webauthn/login/finish", webAuthnController.FinishLogin)
+		auth.GET("/federation/providers", federationController.GetProviders)
+		auth.GET("/federation/:provider/login", federationController.Login)
+		auth.GET("/federation/:provider/callback", federationController.Callback)
+		auth.GET("/saml/providers", samlController.GetProviders)
+		auth.GET("/saml/:provider/metadata", samlController.Metadata)
+		auth.GET("/saml/:provider/login", samlController.Login)
+		auth.POST("/saml/:provider/acs", samlController.ACS)
 	}
 
 	// User routes (protected)
 	user := router.Group("/user")
-	user.Use(middlewares.CheckAuth)
+	user.Use(checkAuth)
 	{
 		user.GET("/profile", authController.GetUserProfile)
+
+		// Account management is not available to API keys
+		account := user.Group("", middlewares.RequireSession)
+		{
+			account.POST("/password", passwordController.ChangePassword)
+			account.GET("/sessions", sessionController.GetSessions)
+			account.DELETE("/sessions/:id", sessionController.DeleteSession)
+			account.GET("/mfa", mfaController.GetStatus)
+			account.POST("/mfa/totp/enroll", mfaController.BeginEnrollment)
+			account.POST("/mfa/totp/confirm", mfaController.ConfirmEnrollment)
+			account.DELETE("/mfa/totp", mfaController.DisableTOTP)
+			account.POST("/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes)
+			account.POST("/webauthn/register/begin", webAuthnController.BeginRegistration)
+			account.POST("/webauthn/register/finish", webAuthnController.FinishRegistration)
+			account.GET("/webauthn/credentials", webAuthnController.GetCredentials)
+			account.DELETE("/webauthn/credentials/:id", webAuthnController.DeleteCredential)
+			account.POST("/api-keys", apiKeyController.CreateAPIKey)
+			account.GET("/api-keys", apiKeyController.GetAPIKeys)
+			account.DELETE("/api-keys/:id", apiKeyController.RevokeAPIKey)
+			account.POST("/step-up", mfaController.StepUp)
+			account.POST("/step-up/webauthn/begin", webAuthnController.BeginStepUp)
+			account.POST("/step-up/webauthn/finish", webAuthnController.FinishStepUp)
+		}
 	}
 
-	// Role management routes (protected)
+	// Role management routes (protected, require an interactive session and role permissions so
+	// scoped API keys cannot grant themselves more)
 	roles := router.Group("/roles")
-	roles.Use(middlewares.CheckAuth)
+	roles.Use(checkAuth, middlewares.RequireSession)
 	{
-		roles.POST("", roleController.CreateRole)
-		roles.GET("", roleController.GetAllRoles)
-		roles.GET("/:id", roleController.GetRoleByID)
-		roles.PUT("/:id", roleController.UpdateRole)
-		roles.DELETE("/:id", roleController.DeleteRole)
-		roles.POST("/assign", roleController.AssignRoleToUser)
-		roles.POST("/remove", roleController.RemoveRoleFromUser)
+		roles.POST("", middlewares.RequirePermission(userService, "roles", "create"), roleController.CreateRole)
+		roles.GET("", middlewares.RequirePermission(userService, "roles", "read"), roleController.GetAllRoles)
+		roles.GET("/:id", middlewares.RequirePermission(userService, "roles", "read"), roleController.GetRoleByID)
+		roles.PUT("/:id", middlewares.RequirePermission(userService, "roles", "update"), roleController.UpdateRole)
+		roles.DELETE("/:id", middlewares.RequirePermission(userService, "roles", "delete"), roleController.DeleteRole)
+		roles.POST("/assign", middlewares.RequirePermission(userService, "roles", "update"), roleController.AssignRoleToUser)
+		roles.POST("/remove", middlewares.RequirePermission(userService, "roles", "update"), roleController.RemoveRoleFromUser)
 	}
 
-	// Permission management routes (protected)
+	// Permission management routes (protected, same requirements as the role routes)
 	permissions := router.Group("/permissions")
-	permissions.Use(middlewares.CheckAuth)
+	permissions.Use(checkAuth, middlewares.RequireSession)
+	{
+		permissions.POST("", middlewares.RequirePermission(userService, "permissions", "create"), permissionController.CreatePermission)
+		permissions.GET("", middlewares.RequirePermission(userService, "permissions", "read"), permissionController.GetAllPermissions)
+		permissions.GET("/:id", middlewares.RequirePermission(userService, "permissions", "read"), permissionController.GetPermissionByID)
+		permissions.GET("/resource/:resource", middlewares.RequirePermission(userService, "permissions", "read"), permissionController.GetPermissionsByResource)
+		permissions.PUT("/:id", middlewares.RequirePermission(userService, "permissions", "update"), permissionController.UpdatePermission)
+		permissions.DELETE("/:id", middlewares.RequirePermission(userService, "permissions", "delete"), permissionController.DeletePermission)
+		permissions.POST("/assign", middlewares.RequirePermission(userService, "permissions", "update"), permissionController.AssignPermissionToRole)
+		permissions.POST("/remove", middlewares.RequirePermission(userService, "permissions", "update"), permissionController.RemovePermissionFromRole)
+		permissions.POST("/check", middlewares.RequirePermission(userService, "permissions", "read"), permissionController.CheckPermission)
+	}
+
+	// Admin routes (protected, require user management permissions)
+	admin := router.Group("/admin")
+	admin.Use(checkAuth, middlewares.RequirePermission(userService, "users", "update"))
 	{
-		permissions.POST("", permissionController.CreatePermission)
-		permissions.GET("", permissionController.GetAllPermissions)
-		permissions.GET("/:id", permissionController.GetPermissionByID)
-		permissions.GET("/resource/:resource", permissionController.GetPermissionsByResource)
-		permissions.PUT("/:id", permissionController.UpdatePermission)
-		permissions.DELETE("/:id", permissionController.DeletePermission)
-		permissions.POST("/assign", permissionController.AssignPermissionToRole)
-		permissions.POST("/remove", permissionController.RemovePermissionFromRole)
-		permissions.POST("/check", permissionController.CheckPermission)
+		admin.POST("/users/:id/unlock", adminController.UnlockUser)
+		admin.POST("/lockouts/unlock-ip", adminController.UnlockIP)
+		admin.GET("/users/:id/sessions", sessionController.GetUserSessions)
+		admin.DELETE("/users/:id/sessions", sessionController.DeleteAllUserSessions)
+		admin.DELETE("/users/:id/sessions/:sessionId", sessionController.DeleteUserSession)
+		admin.POST("/service-accounts", serviceAccountController.CreateServiceAccount)
+		admin.GET("/service-accounts", serviceAccountController.GetServiceAccounts)
+		admin.POST("/service-accounts/:id/api-keys", middlewares.RequireSession, serviceAccountController.CreateAPIKey)
+		admin.GET("/service-accounts/:id/api-keys", serviceAccountController.GetAPIKeys)
+		admin.DELETE("/service-accounts/:id/api-keys/:keyId", serviceAccountController.RevokeAPIKey)
+		admin.POST("/oauth/clients", middlewares.RequireSession, serviceAccountController.CreateOAuthClient)
+		admin.GET("/oauth/clients", serviceAccountController.GetOAuthClients)
+		admin.DELETE("/oauth/clients/:clientId", serviceAccountController.DeleteOAuthClient)
+		// Impersonation tokens and API keys cannot start another impersonation
+		admin.POST("/users/:id/impersonate", middlewares.RequireSession, middlewares.RequirePermission(userService, "users", "impersonate"), adminController.Impersonate)
+		admin.GET("/audit-events", middlewares.RequirePermission(userService, "audit", "read"), adminController.GetAuditEvents)
+		admin.POST("/invitations", middlewares.RequirePermission(userService, "invitations", "create"), invitationController.Invite)
+		admin.GET("/invitations", middlewares.RequirePermission(userService, "invitations", "read"), invitationController.GetInvitations)
+		admin.DELETE("/invitations/:id", middlewares.RequirePermission(userService, "invitations", "delete"), invitationController.RevokeInvitation)
 	}
 
+	// SCIM provisioning (protected, for the service account of an identity provider)
+	scimRoutes := router.Group("/scim/v2")
+	scimRoutes.Use(checkAuth, middlewares.RequirePermission(userService, "scim", "provision"))
+	{
+		scimRoutes.GET("/ServiceProviderConfig", scimController.GetServiceProviderConfig)
+		scimRoutes.GET("/Users", scimController.GetUsers)
+		scimRoutes.POST("/Users", scimController.CreateUser)
+		scimRoutes.GET("/Users/:id", scimController.GetUser)
+		scimRoutes.PUT("/Users/:id", scimController.ReplaceUser)
+		scimRoutes.PATCH("/Users/:id", scimController.PatchUser)
+		scimRoutes.DELETE("/Users/:id", scimController.DeleteUser)
+		scimRoutes.GET("/Groups", scimController.GetGroups)
+		scimRoutes.POST("/Groups", scimController.CreateGroup)
+		scimRoutes.GET("/Groups/:id", scimController.GetGroup)
+		scimRoutes.PUT("/Groups/:id", scimController.ReplaceGroup)
+		scimRoutes.PATCH("/Groups/:id", scimController.PatchGroup)
+		scimRoutes.DELETE("/Groups/:id", scimController.DeleteGroup)
+	}
+
+	// OAuth 2.0 endpoints (public, clients authenticate per request)
+	oauth := router.Group("/oauth")
+	{
+		oauth.GET("/authorize", oauthController.Authorize)
+		oauth.POST("/authorize", oauthController.Authorize)
+		oauth.POST("/token", oauthController.Token)
+		oauth.POST("/device_authorization", oauthController.DeviceAuthorization)
+		oauth.GET("/device", oauthController.Device)
+		oauth.POST("/device", oauthController.Device)
+		oauth.POST("/introspect", oauthController.Introspect)
+		oauth.POST("/revoke", oauthController.Revoke)
+		oauth.GET("/logout", oidcController.Logout)
+		oauth.POST("/logout", oidcController.Logout)
+	}
+
+	// OpenID Connect provider endpoints
+	router.GET("/.well-known/openid-configuration", oidcController.Discovery)
+	router.GET("/.well-known/jwks.json", oidcController.JWKS)
+	router.GET("/userinfo", checkAuth, oidcController.UserInfo)
+	router.POST("/userinfo", checkAuth, oidcController.UserInfo)
+
 	// Traefik authentication endpoints
 	traefik := router.Group("/traefik")
 	{
@@ -1,65 +1,110 @@
 package middlewares
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
-	"os"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/vladimirteddy/go-authentication/entities"
-	"github.com/vladimirteddy/go-authentication/initializers"
+	"github.com/vladimirteddy/go-authentication/cookies"
+	"github.com/vladimirteddy/go-authentication/services"
 )
 
-func CheckAuth(context *gin.Context) {
-	authHeader := context.GetHeader("Authorization")
+// ErrMalformedAuthorization is returned for an Authorization header that is not a Bearer credential
+var ErrMalformedAuthorization = errors.New("malformed Authorization header")
 
-	if authHeader == "" {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
-		context.AbortWithStatus(http.StatusUnauthorized)
-		return
+// ExtractCredential finds the credential of a request: a Bearer token, then an X-API-Key header,
+// then the session cookie. fromCookie tells callers the request needs CSRF validation.
+// The credential is empty when the request carries none.
+func ExtractCredential(context *gin.Context, sessionCookies cookies.Manager) (credential string, fromCookie bool, err error) {
+	if authHeader := context.GetHeader("Authorization"); authHeader != "" {
+		authToken := strings.Split(authHeader, " ")
+		if len(authToken) != 2 || authToken[0] != "Bearer" {
+			return "", false, ErrMalformedAuthorization
+		}
+		return authToken[1], false, nil
 	}
-
-	authToken := strings.Split(authHeader, " ")
-	if len(authToken) != 2 || authToken[0] != "Bearer" {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		context.AbortWithStatus(http.StatusUnauthorized)
-		return
+	if apiKey := context.GetHeader("X-API-Key"); apiKey != "" {
+		return apiKey, false, nil
+	}
+	if cookieToken := sessionCookies.Token(context); cookieToken != "" {
+		return cookieToken, true, nil
 	}
+	return "", false, nil
+}
 
-	tokenString := authToken[1]
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+// CheckAuth authenticates the credential of a request (see ExtractCredential), a session token
+// or an API key. It stores the user in the context as "currentUser", the session as "sessionID"
+// and the full principal as "principal". Requests of an administrator impersonating a user are
+// audited once answered.
+func CheckAuth(authenticationService services.AuthenticationService, sessionCookies cookies.Manager, auditService services.AuditService) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		credential, fromCookie, err := ExtractCredential(context, sessionCookies)
+		if err != nil {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if credential == "" {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is missing"})
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
-		return []byte(os.Getenv("SECRET_JWT")), nil
-	})
-	if err != nil || !token.Valid {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-		context.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		context.Abort()
-		return
-	}
-	if float64(time.Now().Unix()) > claims["exp"].(float64) {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "Token has expired"})
-		context.AbortWithStatus(http.StatusUnauthorized)
-		return
+		principal, err := authenticationService.Authenticate(credential)
+		if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrInvalidAPIKey) {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidSession) {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended"})
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating request: %v", err)
+			context.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		// Exchanged tokens are only meant for the service named in their audience
+		if principal.Audience != "" {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Token is meant for another service"})
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		// Browsers attach cookies to cross-site requests, so those must prove they come from our pages
+		if fromCookie && !sessionCookies.ValidCSRF(context, context.Request.Method, credential) {
+			context.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+			context.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		context.Set("currentUser", principal.User)
+		context.Set("sessionID", principal.SessionID)
+		context.Set("principal", principal)
+		context.Next()
+
+		auditService.RecordImpersonatedRequest(principal, context.Request.Method+" "+context.Request.URL.Path, context.Writer.Status(), services.ClientInfo{
+			UserAgent: context.Request.UserAgent(),
+			IPAddress: context.ClientIP(),
+		})
 	}
-	var user entities.User
-	initializers.DB.Where("ID=?", claims["id"]).Find(&user)
+}
 
-	if user.ID == 0 {
-		context.AbortWithStatus(http.StatusUnauthorized)
+// RequireSession rejects callers authenticated with an API key, with a token obtained by an
+// OAuth client or with an impersonation token. It guards account management endpoints, so a leaked
+// key, a third-party application or an administrator acting as the user cannot be used to mint
+// more credentials or take over the account.
+// It must run after CheckAuth.
+func RequireSession(context *gin.Context) {
+	principal, _ := context.MustGet("principal").(*services.Principal)
+	if principal == nil || principal.SessionID == "" || principal.ClientID != "" || principal.ImpersonatorID != 0 {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint requires an interactive session"})
 		return
 	}
-	context.Set("currentUser", user)
 	context.Next()
 }
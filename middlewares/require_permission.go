@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vladimirteddy/go-authentication/services"
+)
+
+// RequirePermission only lets through users holding the permission for resource and action,
+// when the credential they used (e.g. a scoped API key) allows it too. Permissions requiring a
+// recent second factor are answered with a step-up challenge until the user passed one.
+// It must run after CheckAuth, which stores the principal in the context.
+func RequirePermission(userService services.UserService, resource, action string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		value, exists := context.Get("principal")
+		principal, ok := value.(*services.Principal)
+		if !exists || !ok {
+			context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if !principal.Allows(resource, action) {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+
+		hasPermission, err := userService.HasPermission(principal.User.ID, resource, action)
+		if err != nil {
+			log.Printf("Error checking permission: %v", err)
+			context.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !hasPermission {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+			return
+		}
+
+		if err := userService.CheckStepUp(principal, resource, action); err != nil {
+			AbortWithStepUp(context, err)
+			return
+		}
+
+		context.Next()
+	}
+}
+
+// AbortWithStepUp answers a request whose permission requires a more recent second factor with a
+// challenge telling the client to step up (RFC 9470): 401 with an insufficient_user_authentication
+// Bearer error naming the required acr and maximum age in seconds. Credentials that cannot step up,
+// like API keys, get 403. Other errors are answered with 500.
+func AbortWithStepUp(context *gin.Context, err error) {
+	var stepUpErr *services.StepUpRequiredError
+	if !errors.As(err, &stepUpErr) {
+		log.Printf("Error checking step-up requirement: %v", err)
+		context.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if stepUpErr.Impossible {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This permission requires a recent two-factor authentication, which this credential cannot provide"})
+		return
+	}
+
+	description := "A recent two-factor authentication is required"
+	context.Header("WWW-Authenticate", fmt.Sprintf(
+		`Bearer error="insufficient_user_authentication", error_description=%q, acr_values=%q, max_age=%d`,
+		description, services.ACRMultiFactor, int(stepUpErr.MaxAge.Seconds()),
+	))
+	context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"error":             "insufficient_user_authentication",
+		"error_description": description,
+		"acr_values":        services.ACRMultiFactor,
+		"max_age":           int(stepUpErr.MaxAge.Seconds()),
+	})
+}
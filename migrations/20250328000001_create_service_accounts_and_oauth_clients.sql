@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_service_account BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id SERIAL PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+    auth_method VARCHAR(32) NOT NULL,
+    public_key TEXT NOT NULL DEFAULT '',
+    grant_types TEXT NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    service_account_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One-time identifiers (e.g. client assertion jti values) seen before they expire
+CREATE TABLE IF NOT EXISTS replay_cache (
+    key VARCHAR(512) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_replay_cache_expires_at ON replay_cache(expires_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS replay_cache;
+DROP TABLE IF EXISTS oauth_clients;
+ALTER TABLE users DROP COLUMN IF EXISTS is_service_account;
+
+-- +goose StatementEnd
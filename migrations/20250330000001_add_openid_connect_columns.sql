@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS post_logout_redirect_uris TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS nonce VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+ALTER TABLE oauth_refresh_tokens ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE oauth_refresh_tokens DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS nonce;
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS post_logout_redirect_uris;
+
+-- +goose StatementEnd
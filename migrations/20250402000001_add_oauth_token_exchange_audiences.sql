@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS token_exchange_audiences TEXT NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE oauth_clients DROP COLUMN IF EXISTS token_exchange_audiences;
+
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS external_id VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_external_id ON users(external_id) WHERE external_id <> '';
+
+-- Granted to the role of the service account an identity provider provisions users with
+INSERT INTO permissions (resource, action, description) VALUES
+    ('scim', 'provision', 'Provision users and groups through SCIM')
+ON CONFLICT DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM permissions WHERE resource = 'scim' AND action = 'provision';
+
+DROP INDEX IF EXISTS idx_users_external_id;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS deactivated_at,
+    DROP COLUMN IF EXISTS external_id;
+
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Set on sessions an administrator started to act as the user
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS impersonator_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+-- Audit events outlive the users they mention, so they hold plain IDs rather than foreign keys
+CREATE TABLE IF NOT EXISTS audit_events (
+    id SERIAL PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    actor_id INTEGER,
+    subject_id INTEGER,
+    session_id VARCHAR(64) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_subject_id ON audit_events(subject_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+INSERT INTO permissions (resource, action, description) VALUES
+    ('users', 'impersonate', 'Act as another user to reproduce their issues'),
+    ('audit', 'read', 'Read the audit log')
+ON CONFLICT DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM permissions WHERE (resource = 'users' AND action = 'impersonate') OR (resource = 'audit' AND action = 'read');
+
+DROP TABLE IF EXISTS audit_events;
+
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS impersonator_id;
+
+-- +goose StatementEnd
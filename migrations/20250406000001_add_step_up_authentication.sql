@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- How the user last authenticated in a session, and when; a step-up updates both
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS auth_methods VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS authenticated_at TIMESTAMP;
+
+UPDATE sessions SET authenticated_at = created_at WHERE authenticated_at IS NULL;
+
+ALTER TABLE sessions
+    ALTER COLUMN authenticated_at SET DEFAULT CURRENT_TIMESTAMP,
+    ALTER COLUMN authenticated_at SET NOT NULL;
+
+-- Permissions requiring a two-factor authentication within this many minutes (0 for none)
+ALTER TABLE permissions
+    ADD COLUMN IF NOT EXISTS mfa_max_age_minutes INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE permissions
+    DROP COLUMN IF EXISTS mfa_max_age_minutes;
+
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS authenticated_at,
+    DROP COLUMN IF EXISTS auth_methods;
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Hash of the secret of the device a token was requested from, for tokens redeemable only there
+ALTER TABLE user_tokens
+    ADD COLUMN IF NOT EXISTS binding_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE user_tokens
+    DROP COLUMN IF EXISTS binding_hash;
+
+-- +goose StatementEnd
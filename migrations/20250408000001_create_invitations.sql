@@ -0,0 +1,49 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Tenant the user was invited into, if any
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tenant VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    tenant VARCHAR(255) NOT NULL DEFAULT '',
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    accepted_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_invitations_email ON invitations(email);
+
+-- Roles the invited user receives when accepting
+CREATE TABLE IF NOT EXISTS invitation_roles (
+    invitation_id INTEGER NOT NULL REFERENCES invitations(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (invitation_id, role_id)
+);
+
+INSERT INTO permissions (resource, action, description) VALUES
+    ('invitations', 'create', 'Invite users by email with pre-assigned roles'),
+    ('invitations', 'read', 'List invitations'),
+    ('invitations', 'delete', 'Revoke pending invitations')
+ON CONFLICT DO NOTHING;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DELETE FROM permissions WHERE resource = 'invitations';
+
+DROP TABLE IF EXISTS invitation_roles;
+DROP TABLE IF EXISTS invitations;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS tenant;
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Space-separated methods (RFC 8176) a login had passed when its MFA challenge was issued
+ALTER TABLE user_tokens
+    ADD COLUMN IF NOT EXISTS auth_methods VARCHAR(64) NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE user_tokens
+    DROP COLUMN IF EXISTS auth_methods;
+
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+package notifiers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type logNotifier struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewLogNotifier returns a Notifier that writes messages to w instead of delivering them.
+// It is intended for local development and tests.
+func NewLogNotifier(w io.Writer) Notifier {
+	return &logNotifier{
+		writer: w,
+	}
+}
+
+// NewFileNotifier returns a log Notifier that appends messages to the file at path
+func NewFileNotifier(path string) (Notifier, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogNotifier(file), nil
+}
+
+func (n *logNotifier) Send(message Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err := fmt.Fprintf(n.writer, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), message.To, message.Subject, message.Body)
+	return err
+}
@@ -0,0 +1,13 @@
+package notifiers
+
+// Message is an outbound notification addressed to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users, e.g. password reset links
+type Notifier interface {
+	Send(message Message) error
+}
@@ -0,0 +1,52 @@
+package notifiers
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPConfig holds the connection settings for the SMTP notifier
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type smtpNotifier struct {
+	config SMTPConfig
+}
+
+// NewSMTPNotifier returns a Notifier that sends plain-text emails through an SMTP server
+func NewSMTPNotifier(config SMTPConfig) Notifier {
+	return &smtpNotifier{
+		config: config,
+	}
+}
+
+func (n *smtpNotifier) Send(message Message) error {
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+
+	address := net.JoinHostPort(n.config.Host, n.config.Port)
+	return smtp.SendMail(address, auth, n.config.From, []string{message.To}, n.buildMessage(message))
+}
+
+func (n *smtpNotifier) buildMessage(message Message) []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&builder, "To: %s\r\n", message.To)
+	fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+	fmt.Fprintf(&builder, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return []byte(builder.String())
+}
@@ -0,0 +1,303 @@
+// Package oidcclient implements the relying party side of OpenID Connect: discovery, the
+// authorization code flow with PKCE and ID token validation against the provider's published keys.
+package oidcclient
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// keysRefreshInterval limits how often an unknown key ID makes the provider's keys be fetched again
+	keysRefreshInterval = time.Minute
+	// maxResponseSize bounds documents read from the provider
+	maxResponseSize = 1 << 20
+	// clockSkew is the leeway given to the provider's clock when checking ID token times
+	clockSkew = time.Minute
+)
+
+// signingMethods are the ID token algorithms accepted; symmetric algorithms and "none" are not
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Config describes this service as a client of one provider
+type Config struct {
+	// Issuer is the provider's issuer URL; its metadata is discovered below /.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Metadata is the part of the provider configuration (OpenID Connect Discovery 1.0 section 3) the client uses
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// TokenResponse is the provider's answer to a code exchange
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// Client talks to one OpenID Connect provider. Metadata and keys are fetched on first use and
+// cached, so the service starts even while the provider is unreachable.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mutex         sync.Mutex
+	metadata      *Metadata
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func NewClient(config Config, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// AuthCodeURL is where the browser is sent to log in at the provider
+func (c *Client) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	metadata, err := c.discover()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidcclient: invalid authorization endpoint: %w", err)
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.config.ClientID)
+	query.Set("redirect_uri", c.config.RedirectURL)
+	query.Set("scope", strings.Join(c.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code at the provider's token endpoint
+func (c *Client) Exchange(code, codeVerifier string) (*TokenResponse, error) {
+	metadata, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if c.config.ClientSecret == "" {
+		form.Set("client_id", c.config.ClientID)
+	}
+	request, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		// client_secret_basic encodes both parts before joining them (RFC 6749 section 2.3.1)
+		request.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	var tokens TokenResponse
+	if err := c.do(request, &tokens); err != nil {
+		return nil, fmt.Errorf("oidcclient: code exchange failed: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidcclient: the token response has no ID token")
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce of an ID token
+// (OpenID Connect Core 1.0 section 3.1.3.7) and returns its claims
+func (c *Client) VerifyIDToken(rawIDToken, nonce string) (jwt.MapClaims, error) {
+	metadata, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.Parser{ValidMethods: signingMethods, SkipClaimsValidation: true}
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return c.key(keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oidcclient: invalid ID token: %w", err)
+	}
+
+	now := time.Now()
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, errors.New("oidcclient: ID token has the wrong issuer")
+	}
+	if !claims.VerifyAudience(c.config.ClientID, true) {
+		return nil, errors.New("oidcclient: ID token is not meant for this client")
+	}
+	// A token for several audiences must name this client as the party it was issued to
+	if audiences, ok := claims["aud"].([]interface{}); ok && len(audiences) > 1 {
+		if authorizedParty, _ := claims["azp"].(string); authorizedParty != c.config.ClientID {
+			return nil, errors.New("oidcclient: ID token was issued to another client")
+		}
+	}
+	if !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true) {
+		return nil, errors.New("oidcclient: ID token has expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(clockSkew).Unix(), false) || !claims.VerifyNotBefore(now.Add(clockSkew).Unix(), false) {
+		return nil, errors.New("oidcclient: ID token is not valid yet")
+	}
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("oidcclient: ID token nonce does not match")
+	}
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, errors.New("oidcclient: ID token has no subject")
+	}
+	return claims, nil
+}
+
+// NewCodeVerifier returns a random PKCE code verifier (RFC 7636 section 4.1)
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewNonce returns a random value binding an ID token to the login that requested it
+func NewNonce() (string, error) {
+	return randomString(24)
+}
+
+// CodeChallenge derives the S256 code challenge of a verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// discover fetches and caches the provider metadata, requiring it to name the configured issuer
+func (c *Client) discover() (*Metadata, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var metadata Metadata
+	if err := c.do(request, &metadata); err != nil {
+		return nil, fmt.Errorf("oidcclient: discovery failed: %w", err)
+	}
+	if metadata.Issuer != c.config.Issuer {
+		return nil, fmt.Errorf("oidcclient: provider reports issuer %q instead of %q", metadata.Issuer, c.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("oidcclient: provider metadata is incomplete")
+	}
+	c.metadata = &metadata
+	return c.metadata, nil
+}
+
+// key finds the provider key with keyID, fetching the keys again when it is unknown as the
+// provider may have rotated them. Tokens without a key ID are accepted when there is one key.
+func (c *Client) key(keyID string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if key, ok := c.lookupKey(keyID); ok {
+		return key, nil
+	}
+	if time.Since(c.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("oidcclient: unknown signing key %q", keyID)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, c.metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var keySet jsonWebKeySet
+	if err := c.do(request, &keySet); err != nil {
+		return nil, fmt.Errorf("oidcclient: fetching signing keys failed: %w", err)
+	}
+	c.keys = keySet.publicKeys()
+	c.keysFetchedAt = time.Now()
+
+	if key, ok := c.lookupKey(keyID); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidcclient: unknown signing key %q", keyID)
+}
+
+func (c *Client) lookupKey(keyID string) (interface{}, bool) {
+	if keyID == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[keyID]
+	return key, ok
+}
+
+// do sends request and decodes the JSON response into target
+func (c *Client) do(request *http.Request, target interface{}) error {
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		var oauthError struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauthError) == nil && oauthError.Error != "" {
+			return fmt.Errorf("%s: %s", oauthError.Error, oauthError.ErrorDescription)
+		}
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return json.Unmarshal(body, target)
+}
+
+func randomString(size int) (string, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
@@ -0,0 +1,486 @@
+package oidcclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "s3cret:with/odd chars"
+	testRedirectURL  = "https://app.example.org/callback"
+	testNonce        = "nonce-123"
+	testCode         = "code-123"
+)
+
+// testProvider is an OpenID Connect provider serving discovery, keys and a token endpoint
+type testProvider struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// issuer is reported in the metadata; the server URL unless a test overrides it
+	issuer  string
+	keyID   string
+	key     *rsa.PrivateKey
+	ecKeyID string
+	ecKey   *ecdsa.PrivateKey
+	// idToken is returned for testCode
+	idToken        string
+	tokenRequest   url.Values
+	tokenBasicAuth [2]string
+	requests       map[string]int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{keyID: "rsa-1", key: key, ecKeyID: "ec-1", ecKey: ecKey, requests: make(map[string]int)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.requests["discovery"]++
+		writeJSON(w, http.StatusOK, Metadata{
+			Issuer:                p.issuer,
+			AuthorizationEndpoint: p.server.URL + "/authorize?prompt=login",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.requests["jwks"]++
+		size := (p.ecKey.Curve.Params().BitSize + 7) / 8
+		writeJSON(w, http.StatusOK, jsonWebKeySet{Keys: []jsonWebKey{
+			{
+				KeyType: "RSA",
+				KeyID:   p.keyID,
+				Use:     "sig",
+				N:       base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			},
+			{
+				KeyType: "EC",
+				KeyID:   p.ecKeyID,
+				Curve:   "P-256",
+				X:       base64.RawURLEncoding.EncodeToString(p.ecKey.X.FillBytes(make([]byte, size))),
+				Y:       base64.RawURLEncoding.EncodeToString(p.ecKey.Y.FillBytes(make([]byte, size))),
+			},
+			// Encryption keys are never used to check signatures
+			{KeyType: "RSA", KeyID: "enc-1", Use: "enc", N: "AQAB", E: "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.requests["token"]++
+		if r.Method != http.MethodPost || r.ParseForm() != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+		p.tokenRequest = r.PostForm
+		if username, password, ok := r.BasicAuth(); ok {
+			username, _ = url.QueryUnescape(username)
+			password, _ = url.QueryUnescape(password)
+			p.tokenBasicAuth = [2]string{username, password}
+		}
+		if r.PostForm.Get("code") != testCode {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unknown code"})
+			return
+		}
+		writeJSON(w, http.StatusOK, TokenResponse{AccessToken: "access", TokenType: "Bearer", IDToken: p.idToken})
+	})
+
+	p.server = httptest.NewServer(mux)
+	p.issuer = p.server.URL
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// client returns a client of the provider, with a client secret unless secret is empty
+func (p *testProvider) client(secret string) *Client {
+	return NewClient(Config{
+		Issuer:       p.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: secret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email"},
+	}, p.server.Client())
+}
+
+// claims returns the claims of a valid ID token for the test client
+func (p *testProvider) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   testClientID,
+		"sub":   "user-1",
+		"nonce": testNonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"email": "alice@example.org",
+	}
+}
+
+// sign signs claims with the provider's RSA key under its key ID
+func (p *testProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	return signToken(t, jwt.SigningMethodRS256, p.keyID, p.key, claims)
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, keyID string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if keyID != "" {
+		token.Header["kid"] = keyID
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (p *testProvider) count(endpoint string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests[endpoint]
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newTestProvider(t)
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := p.client("").AuthCodeURL("state-1", testNonce, CodeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Path != "/authorize" {
+		t.Fatalf("authorization endpoint path = %q, want /authorize", parsed.Path)
+	}
+	want := map[string]string{
+		"prompt":                "login",
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email",
+		"state":                 "state-1",
+		"nonce":                 testNonce,
+		"code_challenge":        CodeChallenge(verifier),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := parsed.Query().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	if got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("CodeChallenge = %q", got)
+	}
+}
+
+func TestDiscoveryIsCached(t *testing.T) {
+	p := newTestProvider(t)
+	client := p.client("")
+	for i := 0; i < 3; i++ {
+		if _, err := client.AuthCodeURL("state", testNonce, "challenge"); err != nil {
+			t.Fatalf("AuthCodeURL: %v", err)
+		}
+	}
+	if discoveries := p.count("discovery"); discoveries != 1 {
+		t.Fatalf("metadata fetched %d times, want 1", discoveries)
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	p := newTestProvider(t)
+	p.issuer = "https://attacker.example.org"
+
+	if _, err := p.client("").AuthCodeURL("state", testNonce, "challenge"); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Fatalf("AuthCodeURL error = %v, want an issuer mismatch", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"confidential client", testClientSecret},
+		{"public client", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			p.idToken = p.sign(t, p.claims())
+
+			tokens, err := p.client(tt.secret).Exchange(testCode, "verifier-123")
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if tokens.IDToken != p.idToken || tokens.AccessToken != "access" {
+				t.Fatalf("tokens = %+v", tokens)
+			}
+
+			form := p.tokenRequest
+			if form.Get("grant_type") != "authorization_code" || form.Get("code_verifier") != "verifier-123" || form.Get("redirect_uri") != testRedirectURL {
+				t.Fatalf("token request = %v", form)
+			}
+			if tt.secret != "" {
+				if p.tokenBasicAuth != [2]string{testClientID, testClientSecret} || form.Has("client_id") {
+					t.Fatalf("client authenticated with %v and form %v, want client_secret_basic", p.tokenBasicAuth, form)
+				}
+			} else if form.Get("client_id") != testClientID || p.tokenBasicAuth != [2]string{} {
+				t.Fatalf("public client sent form %v and credentials %v", form, p.tokenBasicAuth)
+			}
+		})
+	}
+}
+
+func TestExchangeErrors(t *testing.T) {
+	p := newTestProvider(t)
+	client := p.client(testClientSecret)
+
+	if _, err := client.Exchange("unknown", "verifier"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange of an unknown code error = %v, want invalid_grant", err)
+	}
+	// A token response without an ID token is not an OpenID Connect login
+	if _, err := client.Exchange(testCode, "verifier"); err == nil {
+		t.Fatal("Exchange without an ID token succeeded")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newTestProvider(t)
+	client := p.client("")
+
+	claims, err := client.VerifyIDToken(p.sign(t, p.claims()), testNonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims["sub"] != "user-1" || claims["email"] != "alice@example.org" {
+		t.Fatalf("claims = %v", claims)
+	}
+
+	ecToken := signToken(t, jwt.SigningMethodES256, p.ecKeyID, p.ecKey, p.claims())
+	if _, err := client.VerifyIDToken(ecToken, testNonce); err != nil {
+		t.Fatalf("VerifyIDToken with an EC key: %v", err)
+	}
+	if fetches := p.count("jwks"); fetches != 1 {
+		t.Fatalf("keys fetched %d times, want 1", fetches)
+	}
+}
+
+func TestVerifyIDTokenAcceptsSeveralAudiences(t *testing.T) {
+	p := newTestProvider(t)
+	claims := p.claims()
+	claims["aud"] = []string{"other-client", testClientID}
+	claims["azp"] = testClientID
+
+	if _, err := p.client("").VerifyIDToken(p.sign(t, claims), testNonce); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	p := newTestProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token func(claims jwt.MapClaims) string
+		nonce string
+	}{
+		{
+			name: "signature by another key",
+			token: func(claims jwt.MapClaims) string {
+				return signToken(t, jwt.SigningMethodRS256, p.keyID, otherKey, claims)
+			},
+		},
+		{
+			name: "tampered payload",
+			token: func(claims jwt.MapClaims) string {
+				parts := strings.Split(p.sign(t, claims), ".")
+				claims["sub"] = "admin"
+				forged := strings.Split(p.sign(t, claims), ".")
+				return parts[0] + "." + forged[1] + "." + parts[2]
+			},
+		},
+		{
+			name:  "unknown key ID",
+			token: func(claims jwt.MapClaims) string { return signToken(t, jwt.SigningMethodRS256, "rsa-2", p.key, claims) },
+		},
+		{
+			name:  "encryption key ID",
+			token: func(claims jwt.MapClaims) string { return signToken(t, jwt.SigningMethodRS256, "enc-1", p.key, claims) },
+		},
+		{
+			name: "HMAC with the public key as secret",
+			token: func(claims jwt.MapClaims) string {
+				return signToken(t, jwt.SigningMethodHS256, p.keyID, p.key.N.Bytes(), claims)
+			},
+		},
+		{
+			name: "unsigned",
+			token: func(claims jwt.MapClaims) string {
+				return signToken(t, jwt.SigningMethodNone, p.keyID, jwt.UnsafeAllowNoneSignatureType, claims)
+			},
+		},
+		{
+			name: "wrong issuer",
+			token: func(claims jwt.MapClaims) string {
+				claims["iss"] = "https://attacker.example.org"
+				return p.sign(t, claims)
+			},
+		},
+		{
+			name:  "missing issuer",
+			token: func(claims jwt.MapClaims) string { delete(claims, "iss"); return p.sign(t, claims) },
+		},
+		{
+			name:  "other audience",
+			token: func(claims jwt.MapClaims) string { claims["aud"] = "other-client"; return p.sign(t, claims) },
+		},
+		{
+			name: "several audiences without azp",
+			token: func(claims jwt.MapClaims) string {
+				claims["aud"] = []string{testClientID, "other-client"}
+				return p.sign(t, claims)
+			},
+		},
+		{
+			name: "issued to another party",
+			token: func(claims jwt.MapClaims) string {
+				claims["aud"] = []string{testClientID, "other-client"}
+				claims["azp"] = "other-client"
+				return p.sign(t, claims)
+			},
+		},
+		{
+			name: "expired",
+			token: func(claims jwt.MapClaims) string {
+				claims["exp"] = now.Add(-clockSkew - time.Minute).Unix()
+				return p.sign(t, claims)
+			},
+		},
+		{
+			name:  "without expiry",
+			token: func(claims jwt.MapClaims) string { delete(claims, "exp"); return p.sign(t, claims) },
+		},
+		{
+			name: "issued in the future",
+			token: func(claims jwt.MapClaims) string {
+				claims["iat"] = now.Add(clockSkew + time.Minute).Unix()
+				return p.sign(t, claims)
+			},
+		},
+		{
+			name: "not valid yet",
+			token: func(claims jwt.MapClaims) string {
+				claims["nbf"] = now.Add(clockSkew + time.Minute).Unix()
+				return p.sign(t, claims)
+			},
+		},
+		{
+			name:  "wrong nonce",
+			token: func(claims jwt.MapClaims) string { return p.sign(t, claims) },
+			nonce: "other-nonce",
+		},
+		{
+			name:  "missing nonce",
+			token: func(claims jwt.MapClaims) string { delete(claims, "nonce"); return p.sign(t, claims) },
+		},
+		{
+			name:  "without subject",
+			token: func(claims jwt.MapClaims) string { delete(claims, "sub"); return p.sign(t, claims) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonce := tt.nonce
+			if nonce == "" {
+				nonce = testNonce
+			}
+			claims, err := p.client("").VerifyIDToken(tt.token(p.claims()), nonce)
+			if err == nil {
+				t.Fatalf("VerifyIDToken accepted the token with claims %v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenFollowsKeyRotation(t *testing.T) {
+	p := newTestProvider(t)
+	client := p.client("")
+	if _, err := client.VerifyIDToken(p.sign(t, p.claims()), testNonce); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.keyID, p.key = "rsa-2", rotated
+	p.mu.Unlock()
+	token := p.sign(t, p.claims())
+
+	// Unknown key IDs do not make every token fetch the keys again
+	if _, err := client.VerifyIDToken(token, testNonce); err == nil {
+		t.Fatal("VerifyIDToken accepted an unknown key right after fetching the keys")
+	}
+	if fetches := p.count("jwks"); fetches != 1 {
+		t.Fatalf("keys fetched %d times, want 1", fetches)
+	}
+
+	client.mutex.Lock()
+	client.keysFetchedAt = time.Now().Add(-keysRefreshInterval)
+	client.mutex.Unlock()
+	if _, err := client.VerifyIDToken(token, testNonce); err != nil {
+		t.Fatalf("VerifyIDToken with the rotated key: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresAPIKey struct {
+	entities.APIKey
+}
+
+type APIKeyRepository interface {
+	Create(apiKey *PostgresAPIKey) (*PostgresAPIKey, error)
+	GetByHash(keyHash string) (*PostgresAPIKey, error)
+	GetForUser(userID uint) ([]*PostgresAPIKey, error)
+	TouchLastUsed(id uint, lastUsedAt time.Time) error
+	Revoke(userID, id uint) (bool, error)
+}
+
+type apiKeyPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &apiKeyPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *apiKeyPostgresRepository) Create(apiKey *PostgresAPIKey) (*PostgresAPIKey, error) {
+	err := r.db.Create(apiKey).Error
+	if err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func (r *apiKeyPostgresRepository) GetByHash(keyHash string) (*PostgresAPIKey, error) {
+	var apiKey PostgresAPIKey
+	result := r.db.Where("key_hash = ?", keyHash).First(&apiKey)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &apiKey, nil
+}
+
+// GetForUser returns the keys of a user that have not been revoked, newest first
+func (r *apiKeyPostgresRepository) GetForUser(userID uint) ([]*PostgresAPIKey, error) {
+	var apiKeys []*PostgresAPIKey
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&apiKeys).Error
+	if err != nil {
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+func (r *apiKeyPostgresRepository) TouchLastUsed(id uint, lastUsedAt time.Time) error {
+	return r.db.Model(&PostgresAPIKey{}).
+		Where("id = ?", id).
+		Update("last_used_at", lastUsedAt).Error
+}
+
+// Revoke disables a key of the given user and reports whether it was active
+func (r *apiKeyPostgresRepository) Revoke(userID, id uint) (bool, error) {
+	result := r.db.Model(&PostgresAPIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
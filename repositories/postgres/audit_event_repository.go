@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresAuditEvent struct {
+	entities.AuditEvent
+}
+
+// AuditEventQuery selects audit events; zero fields match every event
+type AuditEventQuery struct {
+	Action    string
+	ActorID   uint
+	SubjectID uint
+	Offset    int
+	Limit     int
+}
+
+type AuditEventRepository interface {
+	Create(event *PostgresAuditEvent) error
+	Find(query AuditEventQuery) ([]*PostgresAuditEvent, error)
+}
+
+type auditEventPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditEventRepository(db *gorm.DB) AuditEventRepository {
+	return &auditEventPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *auditEventPostgresRepository) Create(event *PostgresAuditEvent) error {
+	return r.db.Create(event).Error
+}
+
+// Find returns a page of the events matching query, newest first
+func (r *auditEventPostgresRepository) Find(query AuditEventQuery) ([]*PostgresAuditEvent, error) {
+	db := r.db.Model(&PostgresAuditEvent{})
+	if query.Action != "" {
+		db = db.Where("action = ?", query.Action)
+	}
+	if query.ActorID != 0 {
+		db = db.Where("actor_id = ?", query.ActorID)
+	}
+	if query.SubjectID != 0 {
+		db = db.Where("subject_id = ?", query.SubjectID)
+	}
+
+	events := []*PostgresAuditEvent{}
+	result := db.Order("id DESC").Offset(query.Offset).Limit(query.Limit).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return events, nil
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresFederatedIdentity struct {
+	entities.FederatedIdentity
+}
+
+type PostgresFederationLoginState struct {
+	entities.FederationLoginState
+}
+
+type FederationRepository interface {
+	CreateLoginState(state *PostgresFederationLoginState) (*PostgresFederationLoginState, error)
+	ConsumeLoginState(provider, stateHash string) (*PostgresFederationLoginState, error)
+	GetIdentity(provider, subject string) (*PostgresFederatedIdentity, error)
+	CreateIdentity(identity *PostgresFederatedIdentity) (*PostgresFederatedIdentity, error)
+	TouchIdentity(id uint, email string, loginAt time.Time) error
+}
+
+type federationPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewFederationRepository(db *gorm.DB) FederationRepository {
+	return &federationPostgresRepository{
+		db: db,
+	}
+}
+
+// CreateLoginState stores a login sent to a provider, purging expired ones first
+func (r *federationPostgresRepository) CreateLoginState(state *PostgresFederationLoginState) (*PostgresFederationLoginState, error) {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&PostgresFederationLoginState{}).Error; err != nil {
+		return nil, err
+	}
+
+	err := r.db.Create(state).Error
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ConsumeLoginState loads an unexpired login of provider and marks it used, so each
+// callback can complete at most one login
+func (r *federationPostgresRepository) ConsumeLoginState(provider, stateHash string) (*PostgresFederationLoginState, error) {
+	var state PostgresFederationLoginState
+	result := r.db.Where("provider = ? AND state_hash = ? AND used_at IS NULL AND expires_at > ?", provider, stateHash, time.Now()).
+		First(&state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	update := r.db.Model(&PostgresFederationLoginState{}).
+		Where("id = ? AND used_at IS NULL", state.ID).
+		Update("used_at", time.Now())
+	if update.Error != nil {
+		return nil, update.Error
+	}
+	if update.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &state, nil
+}
+
+func (r *federationPostgresRepository) GetIdentity(provider, subject string) (*PostgresFederatedIdentity, error) {
+	var identity PostgresFederatedIdentity
+	result := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &identity, nil
+}
+
+func (r *federationPostgresRepository) CreateIdentity(identity *PostgresFederatedIdentity) (*PostgresFederatedIdentity, error) {
+	err := r.db.Create(identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *federationPostgresRepository) TouchIdentity(id uint, email string, loginAt time.Time) error {
+	return r.db.Model(&PostgresFederatedIdentity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":         email,
+		"last_login_at": loginAt,
+	}).Error
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresInvitation struct {
+	entities.Invitation
+}
+
+// InvitationQuery selects invitations; zero fields match every invitation
+type InvitationQuery struct {
+	Email string
+	// Pending keeps only invitations that are neither accepted, revoked nor expired
+	Pending bool
+	Offset  int
+	Limit   int
+}
+
+type InvitationRepository interface {
+	Create(invitation *PostgresInvitation, roleIDs []uint) (*PostgresInvitation, error)
+	GetByID(id uint) (*PostgresInvitation, error)
+	GetByHash(tokenHash string) (*PostgresInvitation, error)
+	Find(query InvitationQuery) ([]*PostgresInvitation, error)
+	Revoke(id uint) (bool, error)
+	Accept(id uint, user *PostgresUser) (*PostgresUser, error)
+}
+
+type invitationPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) InvitationRepository {
+	return &invitationPostgresRepository{
+		db: db,
+	}
+}
+
+// Create stores an invitation with the roles it grants, revoking the pending invitations of the
+// same email so only the latest one can be accepted
+func (r *invitationPostgresRepository) Create(invitation *PostgresInvitation, roleIDs []uint) (*PostgresInvitation, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := pendingInvitations(tx.Model(&PostgresInvitation{})).
+			Where("LOWER(email) = LOWER(?)", invitation.Email).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit("Roles").Create(invitation).Error; err != nil {
+			return err
+		}
+		for _, roleID := range roleIDs {
+			invitationRole := &entities.InvitationRole{
+				InvitationID: invitation.ID,
+				RoleID:       roleID,
+			}
+			if err := tx.Create(invitationRole).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(invitation.ID)
+}
+
+func (r *invitationPostgresRepository) GetByID(id uint) (*PostgresInvitation, error) {
+	var invitation PostgresInvitation
+	result := r.db.Preload("Roles").Where("id = ?", id).First(&invitation)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &invitation, nil
+}
+
+func (r *invitationPostgresRepository) GetByHash(tokenHash string) (*PostgresInvitation, error) {
+	var invitation PostgresInvitation
+	result := r.db.Preload("Roles").Where("token_hash = ?", tokenHash).First(&invitation)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &invitation, nil
+}
+
+// Find returns a page of the invitations matching query, newest first
+func (r *invitationPostgresRepository) Find(query InvitationQuery) ([]*PostgresInvitation, error) {
+	db := r.db.Model(&PostgresInvitation{})
+	if query.Email != "" {
+		db = db.Where("LOWER(email) = LOWER(?)", query.Email)
+	}
+	if query.Pending {
+		db = pendingInvitations(db)
+	}
+
+	invitations := []*PostgresInvitation{}
+	result := db.Preload("Roles").Order("id DESC").Offset(query.Offset).Limit(query.Limit).Find(&invitations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return invitations, nil
+}
+
+// Revoke cancels a pending invitation and reports whether it was still pending
+func (r *invitationPostgresRepository) Revoke(id uint) (bool, error) {
+	result := pendingInvitations(r.db.Model(&PostgresInvitation{})).
+		Where("id = ?", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Accept consumes a pending invitation, creates its user and assigns the invitation's roles in one
+// transaction, so the user never exists without them. It returns gorm.ErrRecordNotFound if the
+// invitation is no longer pending.
+func (r *invitationPostgresRepository) Accept(id uint, user *PostgresUser) (*PostgresUser, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := pendingInvitations(tx.Model(&PostgresInvitation{})).
+			Where("id = ?", id).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		var roleIDs []uint
+		err := tx.Model(&entities.InvitationRole{}).Where("invitation_id = ?", id).Pluck("role_id", &roleIDs).Error
+		if err != nil {
+			return err
+		}
+		for _, roleID := range roleIDs {
+			userRole := entities.UserRole{
+				UserID: user.ID,
+				RoleID: roleID,
+			}
+			if err := tx.Create(&userRole).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&PostgresInvitation{}).Where("id = ?", id).Update("accepted_user_id", user.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// pendingInvitations restricts db to invitations that can still be accepted
+func pendingInvitations(db *gorm.DB) *gorm.DB {
+	return db.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now())
+}
@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PostgresLoginAttempt struct {
+	entities.LoginAttempt
+}
+
+type LoginAttemptRepository interface {
+	Get(key string) (*PostgresLoginAttempt, error)
+	RecordFailure(key string, window time.Duration) (*PostgresLoginAttempt, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+type loginAttemptPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *loginAttemptPostgresRepository) Get(key string) (*PostgresLoginAttempt, error) {
+	var attempt PostgresLoginAttempt
+	result := r.db.Where("key = ?", key).First(&attempt)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &attempt, nil
+}
+
+// RecordFailure atomically increments the failure counter for key. Counters whose last
+// failure is older than window start again from one.
+func (r *loginAttemptPostgresRepository) RecordFailure(key string, window time.Duration) (*PostgresLoginAttempt, error) {
+	now := time.Now()
+	attempt := PostgresLoginAttempt{
+		LoginAttempt: entities.LoginAttempt{
+			Key:          key,
+			Failures:     1,
+			LastFailedAt: now,
+			UpdatedAt:    now,
+		},
+	}
+
+	err := r.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "failures"}, Value: gorm.Expr(
+					"CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.failures + 1 END", now.Add(-window),
+				)},
+				{Column: clause.Column{Name: "last_failed_at"}, Value: now},
+				{Column: clause.Column{Name: "updated_at"}, Value: now},
+			},
+		},
+		clause.Returning{},
+	).Create(&attempt).Error
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (r *loginAttemptPostgresRepository) Lock(key string, until time.Time) error {
+	return r.db.Model(&PostgresLoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (r *loginAttemptPostgresRepository) Reset(key string) error {
+	return r.db.Where("key = ?", key).Delete(&PostgresLoginAttempt{}).Error
+}
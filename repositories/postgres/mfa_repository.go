@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresTOTPCredential struct {
+	entities.TOTPCredential
+}
+
+type PostgresRecoveryCode struct {
+	entities.RecoveryCode
+}
+
+type MFARepository interface {
+	GetTOTPCredential(userID uint) (*PostgresTOTPCredential, error)
+	SaveTOTPCredential(credential *PostgresTOTPCredential) error
+	DeleteTOTPCredential(userID uint) error
+	UpdateLastUsedStep(userID uint, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID uint, codeHashes []string) error
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+	CountUnusedRecoveryCodes(userID uint) (int64, error)
+}
+
+type mfaPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewMFARepository(db *gorm.DB) MFARepository {
+	return &mfaPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *mfaPostgresRepository) GetTOTPCredential(userID uint) (*PostgresTOTPCredential, error) {
+	var credential PostgresTOTPCredential
+	result := r.db.Where("user_id = ?", userID).First(&credential)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &credential, nil
+}
+
+func (r *mfaPostgresRepository) SaveTOTPCredential(credential *PostgresTOTPCredential) error {
+	return r.db.Save(credential).Error
+}
+
+func (r *mfaPostgresRepository) DeleteTOTPCredential(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&PostgresRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&PostgresTOTPCredential{}).Error
+	})
+}
+
+// UpdateLastUsedStep records an accepted time step and reports false if the same
+// or a later step was already used, which means the code is being replayed.
+func (r *mfaPostgresRepository) UpdateLastUsedStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&PostgresTOTPCredential{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *mfaPostgresRepository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&PostgresRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, codeHash := range codeHashes {
+			code := &PostgresRecoveryCode{
+				RecoveryCode: entities.RecoveryCode{
+					UserID:   userID,
+					CodeHash: codeHash,
+				},
+			}
+			if err := tx.Create(code).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode consumes a matching unused recovery code and reports whether one was found
+func (r *mfaPostgresRepository) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := r.db.Model(&PostgresRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *mfaPostgresRepository) CountUnusedRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&PostgresRecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresOAuthAuthorizationCode struct {
+	entities.OAuthAuthorizationCode
+}
+
+type OAuthAuthorizationCodeRepository interface {
+	Create(code *PostgresOAuthAuthorizationCode) (*PostgresOAuthAuthorizationCode, error)
+	GetByHash(codeHash string) (*PostgresOAuthAuthorizationCode, error)
+	MarkUsed(id uint, usedAt time.Time) (bool, error)
+	SetSessionID(id uint, sessionID string) error
+}
+
+type oauthAuthorizationCodePostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthAuthorizationCodeRepository(db *gorm.DB) OAuthAuthorizationCodeRepository {
+	return &oauthAuthorizationCodePostgresRepository{
+		db: db,
+	}
+}
+
+func (r *oauthAuthorizationCodePostgresRepository) Create(code *PostgresOAuthAuthorizationCode) (*PostgresOAuthAuthorizationCode, error) {
+	err := r.db.Create(code).Error
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+func (r *oauthAuthorizationCodePostgresRepository) GetByHash(codeHash string) (*PostgresOAuthAuthorizationCode, error) {
+	var code PostgresOAuthAuthorizationCode
+	result := r.db.Where("code_hash = ?", codeHash).First(&code)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &code, nil
+}
+
+// MarkUsed consumes a code and reports whether this call did so, so concurrent
+// exchanges of the same code cannot both succeed
+func (r *oauthAuthorizationCodePostgresRepository) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	result := r.db.Model(&PostgresOAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *oauthAuthorizationCodePostgresRepository) SetSessionID(id uint, sessionID string) error {
+	return r.db.Model(&PostgresOAuthAuthorizationCode{}).
+		Where("id = ?", id).
+		Update("session_id", sessionID).Error
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresOAuthClient struct {
+	entities.OAuthClient
+}
+
+type OAuthClientRepository interface {
+	Create(client *PostgresOAuthClient) (*PostgresOAuthClient, error)
+	GetByClientID(clientID string) (*PostgresOAuthClient, error)
+	GetAll() ([]*PostgresOAuthClient, error)
+	Delete(clientID string) (bool, error)
+}
+
+type oauthClientPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthClientRepository(db *gorm.DB) OAuthClientRepository {
+	return &oauthClientPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *oauthClientPostgresRepository) Create(client *PostgresOAuthClient) (*PostgresOAuthClient, error) {
+	err := r.db.Create(client).Error
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *oauthClientPostgresRepository) GetByClientID(clientID string) (*PostgresOAuthClient, error) {
+	var client PostgresOAuthClient
+	result := r.db.Where("client_id = ?", clientID).First(&client)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &client, nil
+}
+
+func (r *oauthClientPostgresRepository) GetAll() ([]*PostgresOAuthClient, error) {
+	var clients []*PostgresOAuthClient
+	result := r.db.Order("created_at DESC").Find(&clients)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return clients, nil
+}
+
+// Delete removes a client and reports whether it existed
+func (r *oauthClientPostgresRepository) Delete(clientID string) (bool, error) {
+	result := r.db.Where("client_id = ?", clientID).Delete(&PostgresOAuthClient{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PostgresOAuthConsent struct {
+	entities.OAuthConsent
+}
+
+type OAuthConsentRepository interface {
+	Get(userID uint, clientID string) (*PostgresOAuthConsent, error)
+	Save(userID uint, clientID, scopes string) error
+}
+
+type oauthConsentPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthConsentRepository(db *gorm.DB) OAuthConsentRepository {
+	return &oauthConsentPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *oauthConsentPostgresRepository) Get(userID uint, clientID string) (*PostgresOAuthConsent, error) {
+	var consent PostgresOAuthConsent
+	result := r.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &consent, nil
+}
+
+// Save records the scopes a user granted a client, replacing any earlier consent
+func (r *oauthConsentPostgresRepository) Save(userID uint, clientID, scopes string) error {
+	now := time.Now()
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+	}).Create(&PostgresOAuthConsent{
+		OAuthConsent: entities.OAuthConsent{
+			UserID:    userID,
+			ClientID:  clientID,
+			Scopes:    scopes,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}).Error
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresOAuthDeviceCode struct {
+	entities.OAuthDeviceCode
+}
+
+type OAuthDeviceCodeRepository interface {
+	Create(deviceCode *PostgresOAuthDeviceCode) (*PostgresOAuthDeviceCode, error)
+	GetByDeviceCodeHash(deviceCodeHash string) (*PostgresOAuthDeviceCode, error)
+	GetPendingByUserCodeHash(userCodeHash string) (*PostgresOAuthDeviceCode, error)
+	Decide(id uint, status string, userID uint, authTime time.Time) (bool, error)
+	RecordPoll(id uint, polledAt time.Time, pollInterval int) error
+	MarkUsed(id uint, usedAt time.Time) (bool, error)
+}
+
+type oauthDeviceCodePostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthDeviceCodeRepository(db *gorm.DB) OAuthDeviceCodeRepository {
+	return &oauthDeviceCodePostgresRepository{
+		db: db,
+	}
+}
+
+// Create stores a device authorization request, purging expired ones first so their user codes can be reused
+func (r *oauthDeviceCodePostgresRepository) Create(deviceCode *PostgresOAuthDeviceCode) (*PostgresOAuthDeviceCode, error) {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&PostgresOAuthDeviceCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	err := r.db.Create(deviceCode).Error
+	if err != nil {
+		return nil, err
+	}
+	return deviceCode, nil
+}
+
+func (r *oauthDeviceCodePostgresRepository) GetByDeviceCodeHash(deviceCodeHash string) (*PostgresOAuthDeviceCode, error) {
+	var deviceCode PostgresOAuthDeviceCode
+	result := r.db.Where("device_code_hash = ?", deviceCodeHash).First(&deviceCode)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &deviceCode, nil
+}
+
+// GetPendingByUserCodeHash returns the unexpired request awaiting a decision for a user code
+func (r *oauthDeviceCodePostgresRepository) GetPendingByUserCodeHash(userCodeHash string) (*PostgresOAuthDeviceCode, error) {
+	var deviceCode PostgresOAuthDeviceCode
+	result := r.db.Where("user_code_hash = ? AND status = ? AND expires_at > ?", userCodeHash, entities.DeviceCodeStatusPending, time.Now()).
+		First(&deviceCode)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &deviceCode, nil
+}
+
+// Decide records the user's decision on a pending request and reports whether it was still pending
+func (r *oauthDeviceCodePostgresRepository) Decide(id uint, status string, userID uint, authTime time.Time) (bool, error) {
+	result := r.db.Model(&PostgresOAuthDeviceCode{}).
+		Where("id = ? AND status = ?", id, entities.DeviceCodeStatusPending).
+		Updates(map[string]interface{}{"status": status, "user_id": userID, "auth_time": authTime})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *oauthDeviceCodePostgresRepository) RecordPoll(id uint, polledAt time.Time, pollInterval int) error {
+	return r.db.Model(&PostgresOAuthDeviceCode{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"last_polled_at": polledAt, "poll_interval": pollInterval}).Error
+}
+
+// MarkUsed consumes an approved request and reports whether this call did so
+func (r *oauthDeviceCodePostgresRepository) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	result := r.db.Model(&PostgresOAuthDeviceCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
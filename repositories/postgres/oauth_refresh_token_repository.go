@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresOAuthRefreshToken struct {
+	entities.OAuthRefreshToken
+}
+
+type OAuthRefreshTokenRepository interface {
+	Create(token *PostgresOAuthRefreshToken) (*PostgresOAuthRefreshToken, error)
+	GetByHash(tokenHash string) (*PostgresOAuthRefreshToken, error)
+	MarkRotated(id uint, rotatedAt time.Time) (bool, error)
+}
+
+type oauthRefreshTokenPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewOAuthRefreshTokenRepository(db *gorm.DB) OAuthRefreshTokenRepository {
+	return &oauthRefreshTokenPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *oauthRefreshTokenPostgresRepository) Create(token *PostgresOAuthRefreshToken) (*PostgresOAuthRefreshToken, error) {
+	err := r.db.Create(token).Error
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *oauthRefreshTokenPostgresRepository) GetByHash(tokenHash string) (*PostgresOAuthRefreshToken, error) {
+	var token PostgresOAuthRefreshToken
+	result := r.db.Where("token_hash = ?", tokenHash).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// MarkRotated retires a refresh token and reports whether this call did so; false means
+// the token was already used
+func (r *oauthRefreshTokenPostgresRepository) MarkRotated(id uint, rotatedAt time.Time) (bool, error) {
+	result := r.db.Model(&PostgresOAuthRefreshToken{}).
+		Where("id = ? AND rotated_at IS NULL", id).
+		Update("rotated_at", rotatedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
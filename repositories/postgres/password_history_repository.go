@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresPasswordHistory struct {
+	entities.PasswordHistory
+}
+
+type PasswordHistoryRepository interface {
+	GetRecent(userID uint, limit int) ([]*PostgresPasswordHistory, error)
+	Add(userID uint, passwordHash string, keep int) error
+}
+
+type passwordHistoryPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordHistoryRepository(db *gorm.DB) PasswordHistoryRepository {
+	return &passwordHistoryPostgresRepository{
+		db: db,
+	}
+}
+
+// GetRecent returns the most recent password hashes of a user, newest first
+func (r *passwordHistoryPostgresRepository) GetRecent(userID uint, limit int) ([]*PostgresPasswordHistory, error) {
+	var history []*PostgresPasswordHistory
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// Add records a password hash and prunes entries beyond the newest keep
+func (r *passwordHistoryPostgresRepository) Add(userID uint, passwordHash string, keep int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		entry := &PostgresPasswordHistory{
+			PasswordHistory: entities.PasswordHistory{
+				UserID:       userID,
+				PasswordHash: passwordHash,
+			},
+		}
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+
+		kept := tx.Model(&PostgresPasswordHistory{}).
+			Select("id").
+			Where("user_id = ?", userID).
+			Order("created_at DESC, id DESC").
+			Limit(keep)
+		return tx.Where("user_id = ? AND id NOT IN (?)", userID, kept).
+			Delete(&PostgresPasswordHistory{}).Error
+	})
+}
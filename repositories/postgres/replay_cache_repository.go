@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReplayCacheRepository interface {
+	Remember(key string, expiresAt time.Time) (bool, error)
+}
+
+type replayCachePostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewReplayCacheRepository(db *gorm.DB) ReplayCacheRepository {
+	return &replayCachePostgresRepository{
+		db: db,
+	}
+}
+
+// Remember stores key until expiresAt and reports whether it was new, i.e. not a replay.
+// Expired keys are purged first so they can be reused.
+func (r *replayCachePostgresRepository) Remember(key string, expiresAt time.Time) (bool, error) {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&entities.ReplayCacheEntry{}).Error; err != nil {
+		return false, err
+	}
+
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&entities.ReplayCacheEntry{Key: key, ExpiresAt: expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
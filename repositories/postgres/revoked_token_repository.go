@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PostgresRevokedToken struct {
+	entities.RevokedToken
+}
+
+type RevokedTokenRepository interface {
+	Revoke(tokenID string, expiresAt time.Time) error
+	IsRevoked(tokenID string) (bool, error)
+}
+
+type revokedTokenPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewRevokedTokenRepository(db *gorm.DB) RevokedTokenRepository {
+	return &revokedTokenPostgresRepository{
+		db: db,
+	}
+}
+
+// Revoke denies the token until expiresAt. Entries of tokens that have expired are purged first.
+func (r *revokedTokenPostgresRepository) Revoke(tokenID string, expiresAt time.Time) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&PostgresRevokedToken{}).Error; err != nil {
+		return err
+	}
+
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&PostgresRevokedToken{
+			RevokedToken: entities.RevokedToken{TokenID: tokenID, ExpiresAt: expiresAt},
+		}).Error
+}
+
+func (r *revokedTokenPostgresRepository) IsRevoked(tokenID string) (bool, error) {
+	var count int64
+	err := r.db.Model(&PostgresRevokedToken{}).
+		Where("token_id = ?", tokenID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -19,6 +19,7 @@ type RoleRepository interface {
 	GetRolesForUser(userID uint) ([]*PostgresRole, error)
 	AssignRoleToUser(userID, roleID uint) error
 	RemoveRoleFromUser(userID, roleID uint) error
+	GetUsersForRole(roleID uint) ([]*PostgresUser, error)
 }
 
 type rolePostgresRepository struct {
@@ -108,3 +109,16 @@ func (r *rolePostgresRepository) AssignRoleToUser(userID, roleID uint) error {
 func (r *rolePostgresRepository) RemoveRoleFromUser(userID, roleID uint) error {
 	return r.db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&entities.UserRole{}).Error
 }
+
+// GetUsersForRole returns the users holding a role, service accounts included
+func (r *rolePostgresRepository) GetUsersForRole(roleID uint) ([]*PostgresUser, error) {
+	var users []*PostgresUser
+	err := r.db.Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", roleID).
+		Order("users.id").
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresSession struct {
+	entities.Session
+}
+
+type SessionRepository interface {
+	Create(session *PostgresSession) (*PostgresSession, error)
+	GetByID(id string) (*PostgresSession, error)
+	GetActiveForUser(userID uint) ([]*PostgresSession, error)
+	Touch(id string, lastSeenAt time.Time) error
+	StepUp(id, authMethods string, authenticatedAt time.Time) error
+	Revoke(userID uint, id string) (bool, error)
+	RevokeAllForUser(userID uint, exceptID string) error
+	RevokeAllForClient(clientID string) error
+}
+
+type sessionPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *sessionPostgresRepository) Create(session *PostgresSession) (*PostgresSession, error) {
+	err := r.db.Create(session).Error
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *sessionPostgresRepository) GetByID(id string) (*PostgresSession, error) {
+	var session PostgresSession
+	result := r.db.Where("id = ?", id).First(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// GetActiveForUser returns the unexpired, unrevoked sessions of a user, most recently used first
+func (r *sessionPostgresRepository) GetActiveForUser(userID uint) ([]*PostgresSession, error) {
+	var sessions []*PostgresSession
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *sessionPostgresRepository) Touch(id string, lastSeenAt time.Time) error {
+	return r.db.Model(&PostgresSession{}).
+		Where("id = ?", id).
+		Update("last_seen_at", lastSeenAt).Error
+}
+
+// StepUp records a new authentication in a session
+func (r *sessionPostgresRepository) StepUp(id, authMethods string, authenticatedAt time.Time) error {
+	return r.db.Model(&PostgresSession{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"auth_methods":     authMethods,
+			"authenticated_at": authenticatedAt,
+			"last_seen_at":     authenticatedAt,
+		}).Error
+}
+
+// Revoke terminates a session of the given user and reports whether it was active
+func (r *sessionPostgresRepository) Revoke(userID uint, id string) (bool, error) {
+	result := r.db.Model(&PostgresSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeAllForUser terminates every session of a user except exceptID, which may be empty
+func (r *sessionPostgresRepository) RevokeAllForUser(userID uint, exceptID string) error {
+	return r.db.Model(&PostgresSession{}).
+		Where("user_id = ? AND revoked_at IS NULL AND id <> ?", userID, exceptID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllForClient terminates every session created by authorizing an OAuth client
+func (r *sessionPostgresRepository) RevokeAllForClient(clientID string) error {
+	return r.db.Model(&PostgresSession{}).
+		Where("client_id = ? AND revoked_at IS NULL", clientID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -1,6 +1,8 @@
 package postgres
 
 import (
+	"time"
+
 	"github.com/vladimirteddy/go-authentication/entities"
 	"gorm.io/gorm"
 )
@@ -9,11 +11,29 @@ type PostgresUser struct {
 	entities.User
 }
 
+// UserQuery selects users by attribute; empty attributes match every user. Usernames and emails
+// are compared case-insensitively.
+type UserQuery struct {
+	Username   string
+	Email      string
+	ExternalID string
+	Offset     int
+	// Limit caps the users returned; with 0 only the total is counted
+	Limit int
+}
+
 type UserRepository interface {
 	GetByUsername(username string) (*PostgresUser, error)
+	GetByEmail(email string) (*PostgresUser, error)
 	GetByID(id uint) (*PostgresUser, error)
 	Create(user *PostgresUser) (*PostgresUser, error)
+	CreateWithRoles(user *PostgresUser, roleIDs []uint) (*PostgresUser, error)
 	Update(user *PostgresUser) error
+	UpdatePassword(id uint, passwordHash string) error
+	MarkEmailVerified(id uint) error
+	GetServiceAccounts() ([]*PostgresUser, error)
+	Find(query UserQuery) ([]*PostgresUser, int64, error)
+	Delete(id uint) error
 }
 type userPostgresRepository struct {
 	db *gorm.DB
@@ -33,6 +53,30 @@ func (r *userPostgresRepository) Create(user *PostgresUser) (*PostgresUser, erro
 	return user, nil
 }
 
+// CreateWithRoles creates a user holding the given roles in one transaction, so the user never
+// exists without them
+func (r *userPostgresRepository) CreateWithRoles(user *PostgresUser, roleIDs []uint) (*PostgresUser, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		for _, roleID := range roleIDs {
+			userRole := entities.UserRole{
+				UserID: user.ID,
+				RoleID: roleID,
+			}
+			if err := tx.Create(&userRole).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func (r *userPostgresRepository) GetByUsername(username string) (*PostgresUser, error) {
 	var user PostgresUser
 	result := r.db.Where("username = ?", username).First(&user)
@@ -42,6 +86,16 @@ func (r *userPostgresRepository) GetByUsername(username string) (*PostgresUser,
 	return &user, nil
 }
 
+// GetByEmail finds the user with an email, compared case-insensitively like Find does
+func (r *userPostgresRepository) GetByEmail(email string) (*PostgresUser, error) {
+	var user PostgresUser
+	result := r.db.Where("LOWER(email) = LOWER(?)", email).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
 func (r *userPostgresRepository) GetByID(id uint) (*PostgresUser, error) {
 	var user PostgresUser
 	result := r.db.Where("id = ?", id).First(&user)
@@ -55,3 +109,57 @@ func (r *userPostgresRepository) Update(user *PostgresUser) error {
 	result := r.db.Save(user)
 	return result.Error
 }
+
+func (r *userPostgresRepository) UpdatePassword(id uint, passwordHash string) error {
+	return r.db.Model(&PostgresUser{}).Where("id = ?", id).Update("password", passwordHash).Error
+}
+
+func (r *userPostgresRepository) MarkEmailVerified(id uint) error {
+	return r.db.Model(&PostgresUser{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email_verified":    true,
+		"email_verified_at": time.Now(),
+	}).Error
+}
+
+func (r *userPostgresRepository) GetServiceAccounts() ([]*PostgresUser, error) {
+	var users []*PostgresUser
+	result := r.db.Where("is_service_account = ?", true).Order("username").Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
+
+// Find returns a page of the users, not service accounts, matching query ordered by ID, along with
+// the total number of matches
+func (r *userPostgresRepository) Find(query UserQuery) ([]*PostgresUser, int64, error) {
+	db := r.db.Model(&PostgresUser{}).Where("is_service_account = ?", false)
+	if query.Username != "" {
+		db = db.Where("LOWER(username) = LOWER(?)", query.Username)
+	}
+	if query.Email != "" {
+		db = db.Where("LOWER(email) = LOWER(?)", query.Email)
+	}
+	if query.ExternalID != "" {
+		db = db.Where("external_id = ?", query.ExternalID)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	users := []*PostgresUser{}
+	if query.Limit == 0 {
+		return users, total, nil
+	}
+	result := db.Order("id").Offset(query.Offset).Limit(query.Limit).Find(&users)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return users, total, nil
+}
+
+// Delete removes a user; everything belonging to them goes with them
+func (r *userPostgresRepository) Delete(id uint) error {
+	return r.db.Delete(&PostgresUser{}, id).Error
+}
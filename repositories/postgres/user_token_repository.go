@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresUserToken struct {
+	entities.UserToken
+}
+
+type UserTokenRepository interface {
+	Create(token *PostgresUserToken) (*PostgresUserToken, error)
+	GetByHash(purpose, tokenHash string) (*PostgresUserToken, error)
+	MarkUsed(id uint) (bool, error)
+	InvalidateForUser(userID uint, purpose string) error
+	CountSince(userID uint, purpose string, since time.Time) (int64, error)
+	IncrementAttempts(id uint) (int, error)
+}
+
+type userTokenPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) UserTokenRepository {
+	return &userTokenPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *userTokenPostgresRepository) Create(token *PostgresUserToken) (*PostgresUserToken, error) {
+	err := r.db.Create(token).Error
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *userTokenPostgresRepository) GetByHash(purpose, tokenHash string) (*PostgresUserToken, error) {
+	var token PostgresUserToken
+	result := r.db.Where("purpose = ? AND token_hash = ?", purpose, tokenHash).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// MarkUsed consumes the token and reports whether this call was the one that consumed it,
+// so concurrent redemptions of the same token cannot both succeed.
+func (r *userTokenPostgresRepository) MarkUsed(id uint) (bool, error) {
+	result := r.db.Model(&PostgresUserToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// InvalidateForUser consumes every outstanding token of the given purpose for a user
+func (r *userTokenPostgresRepository) InvalidateForUser(userID uint, purpose string) error {
+	return r.db.Model(&PostgresUserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+// CountSince returns how many tokens of the given purpose were issued to a user after since
+func (r *userTokenPostgresRepository) CountSince(userID uint, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&PostgresUserToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// IncrementAttempts records a failed redemption and returns the new attempt count
+func (r *userTokenPostgresRepository) IncrementAttempts(id uint) (int, error) {
+	var token PostgresUserToken
+	err := r.db.Model(&token).
+		Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+	if err != nil {
+		return 0, err
+	}
+	if err := r.db.Select("attempts").Where("id = ?", id).First(&token).Error; err != nil {
+		return 0, err
+	}
+	return token.Attempts, nil
+}
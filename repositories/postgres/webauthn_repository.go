@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"gorm.io/gorm"
+)
+
+type PostgresWebAuthnCredential struct {
+	entities.WebAuthnCredential
+}
+
+type PostgresWebAuthnChallenge struct {
+	entities.WebAuthnChallenge
+}
+
+type WebAuthnRepository interface {
+	CreateCredential(credential *PostgresWebAuthnCredential) (*PostgresWebAuthnCredential, error)
+	GetCredentialByCredentialID(credentialID string) (*PostgresWebAuthnCredential, error)
+	GetCredentialsForUser(userID uint) ([]*PostgresWebAuthnCredential, error)
+	CountCredentialsForUser(userID uint) (int64, error)
+	UpdateSignCount(id uint, signCount int64) error
+	DeleteCredential(userID, id uint) (bool, error)
+	CreateChallenge(challenge *PostgresWebAuthnChallenge) (*PostgresWebAuthnChallenge, error)
+	ConsumeChallenge(ceremony, challengeHash string) (*PostgresWebAuthnChallenge, error)
+}
+
+type webAuthnPostgresRepository struct {
+	db *gorm.DB
+}
+
+func NewWebAuthnRepository(db *gorm.DB) WebAuthnRepository {
+	return &webAuthnPostgresRepository{
+		db: db,
+	}
+}
+
+func (r *webAuthnPostgresRepository) CreateCredential(credential *PostgresWebAuthnCredential) (*PostgresWebAuthnCredential, error) {
+	err := r.db.Create(credential).Error
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+func (r *webAuthnPostgresRepository) GetCredentialByCredentialID(credentialID string) (*PostgresWebAuthnCredential, error) {
+	var credential PostgresWebAuthnCredential
+	result := r.db.Where("credential_id = ?", credentialID).First(&credential)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &credential, nil
+}
+
+func (r *webAuthnPostgresRepository) GetCredentialsForUser(userID uint) ([]*PostgresWebAuthnCredential, error) {
+	var credentials []*PostgresWebAuthnCredential
+	result := r.db.Where("user_id = ?", userID).Order("id").Find(&credentials)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return credentials, nil
+}
+
+func (r *webAuthnPostgresRepository) CountCredentialsForUser(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&PostgresWebAuthnCredential{}).Where("user_id = ?", userID).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *webAuthnPostgresRepository) UpdateSignCount(id uint, signCount int64) error {
+	return r.db.Model(&PostgresWebAuthnCredential{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": time.Now(),
+	}).Error
+}
+
+func (r *webAuthnPostgresRepository) DeleteCredential(userID, id uint) (bool, error) {
+	result := r.db.Where("user_id = ? AND id = ?", userID, id).Delete(&PostgresWebAuthnCredential{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *webAuthnPostgresRepository) CreateChallenge(challenge *PostgresWebAuthnChallenge) (*PostgresWebAuthnChallenge, error) {
+	err := r.db.Create(challenge).Error
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// ConsumeChallenge loads an unexpired challenge and marks it used, so each challenge
+// can complete at most one ceremony
+func (r *webAuthnPostgresRepository) ConsumeChallenge(ceremony, challengeHash string) (*PostgresWebAuthnChallenge, error) {
+	var challenge PostgresWebAuthnChallenge
+	result := r.db.Where("ceremony = ? AND challenge_hash = ? AND used_at IS NULL AND expires_at > ?", ceremony, challengeHash, time.Now()).
+		First(&challenge)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	update := r.db.Model(&PostgresWebAuthnChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if update.Error != nil {
+		return nil, update.Error
+	}
+	if update.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &challenge, nil
+}
@@ -7,9 +7,9 @@ import (
 )
 
 type APIError struct {
-	StatusCode int
-	Message    string
-	Data       any
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message"`
+	Data       any    `json:"data"`
 }
 
 func NewAPIError(statusCode int, message string, err error) APIError {
@@ -0,0 +1,155 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// canonicalize serializes an element with Exclusive XML Canonicalization without comments
+// (https://www.w3.org/TR/xml-exc-c14n/). The excluded element, if any, is left out, which is how the
+// enveloped signature transform removes a signature from the element it signs. Prefixes in
+// inclusivePrefixes ("#default" for the default namespace) are rendered wherever they are in scope.
+func canonicalize(e *element, excluded *element, inclusivePrefixes []string) []byte {
+	inclusive := make(map[string]bool, len(inclusivePrefixes))
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		inclusive[prefix] = true
+	}
+
+	var buffer bytes.Buffer
+	canonicalizeElement(&buffer, e, excluded, map[string]string{}, inclusive)
+	return buffer.Bytes()
+}
+
+type namespaceDeclaration struct {
+	prefix string
+	uri    string
+}
+
+// canonicalizeElement writes an element. rendered holds the namespace declarations in effect in the
+// output at the parent element.
+func canonicalizeElement(buffer *bytes.Buffer, e, excluded *element, rendered map[string]string, inclusive map[string]bool) {
+	// A namespace is rendered where it is visibly utilized: by the element name or an attribute
+	utilized := map[string]bool{e.prefix: true}
+	for _, attr := range e.attrs {
+		if attr.Name.Space != "" && attr.Name.Space != "xmlns" && attr.Name.Space != "xml" {
+			utilized[attr.Name.Space] = true
+		}
+	}
+	for prefix := range inclusive {
+		if e.inScope(prefix) {
+			utilized[prefix] = true
+		}
+	}
+
+	var declarations []namespaceDeclaration
+	for prefix := range utilized {
+		uri := e.lookupNamespace(prefix)
+		previous, wasRendered := rendered[prefix]
+		if (wasRendered && previous == uri) || (!wasRendered && uri == "") {
+			continue
+		}
+		declarations = append(declarations, namespaceDeclaration{prefix: prefix, uri: uri})
+	}
+	sort.Slice(declarations, func(i, j int) bool { return declarations[i].prefix < declarations[j].prefix })
+
+	if len(declarations) > 0 {
+		inherited := rendered
+		rendered = make(map[string]string, len(inherited)+len(declarations))
+		for prefix, uri := range inherited {
+			rendered[prefix] = uri
+		}
+		for _, declaration := range declarations {
+			rendered[declaration.prefix] = declaration.uri
+		}
+	}
+
+	name := qualifiedName(e.prefix, e.local)
+	buffer.WriteByte('<')
+	buffer.WriteString(name)
+	for _, declaration := range declarations {
+		if declaration.prefix == "" {
+			buffer.WriteString(` xmlns="`)
+		} else {
+			buffer.WriteString(` xmlns:` + declaration.prefix + `="`)
+		}
+		buffer.WriteString(escapeAttributeValue(declaration.uri))
+		buffer.WriteByte('"')
+	}
+
+	// Attributes are sorted by namespace URI, then local name; unqualified ones have no namespace
+	var attrs []xml.Attr
+	for _, attr := range e.attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		iNamespace, jNamespace := e.attrNamespace(attrs[i]), e.attrNamespace(attrs[j])
+		if iNamespace != jNamespace {
+			return iNamespace < jNamespace
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+	for _, attr := range attrs {
+		buffer.WriteByte(' ')
+		buffer.WriteString(qualifiedName(attr.Name.Space, attr.Name.Local))
+		buffer.WriteString(`="`)
+		buffer.WriteString(escapeAttributeValue(attr.Value))
+		buffer.WriteByte('"')
+	}
+	buffer.WriteByte('>')
+
+	for _, child := range e.children {
+		switch child := child.(type) {
+		case text:
+			buffer.WriteString(escapeText(string(child)))
+		case *element:
+			if child != excluded {
+				canonicalizeElement(buffer, child, excluded, rendered, inclusive)
+			}
+		}
+	}
+
+	buffer.WriteString("</")
+	buffer.WriteString(name)
+	buffer.WriteByte('>')
+}
+
+// inScope reports whether a namespace prefix is declared on the element or one of its ancestors
+func (e *element) inScope(prefix string) bool {
+	return prefix == "" || e.lookupNamespace(prefix) != ""
+}
+
+func (e *element) attrNamespace(attr xml.Attr) string {
+	if attr.Name.Space == "" {
+		return ""
+	}
+	return e.lookupNamespace(attr.Name.Space)
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var attributeEscaper = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;",
+)
+
+func escapeText(value string) string {
+	return textEscaper.Replace(value)
+}
+
+func escapeAttributeValue(value string) string {
+	return attributeEscaper.Replace(value)
+}
@@ -0,0 +1,103 @@
+package saml
+
+import "testing"
+
+// The expected outputs are written out by hand from the Exclusive XML Canonicalization rules, so a
+// mistake in canonicalize cannot be hidden by signing and verifying with the same code
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		path      []int
+		excluded  []int
+		inclusive []string
+		want      string
+	}{
+		{
+			name:     "namespaces and attributes are sorted",
+			document: `<a:root xmlns:b="urn:b" xmlns="urn:default" xmlns:a="urn:a" z="1" b:y="2" a:x="3"><child attr="&lt;&quot;&#9;&gt;">t&amp;&gt;<b:leaf/></child></a:root>`,
+			want:     `<a:root xmlns:a="urn:a" xmlns:b="urn:b" z="1" a:x="3" b:y="2"><child xmlns="urn:default" attr="&lt;&quot;&#x9;>">t&amp;&gt;<b:leaf></b:leaf></child></a:root>`,
+		},
+		{
+			name:     "namespaces of ancestors are rendered where they are used",
+			document: `<r xmlns:s="urn:s" xmlns:unused="urn:unused"><s:e s:a="1">x</s:e></r>`,
+			path:     []int{0},
+			want:     `<s:e xmlns:s="urn:s" s:a="1">x</s:e>`,
+		},
+		{
+			name:      "inclusive namespaces are rendered where they are in scope",
+			document:  `<r xmlns:s="urn:s" xmlns:unused="urn:unused"><s:e>x</s:e></r>`,
+			path:      []int{0},
+			inclusive: []string{"unused", "undeclared"},
+			want:      `<s:e xmlns:s="urn:s" xmlns:unused="urn:unused">x</s:e>`,
+		},
+		{
+			name:      "inclusive default namespace",
+			document:  `<r xmlns="urn:d" xmlns:s="urn:s"><s:e/></r>`,
+			path:      []int{0},
+			inclusive: []string{"#default"},
+			want:      `<s:e xmlns="urn:d" xmlns:s="urn:s"></s:e>`,
+		},
+		{
+			name:     "declarations already rendered are not repeated",
+			document: `<a:r xmlns:a="urn:a"><a:e xmlns:a="urn:a"><a:f xmlns:a="urn:other"/></a:e></a:r>`,
+			want:     `<a:r xmlns:a="urn:a"><a:e><a:f xmlns:a="urn:other"></a:f></a:e></a:r>`,
+		},
+		{
+			name:     "undeclared default namespace",
+			document: `<r xmlns="urn:d"><e xmlns=""/></r>`,
+			want:     `<r xmlns="urn:d"><e xmlns=""></e></r>`,
+		},
+		{
+			name:     "empty default namespace that was never declared",
+			document: `<r><e xmlns=""/></r>`,
+			want:     `<r><e></e></r>`,
+		},
+		{
+			name:     "comments are removed",
+			document: `<r>a<!-- comment -->b<e><!----></e></r>`,
+			want:     `<r>ab<e></e></r>`,
+		},
+		{
+			name:     "the excluded element is left out",
+			document: `<r><keep/><drop><x/></drop>tail</r>`,
+			excluded: []int{1},
+			want:     `<r><keep></keep>tail</r>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseDocument([]byte(tt.document))
+			if err != nil {
+				t.Fatalf("parseDocument: %v", err)
+			}
+			var excluded *element
+			if tt.excluded != nil {
+				excluded = childAt(t, root, tt.excluded)
+			}
+
+			got := string(canonicalize(childAt(t, root, tt.path), excluded, tt.inclusive))
+			if got != tt.want {
+				t.Fatalf("canonicalize =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// childAt follows a path of child element indexes from e
+func childAt(t *testing.T, e *element, path []int) *element {
+	t.Helper()
+	for _, index := range path {
+		var children []*element
+		for _, child := range e.children {
+			if el, ok := child.(*element); ok {
+				children = append(children, el)
+			}
+		}
+		if index >= len(children) {
+			t.Fatalf("element %s has no child %d", e.local, index)
+		}
+		e = children[index]
+	}
+	return e
+}
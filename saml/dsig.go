@@ -0,0 +1,185 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Algorithm identifiers of XML Signature (RFC 6931)
+const (
+	algorithmExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algorithmEnvelopedSig = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algorithmSHA256       = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algorithmSHA512       = "http://www.w3.org/2001/04/xmlenc#sha512"
+	algorithmRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algorithmRSASHA512    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algorithmECDSASHA256  = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	algorithmECDSASHA512  = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+var digestAlgorithms = map[string]crypto.Hash{
+	algorithmSHA256: crypto.SHA256,
+	algorithmSHA512: crypto.SHA512,
+}
+
+var signatureAlgorithms = map[string]crypto.Hash{
+	algorithmRSASHA256:   crypto.SHA256,
+	algorithmRSASHA512:   crypto.SHA512,
+	algorithmECDSASHA256: crypto.SHA256,
+	algorithmECDSASHA512: crypto.SHA512,
+}
+
+// errNotSigned is returned by verifySignature for an element without a signature
+var errNotSigned = errors.New("saml: element is not signed")
+
+// verifySignature checks the enveloped signature of an element against the certificates of the
+// identity provider. The signature must be a direct child of the element and reference it by ID,
+// so a signature over some other part of the document cannot vouch for it. Keys the signature
+// carries itself are ignored. SHA-1 is not accepted.
+func verifySignature(e *element, certificates []*x509.Certificate) error {
+	signatures := e.childElements(namespaceDSig, "Signature")
+	if len(signatures) == 0 {
+		return errNotSigned
+	}
+	if len(signatures) > 1 {
+		return errors.New("saml: element has several signatures")
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.child(namespaceDSig, "SignedInfo")
+	signatureValue := signature.child(namespaceDSig, "SignatureValue")
+	if signedInfo == nil || signatureValue == nil {
+		return errors.New("saml: malformed signature")
+	}
+
+	canonicalization := signedInfo.child(namespaceDSig, "CanonicalizationMethod")
+	if canonicalization == nil || canonicalization.attr("Algorithm") != algorithmExcC14N {
+		return errors.New("saml: unsupported canonicalization method")
+	}
+	signatureMethod := signedInfo.child(namespaceDSig, "SignatureMethod")
+	if signatureMethod == nil {
+		return errors.New("saml: malformed signature")
+	}
+	signatureHash, ok := signatureAlgorithms[signatureMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported signature algorithm %q", signatureMethod.attr("Algorithm"))
+	}
+
+	// The reference must cover exactly this element
+	reference := signedInfo.child(namespaceDSig, "Reference")
+	if reference == nil {
+		return errors.New("saml: signature must have exactly one reference")
+	}
+	id := e.attr("ID")
+	if id == "" || reference.attr("URI") != "#"+id {
+		return errors.New("saml: signature does not reference the signed element")
+	}
+	if err := verifyDigest(e, signature, reference); err != nil {
+		return err
+	}
+
+	value, err := base64.StdEncoding.DecodeString(stripWhitespace(signatureValue.text()))
+	if err != nil {
+		return errors.New("saml: malformed signature value")
+	}
+	hasher := signatureHash.New()
+	hasher.Write(canonicalize(signedInfo, nil, inclusiveNamespaces(canonicalization)))
+	digest := hasher.Sum(nil)
+
+	for _, certificate := range certificates {
+		if verifyWithKey(certificate.PublicKey, signatureMethod.attr("Algorithm"), signatureHash, digest, value) {
+			return nil
+		}
+	}
+	return errors.New("saml: signature verification failed")
+}
+
+// verifyDigest checks the digest of the referenced element after applying its transforms, which
+// must be the enveloped signature transform and exclusive canonicalization
+func verifyDigest(e, signature, reference *element) error {
+	var inclusivePrefixes []string
+	canonicalized := false
+	if transforms := reference.child(namespaceDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.childElements(namespaceDSig, "Transform") {
+			switch transform.attr("Algorithm") {
+			case algorithmEnvelopedSig:
+			case algorithmExcC14N:
+				canonicalized = true
+				inclusivePrefixes = inclusiveNamespaces(transform)
+			default:
+				return fmt.Errorf("saml: unsupported transform %q", transform.attr("Algorithm"))
+			}
+		}
+	}
+	if !canonicalized {
+		return errors.New("saml: signature must use exclusive canonicalization")
+	}
+
+	digestMethod := reference.child(namespaceDSig, "DigestMethod")
+	digestValue := reference.child(namespaceDSig, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return errors.New("saml: malformed signature reference")
+	}
+	digestHash, ok := digestAlgorithms[digestMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("saml: unsupported digest algorithm %q", digestMethod.attr("Algorithm"))
+	}
+	expected, err := base64.StdEncoding.DecodeString(stripWhitespace(digestValue.text()))
+	if err != nil {
+		return errors.New("saml: malformed digest value")
+	}
+
+	// The enveloped signature transform always applies: the signature cannot cover itself
+	hasher := digestHash.New()
+	hasher.Write(canonicalize(e, signature, inclusivePrefixes))
+	if subtle.ConstantTimeCompare(hasher.Sum(nil), expected) != 1 {
+		return errors.New("saml: digest of the signed element does not match")
+	}
+	return nil
+}
+
+// inclusiveNamespaces reads the InclusiveNamespaces PrefixList parameter of a canonicalization method
+func inclusiveNamespaces(method *element) []string {
+	parameter := method.child(namespaceExcC14N, "InclusiveNamespaces")
+	if parameter == nil {
+		return nil
+	}
+	return strings.Fields(parameter.attr("PrefixList"))
+}
+
+func verifyWithKey(publicKey interface{}, algorithm string, hash crypto.Hash, digest, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if algorithm != algorithmRSASHA256 && algorithm != algorithmRSASHA512 {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if algorithm != algorithmECDSASHA256 && algorithm != algorithmECDSASHA512 {
+			return false
+		}
+		// XML signatures carry r and s concatenated, each the size of the curve order. Some signers
+		// send the ASN.1 encoding instead.
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ecdsa.VerifyASN1(key, digest, signature)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func stripWhitespace(value string) string {
+	return strings.Join(strings.Fields(value), "")
+}
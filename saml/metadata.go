@@ -0,0 +1,134 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+)
+
+// Binding and name identifier URIs
+const (
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	NameIDFormatPersistent   = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatTransient    = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+	NameIDFormatEmailAddress = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+)
+
+// IdentityProvider is the configuration of a SAML identity provider
+type IdentityProvider struct {
+	EntityID string
+	// SSOURL is the single sign-on endpoint of the HTTP-Redirect binding
+	SSOURL string
+	// Certificates verify the signatures of the provider; several are allowed during key rollover
+	Certificates []*x509.Certificate
+}
+
+// ParseIdentityProviderMetadata reads an identity provider from its metadata document
+func ParseIdentityProviderMetadata(data []byte) (*IdentityProvider, error) {
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !root.is(namespaceMetadata, "EntityDescriptor") {
+		return nil, errors.New("saml: metadata must be an EntityDescriptor")
+	}
+	descriptor := root.child(namespaceMetadata, "IDPSSODescriptor")
+	if descriptor == nil {
+		return nil, errors.New("saml: metadata has no IDPSSODescriptor")
+	}
+
+	provider := &IdentityProvider{EntityID: root.attr("entityID")}
+	for _, service := range descriptor.childElements(namespaceMetadata, "SingleSignOnService") {
+		if service.attr("Binding") == BindingHTTPRedirect {
+			provider.SSOURL = service.attr("Location")
+			break
+		}
+	}
+	for _, keyDescriptor := range descriptor.childElements(namespaceMetadata, "KeyDescriptor") {
+		if use := keyDescriptor.attr("use"); use != "" && use != "signing" {
+			continue
+		}
+		keyInfo := keyDescriptor.child(namespaceDSig, "KeyInfo")
+		if keyInfo == nil {
+			continue
+		}
+		for _, data := range keyInfo.childElements(namespaceDSig, "X509Data") {
+			for _, encoded := range data.childElements(namespaceDSig, "X509Certificate") {
+				der, err := base64.StdEncoding.DecodeString(stripWhitespace(encoded.text()))
+				if err != nil {
+					return nil, errors.New("saml: malformed certificate in metadata")
+				}
+				certificate, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("saml: invalid certificate in metadata: %w", err)
+				}
+				provider.Certificates = append(provider.Certificates, certificate)
+			}
+		}
+	}
+
+	if provider.EntityID == "" || provider.SSOURL == "" || len(provider.Certificates) == 0 {
+		return nil, errors.New("saml: metadata needs an entityID, an HTTP-Redirect SingleSignOnService and a signing certificate")
+	}
+	return provider, nil
+}
+
+type entityDescriptor struct {
+	XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string          `xml:"entityID,attr"`
+	SPSSODescriptor spSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool                     `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool                     `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string                   `xml:"protocolSupportEnumeration,attr"`
+	KeyDescriptor              keyDescriptor            `xml:"KeyDescriptor"`
+	NameIDFormat               string                   `xml:"NameIDFormat"`
+	AssertionConsumerService   assertionConsumerService `xml:"AssertionConsumerService"`
+}
+
+type keyDescriptor struct {
+	Use     string  `xml:"use,attr"`
+	KeyInfo keyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+}
+
+type keyInfo struct {
+	X509Certificate string `xml:"http://www.w3.org/2000/09/xmldsig# X509Data>X509Certificate"`
+}
+
+type assertionConsumerService struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+// Metadata returns the metadata document identity providers are configured with
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	descriptor := entityDescriptor{
+		EntityID: sp.EntityID,
+		SPSSODescriptor: spSSODescriptor{
+			AuthnRequestsSigned:        true,
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: namespaceProtocol,
+			KeyDescriptor: keyDescriptor{
+				Use:     "signing",
+				KeyInfo: keyInfo{X509Certificate: base64.StdEncoding.EncodeToString(sp.Certificate.Raw)},
+			},
+			NameIDFormat: sp.nameIDFormat(),
+			AssertionConsumerService: assertionConsumerService{
+				Binding:  BindingHTTPPost,
+				Location: sp.ACSURL,
+			},
+		},
+	}
+	encoded, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), encoded...), nil
+}
@@ -0,0 +1,444 @@
+// Package saml implements the service provider side of SAML 2.0 Web Browser SSO: signed
+// AuthnRequests over the HTTP-Redirect binding and validation of responses posted back over the
+// HTTP-POST binding. XML signatures are verified with exclusive canonicalization only, which is
+// what identity providers use for SAML.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	statusSuccess             = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	subjectConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// clockSkew is the difference between clocks tolerated when checking validity periods
+const clockSkew = 2 * time.Minute
+
+// ServiceProvider is this service as a SAML service provider of one identity provider
+type ServiceProvider struct {
+	EntityID string
+	// ACSURL is the assertion consumer service responses are posted to
+	ACSURL string
+	// Key signs AuthnRequests; Certificate publishes its public key in the metadata
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+	// NameIDFormat is requested from the identity provider; persistent when empty
+	NameIDFormat string
+	// ReplayCache remembers the IDs of accepted assertions, so a response cannot be posted twice.
+	// Without one, callers must reject assertion IDs they have seen before.
+	ReplayCache ReplayCache
+}
+
+// ReplayCache remembers keys until they expire
+type ReplayCache interface {
+	// Remember stores key until expiresAt and reports whether it was not stored already
+	Remember(key string, expiresAt time.Time) (bool, error)
+}
+
+// Assertion is the validated content of an identity provider's assertion
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	// Attributes are keyed by Name, and also by FriendlyName when the provider sends one
+	Attributes map[string][]string
+	// ExpiresAt is when the assertion stops being acceptable; its ID must be remembered until then
+	ExpiresAt time.Time
+}
+
+// Attribute returns the first value of an attribute, or "" when it has none
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// NewRequestID returns a random ID for an AuthnRequest. IDs must not start with a digit.
+func NewRequestID() (string, error) {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "id-" + hex.EncodeToString(id), nil
+}
+
+// NewCertificate returns a self-signed certificate for key to publish in the metadata. Identity
+// providers only use the key it carries. The certificate is derived from the key and entity ID
+// alone, so it stays the same across restarts.
+func NewCertificate(key *rsa.PrivateKey, entityID string) (*x509.Certificate, error) {
+	subject := entityID
+	if parsed, err := url.Parse(entityID); err == nil && parsed.Hostname() != "" {
+		subject = parsed.Hostname()
+	}
+	notBefore := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.AddDate(30, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	// PKCS #1 v1.5 signatures are deterministic, so is the certificate
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+type authnRequest struct {
+	XMLName                     xml.Name     `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string       `xml:"ID,attr"`
+	Version                     string       `xml:"Version,attr"`
+	IssueInstant                string       `xml:"IssueInstant,attr"`
+	Destination                 string       `xml:"Destination,attr"`
+	ProtocolBinding             string       `xml:"ProtocolBinding,attr"`
+	AssertionConsumerServiceURL string       `xml:"AssertionConsumerServiceURL,attr"`
+	Issuer                      string       `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                nameIDPolicy `xml:"NameIDPolicy"`
+}
+
+type nameIDPolicy struct {
+	Format      string `xml:"Format,attr"`
+	AllowCreate bool   `xml:"AllowCreate,attr"`
+}
+
+// AuthnRequestURL returns the URL sending the browser to the identity provider with a signed
+// AuthnRequest (HTTP-Redirect binding, SAML bindings section 3.4.4.1)
+func (sp *ServiceProvider) AuthnRequestURL(idp *IdentityProvider, requestID, relayState string) (string, error) {
+	request, err := xml.Marshal(authnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC().Format(time.RFC3339),
+		Destination:                 idp.SSOURL,
+		ProtocolBinding:             BindingHTTPPost,
+		AssertionConsumerServiceURL: sp.ACSURL,
+		Issuer:                      sp.EntityID,
+		NameIDPolicy:                nameIDPolicy{Format: sp.nameIDFormat(), AllowCreate: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var deflated bytes.Buffer
+	writer, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(request); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	// The signature covers the parameters in this order, exactly as they appear in the URL
+	query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(algorithmRSASHA256)
+	digest := sha256.Sum256([]byte(query))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, sp.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+
+	separator := "?"
+	if strings.Contains(idp.SSOURL, "?") {
+		separator = "&"
+	}
+	return idp.SSOURL + separator + query, nil
+}
+
+func (sp *ServiceProvider) nameIDFormat() string {
+	if sp.NameIDFormat == "" {
+		return NameIDFormatPersistent
+	}
+	return sp.NameIDFormat
+}
+
+// ParseResponse validates the base64 encoded Response an identity provider posted to the assertion
+// consumer service in reply to the AuthnRequest requestID, and returns its assertion. The response
+// or the assertion must be signed by the provider. An assertion is accepted once; its ID is kept in
+// the replay cache until ExpiresAt.
+func (sp *ServiceProvider) ParseResponse(idp *IdentityProvider, encodedResponse, requestID string, now time.Time) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(stripWhitespace(encodedResponse))
+	if err != nil {
+		return nil, errors.New("saml: response is not base64 encoded")
+	}
+	response, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !response.is(namespaceProtocol, "Response") {
+		return nil, errors.New("saml: document is not a Response")
+	}
+	if err := checkUniqueIDs(response); err != nil {
+		return nil, err
+	}
+
+	if len(response.childElements(namespaceAssertion, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("saml: encrypted assertions are not supported")
+	}
+	assertion := response.child(namespaceAssertion, "Assertion")
+	if assertion == nil {
+		return nil, errors.New("saml: response must contain exactly one assertion")
+	}
+
+	// A signed response covers its assertion; otherwise the assertion must be signed itself.
+	// A signature that is present must be valid either way.
+	responseErr := verifySignature(response, idp.Certificates)
+	assertionErr := verifySignature(assertion, idp.Certificates)
+	switch {
+	case responseErr != nil && responseErr != errNotSigned:
+		return nil, responseErr
+	case assertionErr != nil && assertionErr != errNotSigned:
+		return nil, assertionErr
+	case responseErr == errNotSigned && assertionErr == errNotSigned:
+		return nil, errors.New("saml: neither the response nor the assertion is signed")
+	}
+
+	if err := sp.checkResponse(idp, response, requestID); err != nil {
+		return nil, err
+	}
+	result, err := sp.readAssertion(idp, assertion, requestID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if sp.ReplayCache != nil {
+		fresh, err := sp.ReplayCache.Remember(result.ID, result.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, fmt.Errorf("saml: assertion %s was already used", result.ID)
+		}
+	}
+	return result, nil
+}
+
+func (sp *ServiceProvider) checkResponse(idp *IdentityProvider, response *element, requestID string) error {
+	if response.attr("Version") != "2.0" {
+		return errors.New("saml: unsupported response version")
+	}
+	if destination := response.attr("Destination"); destination != "" && destination != sp.ACSURL {
+		return fmt.Errorf("saml: response is meant for %s", destination)
+	}
+	if response.attr("InResponseTo") != requestID {
+		return errors.New("saml: response does not answer this login request")
+	}
+	if issuer := response.child(namespaceAssertion, "Issuer"); issuer != nil && issuer.text() != idp.EntityID {
+		return errors.New("saml: response is from an unexpected issuer")
+	}
+
+	status := response.child(namespaceProtocol, "Status")
+	if status == nil {
+		return errors.New("saml: response has no status")
+	}
+	statusCode := status.child(namespaceProtocol, "StatusCode")
+	if statusCode == nil || statusCode.attr("Value") != statusSuccess {
+		message := ""
+		if statusMessage := status.child(namespaceProtocol, "StatusMessage"); statusMessage != nil {
+			message = ": " + statusMessage.text()
+		}
+		code := ""
+		if statusCode != nil {
+			code = statusCode.attr("Value")
+		}
+		return fmt.Errorf("saml: login failed with status %s%s", code, message)
+	}
+	return nil
+}
+
+func (sp *ServiceProvider) readAssertion(idp *IdentityProvider, assertion *element, requestID string, now time.Time) (*Assertion, error) {
+	result := &Assertion{ID: assertion.attr("ID"), Attributes: make(map[string][]string)}
+	if result.ID == "" || assertion.attr("Version") != "2.0" {
+		return nil, errors.New("saml: malformed assertion")
+	}
+
+	issuer := assertion.child(namespaceAssertion, "Issuer")
+	if issuer == nil || issuer.text() != idp.EntityID {
+		return nil, errors.New("saml: assertion is from an unexpected issuer")
+	}
+	result.Issuer = issuer.text()
+
+	subject := assertion.child(namespaceAssertion, "Subject")
+	if subject == nil {
+		return nil, errors.New("saml: assertion has no subject")
+	}
+	nameID := subject.child(namespaceAssertion, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return nil, errors.New("saml: assertion has no NameID")
+	}
+	result.NameID = nameID.text()
+	result.NameIDFormat = nameID.attr("Format")
+
+	confirmedUntil, err := sp.checkSubjectConfirmation(subject, requestID, now)
+	if err != nil {
+		return nil, err
+	}
+	result.ExpiresAt = confirmedUntil
+
+	conditions := assertion.child(namespaceAssertion, "Conditions")
+	if conditions == nil {
+		return nil, errors.New("saml: assertion has no conditions")
+	}
+	validUntil, err := sp.checkConditions(conditions, now)
+	if err != nil {
+		return nil, err
+	}
+	if !validUntil.IsZero() && validUntil.Before(result.ExpiresAt) {
+		result.ExpiresAt = validUntil
+	}
+
+	authnStatement := assertion.child(namespaceAssertion, "AuthnStatement")
+	if authnStatement == nil {
+		return nil, errors.New("saml: assertion has no authentication statement")
+	}
+	result.SessionIndex = authnStatement.attr("SessionIndex")
+	if sessionEnd := authnStatement.attr("SessionNotOnOrAfter"); sessionEnd != "" {
+		sessionNotOnOrAfter, err := parseTime(sessionEnd)
+		if err != nil {
+			return nil, err
+		}
+		if !now.Before(sessionNotOnOrAfter.Add(clockSkew)) {
+			return nil, errors.New("saml: the identity provider session has ended")
+		}
+	}
+
+	for _, statement := range assertion.childElements(namespaceAssertion, "AttributeStatement") {
+		for _, attribute := range statement.childElements(namespaceAssertion, "Attribute") {
+			var values []string
+			for _, value := range attribute.childElements(namespaceAssertion, "AttributeValue") {
+				values = append(values, value.text())
+			}
+			for _, name := range []string{attribute.attr("Name"), attribute.attr("FriendlyName")} {
+				if name != "" {
+					result.Attributes[name] = append(result.Attributes[name], values...)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// checkSubjectConfirmation requires a bearer confirmation for this login, delivered to this service,
+// and returns until when it may be used
+func (sp *ServiceProvider) checkSubjectConfirmation(subject *element, requestID string, now time.Time) (time.Time, error) {
+	for _, confirmation := range subject.childElements(namespaceAssertion, "SubjectConfirmation") {
+		if confirmation.attr("Method") != subjectConfirmationBearer {
+			continue
+		}
+		data := confirmation.child(namespaceAssertion, "SubjectConfirmationData")
+		if data == nil || data.hasAttr("NotBefore") {
+			continue
+		}
+		if data.attr("Recipient") != sp.ACSURL {
+			continue
+		}
+		if inResponseTo := data.attr("InResponseTo"); inResponseTo != "" && inResponseTo != requestID {
+			continue
+		}
+		notOnOrAfter, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || !now.Before(notOnOrAfter.Add(clockSkew)) {
+			continue
+		}
+		return notOnOrAfter.Add(clockSkew), nil
+	}
+	return time.Time{}, errors.New("saml: assertion has no valid bearer subject confirmation for this service")
+}
+
+// checkConditions checks the validity period and audience restrictions of an assertion and returns
+// the end of the validity period, if it has one
+func (sp *ServiceProvider) checkConditions(conditions *element, now time.Time) (time.Time, error) {
+	if notBefore := conditions.attr("NotBefore"); notBefore != "" {
+		start, err := parseTime(notBefore)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if now.Add(clockSkew).Before(start) {
+			return time.Time{}, errors.New("saml: assertion is not yet valid")
+		}
+	}
+	var validUntil time.Time
+	if notOnOrAfter := conditions.attr("NotOnOrAfter"); notOnOrAfter != "" {
+		end, err := parseTime(notOnOrAfter)
+		if err != nil {
+			return time.Time{}, err
+		}
+		validUntil = end.Add(clockSkew)
+		if !now.Before(validUntil) {
+			return time.Time{}, errors.New("saml: assertion has expired")
+		}
+	}
+
+	// Every audience restriction must include this service
+	restrictions := conditions.childElements(namespaceAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return time.Time{}, errors.New("saml: assertion has no audience restriction")
+	}
+	for _, restriction := range restrictions {
+		allowed := false
+		for _, audience := range restriction.childElements(namespaceAssertion, "Audience") {
+			if audience.text() == sp.EntityID {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return time.Time{}, errors.New("saml: assertion is meant for another audience")
+		}
+	}
+	return validUntil, nil
+}
+
+// checkUniqueIDs rejects documents in which two elements share an ID, which signature wrapping
+// attacks rely on
+func checkUniqueIDs(root *element) error {
+	seen := make(map[string]bool)
+	var duplicate bool
+	root.walk(func(el *element) {
+		if id := el.attr("ID"); id != "" {
+			if seen[id] {
+				duplicate = true
+			}
+			seen[id] = true
+		}
+	})
+	if duplicate {
+		return errors.New("saml: duplicate IDs in document")
+	}
+	return nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("saml: invalid timestamp %q", value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,572 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+const (
+	testRequestID   = "id-request-1"
+	testAssertionID = "id-assertion-1"
+	testResponseID  = "id-response-1"
+	testIdPEntityID = "https://idp.example.org/metadata"
+	testSPEntityID  = "https://sp.example.org/auth/saml/corp/metadata"
+	testACSURL      = "https://sp.example.org/auth/saml/corp/acs"
+
+	algorithmSHA1    = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algorithmRSASHA1 = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+)
+
+// signatureMethod is how a test identity provider signs
+type signatureMethod struct {
+	signature, digest         string
+	signatureHash, digestHash crypto.Hash
+}
+
+var (
+	rsaSHA256 = signatureMethod{algorithmRSASHA256, algorithmSHA256, crypto.SHA256, crypto.SHA256}
+	rsaSHA1   = signatureMethod{algorithmRSASHA1, algorithmSHA1, crypto.SHA1, crypto.SHA1}
+)
+
+// testIdentityProvider signs responses like an identity provider would
+type testIdentityProvider struct {
+	key      *rsa.PrivateKey
+	provider *IdentityProvider
+}
+
+func newTestIdentityProvider(t *testing.T) *testIdentityProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.org"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIdentityProvider{
+		key:      key,
+		provider: &IdentityProvider{EntityID: testIdPEntityID, SSOURL: "https://idp.example.org/sso", Certificates: []*x509.Certificate{certificate}},
+	}
+}
+
+// responseFields fill in responseTemplate. Signatures go where the sign:ID comments are.
+type responseFields struct {
+	ResponseID          string
+	AssertionID         string
+	Destination         string
+	InResponseTo        string
+	Issuer              string
+	NameID              string
+	Recipient           string
+	SubjectInResponseTo string
+	Audience            string
+	IssueInstant        string
+	ConfirmUntil        string
+	ValidFrom           string
+	ValidUntil          string
+}
+
+var responseTemplate = template.Must(template.New("response").Parse(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ` +
+	`xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="{{.ResponseID}}" Version="2.0" IssueInstant="{{.IssueInstant}}" ` +
+	`Destination="{{.Destination}}" InResponseTo="{{.InResponseTo}}">
+  <saml:Issuer>{{.Issuer}}</saml:Issuer><!--sign:{{.ResponseID}}-->
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  <saml:Assertion ID="{{.AssertionID}}" Version="2.0" IssueInstant="{{.IssueInstant}}">
+    <saml:Issuer>{{.Issuer}}</saml:Issuer><!--sign:{{.AssertionID}}-->
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">{{.NameID}}</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="{{.SubjectInResponseTo}}" Recipient="{{.Recipient}}" NotOnOrAfter="{{.ConfirmUntil}}"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="{{.ValidFrom}}" NotOnOrAfter="{{.ValidUntil}}">
+      <saml:AudienceRestriction><saml:Audience>{{.Audience}}</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AuthnStatement AuthnInstant="{{.IssueInstant}}" SessionIndex="session-1"/>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="urn:oid:0.9.2342.19200300.100.1.3" FriendlyName="mail">
+        <saml:AttributeValue>alice@example.org</saml:AttributeValue>
+      </saml:Attribute>
+      <saml:Attribute Name="groups">
+        <saml:AttributeValue>admins</saml:AttributeValue>
+        <saml:AttributeValue>staff</saml:AttributeValue>
+      </saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`))
+
+// validFields describe a response answering testRequestID that is valid at now
+func validFields(now time.Time) responseFields {
+	return responseFields{
+		ResponseID:          testResponseID,
+		AssertionID:         testAssertionID,
+		Destination:         testACSURL,
+		InResponseTo:        testRequestID,
+		Issuer:              testIdPEntityID,
+		NameID:              "alice@example.org",
+		Recipient:           testACSURL,
+		SubjectInResponseTo: testRequestID,
+		Audience:            testSPEntityID,
+		IssueInstant:        now.UTC().Format(time.RFC3339),
+		ConfirmUntil:        now.Add(5 * time.Minute).UTC().Format(time.RFC3339),
+		ValidFrom:           now.Add(-time.Minute).UTC().Format(time.RFC3339),
+		ValidUntil:          now.Add(10 * time.Minute).UTC().Format(time.RFC3339),
+	}
+}
+
+func (f responseFields) render(t *testing.T) string {
+	t.Helper()
+	var document bytes.Buffer
+	if err := responseTemplate.Execute(&document, f); err != nil {
+		t.Fatal(err)
+	}
+	return document.String()
+}
+
+// sign inserts an enveloped signature of the element with the given ID at its sign:ID comment
+func (idp *testIdentityProvider) sign(t *testing.T, document, id string, method signatureMethod) string {
+	t.Helper()
+	signed := findByID(t, document, id)
+	digest := method.digestHash.New()
+	digest.Write(canonicalize(signed, nil, nil))
+
+	signedInfo := fmt.Sprintf(`<ds:SignedInfo xmlns:ds="%s"><ds:CanonicalizationMethod Algorithm="%s"/>`+
+		`<ds:SignatureMethod Algorithm="%s"/><ds:Reference URI="#%s"><ds:Transforms>`+
+		`<ds:Transform Algorithm="%s"/><ds:Transform Algorithm="%s"/></ds:Transforms>`+
+		`<ds:DigestMethod Algorithm="%s"/><ds:DigestValue>%s</ds:DigestValue></ds:Reference></ds:SignedInfo>`,
+		namespaceDSig, algorithmExcC14N, method.signature, id, algorithmEnvelopedSig, algorithmExcC14N,
+		method.digest, base64.StdEncoding.EncodeToString(digest.Sum(nil)))
+	value := idp.signSignedInfo(t, signedInfo, method)
+
+	signature := fmt.Sprintf(`<ds:Signature xmlns:ds="%s">%s<ds:SignatureValue>%s</ds:SignatureValue></ds:Signature>`,
+		namespaceDSig, signedInfo, value)
+	marker := "<!--sign:" + id + "-->"
+	if !strings.Contains(document, marker) {
+		t.Fatalf("document has no place for the signature of %s", id)
+	}
+	return strings.Replace(document, marker, signature, 1)
+}
+
+func (idp *testIdentityProvider) signSignedInfo(t *testing.T, signedInfo string, method signatureMethod) string {
+	t.Helper()
+	root, err := parseDocument([]byte(signedInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher := method.signatureHash.New()
+	hasher.Write(canonicalize(root, nil, nil))
+	value, err := rsa.SignPKCS1v15(rand.Reader, idp.key, method.signatureHash, hasher.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(value)
+}
+
+// findByID parses document and returns the element with the given ID
+func findByID(t *testing.T, document, id string) *element {
+	t.Helper()
+	root, err := parseDocument([]byte(document))
+	if err != nil {
+		t.Fatalf("parseDocument: %v", err)
+	}
+	var found *element
+	root.walk(func(el *element) {
+		if el.attr("ID") == id {
+			found = el
+		}
+	})
+	if found == nil {
+		t.Fatalf("document has no element %s", id)
+	}
+	return found
+}
+
+// redigest updates the digest of the signature of the element with the given ID to match its
+// current content, as an attacker could, leaving the signature over SignedInfo as it was
+func redigest(t *testing.T, document, id string) string {
+	t.Helper()
+	signed := findByID(t, document, id)
+	signature := signed.child(namespaceDSig, "Signature")
+	reference := signature.child(namespaceDSig, "SignedInfo").child(namespaceDSig, "Reference")
+	oldDigest := reference.child(namespaceDSig, "DigestValue").text()
+
+	digest := crypto.SHA256.New()
+	digest.Write(canonicalize(signed, signature, nil))
+	return strings.Replace(document, oldDigest, base64.StdEncoding.EncodeToString(digest.Sum(nil)), 1)
+}
+
+// memoryReplayCache is a replay cache without expiry
+type memoryReplayCache map[string]time.Time
+
+func (c memoryReplayCache) Remember(key string, expiresAt time.Time) (bool, error) {
+	if _, ok := c[key]; ok {
+		return false, nil
+	}
+	c[key] = expiresAt
+	return true, nil
+}
+
+func testServiceProvider() *ServiceProvider {
+	return &ServiceProvider{EntityID: testSPEntityID, ACSURL: testACSURL, ReplayCache: memoryReplayCache{}}
+}
+
+func encode(document string) string {
+	return base64.StdEncoding.EncodeToString([]byte(document))
+}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// document returns the response to post
+		document func(t *testing.T) string
+	}{
+		{
+			name: "signed response",
+			document: func(t *testing.T) string {
+				return idp.sign(t, validFields(now).render(t), testResponseID, rsaSHA256)
+			},
+		},
+		{
+			name: "signed assertion",
+			document: func(t *testing.T) string {
+				return idp.sign(t, validFields(now).render(t), testAssertionID, rsaSHA256)
+			},
+		},
+		{
+			name: "signed response and assertion",
+			document: func(t *testing.T) string {
+				document := idp.sign(t, validFields(now).render(t), testAssertionID, rsaSHA256)
+				return idp.sign(t, document, testResponseID, rsaSHA256)
+			},
+		},
+		{
+			name: "signed assertion with a wrapped signature value",
+			document: func(t *testing.T) string {
+				document := idp.sign(t, validFields(now).render(t), testAssertionID, rsaSHA256)
+				return strings.Replace(document, "<ds:SignatureValue>", "<ds:SignatureValue>\n  ", 1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertion, err := testServiceProvider().ParseResponse(idp.provider, encode(tt.document(t)), testRequestID, now)
+			if err != nil {
+				t.Fatalf("ParseResponse: %v", err)
+			}
+			if assertion.ID != testAssertionID || assertion.Issuer != testIdPEntityID || assertion.NameID != "alice@example.org" ||
+				assertion.NameIDFormat != NameIDFormatEmailAddress || assertion.SessionIndex != "session-1" {
+				t.Fatalf("assertion = %+v", assertion)
+			}
+			if assertion.Attribute("mail") != "alice@example.org" || assertion.Attribute("urn:oid:0.9.2342.19200300.100.1.3") != "alice@example.org" ||
+				strings.Join(assertion.Attributes["groups"], ",") != "admins,staff" {
+				t.Fatalf("attributes = %v", assertion.Attributes)
+			}
+			// The subject confirmation ends before the conditions do
+			if want := now.Add(5*time.Minute + clockSkew).Truncate(time.Second); !assertion.ExpiresAt.Equal(want) {
+				t.Fatalf("ExpiresAt = %v, want %v", assertion.ExpiresAt, want)
+			}
+		})
+	}
+}
+
+func TestParseResponseRejects(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	other := newTestIdentityProvider(t)
+	now := time.Now()
+
+	signedAssertion := func(t *testing.T, fields responseFields) string {
+		return idp.sign(t, fields.render(t), fields.AssertionID, rsaSHA256)
+	}
+	// assertionXML cuts the assertion, with its signature, out of a response
+	assertionXML := func(document string) string {
+		start := strings.Index(document, "<saml:Assertion ")
+		end := strings.Index(document, "</saml:Assertion>") + len("</saml:Assertion>")
+		return document[start:end]
+	}
+	evilFields := validFields(now)
+	evilFields.AssertionID = "id-evil"
+	evilFields.NameID = "admin@example.org"
+
+	tests := []struct {
+		name     string
+		document func(t *testing.T) string
+		wantErr  string
+	}{
+		{
+			name:     "unsigned",
+			document: func(t *testing.T) string { return validFields(now).render(t) },
+			wantErr:  "neither the response nor the assertion is signed",
+		},
+		{
+			name: "signed by another key",
+			document: func(t *testing.T) string {
+				return other.sign(t, validFields(now).render(t), testAssertionID, rsaSHA256)
+			},
+			wantErr: "signature verification failed",
+		},
+		{
+			name: "signed assertion moved out of the way of an unsigned one",
+			document: func(t *testing.T) string {
+				signed := assertionXML(signedAssertion(t, validFields(now)))
+				evil := assertionXML(evilFields.render(t))
+				return strings.Replace(validFields(now).render(t), assertionXML(validFields(now).render(t)),
+					"<samlp:Extensions>"+signed+"</samlp:Extensions>"+evil, 1)
+			},
+			wantErr: "neither the response nor the assertion is signed",
+		},
+		{
+			name: "unsigned assertion next to a signed one",
+			document: func(t *testing.T) string {
+				document := signedAssertion(t, validFields(now))
+				return strings.Replace(document, "</samlp:Response>", assertionXML(evilFields.render(t))+"</samlp:Response>", 1)
+			},
+			wantErr: "exactly one assertion",
+		},
+		{
+			name: "duplicate ID",
+			document: func(t *testing.T) string {
+				// The evil assertion takes the ID of the signed one, which is hidden elsewhere
+				signed := assertionXML(signedAssertion(t, validFields(now)))
+				evil := validFields(now)
+				evil.NameID = "admin@example.org"
+				return strings.Replace(validFields(now).render(t), assertionXML(validFields(now).render(t)),
+					"<samlp:Extensions>"+signed+"</samlp:Extensions>"+assertionXML(evil.render(t)), 1)
+			},
+			wantErr: "duplicate IDs",
+		},
+		{
+			name: "signature moved to another assertion",
+			document: func(t *testing.T) string {
+				signed := signedAssertion(t, validFields(now))
+				signature := signed[strings.Index(signed, "<ds:Signature ") : strings.Index(signed, "</ds:Signature>")+len("</ds:Signature>")]
+				evil := strings.Replace(assertionXML(evilFields.render(t)), "<!--sign:id-evil-->", signature, 1)
+				return strings.Replace(validFields(now).render(t), assertionXML(validFields(now).render(t)),
+					"<samlp:Extensions>"+assertionXML(signed)+"</samlp:Extensions>"+evil, 1)
+			},
+			wantErr: "does not reference the signed element",
+		},
+		{
+			name: "signed response wrapping an injected assertion",
+			document: func(t *testing.T) string {
+				document := idp.sign(t, validFields(now).render(t), testResponseID, rsaSHA256)
+				return strings.Replace(document, assertionXML(document), assertionXML(evilFields.render(t)), 1)
+			},
+			wantErr: "digest of the signed element does not match",
+		},
+		{
+			name: "changed content",
+			document: func(t *testing.T) string {
+				return strings.Replace(signedAssertion(t, validFields(now)), ">alice@example.org</saml:NameID>", ">admin@example.org</saml:NameID>", 1)
+			},
+			wantErr: "digest of the signed element does not match",
+		},
+		{
+			name: "changed digest",
+			document: func(t *testing.T) string {
+				document := signedAssertion(t, validFields(now))
+				digest := findByID(t, document, testAssertionID).child(namespaceDSig, "Signature").
+					child(namespaceDSig, "SignedInfo").child(namespaceDSig, "Reference").child(namespaceDSig, "DigestValue").text()
+				return strings.Replace(document, digest, base64.StdEncoding.EncodeToString(make([]byte, 32)), 1)
+			},
+			wantErr: "digest of the signed element does not match",
+		},
+		{
+			name: "changed SignedInfo",
+			document: func(t *testing.T) string {
+				document := strings.Replace(signedAssertion(t, validFields(now)), ">alice@example.org</saml:NameID>", ">admin@example.org</saml:NameID>", 1)
+				return redigest(t, document, testAssertionID)
+			},
+			wantErr: "signature verification failed",
+		},
+		{
+			name: "SHA-1 signature",
+			document: func(t *testing.T) string {
+				return idp.sign(t, validFields(now).render(t), testAssertionID, rsaSHA1)
+			},
+			wantErr: "unsupported signature algorithm",
+		},
+		{
+			name: "SHA-1 digest",
+			document: func(t *testing.T) string {
+				return idp.sign(t, validFields(now).render(t), testAssertionID, signatureMethod{algorithmRSASHA256, algorithmSHA1, crypto.SHA256, crypto.SHA1})
+			},
+			wantErr: "unsupported digest algorithm",
+		},
+		{
+			name: "two signatures",
+			document: func(t *testing.T) string {
+				document := signedAssertion(t, validFields(now))
+				signature := document[strings.Index(document, "<ds:Signature ") : strings.Index(document, "</ds:Signature>")+len("</ds:Signature>")]
+				return strings.Replace(document, "<saml:Subject>", signature+"<saml:Subject>", 1)
+			},
+			wantErr: "several signatures",
+		},
+		{
+			name: "wrong audience",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.Audience = "https://other-sp.example.org/metadata"
+				return signedAssertion(t, fields)
+			},
+			wantErr: "another audience",
+		},
+		{
+			name: "wrong recipient",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.Recipient = "https://other-sp.example.org/acs"
+				return signedAssertion(t, fields)
+			},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name: "wrong destination",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.Destination = "https://other-sp.example.org/acs"
+				return signedAssertion(t, fields)
+			},
+			wantErr: "meant for",
+		},
+		{
+			name: "response to another request",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.InResponseTo = "id-request-2"
+				return signedAssertion(t, fields)
+			},
+			wantErr: "does not answer this login request",
+		},
+		{
+			name: "assertion confirmed for another request",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.SubjectInResponseTo = "id-request-2"
+				return signedAssertion(t, fields)
+			},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name: "wrong issuer",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.Issuer = "https://other-idp.example.org/metadata"
+				return signedAssertion(t, fields)
+			},
+			wantErr: "unexpected issuer",
+		},
+		{
+			name: "expired subject confirmation",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.ConfirmUntil = now.Add(-clockSkew - time.Second).UTC().Format(time.RFC3339)
+				return signedAssertion(t, fields)
+			},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name: "expired conditions",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.ValidUntil = now.Add(-clockSkew - time.Second).UTC().Format(time.RFC3339)
+				return signedAssertion(t, fields)
+			},
+			wantErr: "assertion has expired",
+		},
+		{
+			name: "not yet valid",
+			document: func(t *testing.T) string {
+				fields := validFields(now)
+				fields.ValidFrom = now.Add(clockSkew + time.Minute).UTC().Format(time.RFC3339)
+				return signedAssertion(t, fields)
+			},
+			wantErr: "not yet valid",
+		},
+		{
+			name: "document type declaration",
+			document: func(t *testing.T) string {
+				return `<?xml version="1.0"?><!DOCTYPE r [<!ENTITY e "alice">]>` + signedAssertion(t, validFields(now))
+			},
+			wantErr: "DTD",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertion, err := testServiceProvider().ParseResponse(idp.provider, encode(tt.document(t)), testRequestID, now)
+			if err == nil {
+				t.Fatalf("ParseResponse accepted the response as %+v", assertion)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ParseResponse error = %v, want one about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A comment splitting the NameID leaves the signature valid, as canonicalization drops comments.
+// The NameID must still be read whole rather than up to the comment.
+func TestParseResponseReadsNameIDAcrossComments(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	now := time.Now()
+	fields := validFields(now)
+	fields.NameID = "alice@example.org.evil.example"
+	document := idp.sign(t, fields.render(t), testAssertionID, rsaSHA256)
+	document = strings.Replace(document, "alice@example.org.evil.example", "alice@example.org<!---->.evil.example", 1)
+
+	assertion, err := testServiceProvider().ParseResponse(idp.provider, encode(document), testRequestID, now)
+	if err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if assertion.NameID != "alice@example.org.evil.example" {
+		t.Fatalf("NameID = %q, want the whole signed value", assertion.NameID)
+	}
+}
+
+func TestParseResponseRejectsReplays(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	now := time.Now()
+	sp := testServiceProvider()
+	response := encode(idp.sign(t, validFields(now).render(t), testAssertionID, rsaSHA256))
+
+	if _, err := sp.ParseResponse(idp.provider, response, testRequestID, now); err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if _, err := sp.ParseResponse(idp.provider, response, testRequestID, now); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("replayed ParseResponse error = %v, want a replay", err)
+	}
+
+	// A response that fails validation does not use up its assertion ID
+	fresh := testServiceProvider()
+	if _, err := fresh.ParseResponse(idp.provider, response, "id-request-2", now); err == nil {
+		t.Fatal("ParseResponse accepted a response to another request")
+	}
+	if _, err := fresh.ParseResponse(idp.provider, response, testRequestID, now); err != nil {
+		t.Fatalf("ParseResponse after a rejected attempt: %v", err)
+	}
+}
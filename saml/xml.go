@@ -0,0 +1,184 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Namespaces used by SAML messages and their signatures
+const (
+	namespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	namespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	namespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	namespaceDSig      = "http://www.w3.org/2000/09/xmldsig#"
+	namespaceExcC14N   = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	namespaceXML       = "http://www.w3.org/XML/1998/namespace"
+)
+
+// maxDocumentSize bounds the XML documents that are parsed
+const maxDocumentSize = 1 << 20
+
+// element is a node of a parsed document. Prefixes and namespace declarations are kept as written,
+// which canonicalization needs; names are resolved to namespaces on demand.
+type element struct {
+	prefix   string
+	local    string
+	attrs    []xml.Attr
+	children []node
+	parent   *element
+}
+
+// node is a child of an element: either an *element or text
+type node interface{}
+
+type text string
+
+// parseDocument parses a document into its root element. Comments and processing instructions are
+// dropped, and documents with a DTD are rejected so entity declarations cannot be abused.
+func parseDocument(data []byte) (*element, error) {
+	if len(data) > maxDocumentSize {
+		return nil, errors.New("saml: document too large")
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *element
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("saml: invalid XML: %w", err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if root != nil && current == nil {
+				return nil, errors.New("saml: document has several root elements")
+			}
+			el := &element{
+				prefix: token.Name.Space,
+				local:  token.Name.Local,
+				attrs:  append([]xml.Attr(nil), token.Attr...),
+				parent: current,
+			}
+			if current == nil {
+				root = el
+			} else {
+				current.children = append(current.children, el)
+			}
+			current = el
+		case xml.EndElement:
+			if current == nil || token.Name.Space != current.prefix || token.Name.Local != current.local {
+				return nil, errors.New("saml: invalid XML: mismatched end tag")
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, text(token))
+			} else if len(bytes.TrimSpace(token)) > 0 {
+				return nil, errors.New("saml: invalid XML: text outside the root element")
+			}
+		case xml.Directive:
+			return nil, errors.New("saml: documents with a DTD are not accepted")
+		}
+	}
+	if root == nil || current != nil {
+		return nil, errors.New("saml: invalid XML: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNamespace returns the namespace bound to prefix where the element is, "" for none
+func (e *element) lookupNamespace(prefix string) string {
+	if prefix == "xml" {
+		return namespaceXML
+	}
+	for el := e; el != nil; el = el.parent {
+		for _, attr := range el.attrs {
+			if prefix == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+				return attr.Value
+			}
+			if prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// is reports whether the element has the given namespace and local name
+func (e *element) is(namespace, local string) bool {
+	return e.local == local && e.lookupNamespace(e.prefix) == namespace
+}
+
+// attr returns the value of an unprefixed attribute
+func (e *element) attr(name string) string {
+	for _, attr := range e.attrs {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (e *element) hasAttr(name string) bool {
+	for _, attr := range e.attrs {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+// childElements returns the child elements with the given namespace and local name
+func (e *element) childElements(namespace, local string) []*element {
+	var matches []*element
+	for _, child := range e.children {
+		if el, ok := child.(*element); ok && el.is(namespace, local) {
+			matches = append(matches, el)
+		}
+	}
+	return matches
+}
+
+// child returns the only child element with the given name, or nil when there is none or several
+func (e *element) child(namespace, local string) *element {
+	matches := e.childElements(namespace, local)
+	if len(matches) != 1 {
+		return nil
+	}
+	return matches[0]
+}
+
+// text returns the text content of the element, with surrounding whitespace removed
+func (e *element) text() string {
+	var content strings.Builder
+	var collect func(el *element)
+	collect = func(el *element) {
+		for _, child := range el.children {
+			switch child := child.(type) {
+			case text:
+				content.WriteString(string(child))
+			case *element:
+				collect(child)
+			}
+		}
+	}
+	collect(e)
+	return strings.TrimSpace(content.String())
+}
+
+// walk calls fn for the element and all its descendants
+func (e *element) walk(fn func(el *element)) {
+	fn(e)
+	for _, child := range e.children {
+		if el, ok := child.(*element); ok {
+			el.walk(fn)
+		}
+	}
+}
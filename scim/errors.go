@@ -0,0 +1,57 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Detail error types (RFC 7644 section 3.12)
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorNoTarget      = "noTarget"
+	ErrorInvalidValue  = "invalidValue"
+)
+
+// Error is the error response of the protocol
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError creates an error response with an HTTP status and, for 400 and 409, a detail error type
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{MessageError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// BadRequest creates a 400 error of a detail error type
+func BadRequest(scimType, format string, args ...any) *Error {
+	return NewError(http.StatusBadRequest, scimType, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	if e.ScimType != "" {
+		return "scim: " + e.ScimType + ": " + e.Detail
+	}
+	return "scim: " + e.Detail
+}
+
+// StatusCode returns the HTTP status of the error
+func (e *Error) StatusCode() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
@@ -0,0 +1,255 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Comparison is an equality comparison of an attribute. Attribute is lower-cased, with sub-attributes
+// joined by a dot, e.g. "emails.value". Value is the compared value as a string; booleans and numbers
+// keep their literal form.
+type Comparison struct {
+	Attribute string
+	Value     string
+}
+
+// Filter is a conjunction of equality comparisons. That is the part of the filter language
+// identity providers send, e.g. userName eq "bjensen" or id eq "7" and members[value eq "9"].
+type Filter []Comparison
+
+// ParseFilter parses a filter; other operators, "or", "not" and grouping are rejected with an
+// invalidFilter error
+func ParseFilter(filter string) (Filter, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens}
+	parsed, err := parser.conjunction("")
+	if err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		return nil, BadRequest(ErrorInvalidFilter, "unexpected %q in filter", parser.peek().text)
+	}
+	return parsed, nil
+}
+
+// Get returns the value an attribute is compared with
+func (f Filter) Get(attribute string) (string, bool) {
+	for _, comparison := range f {
+		if comparison.Attribute == attribute {
+			return comparison.Value, true
+		}
+	}
+	return "", false
+}
+
+// Path is the target of a PATCH operation, e.g. members[value eq "9"] or emails[type eq "work"].value.
+// Attribute and SubAttribute are lower-cased.
+type Path struct {
+	Attribute    string
+	SubAttribute string
+	// Filter selects values of a multi-valued attribute; nil selects all of them
+	Filter Filter
+}
+
+// ParsePath parses the path of a PATCH operation
+func ParsePath(path string) (*Path, error) {
+	attribute, rest := path, ""
+	if open := strings.IndexByte(path, '['); open >= 0 {
+		attribute, rest = path[:open], path[open:]
+	}
+	attribute = strings.ToLower(stripSchema(attribute))
+	if attribute == "" {
+		return nil, BadRequest(ErrorInvalidPath, "invalid path %q", path)
+	}
+
+	parsed := &Path{}
+	if rest == "" {
+		parsed.Attribute, parsed.SubAttribute, _ = strings.Cut(attribute, ".")
+		return parsed, nil
+	}
+	parsed.Attribute = attribute
+
+	closing := strings.LastIndexByte(rest, ']')
+	if closing < 0 {
+		return nil, BadRequest(ErrorInvalidPath, "invalid path %q", path)
+	}
+	filter, err := ParseFilter(rest[1:closing])
+	if err != nil {
+		return nil, BadRequest(ErrorInvalidPath, "invalid filter in path %q", path)
+	}
+	parsed.Filter = filter
+	if after := rest[closing+1:]; after != "" {
+		if !strings.HasPrefix(after, ".") || len(after) == 1 {
+			return nil, BadRequest(ErrorInvalidPath, "invalid path %q", path)
+		}
+		parsed.SubAttribute = strings.ToLower(after[1:])
+	}
+	return parsed, nil
+}
+
+// stripSchema removes the schema URN some providers qualify attributes with, e.g.
+// urn:ietf:params:scim:schemas:core:2.0:User:userName
+func stripSchema(attribute string) string {
+	if !strings.HasPrefix(strings.ToLower(attribute), "urn:") {
+		return attribute
+	}
+	return attribute[strings.LastIndexByte(attribute, ':')+1:]
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokenOpen, text: "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokenClose, text: "]"})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(filter) && filter[end] != '"'; end++ {
+				if filter[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(filter) {
+				return nil, BadRequest(ErrorInvalidFilter, "unterminated string in filter")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, BadRequest(ErrorInvalidFilter, "invalid string in filter")
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		case c == '(' || c == ')':
+			return nil, BadRequest(ErrorInvalidFilter, "grouping is not supported in filters")
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t[]()\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens   []token
+	position int
+}
+
+func (p *filterParser) done() bool {
+	return p.position >= len(p.tokens)
+}
+
+func (p *filterParser) peek() token {
+	if p.done() {
+		return token{}
+	}
+	return p.tokens[p.position]
+}
+
+func (p *filterParser) next() (token, error) {
+	if p.done() {
+		return token{}, BadRequest(ErrorInvalidFilter, "incomplete filter")
+	}
+	p.position++
+	return p.tokens[p.position-1], nil
+}
+
+// conjunction parses comparisons joined by "and". Inside a value path, prefix is the attribute the
+// comparisons are about.
+func (p *filterParser) conjunction(prefix string) (Filter, error) {
+	var filter Filter
+	for {
+		comparisons, err := p.comparison(prefix)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, comparisons...)
+
+		following := p.peek()
+		if following.kind != tokenWord {
+			return filter, nil
+		}
+		switch strings.ToLower(following.text) {
+		case "and":
+			p.position++
+		case "or":
+			return nil, BadRequest(ErrorInvalidFilter, "\"or\" is not supported in filters")
+		default:
+			return filter, nil
+		}
+	}
+}
+
+func (p *filterParser) comparison(prefix string) (Filter, error) {
+	attribute, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if attribute.kind != tokenWord {
+		return nil, BadRequest(ErrorInvalidFilter, "expected an attribute, got %q", attribute.text)
+	}
+	if strings.EqualFold(attribute.text, "not") {
+		return nil, BadRequest(ErrorInvalidFilter, "\"not\" is not supported in filters")
+	}
+	name := strings.ToLower(stripSchema(attribute.text))
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+
+	// A value path, e.g. members[value eq "9"], compares sub-attributes of the attribute
+	if p.peek().kind == tokenOpen {
+		if prefix != "" {
+			return nil, BadRequest(ErrorInvalidFilter, "nested value paths are not supported")
+		}
+		p.position++
+		filter, err := p.conjunction(name)
+		if err != nil {
+			return nil, err
+		}
+		if closing, err := p.next(); err != nil || closing.kind != tokenClose {
+			return nil, BadRequest(ErrorInvalidFilter, "unterminated value path in filter")
+		}
+		return filter, nil
+	}
+
+	operator, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if operator.kind != tokenWord || !strings.EqualFold(operator.text, "eq") {
+		return nil, BadRequest(ErrorInvalidFilter, "operator %q is not supported, only eq is", operator.text)
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if value.kind != tokenString && value.kind != tokenWord {
+		return nil, BadRequest(ErrorInvalidFilter, "expected a value, got %q", value.text)
+	}
+	return Filter{{Attribute: name, Value: value.text}}, nil
+}
@@ -0,0 +1,233 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   Filter
+	}{
+		{
+			name:   "equality",
+			filter: `userName eq "bjensen"`,
+			want:   Filter{{Attribute: "username", Value: "bjensen"}},
+		},
+		{
+			name:   "keywords and attributes are case-insensitive",
+			filter: `UserName EQ "bjensen" AND externalId Eq "42"`,
+			want:   Filter{{Attribute: "username", Value: "bjensen"}, {Attribute: "externalid", Value: "42"}},
+		},
+		{
+			name:   "schema-qualified attribute",
+			filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`,
+			want:   Filter{{Attribute: "username", Value: "bjensen"}},
+		},
+		{
+			name:   "sub-attribute",
+			filter: `emails.value eq "bjensen@example.com"`,
+			want:   Filter{{Attribute: "emails.value", Value: "bjensen@example.com"}},
+		},
+		{
+			name:   "literals keep their form",
+			filter: `active eq true and employeeNumber eq 701984`,
+			want:   Filter{{Attribute: "active", Value: "true"}, {Attribute: "employeenumber", Value: "701984"}},
+		},
+		{
+			name:   "quoted strings are decoded",
+			filter: `displayName eq "Babs \"B\" Jensen é"`,
+			want:   Filter{{Attribute: "displayname", Value: `Babs "B" Jensen é`}},
+		},
+		{
+			name:   "keywords and brackets inside strings are values",
+			filter: `displayName eq "a or b and not (c) [d]"`,
+			want:   Filter{{Attribute: "displayname", Value: "a or b and not (c) [d]"}},
+		},
+		{
+			name:   "empty string",
+			filter: `externalId eq ""`,
+			want:   Filter{{Attribute: "externalid", Value: ""}},
+		},
+		{
+			name:   "value path",
+			filter: `id eq "7" and members[value eq "9"]`,
+			want:   Filter{{Attribute: "id", Value: "7"}, {Attribute: "members.value", Value: "9"}},
+		},
+		{
+			name:   "conjunction inside a value path binds to the path",
+			filter: `emails[type eq "work" and value eq "b@example.com"] and userName eq "b"`,
+			want: Filter{
+				{Attribute: "emails.type", Value: "work"},
+				{Attribute: "emails.value", Value: "b@example.com"},
+				{Attribute: "username", Value: "b"},
+			},
+		},
+		{
+			name:   "extra whitespace",
+			filter: "  userName\teq   \"bjensen\"  ",
+			want:   Filter{{Attribute: "username", Value: "bjensen"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q): %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseFilter(%q) = %+v, want %+v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+// Filters the parser cannot represent are rejected rather than read as something else, e.g. an
+// "or" read as "and" or a "not" ignored
+func TestParseFilterRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+	}{
+		{name: "empty", filter: ""},
+		{name: "whitespace", filter: "   "},
+		{name: "or", filter: `userName eq "a" or userName eq "b"`},
+		{name: "or after and", filter: `userName eq "a" and active eq true or userName eq "b"`},
+		{name: "or before and", filter: `userName eq "a" OR userName eq "b" and active eq true`},
+		{name: "or inside a value path", filter: `emails[type eq "work" or type eq "home"]`},
+		{name: "not", filter: `not (userName eq "a")`},
+		{name: "not without grouping", filter: `not userName eq "a"`},
+		{name: "not after and", filter: `active eq true and NOT userName eq "a"`},
+		{name: "grouping", filter: `(userName eq "a")`},
+		{name: "grouping after and", filter: `active eq true and (userName eq "a")`},
+		{name: "closing parenthesis", filter: `userName eq "a")`},
+		{name: "other operator", filter: `userName co "a"`},
+		{name: "not equal", filter: `userName ne "a"`},
+		{name: "present", filter: `userName pr`},
+		{name: "missing operator", filter: `userName "a"`},
+		{name: "missing value", filter: `userName eq`},
+		{name: "string as attribute", filter: `"userName" eq "a"`},
+		{name: "bracket as value", filter: `userName eq ]`},
+		{name: "dangling and", filter: `userName eq "a" and`},
+		{name: "leading and", filter: `and userName eq "a"`},
+		{name: "missing conjunction", filter: `userName eq "a" active eq true`},
+		{name: "trailing value", filter: `userName eq "a" "b"`},
+		{name: "unterminated string", filter: `userName eq "a`},
+		{name: "string ending in an escape", filter: `userName eq "a\"`},
+		{name: "invalid escape", filter: `userName eq "\x"`},
+		{name: "lone quote", filter: `"`},
+		{name: "lone backslash", filter: `\`},
+		{name: "lone opening bracket", filter: `[`},
+		{name: "lone closing bracket", filter: `]`},
+		{name: "unterminated value path", filter: `members[value eq "9"`},
+		{name: "empty value path", filter: `members[]`},
+		{name: "value path without attribute", filter: `[value eq "9"]`},
+		{name: "nested value path", filter: `members[value[x eq "1"]]`},
+		{name: "extra closing bracket", filter: `members[value eq "9"]]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			if err == nil {
+				t.Fatalf("ParseFilter(%q) = %+v, want an error", tt.filter, filter)
+			}
+			requireError(t, err, http.StatusBadRequest, ErrorInvalidFilter)
+		})
+	}
+}
+
+// Every prefix of a valid filter is malformed input a client could send; none may panic
+func TestParseFilterTruncated(t *testing.T) {
+	filters := []string{
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "b\"j\\ensené"`,
+		`id eq "7" and members[value eq "9"]`,
+		`emails[type eq "work" and value eq "b@example.com"].value`,
+	}
+	for _, filter := range filters {
+		for end := 0; end <= len(filter); end++ {
+			if _, err := ParseFilter(filter[:end]); err != nil {
+				var scimError *Error
+				if !errors.As(err, &scimError) {
+					t.Fatalf("ParseFilter(%q) returned %T, want *Error", filter[:end], err)
+				}
+			}
+		}
+	}
+}
+
+func FuzzParseFilter(f *testing.F) {
+	f.Add(`userName eq "bjensen"`)
+	f.Add(`id eq "7" and members[value eq "9"]`)
+	f.Add(`not (userName eq "a") or [`)
+	f.Fuzz(func(t *testing.T, filter string) {
+		parsed, err := ParseFilter(filter)
+		if err == nil && len(parsed) == 0 {
+			t.Fatalf("ParseFilter(%q) returned no comparisons and no error", filter)
+		}
+	})
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want *Path
+	}{
+		{path: "active", want: &Path{Attribute: "active"}},
+		{path: "name.givenName", want: &Path{Attribute: "name", SubAttribute: "givenname"}},
+		{path: "urn:ietf:params:scim:schemas:core:2.0:User:userName", want: &Path{Attribute: "username"}},
+		{
+			path: `members[value eq "9"]`,
+			want: &Path{Attribute: "members", Filter: Filter{{Attribute: "value", Value: "9"}}},
+		},
+		{
+			path: `emails[type eq "work"].value`,
+			want: &Path{Attribute: "emails", SubAttribute: "value", Filter: Filter{{Attribute: "type", Value: "work"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParsePath(tt.path)
+			if err != nil {
+				t.Fatalf("ParsePath(%q): %v", tt.path, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParsePath(%q) = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePathRejects(t *testing.T) {
+	for _, path := range []string{
+		"",
+		`[value eq "9"]`,
+		`members[value eq "9"`,
+		`members[value or "9"]`,
+		`emails[type eq "work"]value`,
+		`emails[type eq "work"].`,
+	} {
+		t.Run(path, func(t *testing.T) {
+			parsed, err := ParsePath(path)
+			if err == nil {
+				t.Fatalf("ParsePath(%q) = %+v, want an error", path, parsed)
+			}
+			requireError(t, err, http.StatusBadRequest, ErrorInvalidPath)
+		})
+	}
+}
+
+func requireError(t *testing.T, err error, status int, scimType string) {
+	t.Helper()
+	var scimError *Error
+	if !errors.As(err, &scimError) {
+		t.Fatalf("error %v is %T, want *Error", err, err)
+	}
+	if scimError.StatusCode() != status || scimError.ScimType != scimType {
+		t.Fatalf("error %v has status %d and type %q, want %d and %q",
+			err, scimError.StatusCode(), scimError.ScimType, status, scimType)
+	}
+}
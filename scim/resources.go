@@ -0,0 +1,182 @@
+// Package scim implements the resources and protocol messages of SCIM 2.0 (RFC 7643, RFC 7644)
+// that identity providers use to provision users and groups: the core User and Group schemas,
+// list responses, PATCH requests, errors and the subset of the filter language they send.
+package scim
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Schema and message URNs
+const (
+	SchemaUser          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	MessageListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	MessagePatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	MessageError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// User is the core User resource. Attributes this service does not store, like name, are accepted
+// and dropped.
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Emails     []Email  `json:"emails,omitempty"`
+	// Active is true when absent from a request
+	Active *bool `json:"active,omitempty"`
+	// Password is write-only and never returned
+	Password string `json:"password,omitempty"`
+	Meta     *Meta  `json:"meta,omitempty"`
+}
+
+// PrimaryEmail returns the email marked primary, else the first one, or "" when there is none
+func (u *User) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// IsActive reports whether the user is active, which it is unless set otherwise
+func (u *User) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Group is the core Group resource
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Member is a member of a group; Value is the ID of the user
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	// Version is the entity tag of the resource, e.g. W/"3694e05e9dff590"
+	Version string `json:"version,omitempty"`
+}
+
+// ListResponse is a page of query results. StartIndex counts from 1.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int64    `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// NewListResponse wraps a page of resources starting at startIndex out of total results
+func NewListResponse(resources []any, total int64, startIndex int) *ListResponse {
+	if resources == nil {
+		resources = []any{}
+	}
+	return &ListResponse{
+		Schemas:      []string{MessageListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// PatchRequest modifies a resource with a list of operations applied in order
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// Patch operations
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// PatchOperation is one operation of a PATCH request. Without a path, Value is an object of the
+// attributes to modify.
+type PatchOperation struct {
+	// Op is matched case-insensitively since some providers send "Replace"
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ServiceProviderConfig describes the features of the service provider (RFC 7643 section 5)
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulk                   `json:"bulk"`
+	Filter                filter                 `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type bulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type filter struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
+
+// NewServiceProviderConfig describes what this package supports: PATCH, equality filters returning
+// up to maxResults resources, ETags and password changes, with Bearer token authentication
+func NewServiceProviderConfig(maxResults int) *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas:        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		Patch:          supported{Supported: true},
+		Filter:         filter{Supported: true, MaxResults: maxResults},
+		ChangePassword: supported{Supported: true},
+		ETag:           supported{Supported: true},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "Authentication with an access token or API key in the Authorization header",
+			Primary:     true,
+		}},
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	// APIKeyPrefix starts every API key so they are recognisable, e.g. by secret scanners
+	APIKeyPrefix = "gak_"
+	// apiKeyDisplayLength is how much of a key is stored in clear text to identify it
+	apiKeyDisplayLength = len(APIKeyPrefix) + 8
+)
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is unknown, revoked or expired
+	ErrInvalidAPIKey = errors.New("invalid or expired API key")
+	// ErrAPIKeyNotFound is returned when revoking a key the user does not own
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidAPIKeyScopes is returned when the requested scopes are malformed or not held by the owner
+	ErrInvalidAPIKeyScopes = errors.New("invalid API key scopes")
+	// ErrInvalidAPIKeyExpiry is returned when an API key would expire in the past
+	ErrInvalidAPIKeyExpiry = errors.New("API key expiry must be in the future")
+)
+
+// APIKeyConfig configures API keys
+type APIKeyConfig struct {
+	// TouchInterval limits how often last-used timestamps are written
+	TouchInterval time.Duration
+}
+
+type APIKeyService interface {
+	CreateAPIKey(userID uint, name string, scopes []string, expiresAt *time.Time) (*entities.APIKey, string, error)
+	GetAPIKeysForUser(userID uint) ([]*entities.APIKey, error)
+	RevokeAPIKey(userID, id uint) error
+	Authenticate(key string) (*Principal, error)
+}
+
+type apiKeyService struct {
+	apiKeyRepository     postgres.APIKeyRepository
+	userRepository       postgres.UserRepository
+	permissionRepository postgres.PermissionRepository
+	config               APIKeyConfig
+}
+
+func NewAPIKeyService(
+	apiKeyRepository postgres.APIKeyRepository,
+	userRepository postgres.UserRepository,
+	permissionRepository postgres.PermissionRepository,
+	config APIKeyConfig,
+) APIKeyService {
+	return &apiKeyService{
+		apiKeyRepository:     apiKeyRepository,
+		userRepository:       userRepository,
+		permissionRepository: permissionRepository,
+		config:               config,
+	}
+}
+
+// CreateAPIKey creates a key limited to scopes, each of which the user must currently hold.
+// The key itself is only returned here; just its hash is stored.
+func (as *apiKeyService) CreateAPIKey(userID uint, name string, scopes []string, expiresAt *time.Time) (*entities.APIKey, string, error) {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidAPIKeyExpiry
+	}
+
+	scopes = parseScopes(strings.Join(scopes, " "))
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyScopes)
+	}
+	for _, scope := range scopes {
+		resource, action, found := strings.Cut(scope, ":")
+		if !found || resource == "" || action == "" {
+			return nil, "", fmt.Errorf("%w: %q is not of the form resource:action", ErrInvalidAPIKeyScopes, scope)
+		}
+		held, err := as.permissionRepository.CheckUserPermission(userID, resource, action)
+		if err != nil {
+			return nil, "", err
+		}
+		if !held {
+			return nil, "", fmt.Errorf("%w: you do not have the %q permission", ErrInvalidAPIKeyScopes, scope)
+		}
+	}
+
+	secret, err := generateToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey, err := as.apiKeyRepository.Create(&postgres.PostgresAPIKey{
+		APIKey: entities.APIKey{
+			UserID:    userID,
+			Name:      name,
+			Prefix:    key[:apiKeyDisplayLength],
+			KeyHash:   hashToken(key),
+			Scopes:    strings.Join(scopes, " "),
+			ExpiresAt: expiresAt,
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &apiKey.APIKey, key, nil
+}
+
+func (as *apiKeyService) GetAPIKeysForUser(userID uint) ([]*entities.APIKey, error) {
+	postgresAPIKeys, err := as.apiKeyRepository.GetForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := make([]*entities.APIKey, 0, len(postgresAPIKeys))
+	for _, postgresAPIKey := range postgresAPIKeys {
+		apiKeys = append(apiKeys, &postgresAPIKey.APIKey)
+	}
+	return apiKeys, nil
+}
+
+func (as *apiKeyService) RevokeAPIKey(userID, id uint) error {
+	revoked, err := as.apiKeyRepository.Revoke(userID, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves an API key to its owner, limited to the key's scopes
+func (as *apiKeyService) Authenticate(key string) (*Principal, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := as.apiKeyRepository.GetByHash(hashToken(key))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	user, err := as.userRepository.GetByID(apiKey.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= as.config.TouchInterval {
+		if err := as.apiKeyRepository.TouchLastUsed(apiKey.ID, now); err != nil {
+			log.Printf("Error updating last used time of API key %d: %v", apiKey.ID, err)
+		}
+	}
+
+	return &Principal{
+		User:      user.User,
+		APIKeyID:  apiKey.ID,
+		Scopes:    parseScopes(apiKey.Scopes),
+		ExpiresAt: apiKey.ExpiresAt,
+	}, nil
+}
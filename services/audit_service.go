@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+// Audited actions
+const (
+	AuditImpersonationStarted = "impersonation.started"
+	AuditImpersonationDenied  = "impersonation.denied"
+	// AuditImpersonatedRequest is recorded for every request made while impersonating a user
+	AuditImpersonatedRequest = "impersonation.request"
+)
+
+// maxAuditEvents bounds a page of the audit log
+const maxAuditEvents = 500
+
+// AuditService keeps the audit log of sensitive actions. Events are also written to the
+// application log, so they survive even when the database write fails.
+type AuditService interface {
+	Record(event *entities.AuditEvent) error
+	RecordImpersonatedRequest(principal *Principal, request string, status int, client ClientInfo)
+	GetEvents(query postgres.AuditEventQuery) ([]*entities.AuditEvent, error)
+}
+
+type auditService struct {
+	auditEventRepository postgres.AuditEventRepository
+}
+
+func NewAuditService(auditEventRepository postgres.AuditEventRepository) AuditService {
+	return &auditService{
+		auditEventRepository: auditEventRepository,
+	}
+}
+
+func (as *auditService) Record(event *entities.AuditEvent) error {
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+	log.Printf("Audit: %s actor=%s subject=%s session=%s ip=%s detail=%q",
+		event.Action, auditUserID(event.ActorID), auditUserID(event.SubjectID), event.SessionID, event.IPAddress, event.Detail)
+	return as.auditEventRepository.Create(&postgres.PostgresAuditEvent{AuditEvent: *event})
+}
+
+// RecordImpersonatedRequest records a request, e.g. "GET /user/profile", made by an administrator
+// acting as a user and the status it was answered with. Principals not impersonating anyone are
+// ignored. Failures are logged since the request has already been served.
+func (as *auditService) RecordImpersonatedRequest(principal *Principal, request string, status int, client ClientInfo) {
+	if principal.ImpersonatorID == 0 {
+		return
+	}
+	impersonatorID, userID := principal.ImpersonatorID, principal.User.ID
+	err := as.Record(&entities.AuditEvent{
+		Action:    AuditImpersonatedRequest,
+		ActorID:   &impersonatorID,
+		SubjectID: &userID,
+		SessionID: principal.SessionID,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Detail:    fmt.Sprintf("%s -> %d", request, status),
+	})
+	if err != nil {
+		log.Printf("Error recording impersonated request: %v", err)
+	}
+}
+
+// GetEvents returns a page of the audit log, newest first. The page size defaults to and is capped
+// at maxAuditEvents.
+func (as *auditService) GetEvents(query postgres.AuditEventQuery) ([]*entities.AuditEvent, error) {
+	if query.Limit <= 0 || query.Limit > maxAuditEvents {
+		query.Limit = maxAuditEvents
+	}
+	postgresEvents, err := as.auditEventRepository.Find(query)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*entities.AuditEvent, 0, len(postgresEvents))
+	for _, postgresEvent := range postgresEvents {
+		events = append(events, &postgresEvent.AuditEvent)
+	}
+	return events, nil
+}
+
+func auditUserID(id *uint) string {
+	if id == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", *id)
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+// AuthenticationService resolves any credential a client may present, a session token,
+// an OAuth client token or an API key, to the principal making the request
+type AuthenticationService interface {
+	Authenticate(credential string) (*Principal, error)
+}
+
+type authenticationService struct {
+	tokenService           TokenService
+	sessionService         SessionService
+	apiKeyService          APIKeyService
+	oauthService           OAuthService
+	revokedTokenRepository postgres.RevokedTokenRepository
+}
+
+func NewAuthenticationService(
+	tokenService TokenService,
+	sessionService SessionService,
+	apiKeyService APIKeyService,
+	oauthService OAuthService,
+	revokedTokenRepository postgres.RevokedTokenRepository,
+) AuthenticationService {
+	return &authenticationService{
+		tokenService:           tokenService,
+		sessionService:         sessionService,
+		apiKeyService:          apiKeyService,
+		oauthService:           oauthService,
+		revokedTokenRepository: revokedTokenRepository,
+	}
+}
+
+func (as *authenticationService) Authenticate(credential string) (*Principal, error) {
+	if strings.HasPrefix(credential, APIKeyPrefix) {
+		return as.apiKeyService.Authenticate(credential)
+	}
+
+	claims, err := as.tokenService.Parse(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := tokenRevoked(as.revokedTokenRepository, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+
+	principal, err := as.authenticateClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Unix(int64(claims["exp"].(float64)), 0)
+	principal.ExpiresAt = &expiresAt
+	return principal, nil
+}
+
+func (as *authenticationService) authenticateClaims(claims jwt.MapClaims) (*Principal, error) {
+	// Tokens without a session were issued to a client acting for its service account
+	if _, hasSession := claims["sid"]; !hasSession {
+		if _, hasClient := claims["client_id"]; hasClient {
+			return as.oauthService.AuthenticateClientToken(claims)
+		}
+	}
+	return as.sessionService.Authenticate(claims)
+}
+
+// tokenRevoked reports whether a token was revoked individually. Revoked tokens are remembered
+// by ID until they expire.
+func tokenRevoked(revokedTokenRepository postgres.RevokedTokenRepository, claims jwt.MapClaims) (bool, error) {
+	tokenID, ok := claims["jti"].(string)
+	if !ok {
+		return false, nil
+	}
+	return revokedTokenRepository.IsRevoked(tokenID)
+}
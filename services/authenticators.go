@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// Authenticator checks a username and password against one credential store. Login tries the
+// configured authenticators in order until one accepts the credentials.
+type Authenticator interface {
+	// Authenticate returns the local user the credentials belong to, or ErrInvalidCredentials
+	// when the store does not know the username or the password is wrong
+	Authenticate(username, password string) (*entities.User, error)
+}
+
+type localAuthenticator struct {
+	userRepository postgres.UserRepository
+	passwordHasher PasswordHasher
+	// dummyHash is verified for usernames without a password, so they are rejected as slowly as
+	// a wrong password and timing does not tell which usernames exist
+	dummyHash string
+}
+
+// NewLocalAuthenticator checks passwords against the hashes stored in the users table
+func NewLocalAuthenticator(userRepository postgres.UserRepository, passwordHasher PasswordHasher) Authenticator {
+	// Made with the configured algorithm and parameters, it costs as much to verify as a real hash
+	dummyHash, err := passwordHasher.Hash("dummy password")
+	if err != nil {
+		log.Printf("Error creating the dummy password hash: %v", err)
+	}
+	return &localAuthenticator{
+		userRepository: userRepository,
+		passwordHasher: passwordHasher,
+		dummyHash:      dummyHash,
+	}
+}
+
+func (la *localAuthenticator) Authenticate(username, password string) (*entities.User, error) {
+	userFound, err := la.userRepository.GetByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		la.passwordHasher.Verify(la.dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the user exists; service accounts, and users provisioned without a password, never
+	// log in with one
+	if userFound.ID == 0 || userFound.IsServiceAccount || userFound.Password == "" {
+		la.passwordHasher.Verify(la.dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+
+	// Verify password
+	if !la.passwordHasher.Verify(userFound.Password, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Upgrade hashes made with an older algorithm or cost while the plain password is at hand
+	if la.passwordHasher.NeedsRehash(userFound.Password) {
+		la.rehashPassword(userFound.ID, password)
+	}
+	return &userFound.User, nil
+}
+
+// rehashPassword stores a fresh hash of password. Failures are only logged since the login itself succeeded.
+func (la *localAuthenticator) rehashPassword(userID uint, password string) {
+	passwordHash, err := la.passwordHasher.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing password for user %d: %v", userID, err)
+		return
+	}
+	if err := la.userRepository.UpdatePassword(userID, passwordHash); err != nil {
+		log.Printf("Error storing rehashed password for user %d: %v", userID, err)
+	}
+}
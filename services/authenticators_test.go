@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// recordingPasswordHasher records the hashes passwords are verified against
+type recordingPasswordHasher struct {
+	PasswordHasher
+	verified []string
+}
+
+func (ph *recordingPasswordHasher) Verify(passwordHash, password string) bool {
+	ph.verified = append(ph.verified, passwordHash)
+	return ph.PasswordHasher.Verify(passwordHash, password)
+}
+
+func TestLocalAuthenticatorVerifiesUnknownUsernames(t *testing.T) {
+	hasher, err := NewPasswordHasher(PasswordHashConfig{
+		Algorithm:         PasswordHashArgon2id,
+		Argon2Memory:      1024,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		Argon2SaltLength:  16,
+		Argon2KeyLength:   32,
+	})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher: %v", err)
+	}
+	passwordHash, err := hasher.Hash("frank-secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	users := newMemoryUserRepository(
+		entities.User{ID: 1, Username: "frank", Password: passwordHash},
+		entities.User{ID: 2, Username: "federated"},
+		entities.User{ID: 3, Username: "robot", Password: passwordHash, IsServiceAccount: true},
+	)
+	recording := &recordingPasswordHasher{PasswordHasher: hasher}
+	authenticator := NewLocalAuthenticator(users, recording)
+
+	user, err := authenticator.Authenticate("frank", "frank-secret")
+	if err != nil || user.ID != 1 {
+		t.Fatalf("Authenticate(frank) = %+v, %v", user, err)
+	}
+
+	// Usernames that cannot log in with a password cost a verification like a wrong password does
+	for _, username := range []string{"frank", "nobody", "federated", "robot"} {
+		t.Run(username, func(t *testing.T) {
+			recording.verified = nil
+			if _, err := authenticator.Authenticate(username, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+			}
+			if len(recording.verified) != 1 || !strings.HasPrefix(recording.verified[0], "$argon2id$") {
+				t.Fatalf("verified against %q, want one argon2id hash", recording.verified)
+			}
+		})
+	}
+
+	// The dummy hash accepts no password, not even the one it was made from
+	if _, err := authenticator.Authenticate("nobody", "dummy password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+	}
+}
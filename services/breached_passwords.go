@@ -0,0 +1,94 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// breachedHashPrefixLength is the number of hex characters used to bucket hashes,
+// matching the range size of the Pwned Passwords k-anonymity API
+const breachedHashPrefixLength = 5
+
+// BreachedPasswordChecker reports whether a password appears in a known breach corpus
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// breachedPasswordList is an in-memory breach corpus bucketed by SHA-1 prefix.
+// Lookups only ever touch the bucket for the password's prefix, so the list can be
+// swapped for a remote range API without changing callers.
+type breachedPasswordList struct {
+	ranges map[string]map[string]struct{}
+}
+
+// NewBreachedPasswordList reads SHA-1 hashes, one per line, optionally followed by
+// ":<count>" as in the Pwned Passwords downloads. Hashes seen fewer than minCount
+// times are skipped, as are blank lines, comments and malformed entries.
+func NewBreachedPasswordList(reader io.Reader, minCount int) (BreachedPasswordChecker, error) {
+	list := &breachedPasswordList{ranges: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, countText, hasCount := strings.Cut(line, ":")
+		if hasCount && minCount > 1 {
+			count, err := strconv.Atoi(strings.TrimSpace(countText))
+			if err != nil || count < minCount {
+				continue
+			}
+		}
+
+		hash = strings.ToUpper(strings.TrimSpace(hash))
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			continue
+		}
+
+		prefix, suffix := hash[:breachedHashPrefixLength], hash[breachedHashPrefixLength:]
+		bucket, ok := list.ranges[prefix]
+		if !ok {
+			bucket = make(map[string]struct{})
+			list.ranges[prefix] = bucket
+		}
+		bucket[suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// LoadBreachedPasswordFile loads a breach corpus from a file in the format accepted by NewBreachedPasswordList
+func LoadBreachedPasswordFile(path string, minCount int) (BreachedPasswordChecker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewBreachedPasswordList(file, minCount)
+}
+
+func (l *breachedPasswordList) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	bucket, ok := l.ranges[hash[:breachedHashPrefixLength]]
+	if !ok {
+		return false, nil
+	}
+	_, found := bucket[hash[breachedHashPrefixLength:]]
+	return found, nil
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/notifiers"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// EmailVerificationPolicy controls what unverified users are allowed to do
+type EmailVerificationPolicy string
+
+const (
+	// EmailVerificationOptional lets unverified users log in and use their permissions
+	EmailVerificationOptional EmailVerificationPolicy = "optional"
+	// EmailVerificationBlockLogin refuses to log in users until they verify their email
+	EmailVerificationBlockLogin EmailVerificationPolicy = "block_login"
+	// EmailVerificationRestrict lets unverified users log in but denies all permission checks
+	EmailVerificationRestrict EmailVerificationPolicy = "restrict"
+)
+
+// ParseEmailVerificationPolicy returns the policy named value. Unknown names are an error rather
+// than a fallback, since a typo would otherwise silently stop enforcing verification.
+func ParseEmailVerificationPolicy(value string) (EmailVerificationPolicy, error) {
+	switch policy := EmailVerificationPolicy(value); policy {
+	case EmailVerificationOptional, EmailVerificationBlockLogin, EmailVerificationRestrict:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown email verification policy %q, expected %s, %s or %s",
+			value, EmailVerificationOptional, EmailVerificationBlockLogin, EmailVerificationRestrict)
+	}
+}
+
+var (
+	// ErrInvalidVerificationToken is returned when a verification token is unknown, expired or already used
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	// ErrVerificationThrottled is returned when verification emails are requested too often
+	ErrVerificationThrottled = errors.New("too many verification emails requested")
+)
+
+// EmailVerificationConfig configures the email verification flow
+type EmailVerificationConfig struct {
+	Policy EmailVerificationPolicy
+	// TokenTTL is how long a verification link remains valid
+	TokenTTL time.Duration
+	// VerifyURL is the page the emailed link points to; the token is appended as a query parameter
+	VerifyURL string
+	// ResendInterval is the minimum time between two verification emails for the same user
+	ResendInterval time.Duration
+	// MaxSendsPerHour caps the number of verification emails sent to a user per hour
+	MaxSendsPerHour int
+}
+
+type EmailVerificationService interface {
+	Policy() EmailVerificationPolicy
+	SendVerification(user *entities.User) error
+	ResendVerification(email string) error
+	VerifyEmail(token string) error
+}
+
+type emailVerificationService struct {
+	userRepository      postgres.UserRepository
+	userTokenRepository postgres.UserTokenRepository
+	notifier            notifiers.Notifier
+	config              EmailVerificationConfig
+}
+
+func NewEmailVerificationService(
+	userRepository postgres.UserRepository,
+	userTokenRepository postgres.UserTokenRepository,
+	notifier notifiers.Notifier,
+	config EmailVerificationConfig,
+) EmailVerificationService {
+	return &emailVerificationService{
+		userRepository:      userRepository,
+		userTokenRepository: userTokenRepository,
+		notifier:            notifier,
+		config:              config,
+	}
+}
+
+func (es *emailVerificationService) Policy() EmailVerificationPolicy {
+	return es.config.Policy
+}
+
+// SendVerification emails a verification link to user without waiting for the email to be sent
+func (es *emailVerificationService) SendVerification(user *entities.User) error {
+	if user.EmailVerified || user.Email == "" {
+		return nil
+	}
+	if err := es.checkThrottle(user.ID); err != nil {
+		return err
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		return err
+	}
+
+	_, err = es.userTokenRepository.Create(&postgres.PostgresUserToken{
+		UserToken: entities.UserToken{
+			UserID:    user.ID,
+			Purpose:   entities.TokenPurposeEmailVerification,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(es.config.TokenTTL),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	sendInBackground(es.notifier, notifiers.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by following the link below:\n\n%s\n\nThe link expires in %s.",
+			user.Username, buildTokenLink(es.config.VerifyURL, token), es.config.TokenTTL,
+		),
+	})
+	return nil
+}
+
+// ResendVerification sends a fresh verification email. Unknown or already verified addresses,
+// and addresses sent one too recently, are ignored so callers cannot tell which emails are registered.
+func (es *emailVerificationService) ResendVerification(email string) error {
+	user, err := es.userRepository.GetByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Verification email requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = es.SendVerification(&user.User)
+	if errors.Is(err, ErrVerificationThrottled) {
+		log.Printf("Verification email for user %d throttled", user.ID)
+		return nil
+	}
+	return err
+}
+
+func (es *emailVerificationService) VerifyEmail(token string) error {
+	verificationToken, err := es.userTokenRepository.GetByHash(entities.TokenPurposeEmailVerification, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	if verificationToken.UsedAt != nil || time.Now().After(verificationToken.ExpiresAt) {
+		return ErrInvalidVerificationToken
+	}
+
+	consumed, err := es.userTokenRepository.MarkUsed(verificationToken.ID)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidVerificationToken
+	}
+
+	if err := es.userRepository.MarkEmailVerified(verificationToken.UserID); err != nil {
+		return err
+	}
+
+	return es.userTokenRepository.InvalidateForUser(verificationToken.UserID, entities.TokenPurposeEmailVerification)
+}
+
+func (es *emailVerificationService) checkThrottle(userID uint) error {
+	now := time.Now()
+
+	recent, err := es.userTokenRepository.CountSince(userID, entities.TokenPurposeEmailVerification, now.Add(-es.config.ResendInterval))
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrVerificationThrottled
+	}
+
+	lastHour, err := es.userTokenRepository.CountSince(userID, entities.TokenPurposeEmailVerification, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if es.config.MaxSendsPerHour > 0 && lastHour >= int64(es.config.MaxSendsPerHour) {
+		return ErrVerificationThrottled
+	}
+
+	return nil
+}
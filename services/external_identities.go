@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// externalIdentity is a user as asserted by an identity source outside this service, such as an
+// OpenID Connect provider or an LDAP directory
+type externalIdentity struct {
+	// Source names the identity source; links are stored in federated_identities under it
+	Source string
+	// Subject identifies the user at the source and never changes
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Username is the preferred username for a provisioned account; Email is used when empty
+	Username string
+}
+
+// externalIdentities links external identities to local accounts. It is shared by the login
+// methods that trust another system to authenticate the user.
+type externalIdentities struct {
+	userRepository       postgres.UserRepository
+	roleRepository       postgres.RoleRepository
+	federationRepository postgres.FederationRepository
+	signupPolicy         *signupPolicy
+}
+
+// resolve finds the user an identity belongs to. Unknown identities are linked to the user with
+// the same email when both sides verified it, or get a new account when autoProvision is set.
+func (ei *externalIdentities) resolve(identity externalIdentity, autoProvision bool) (uint, error) {
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+	emailVerified := email != "" && identity.EmailVerified
+
+	link, err := ei.federationRepository.GetIdentity(identity.Source, identity.Subject)
+	if err == nil {
+		if err := ei.federationRepository.TouchIdentity(link.ID, email, time.Now()); err != nil {
+			log.Printf("Error updating federated identity %d: %v", link.ID, err)
+		}
+		return link.UserID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	if !emailVerified {
+		return 0, ErrFederatedEmailNotVerified
+	}
+
+	var userID uint
+	existing, err := ei.userRepository.GetByEmail(email)
+	switch {
+	case err == nil:
+		// An unverified local email may have been registered by someone else to capture the identity
+		if existing.IsServiceAccount || !existing.EmailVerified {
+			return 0, ErrFederatedAccountConflict
+		}
+		userID = existing.ID
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if !autoProvision {
+			return 0, ErrFederatedSignupDisabled
+		}
+		created, err := ei.provisionUser(identity.Username, email)
+		if err != nil {
+			return 0, err
+		}
+		userID = created.ID
+	default:
+		return 0, err
+	}
+
+	_, err = ei.federationRepository.CreateIdentity(&postgres.PostgresFederatedIdentity{
+		FederatedIdentity: entities.FederatedIdentity{
+			UserID:      userID,
+			Provider:    identity.Source,
+			Subject:     identity.Subject,
+			Email:       email,
+			LastLoginAt: time.Now(),
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// provisionUser creates the account of a new identity, with the default roles and the signup hooks
+// of a self-service signup. It has no password, so it can only log in through its identity source
+// until its owner sets one with a password reset.
+func (ei *externalIdentities) provisionUser(baseUsername, email string) (*postgres.PostgresUser, error) {
+	if baseUsername == "" {
+		baseUsername = email
+	}
+
+	// Usernames of local accounts are never taken over; a taken one gets a random suffix
+	username := baseUsername
+	for attempt := 0; ; attempt++ {
+		_, err := ei.userRepository.GetByUsername(username)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attempt == 3 {
+			return nil, ErrUserAlreadyExists
+		}
+		suffix, err := generateToken(3)
+		if err != nil {
+			return nil, err
+		}
+		username = baseUsername + "-" + strings.ToLower(suffix)
+	}
+
+	signup, roles, err := ei.signupPolicy.begin(username, email)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	created, err := ei.userRepository.CreateWithRoles(&postgres.PostgresUser{
+		User: entities.User{
+			Username:        username,
+			Email:           email,
+			EmailVerified:   true,
+			EmailVerifiedAt: &now,
+			Tenant:          signup.Tenant,
+		},
+	}, roleIDs(roles))
+	if err != nil {
+		return nil, err
+	}
+	ei.signupPolicy.finish(&entities.User{
+		ID:       created.ID,
+		Username: created.Username,
+		Email:    created.Email,
+		Tenant:   created.Tenant,
+		Roles:    roles,
+	}, signup)
+	return created, nil
+}
+
+// syncRoles grants the roles mapped from the user's groups at the source and removes mapped roles
+// the user no longer qualifies for
+func (ei *externalIdentities) syncRoles(source string, userID uint, groups []string, groupRoles map[string][]string) error {
+	if len(groupRoles) == 0 {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, group := range groups {
+		for _, roleName := range groupRoles[group] {
+			wanted[roleName] = true
+		}
+	}
+
+	current, err := ei.roleRepository.GetRolesForUser(userID)
+	if err != nil {
+		return err
+	}
+	held := make(map[string]uint, len(current))
+	for _, role := range current {
+		held[role.Name] = role.ID
+	}
+
+	for _, roleNames := range groupRoles {
+		for _, roleName := range roleNames {
+			roleID, has := held[roleName]
+			switch {
+			case wanted[roleName] && !has:
+				role, err := ei.roleRepository.GetByName(roleName)
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					log.Printf("Role %q mapped from %s does not exist", roleName, source)
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := ei.roleRepository.AssignRoleToUser(userID, role.ID); err != nil {
+					return err
+				}
+				held[roleName] = role.ID
+			case !wanted[roleName] && has:
+				if err := ei.roleRepository.RemoveRoleFromUser(userID, roleID); err != nil {
+					return err
+				}
+				delete(held, roleName)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/oidcclient"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrFederationProviderNotFound is returned for a provider name that is not configured
+	ErrFederationProviderNotFound = errors.New("identity provider not found")
+	// ErrInvalidFederationState is returned for a callback that does not match a pending login
+	ErrInvalidFederationState = errors.New("login request is invalid or has expired")
+	// ErrInvalidReturnURL is returned for a return URL outside the allowed origins
+	ErrInvalidReturnURL = errors.New("return URL is not allowed")
+	// ErrFederationFailed is returned when the provider rejects the login or its ID token is invalid
+	ErrFederationFailed = errors.New("identity provider login failed")
+	// ErrFederatedEmailNotVerified is returned when an unknown identity comes without a verified email,
+	// so it can neither be linked nor provisioned
+	ErrFederatedEmailNotVerified = errors.New("the identity provider did not return a verified email address")
+	// ErrFederatedAccountConflict is returned when the email belongs to a local account that cannot
+	// safely be linked: its own email is unverified, or it is a service account
+	ErrFederatedAccountConflict = errors.New("an account with this email exists but cannot be linked")
+	// ErrFederatedSignupDisabled is returned for a new identity of a provider without just-in-time provisioning
+	ErrFederatedSignupDisabled = errors.New("no account exists for this identity")
+)
+
+// FederationProviderConfig configures an upstream OpenID Connect provider users can log in with
+type FederationProviderConfig struct {
+	// Name identifies the provider in URLs, e.g. "google"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// AutoProvision creates an account on the first login of an identity with a verified email
+	// that matches no user
+	AutoProvision bool
+	// TrustEmail treats the email of the provider as verified even without an email_verified claim,
+	// for providers such as Azure AD that only release verified addresses
+	TrustEmail bool
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+	// GroupRoles maps upstream groups to local role names. On every login the user gets exactly the
+	// mapped roles of their groups; roles that appear in no mapping are left untouched.
+	GroupRoles map[string][]string
+}
+
+// FederationConfig configures login through external identity providers
+type FederationConfig struct {
+	Providers []FederationProviderConfig
+	// BaseURL is the public base URL of this service; provider callbacks go to
+	// BaseURL/auth/federation/{name}/callback
+	BaseURL string
+	// StateTTL is how long the user has to log in at the provider
+	StateTTL time.Duration
+	// DefaultReturnURL is where users go after logging in when the login did not name a return URL
+	DefaultReturnURL string
+	// ReturnOrigins are the origins, besides BaseURL, return URLs may point to
+	ReturnOrigins []string
+}
+
+// FederationLoginStart is a login sent to an identity provider
+type FederationLoginStart struct {
+	// AuthURL is the provider page the browser is sent to
+	AuthURL string
+	// State must come back with the callback; callers bind it to the browser so a login started
+	// by someone else cannot be completed in it
+	State     string
+	ExpiresAt time.Time
+}
+
+// FederatedLogin is a completed login through an identity provider
+type FederatedLogin struct {
+	Token    string
+	ReturnTo string
+}
+
+// FederationService logs users in with external OpenID Connect providers ("Sign in with Google")
+type FederationService interface {
+	Providers() []string
+	BeginLogin(providerName, returnTo string) (*FederationLoginStart, error)
+	CompleteLogin(providerName, state, code string, client ClientInfo) (*FederatedLogin, error)
+}
+
+type federationProvider struct {
+	config FederationProviderConfig
+	client *oidcclient.Client
+}
+
+type federationService struct {
+	userService          UserService
+	federationRepository postgres.FederationRepository
+	identities           *externalIdentities
+	providers            map[string]*federationProvider
+	config               FederationConfig
+}
+
+func NewFederationService(
+	userService UserService,
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	federationRepository postgres.FederationRepository,
+	signupConfig SignupConfig,
+	config FederationConfig,
+) FederationService {
+	providers := make(map[string]*federationProvider, len(config.Providers))
+	for _, providerConfig := range config.Providers {
+		providers[providerConfig.Name] = &federationProvider{
+			config: providerConfig,
+			client: oidcclient.NewClient(oidcclient.Config{
+				Issuer:       providerConfig.Issuer,
+				ClientID:     providerConfig.ClientID,
+				ClientSecret: providerConfig.ClientSecret,
+				RedirectURL:  strings.TrimSuffix(config.BaseURL, "/") + "/auth/federation/" + url.PathEscape(providerConfig.Name) + "/callback",
+				Scopes:       providerConfig.Scopes,
+			}, nil),
+		}
+	}
+
+	return &federationService{
+		userService:          userService,
+		federationRepository: federationRepository,
+		identities: &externalIdentities{
+			userRepository:       userRepository,
+			roleRepository:       roleRepository,
+			federationRepository: federationRepository,
+			signupPolicy:         newSignupPolicy(roleRepository, signupConfig),
+		},
+		providers: providers,
+		config:    config,
+	}
+}
+
+// Providers lists the names of the configured providers
+func (fs *federationService) Providers() []string {
+	names := make([]string, 0, len(fs.providers))
+	for name := range fs.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BeginLogin starts a login at a provider, to return to returnTo once completed
+func (fs *federationService) BeginLogin(providerName, returnTo string) (*FederationLoginStart, error) {
+	provider, ok := fs.providers[providerName]
+	if !ok {
+		return nil, ErrFederationProviderNotFound
+	}
+	if returnTo == "" {
+		returnTo = fs.config.DefaultReturnURL
+	}
+	if !allowedReturnURL(returnTo, fs.config.BaseURL, fs.config.ReturnOrigins) {
+		return nil, ErrInvalidReturnURL
+	}
+
+	state, err := generateToken(24)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := oidcclient.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	codeVerifier, err := oidcclient.NewCodeVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.client.AuthCodeURL(state, nonce, oidcclient.CodeChallenge(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	expiresAt := time.Now().Add(fs.config.StateTTL)
+	_, err = fs.federationRepository.CreateLoginState(&postgres.PostgresFederationLoginState{
+		FederationLoginState: entities.FederationLoginState{
+			Provider:     providerName,
+			StateHash:    hashToken(state),
+			Nonce:        nonce,
+			CodeVerifier: codeVerifier,
+			ReturnTo:     returnTo,
+			ExpiresAt:    expiresAt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &FederationLoginStart{AuthURL: authURL, State: state, ExpiresAt: expiresAt}, nil
+}
+
+// CompleteLogin redeems the code of a provider callback, validates the ID token and logs in the
+// user the identity belongs to, linking or provisioning an account on the first login
+func (fs *federationService) CompleteLogin(providerName, state, code string, client ClientInfo) (*FederatedLogin, error) {
+	provider, ok := fs.providers[providerName]
+	if !ok {
+		return nil, ErrFederationProviderNotFound
+	}
+	if state == "" || code == "" {
+		return nil, ErrInvalidFederationState
+	}
+
+	loginState, err := fs.federationRepository.ConsumeLoginState(providerName, hashToken(state))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidFederationState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := provider.client.Exchange(code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	claims, err := provider.client.VerifyIDToken(tokens.IDToken, loginState.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	emailVerified, _ := claims["email_verified"].(bool)
+	identity := externalIdentity{
+		Source:        providerName,
+		Subject:       stringClaim(claims, "sub"),
+		Email:         stringClaim(claims, "email"),
+		EmailVerified: emailVerified || provider.config.TrustEmail,
+		Username:      stringClaim(claims, "preferred_username"),
+	}
+
+	userID, err := fs.identities.resolve(identity, provider.config.AutoProvision)
+	if err != nil {
+		return nil, err
+	}
+	if provider.config.GroupsClaim != "" {
+		groups := stringListClaim(claims, provider.config.GroupsClaim)
+		if err := fs.identities.syncRoles("provider "+providerName, userID, groups, provider.config.GroupRoles); err != nil {
+			return nil, err
+		}
+	}
+
+	// The provider is responsible for the strength of the login, including any second factor
+	token, err := fs.userService.IssueToken(userID, []string{AuthMethodFederated}, client)
+	if err != nil {
+		return nil, err
+	}
+	return &FederatedLogin{Token: token, ReturnTo: loginState.ReturnTo}, nil
+}
+
+// allowedReturnURL accepts paths of this service and URLs on baseURL or one of returnOrigins,
+// so a login cannot be used to redirect users to arbitrary sites
+func allowedReturnURL(returnTo, baseURL string, returnOrigins []string) bool {
+	if strings.HasPrefix(returnTo, "/") {
+		return !strings.HasPrefix(returnTo, "//") && !strings.HasPrefix(returnTo, "/\\")
+	}
+
+	target, err := url.Parse(returnTo)
+	if err != nil || target.Host == "" {
+		return false
+	}
+	for _, origin := range append([]string{baseURL}, returnOrigins...) {
+		allowed, err := url.Parse(origin)
+		if err == nil && allowed.Scheme == target.Scheme && allowed.Host == target.Host {
+			return true
+		}
+	}
+	return false
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// stringListClaim reads a claim that is a list of strings, or a single string
+func stringListClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				items = append(items, text)
+			}
+		}
+		return items
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,457 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/oidcclient"
+)
+
+const testProviderName = "corp"
+
+// mockIdentityProvider is an OpenID Connect provider that issues an authorization code for every
+// authorization request and checks the PKCE verifier when the code is redeemed
+type mockIdentityProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	clientID      string
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+func newMockIdentityProvider(t *testing.T) *mockIdentityProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockIdentityProvider{key: key, codes: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, oidcclient.Metadata{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+		p.mu.Lock()
+		authorization, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+		clientID, _, _ := r.BasicAuth()
+		if !ok || clientID != authorization.clientID ||
+			oidcclient.CodeChallenge(r.PostForm.Get("code_verifier")) != authorization.codeChallenge {
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, authorization.claims)
+		token.Header["kid"] = "key-1"
+		idToken, err := token.SignedString(p.key)
+		if err != nil {
+			writeTestJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, oidcclient.TokenResponse{AccessToken: "access", TokenType: "Bearer", IDToken: idToken})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// authorize plays the user logging in at the provider: it answers the authorization request the
+// browser was sent with, issuing an ID token with the given claims for the returned code
+func (p *mockIdentityProvider) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request %v does not use PKCE", query)
+	}
+
+	now := time.Now()
+	issued := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   query.Get("client_id"),
+		"nonce": query.Get("nonce"),
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		issued[name] = value
+	}
+
+	code, err := generateToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = mockAuthorization{clientID: query.Get("client_id"), codeChallenge: query.Get("code_challenge"), claims: issued}
+	return code
+}
+
+// tokenIssuingUserService records the tokens the federation service asks for
+type tokenIssuingUserService struct {
+	UserService
+	issued []uint
+}
+
+func (us *tokenIssuingUserService) IssueToken(userID uint, authMethods []string, client ClientInfo) (string, error) {
+	if !reflect.DeepEqual(authMethods, []string{AuthMethodFederated}) {
+		return "", errors.New("unexpected authentication methods")
+	}
+	us.issued = append(us.issued, userID)
+	return "token-for-user", nil
+}
+
+// federationFixture is a federation service with one provider, backed by in-memory repositories
+type federationFixture struct {
+	provider    *mockIdentityProvider
+	users       *memoryUserRepository
+	roles       *memoryRoleRepository
+	federations *memoryFederationRepository
+	signups     *recordingSignupHook
+	userService *tokenIssuingUserService
+	service     FederationService
+}
+
+func newFederationFixture(t *testing.T, configure func(config *FederationProviderConfig), users ...entities.User) *federationFixture {
+	t.Helper()
+	fixture := &federationFixture{
+		provider: newMockIdentityProvider(t),
+		users:    newMemoryUserRepository(users...),
+		roles: newMemoryRoleRepository(
+			entities.Role{ID: 1, Name: "admin"},
+			entities.Role{ID: 2, Name: "user"},
+		),
+		federations: &memoryFederationRepository{},
+		signups:     &recordingSignupHook{},
+		userService: &tokenIssuingUserService{},
+	}
+	fixture.users.roles = fixture.roles
+
+	providerConfig := FederationProviderConfig{
+		Name:          testProviderName,
+		Issuer:        fixture.provider.server.URL,
+		ClientID:      "auth-service",
+		ClientSecret:  "provider-secret",
+		Scopes:        []string{"openid", "email"},
+		AutoProvision: true,
+		GroupsClaim:   "groups",
+	}
+	if configure != nil {
+		configure(&providerConfig)
+	}
+	fixture.service = NewFederationService(fixture.userService, fixture.users, fixture.roles, fixture.federations, testSignupConfig(fixture.signups), FederationConfig{
+		Providers:        []FederationProviderConfig{providerConfig},
+		BaseURL:          "https://auth.example.org",
+		StateTTL:         5 * time.Minute,
+		DefaultReturnURL: "/",
+		ReturnOrigins:    []string{"https://app.example.org"},
+	})
+	return fixture
+}
+
+// login goes through a whole login at the provider, which asserts the given claims
+func (f *federationFixture) login(t *testing.T, claims jwt.MapClaims) (*FederatedLogin, error) {
+	t.Helper()
+	start, err := f.service.BeginLogin(testProviderName, "https://app.example.org/home")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	code := f.provider.authorize(t, start.AuthURL, claims)
+	return f.service.CompleteLogin(testProviderName, start.State, code, ClientInfo{})
+}
+
+// loggedInUser returns the user the last login issued a token for
+func (f *federationFixture) loggedInUser(t *testing.T) uint {
+	t.Helper()
+	if len(f.userService.issued) == 0 {
+		t.Fatal("no token was issued")
+	}
+	return f.userService.issued[len(f.userService.issued)-1]
+}
+
+func TestFederationProvisionsOnFirstLogin(t *testing.T) {
+	fixture := newFederationFixture(t, nil)
+
+	login, err := fixture.login(t, jwt.MapClaims{"sub": "sub-alice", "email": "Alice@Example.org", "email_verified": true, "preferred_username": "alice"})
+	if err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if login.Token != "token-for-user" || login.ReturnTo != "https://app.example.org/home" {
+		t.Fatalf("login = %+v", login)
+	}
+	user, err := fixture.users.GetByID(fixture.loggedInUser(t))
+	if err != nil {
+		t.Fatalf("logged in user not found: %v", err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.org" || !user.EmailVerified {
+		t.Fatalf("provisioned user = %+v, want alice with verified alice@example.org", user.User)
+	}
+	// Provisioning is a signup: the user gets the default roles and goes through the hooks
+	if roles := fixture.roles.roleNames(user.ID); !reflect.DeepEqual(roles, []string{"user"}) || user.Tenant != "acme" {
+		t.Fatalf("provisioned user has roles %v and tenant %q, want [user] and acme", roles, user.Tenant)
+	}
+	if len(fixture.signups.created) != 1 || fixture.signups.created[0].ID != user.ID {
+		t.Fatalf("post-signup hooks saw %+v, want the provisioned user", fixture.signups.created)
+	}
+
+	// The identity stays linked by subject, even once the provider reports another email
+	if _, err := fixture.login(t, jwt.MapClaims{"sub": "sub-alice", "email": "alice@new.example.org"}); err != nil {
+		t.Fatalf("second CompleteLogin: %v", err)
+	}
+	if fixture.loggedInUser(t) != user.ID || fixture.users.count() != 1 {
+		t.Fatalf("second login gave user %d with %d users, want the same user only", fixture.loggedInUser(t), fixture.users.count())
+	}
+	identity, err := fixture.federations.GetIdentity(testProviderName, "sub-alice")
+	if err != nil || identity.Email != "alice@new.example.org" {
+		t.Fatalf("identity = %+v, %v, want the new email recorded", identity, err)
+	}
+}
+
+func TestFederationProvisioningHonorsSignupHooks(t *testing.T) {
+	fixture := newFederationFixture(t, nil)
+	fixture.signups.rejectEmail = "mallory@example.org"
+
+	_, err := fixture.login(t, jwt.MapClaims{"sub": "sub-mallory", "email": "mallory@example.org", "email_verified": true})
+	var rejectedErr *SignupRejectedError
+	if !errors.As(err, &rejectedErr) {
+		t.Fatalf("CompleteLogin error = %v, want *SignupRejectedError", err)
+	}
+	if fixture.users.count() != 0 {
+		t.Fatal("a vetoed identity was provisioned")
+	}
+	if _, err := fixture.federations.GetIdentity(testProviderName, "sub-mallory"); err == nil {
+		t.Fatal("a vetoed identity was linked")
+	}
+}
+
+func TestFederationLinksVerifiedLocalUser(t *testing.T) {
+	// Emails are matched case-insensitively, whichever side has the capitals
+	for _, email := range []string{"alice@example.org", "Alice@Example.org"} {
+		t.Run(email, func(t *testing.T) {
+			local := entities.User{ID: 42, Username: "alice.local", Email: email, EmailVerified: true}
+			fixture := newFederationFixture(t, nil, local)
+
+			if _, err := fixture.login(t, jwt.MapClaims{"sub": "sub-alice", "email": "ALICE@example.org", "email_verified": true}); err != nil {
+				t.Fatalf("CompleteLogin: %v", err)
+			}
+			if fixture.loggedInUser(t) != local.ID || fixture.users.count() != 1 {
+				t.Fatalf("logged in as user %d with %d users, want the existing user %d", fixture.loggedInUser(t), fixture.users.count(), local.ID)
+			}
+			if identity, err := fixture.federations.GetIdentity(testProviderName, "sub-alice"); err != nil || identity.UserID != local.ID {
+				t.Fatalf("identity = %+v, %v, want it linked to user %d", identity, err, local.ID)
+			}
+		})
+	}
+}
+
+func TestFederationRefusesUnsafeLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   entities.User
+		claims  jwt.MapClaims
+		wantErr error
+	}{
+		{
+			name:    "unverified local email",
+			local:   entities.User{ID: 42, Username: "squatter", Email: "alice@example.org"},
+			claims:  jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": true},
+			wantErr: ErrFederatedAccountConflict,
+		},
+		{
+			name:    "service account",
+			local:   entities.User{ID: 42, Username: "robot", Email: "alice@example.org", EmailVerified: true, IsServiceAccount: true},
+			claims:  jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": true},
+			wantErr: ErrFederatedAccountConflict,
+		},
+		{
+			name:    "unverified provider email",
+			local:   entities.User{ID: 42, Username: "alice", Email: "alice@example.org", EmailVerified: true},
+			claims:  jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": false},
+			wantErr: ErrFederatedEmailNotVerified,
+		},
+		{
+			name:    "email_verified as a string",
+			local:   entities.User{ID: 42, Username: "alice", Email: "alice@example.org", EmailVerified: true},
+			claims:  jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": "true"},
+			wantErr: ErrFederatedEmailNotVerified,
+		},
+		{
+			name:    "no email",
+			local:   entities.User{ID: 42, Username: "alice", Email: "alice@example.org", EmailVerified: true},
+			claims:  jwt.MapClaims{"sub": "sub-alice", "email_verified": true},
+			wantErr: ErrFederatedEmailNotVerified,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := newFederationFixture(t, nil, tt.local)
+
+			_, err := fixture.login(t, tt.claims)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CompleteLogin error = %v, want %v", err, tt.wantErr)
+			}
+			if len(fixture.userService.issued) != 0 || len(fixture.federations.identities) != 0 || fixture.users.count() != 1 {
+				t.Fatalf("refused login issued tokens for %v and left %d identities and %d users", fixture.userService.issued, len(fixture.federations.identities), fixture.users.count())
+			}
+		})
+	}
+}
+
+func TestFederationTrustEmail(t *testing.T) {
+	local := entities.User{ID: 42, Username: "alice", Email: "alice@example.org", EmailVerified: true}
+	fixture := newFederationFixture(t, func(config *FederationProviderConfig) { config.TrustEmail = true }, local)
+
+	if _, err := fixture.login(t, jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org"}); err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if fixture.loggedInUser(t) != local.ID {
+		t.Fatalf("logged in as user %d, want %d", fixture.loggedInUser(t), local.ID)
+	}
+}
+
+func TestFederationWithoutAutoProvision(t *testing.T) {
+	fixture := newFederationFixture(t, func(config *FederationProviderConfig) { config.AutoProvision = false })
+
+	_, err := fixture.login(t, jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": true})
+	if !errors.Is(err, ErrFederatedSignupDisabled) {
+		t.Fatalf("CompleteLogin error = %v, want ErrFederatedSignupDisabled", err)
+	}
+	if fixture.users.count() != 0 {
+		t.Fatalf("%d users were created", fixture.users.count())
+	}
+}
+
+func TestFederationRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"nonce of another login", jwt.MapClaims{"nonce": "other-nonce"}},
+		{"other audience", jwt.MapClaims{"aud": "other-client"}},
+		{"other issuer", jwt.MapClaims{"iss": "https://attacker.example.org"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := newFederationFixture(t, nil)
+			tt.claims["sub"] = "sub-alice"
+			tt.claims["email"] = "alice@example.org"
+			tt.claims["email_verified"] = true
+
+			_, err := fixture.login(t, tt.claims)
+			if !errors.Is(err, ErrFederationFailed) {
+				t.Fatalf("CompleteLogin error = %v, want ErrFederationFailed", err)
+			}
+			if len(fixture.userService.issued) != 0 || fixture.users.count() != 0 {
+				t.Fatalf("invalid ID token issued tokens for %v and created %d users", fixture.userService.issued, fixture.users.count())
+			}
+		})
+	}
+}
+
+func TestFederationStateIsSingleUse(t *testing.T) {
+	fixture := newFederationFixture(t, nil)
+	claims := jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": true}
+
+	start, err := fixture.service.BeginLogin(testProviderName, "")
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	if _, err := fixture.service.CompleteLogin(testProviderName, "forged-state", fixture.provider.authorize(t, start.AuthURL, claims), ClientInfo{}); !errors.Is(err, ErrInvalidFederationState) {
+		t.Fatalf("CompleteLogin with a forged state error = %v, want ErrInvalidFederationState", err)
+	}
+	login, err := fixture.service.CompleteLogin(testProviderName, start.State, fixture.provider.authorize(t, start.AuthURL, claims), ClientInfo{})
+	if err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if login.ReturnTo != "/" {
+		t.Fatalf("ReturnTo = %q, want the default return URL", login.ReturnTo)
+	}
+	if _, err := fixture.service.CompleteLogin(testProviderName, start.State, fixture.provider.authorize(t, start.AuthURL, claims), ClientInfo{}); !errors.Is(err, ErrInvalidFederationState) {
+		t.Fatalf("replayed CompleteLogin error = %v, want ErrInvalidFederationState", err)
+	}
+}
+
+func TestFederationRejectsForeignReturnURL(t *testing.T) {
+	fixture := newFederationFixture(t, nil)
+
+	for _, returnTo := range []string{"https://attacker.example.org/", "//attacker.example.org/", "/\\attacker.example.org"} {
+		if _, err := fixture.service.BeginLogin(testProviderName, returnTo); !errors.Is(err, ErrInvalidReturnURL) {
+			t.Errorf("BeginLogin(%q) error = %v, want ErrInvalidReturnURL", returnTo, err)
+		}
+	}
+	if _, err := fixture.service.BeginLogin("unknown", ""); !errors.Is(err, ErrFederationProviderNotFound) {
+		t.Errorf("BeginLogin of an unknown provider error = %v, want ErrFederationProviderNotFound", err)
+	}
+}
+
+func TestFederationMapsGroupsToRoles(t *testing.T) {
+	fixture := newFederationFixture(t, func(config *FederationProviderConfig) {
+		config.GroupRoles = map[string][]string{"platform-admins": {"admin"}}
+	}, entities.User{ID: 7, Username: "alice", Email: "alice@example.org", EmailVerified: true})
+	_ = fixture.roles.AssignRoleToUser(7, 2)
+
+	claims := jwt.MapClaims{"sub": "sub-alice", "email": "alice@example.org", "email_verified": true, "groups": []string{"platform-admins"}}
+	if _, err := fixture.login(t, claims); err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if roles := fixture.roles.roleNames(7); !reflect.DeepEqual(roles, []string{"admin", "user"}) {
+		t.Fatalf("roles = %v, want [admin user]", roles)
+	}
+
+	// Leaving the group at the provider takes the mapped role away on the next login
+	delete(claims, "groups")
+	if _, err := fixture.login(t, claims); err != nil {
+		t.Fatalf("second CompleteLogin: %v", err)
+	}
+	if roles := fixture.roles.roleNames(7); !reflect.DeepEqual(roles, []string{"user"}) {
+		t.Fatalf("roles = %v, want [user]", roles)
+	}
+}
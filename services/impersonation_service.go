@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrImpersonationTargetNotFound is returned when impersonating a user that does not exist
+	ErrImpersonationTargetNotFound = errors.New("user not found")
+	// ErrImpersonationNotAllowed is returned when the administrator may not act as the user, e.g.
+	// because the user holds permissions the administrator does not
+	ErrImpersonationNotAllowed = errors.New("user cannot be impersonated")
+)
+
+// ImpersonationConfig configures impersonation
+type ImpersonationConfig struct {
+	// TTL is how long an impersonation session and its token last
+	TTL time.Duration
+}
+
+// ImpersonationResult is the token an administrator acts as a user with
+type ImpersonationResult struct {
+	AccessToken string    `json:"accessToken"`
+	SessionID   string    `json:"sessionId"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// ImpersonationService lets support staff act as a user to reproduce their issues
+type ImpersonationService interface {
+	Impersonate(impersonatorID, userID uint, reason string, client ClientInfo) (*ImpersonationResult, error)
+}
+
+type impersonationService struct {
+	userRepository       postgres.UserRepository
+	roleRepository       postgres.RoleRepository
+	permissionRepository postgres.PermissionRepository
+	sessionService       SessionService
+	tokenService         TokenService
+	auditService         AuditService
+	config               ImpersonationConfig
+}
+
+func NewImpersonationService(
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	permissionRepository postgres.PermissionRepository,
+	sessionService SessionService,
+	tokenService TokenService,
+	auditService AuditService,
+	config ImpersonationConfig,
+) ImpersonationService {
+	return &impersonationService{
+		userRepository:       userRepository,
+		roleRepository:       roleRepository,
+		permissionRepository: permissionRepository,
+		sessionService:       sessionService,
+		tokenService:         tokenService,
+		auditService:         auditService,
+		config:               config,
+	}
+}
+
+// Impersonate starts a short session of a user for an administrator and returns its token, whose
+// act claim names the administrator. Only users whose permissions the administrator holds all of
+// can be impersonated, so impersonation never escalates privileges. Every attempt is audited with
+// the reason given.
+func (is *impersonationService) Impersonate(impersonatorID, userID uint, reason string, client ClientInfo) (*ImpersonationResult, error) {
+	impersonator, err := is.userRepository.GetByID(impersonatorID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := is.userRepository.GetByID(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrImpersonationTargetNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	event := &entities.AuditEvent{
+		Action:    AuditImpersonationStarted,
+		ActorID:   &impersonatorID,
+		SubjectID: &userID,
+		IPAddress: client.IPAddress,
+		UserAgent: client.UserAgent,
+		Detail:    reason,
+	}
+	if err := is.checkAllowed(&impersonator.User, &user.User); err != nil {
+		if errors.Is(err, ErrImpersonationNotAllowed) || errors.Is(err, ErrAccountDeactivated) {
+			event.Action = AuditImpersonationDenied
+			event.Detail = fmt.Sprintf("%s (%v)", reason, err)
+			if recordErr := is.auditService.Record(event); recordErr != nil {
+				return nil, recordErr
+			}
+		}
+		return nil, err
+	}
+
+	roles, err := is.roleRepository.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	var roleNames []string
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	session, err := is.sessionService.CreateImpersonation(userID, impersonatorID, client, is.config.TTL)
+	if err != nil {
+		return nil, err
+	}
+	// The start is recorded before the token is handed out, so no impersonation goes unaudited
+	event.SessionID = session.ID
+	if err := is.auditService.Record(event); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := is.tokenService.IssueImpersonationToken(&user.User, roleNames, session, &impersonator.User)
+	if err != nil {
+		return nil, err
+	}
+	return &ImpersonationResult{
+		AccessToken: accessToken,
+		SessionID:   session.ID,
+		ExpiresAt:   session.ExpiresAt,
+	}, nil
+}
+
+// checkAllowed rejects impersonating oneself, service accounts, deactivated users and users holding
+// a permission the administrator lacks
+func (is *impersonationService) checkAllowed(impersonator, user *entities.User) error {
+	if user.ID == impersonator.ID {
+		return fmt.Errorf("%w: administrators cannot impersonate themselves", ErrImpersonationNotAllowed)
+	}
+	if user.IsServiceAccount {
+		return fmt.Errorf("%w: service accounts cannot be impersonated", ErrImpersonationNotAllowed)
+	}
+	if user.DeactivatedAt != nil {
+		return ErrAccountDeactivated
+	}
+
+	impersonatorPermissions, err := is.permissionRepository.GetPermissionsForUser(impersonator.ID)
+	if err != nil {
+		return err
+	}
+	held := make(map[uint]bool, len(impersonatorPermissions))
+	for _, permission := range impersonatorPermissions {
+		held[permission.ID] = true
+	}
+	userPermissions, err := is.permissionRepository.GetPermissionsForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, permission := range userPermissions {
+		if !held[permission.ID] {
+			return fmt.Errorf("%w: the user holds %s:%s", ErrImpersonationNotAllowed, permission.Resource, permission.Action)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/notifiers"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidInvitation is returned when an invitation token is unknown, expired, revoked or already used
+	ErrInvalidInvitation = errors.New("invalid or expired invitation")
+	// ErrInvitationNotFound is returned when revoking an invitation that does not exist or is no longer pending
+	ErrInvitationNotFound = errors.New("pending invitation not found")
+	// ErrInvitationRoleNotFound is returned when inviting with a role that does not exist
+	ErrInvitationRoleNotFound = errors.New("role not found")
+	// ErrInvitationNotAllowed is returned when an administrator grants a role with permissions they lack
+	ErrInvitationNotAllowed = errors.New("invitation not allowed")
+	// ErrInvalidInvitationExpiry is returned for an expiry in the past
+	ErrInvalidInvitationExpiry = errors.New("invitation expiry must be in the future")
+)
+
+// maxInvitations bounds a page of invitations
+const maxInvitations = 500
+
+// InvitationConfig configures invitation-based onboarding
+type InvitationConfig struct {
+	// TTL is how long an invitation remains valid when no expiry is given
+	TTL time.Duration
+	// AcceptURL is the page the emailed link points to; the token is appended as a query parameter
+	AcceptURL string
+}
+
+// InvitationService lets administrators invite users by email with their roles decided up front
+type InvitationService interface {
+	Invite(inviterID uint, email, tenant string, roleIDs []uint, expiresAt *time.Time) (*entities.Invitation, error)
+	GetInvitations(query postgres.InvitationQuery) ([]*entities.Invitation, error)
+	RevokeInvitation(id uint) error
+	AcceptInvitation(token, username, password string) (*entities.User, error)
+}
+
+type invitationService struct {
+	invitationRepository  postgres.InvitationRepository
+	userRepository        postgres.UserRepository
+	roleRepository        postgres.RoleRepository
+	permissionRepository  postgres.PermissionRepository
+	passwordPolicyService PasswordPolicyService
+	passwordHasher        PasswordHasher
+	notifier              notifiers.Notifier
+	config                InvitationConfig
+}
+
+func NewInvitationService(
+	invitationRepository postgres.InvitationRepository,
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	permissionRepository postgres.PermissionRepository,
+	passwordPolicyService PasswordPolicyService,
+	passwordHasher PasswordHasher,
+	notifier notifiers.Notifier,
+	config InvitationConfig,
+) InvitationService {
+	return &invitationService{
+		invitationRepository:  invitationRepository,
+		userRepository:        userRepository,
+		roleRepository:        roleRepository,
+		permissionRepository:  permissionRepository,
+		passwordPolicyService: passwordPolicyService,
+		passwordHasher:        passwordHasher,
+		notifier:              notifier,
+		config:                config,
+	}
+}
+
+// Invite emails an invitation to create an account holding the given roles. Administrators may
+// only grant roles whose permissions they hold all of, so inviting never escalates privileges.
+// A new invitation replaces the pending ones of the same email.
+func (is *invitationService) Invite(inviterID uint, email, tenant string, roleIDs []uint, expiresAt *time.Time) (*entities.Invitation, error) {
+	email = strings.TrimSpace(email)
+	existing, err := is.userRepository.GetByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if existing != nil && existing.ID != 0 {
+		return nil, ErrUserAlreadyExists
+	}
+
+	expiry := time.Now().Add(is.config.TTL)
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return nil, ErrInvalidInvitationExpiry
+		}
+		expiry = *expiresAt
+	}
+
+	roleIDs = uniqueIDs(roleIDs)
+	if err := is.checkRolesAllowed(inviterID, roleIDs); err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	invitation, err := is.invitationRepository.Create(&postgres.PostgresInvitation{
+		Invitation: entities.Invitation{
+			Email:     email,
+			Tenant:    strings.TrimSpace(tenant),
+			TokenHash: hashToken(token),
+			InvitedBy: &inviterID,
+			ExpiresAt: expiry,
+		},
+	}, roleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The invitation stays valid if the email cannot be sent; the administrator can invite again
+	err = is.notifier.Send(notifiers.Message{
+		To:      email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf(
+			"Hi,\n\nYou have been invited to create an account. Follow the link below to choose a username and password:\n\n%s\n\nThe invitation expires on %s.",
+			buildTokenLink(is.config.AcceptURL, token), expiry.Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		log.Printf("Error sending invitation %d: %v", invitation.ID, err)
+	}
+
+	return &invitation.Invitation, nil
+}
+
+// GetInvitations lists the invitations matching query, newest first
+func (is *invitationService) GetInvitations(query postgres.InvitationQuery) ([]*entities.Invitation, error) {
+	if query.Limit <= 0 || query.Limit > maxInvitations {
+		query.Limit = maxInvitations
+	}
+	postgresInvitations, err := is.invitationRepository.Find(query)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := make([]*entities.Invitation, 0, len(postgresInvitations))
+	for _, postgresInvitation := range postgresInvitations {
+		invitations = append(invitations, &postgresInvitation.Invitation)
+	}
+	return invitations, nil
+}
+
+func (is *invitationService) RevokeInvitation(id uint) error {
+	revoked, err := is.invitationRepository.Revoke(id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation creates the account of an invited user with the invitation's email, tenant and
+// roles. The email counts as verified since the invitation was sent to it.
+func (is *invitationService) AcceptInvitation(token, username, password string) (*entities.User, error) {
+	invitation, err := is.invitationRepository.GetByHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invitation.AcceptedAt != nil || invitation.RevokedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvalidInvitation
+	}
+
+	userFound, err := is.userRepository.GetByUsername(username)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if userFound != nil && userFound.ID != 0 {
+		return nil, ErrUserAlreadyExists
+	}
+	emailFound, err := is.userRepository.GetByEmail(invitation.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if emailFound != nil && emailFound.ID != 0 {
+		return nil, ErrUserAlreadyExists
+	}
+
+	user := entities.User{
+		Username: username,
+		Email:    invitation.Email,
+	}
+	if err := is.passwordPolicyService.Validate(&user, password); err != nil {
+		return nil, err
+	}
+	passwordHash, err := is.passwordHasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	verifiedAt := time.Now()
+	user.Password = passwordHash
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &verifiedAt
+	user.Tenant = invitation.Tenant
+	userCreated, err := is.invitationRepository.Accept(invitation.ID, &postgres.PostgresUser{User: user})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Accepted, revoked or expired since it was loaded
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := is.passwordPolicyService.Remember(userCreated.ID, passwordHash); err != nil {
+		log.Printf("Error recording password history for user %d: %v", userCreated.ID, err)
+	}
+
+	return &entities.User{
+		ID:            userCreated.ID,
+		Username:      userCreated.Username,
+		Email:         userCreated.Email,
+		EmailVerified: true,
+		Tenant:        userCreated.Tenant,
+		Roles:         invitation.Roles,
+	}, nil
+}
+
+// checkRolesAllowed rejects unknown roles and roles granting a permission the inviter lacks
+func (is *invitationService) checkRolesAllowed(inviterID uint, roleIDs []uint) error {
+	inviterPermissions, err := is.permissionRepository.GetPermissionsForUser(inviterID)
+	if err != nil {
+		return err
+	}
+	held := make(map[uint]bool, len(inviterPermissions))
+	for _, permission := range inviterPermissions {
+		held[permission.ID] = true
+	}
+
+	for _, roleID := range roleIDs {
+		role, err := is.roleRepository.GetByID(roleID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", ErrInvitationRoleNotFound, roleID)
+		}
+		if err != nil {
+			return err
+		}
+		rolePermissions, err := is.permissionRepository.GetPermissionsForRole(roleID)
+		if err != nil {
+			return err
+		}
+		for _, permission := range rolePermissions {
+			if !held[permission.ID] {
+				return fmt.Errorf("%w: role %s grants %s:%s", ErrInvitationNotAllowed, role.Name, permission.Resource, permission.Action)
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueIDs returns ids without duplicates, keeping their order
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/ldap"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+// LDAPIdentitySource is the name LDAP accounts are linked under in federated_identities
+const LDAPIdentitySource = "ldap"
+
+// LDAPConfig configures logins against an LDAP directory such as OpenLDAP or Active Directory
+type LDAPConfig struct {
+	// URL is the ldap:// or ldaps:// address of the directory server
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before any credentials are sent
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the service account used to look users up
+	BindDN       string
+	BindPassword string
+	// BaseDN is where user entries are searched
+	BaseDN string
+	// UserFilter finds the entry of a username; %s is replaced by the escaped username,
+	// e.g. "(uid=%s)" or "(sAMAccountName=%s)" for Active Directory
+	UserFilter        string
+	UsernameAttribute string
+	EmailAttribute    string
+	// IDAttribute holds a stable identifier of the entry, e.g. entryUUID or objectGUID. The DN is
+	// used when the entry has none, which breaks the link if the entry is renamed.
+	IDAttribute string
+	// GroupAttribute lists the DNs of the groups of the user, e.g. memberOf
+	GroupAttribute string
+	// GroupRoles maps groups, by DN or by the value of their first RDN (the cn), to local role names.
+	// On every login the user gets exactly the mapped roles of their groups; roles that appear in no
+	// mapping are left untouched.
+	GroupRoles map[string][]string
+	// AutoProvision creates an account on the first login of a directory user that matches no local user
+	AutoProvision bool
+	// PoolSize is the number of idle connections kept open
+	PoolSize int
+	Timeout  time.Duration
+}
+
+type ldapAuthenticator struct {
+	userRepository postgres.UserRepository
+	identities     *externalIdentities
+	pool           *ldap.Pool
+	config         LDAPConfig
+}
+
+// NewLDAPAuthenticator checks passwords by binding to an LDAP directory as the user (search and
+// bind). Directory users are linked to local accounts on their first login, by verified email or
+// by provisioning a new account.
+func NewLDAPAuthenticator(
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	federationRepository postgres.FederationRepository,
+	signupConfig SignupConfig,
+	config LDAPConfig,
+) Authenticator {
+	dial := func() (*ldap.Conn, error) {
+		conn, err := ldap.Dial(config.URL, config.TLSConfig, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if config.StartTLS {
+			if err := conn.StartTLS(config.TLSConfig); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+
+	return &ldapAuthenticator{
+		userRepository: userRepository,
+		identities: &externalIdentities{
+			userRepository:       userRepository,
+			roleRepository:       roleRepository,
+			federationRepository: federationRepository,
+			signupPolicy:         newSignupPolicy(roleRepository, signupConfig),
+		},
+		pool:   ldap.NewPool(config.PoolSize, dial),
+		config: config,
+	}
+}
+
+func (la *ldapAuthenticator) Authenticate(username, password string) (*entities.User, error) {
+	// An empty password would be an unauthenticated bind, which servers accept for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := la.pool.Get()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to LDAP server: %w", err)
+	}
+	defer la.pool.Put(conn)
+
+	entry, err := la.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsResultCode(err, ldap.ResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("binding as LDAP user: %w", err)
+	}
+
+	directoryUsername := entry.Get(la.config.UsernameAttribute)
+	if directoryUsername == "" {
+		directoryUsername = username
+	}
+	userID, err := la.identities.resolve(externalIdentity{
+		Source:  LDAPIdentitySource,
+		Subject: entryID(entry, la.config.IDAttribute),
+		Email:   entry.Get(la.config.EmailAttribute),
+		// The directory is the authority on its users' addresses
+		EmailVerified: true,
+		Username:      directoryUsername,
+	}, la.config.AutoProvision)
+	if err != nil {
+		return nil, err
+	}
+	if la.config.GroupAttribute != "" {
+		groups := groupNames(entry.GetAll(la.config.GroupAttribute))
+		if err := la.identities.syncRoles("LDAP", userID, groups, la.config.GroupRoles); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := la.userRepository.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &user.User, nil
+}
+
+// findUser looks up the entry of a username as the service account. Pooled connections are still
+// bound as their previous user, so the service account binds on every lookup.
+func (la *ldapAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	if err := conn.Bind(la.config.BindDN, la.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("binding as LDAP service account: %w", err)
+	}
+
+	filter, err := ldap.ParseFilter(fmt.Sprintf(la.config.UserFilter, ldap.EscapeFilter(username)))
+	if err != nil {
+		return nil, err
+	}
+	attributes := []string{la.config.UsernameAttribute, la.config.EmailAttribute}
+	if la.config.IDAttribute != "" {
+		attributes = append(attributes, la.config.IDAttribute)
+	}
+	if la.config.GroupAttribute != "" {
+		attributes = append(attributes, la.config.GroupAttribute)
+	}
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     la.config.BaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     filter,
+		Attributes: attributes,
+		SizeLimit:  2,
+	})
+	if ldap.IsResultCode(err, ldap.ResultSizeLimitExceeded) || len(entries) > 1 {
+		return nil, errors.New("LDAP user filter matches more than one entry")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("searching LDAP users: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	return entries[0], nil
+}
+
+// entryID returns the stable identifier of an entry. Binary identifiers such as the objectGUID of
+// Active Directory are base64 encoded.
+func entryID(entry *ldap.Entry, attribute string) string {
+	id := ""
+	if attribute != "" {
+		id = entry.Get(attribute)
+	}
+	if id == "" {
+		return "dn:" + strings.ToLower(entry.DN)
+	}
+	if !utf8.ValidString(id) {
+		return base64.StdEncoding.EncodeToString([]byte(id))
+	}
+	return id
+}
+
+// groupNames lists each group DN along with the value of its first RDN, so mappings can name
+// groups either way
+func groupNames(groupDNs []string) []string {
+	names := make([]string, 0, 2*len(groupDNs))
+	for _, dn := range groupDNs {
+		names = append(names, dn)
+		rdn, _, _ := strings.Cut(dn, ",")
+		if _, value, found := strings.Cut(rdn, "="); found {
+			names = append(names, value)
+		}
+	}
+	return names
+}
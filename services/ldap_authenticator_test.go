@@ -0,0 +1,306 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/ldap"
+)
+
+const (
+	testBaseDN        = "ou=people,dc=example,dc=org"
+	testServiceDN     = "cn=reader,dc=example,dc=org"
+	testServiceSecret = "reader-secret"
+)
+
+var testDirectory = []ldap.TestEntry{
+	{DN: testServiceDN, Password: testServiceSecret},
+	{DN: testBaseDN},
+	{
+		DN:       "uid=alice," + testBaseDN,
+		Password: "alice-secret",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"alice"},
+			"mail":        {"Alice@Example.org"},
+			"entryUUID":   {"7f1c6d9e-alice"},
+			"memberOf":    {"cn=admins,ou=groups,dc=example,dc=org", "cn=staff,ou=groups,dc=example,dc=org"},
+		},
+	},
+	{
+		DN:       "uid=bob," + testBaseDN,
+		Password: "bob-secret",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+			"mail":        {"bob@example.org"},
+			"entryUUID":   {"7f1c6d9e-bob"},
+		},
+	},
+}
+
+// ldapFixture is an authenticator wired to an in-process directory and in-memory repositories
+type ldapFixture struct {
+	server        *ldap.TestServer
+	users         *memoryUserRepository
+	roles         *memoryRoleRepository
+	federations   *memoryFederationRepository
+	signups       *recordingSignupHook
+	authenticator Authenticator
+}
+
+func newLDAPFixture(t *testing.T, tlsConfig *tls.Config, configure func(config *LDAPConfig), users ...entities.User) *ldapFixture {
+	t.Helper()
+	server, err := ldap.NewTestServer(testDirectory, tlsConfig)
+	if err != nil {
+		t.Fatalf("starting directory: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	config := LDAPConfig{
+		URL:               server.URL(),
+		BindDN:            testServiceDN,
+		BindPassword:      testServiceSecret,
+		BaseDN:            testBaseDN,
+		UserFilter:        "(uid=%s)",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		IDAttribute:       "entryUUID",
+		GroupAttribute:    "memberOf",
+		AutoProvision:     true,
+		PoolSize:          2,
+		Timeout:           5 * time.Second,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	fixture := &ldapFixture{
+		server: server,
+		users:  newMemoryUserRepository(users...),
+		roles: newMemoryRoleRepository(
+			entities.Role{ID: 1, Name: "admin"},
+			entities.Role{ID: 2, Name: "developer"},
+			entities.Role{ID: 3, Name: "user"},
+		),
+		federations: &memoryFederationRepository{},
+		signups:     &recordingSignupHook{},
+	}
+	fixture.users.roles = fixture.roles
+	fixture.authenticator = NewLDAPAuthenticator(fixture.users, fixture.roles, fixture.federations, testSignupConfig(fixture.signups), config)
+	return fixture
+}
+
+func TestLDAPAuthenticatorProvisionsOnFirstLogin(t *testing.T) {
+	fixture := newLDAPFixture(t, nil, nil)
+
+	user, err := fixture.authenticator.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.org" || !user.EmailVerified {
+		t.Fatalf("provisioned user = %+v, want alice with verified alice@example.org", user)
+	}
+	if roles := fixture.roles.roleNames(user.ID); !reflect.DeepEqual(roles, []string{"user"}) || user.Tenant != "acme" {
+		t.Fatalf("provisioned user has roles %v and tenant %q, want [user] and acme", roles, user.Tenant)
+	}
+	if len(fixture.signups.created) != 1 {
+		t.Fatalf("post-signup hooks ran %d times, want 1", len(fixture.signups.created))
+	}
+	identity, err := fixture.federations.GetIdentity(LDAPIdentitySource, "7f1c6d9e-alice")
+	if err != nil {
+		t.Fatalf("identity not linked: %v", err)
+	}
+	if identity.UserID != user.ID {
+		t.Fatalf("identity linked to user %d, want %d", identity.UserID, user.ID)
+	}
+
+	again, err := fixture.authenticator.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("second Authenticate: %v", err)
+	}
+	if again.ID != user.ID || fixture.users.count() != 1 {
+		t.Fatalf("second login gave user %d with %d users, want the same user only", again.ID, fixture.users.count())
+	}
+}
+
+func TestLDAPAuthenticatorLinksVerifiedLocalUser(t *testing.T) {
+	local := entities.User{ID: 42, Username: "alice.local", Email: "alice@example.org", EmailVerified: true}
+	fixture := newLDAPFixture(t, nil, nil, local)
+
+	user, err := fixture.authenticator.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.ID != local.ID || fixture.users.count() != 1 {
+		t.Fatalf("logged in as user %d with %d users, want the existing user %d", user.ID, fixture.users.count(), local.ID)
+	}
+}
+
+func TestLDAPAuthenticatorRefusesUnverifiedLocalEmail(t *testing.T) {
+	local := entities.User{ID: 42, Username: "squatter", Email: "alice@example.org"}
+	fixture := newLDAPFixture(t, nil, nil, local)
+
+	_, err := fixture.authenticator.Authenticate("alice", "alice-secret")
+	if !errors.Is(err, ErrFederatedAccountConflict) {
+		t.Fatalf("Authenticate error = %v, want ErrFederatedAccountConflict", err)
+	}
+}
+
+func TestLDAPAuthenticatorWithoutAutoProvision(t *testing.T) {
+	fixture := newLDAPFixture(t, nil, func(config *LDAPConfig) { config.AutoProvision = false })
+
+	_, err := fixture.authenticator.Authenticate("alice", "alice-secret")
+	if !errors.Is(err, ErrFederatedSignupDisabled) {
+		t.Fatalf("Authenticate error = %v, want ErrFederatedSignupDisabled", err)
+	}
+	if fixture.users.count() != 0 {
+		t.Fatalf("%d users were created", fixture.users.count())
+	}
+}
+
+func TestLDAPAuthenticatorRejectsInvalidCredentials(t *testing.T) {
+	fixture := newLDAPFixture(t, nil, nil)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "bob-secret"},
+		{"unknown user", "mallory", "alice-secret"},
+		{"empty password", "alice", ""},
+		{"filter injection", "*", "alice-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fixture.authenticator.Authenticate(tt.username, tt.password)
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Authenticate error = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+	if fixture.users.count() != 0 {
+		t.Fatalf("%d users were created", fixture.users.count())
+	}
+}
+
+func TestLDAPAuthenticatorRejectsAmbiguousFilter(t *testing.T) {
+	fixture := newLDAPFixture(t, nil, func(config *LDAPConfig) { config.UserFilter = "(|(uid=%s)(objectClass=person))" })
+
+	_, err := fixture.authenticator.Authenticate("alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v, want a configuration error", err)
+	}
+}
+
+func TestLDAPAuthenticatorMapsGroupsToRoles(t *testing.T) {
+	fixture := newLDAPFixture(t, nil, func(config *LDAPConfig) {
+		config.GroupRoles = map[string][]string{
+			// By cn
+			"admins": {"admin"},
+			// By DN
+			"cn=developers,ou=groups,dc=example,dc=org": {"developer"},
+		}
+	}, entities.User{ID: 7, Username: "alice", Email: "alice@example.org", EmailVerified: true})
+	// developer is mapped from a group alice is not in; user is not mapped at all
+	_ = fixture.roles.AssignRoleToUser(7, 2)
+	_ = fixture.roles.AssignRoleToUser(7, 3)
+
+	if _, err := fixture.authenticator.Authenticate("alice", "alice-secret"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if roles := fixture.roles.roleNames(7); !reflect.DeepEqual(roles, []string{"admin", "user"}) {
+		t.Fatalf("roles = %v, want [admin user]", roles)
+	}
+}
+
+func TestLDAPAuthenticatorStartTLS(t *testing.T) {
+	serverConfig, clientConfig := testTLSConfigs(t)
+
+	fixture := newLDAPFixture(t, serverConfig, func(config *LDAPConfig) {
+		config.StartTLS = true
+		config.TLSConfig = clientConfig
+	})
+	if _, err := fixture.authenticator.Authenticate("alice", "alice-secret"); err != nil {
+		t.Fatalf("Authenticate over StartTLS: %v", err)
+	}
+
+	// A server without StartTLS must not receive the credentials in the clear
+	plain := newLDAPFixture(t, nil, func(config *LDAPConfig) {
+		config.StartTLS = true
+		config.TLSConfig = clientConfig
+	})
+	_, err := plain.authenticator.Authenticate("alice", "alice-secret")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate without StartTLS error = %v, want a connection error", err)
+	}
+}
+
+func TestLDAPAuthenticatorReusesConnections(t *testing.T) {
+	fixture := newLDAPFixture(t, nil, func(config *LDAPConfig) { config.PoolSize = 1 })
+
+	// bob's bind leaves the pooled connection bound as bob, so the lookup for alice must rebind
+	for _, login := range []struct{ username, password string }{
+		{"alice", "alice-secret"},
+		{"bob", "bob-secret"},
+		{"alice", "alice-secret"},
+	} {
+		if _, err := fixture.authenticator.Authenticate(login.username, login.password); err != nil {
+			t.Fatalf("Authenticate %s: %v", login.username, err)
+		}
+	}
+	if _, err := fixture.authenticator.Authenticate("bob", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate with a wrong password error = %v, want ErrInvalidCredentials", err)
+	}
+	if connections := fixture.server.Connections(); connections != 1 {
+		t.Fatalf("directory saw %d connections, want 1", connections)
+	}
+}
+
+// testTLSConfigs returns a server configuration with a self-signed certificate for 127.0.0.1 and a
+// client configuration trusting it
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "directory"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	clientConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return serverConfig, clientConfig
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// LoginAttemptStore persists failed login counters. Implementations shared by several
+// replicas must update counters atomically.
+type LoginAttemptStore interface {
+	// Get returns the counter for key, or nil if there have been no recent failures
+	Get(key string) (*entities.LoginAttempt, error)
+	// RecordFailure increments the counter for key, restarting it if the last failure is older than window
+	RecordFailure(key string, window time.Duration) (*entities.LoginAttempt, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+type postgresLoginAttemptStore struct {
+	loginAttemptRepository postgres.LoginAttemptRepository
+}
+
+// NewPostgresLoginAttemptStore returns a store backed by the login_attempts table
+func NewPostgresLoginAttemptStore(loginAttemptRepository postgres.LoginAttemptRepository) LoginAttemptStore {
+	return &postgresLoginAttemptStore{
+		loginAttemptRepository: loginAttemptRepository,
+	}
+}
+
+func (s *postgresLoginAttemptStore) Get(key string) (*entities.LoginAttempt, error) {
+	attempt, err := s.loginAttemptRepository.Get(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempt.LoginAttempt, nil
+}
+
+func (s *postgresLoginAttemptStore) RecordFailure(key string, window time.Duration) (*entities.LoginAttempt, error) {
+	attempt, err := s.loginAttemptRepository.RecordFailure(key, window)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt.LoginAttempt, nil
+}
+
+func (s *postgresLoginAttemptStore) Lock(key string, until time.Time) error {
+	return s.loginAttemptRepository.Lock(key, until)
+}
+
+func (s *postgresLoginAttemptStore) Reset(key string) error {
+	return s.loginAttemptRepository.Reset(key)
+}
+
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]entities.LoginAttempt
+}
+
+// NewMemoryLoginAttemptStore returns a process-local store for single-instance deployments and tests
+func NewMemoryLoginAttemptStore() LoginAttemptStore {
+	return &memoryLoginAttemptStore{
+		attempts: make(map[string]entities.LoginAttempt),
+	}
+}
+
+func (s *memoryLoginAttemptStore) Get(key string) (*entities.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (s *memoryLoginAttemptStore) RecordFailure(key string, window time.Duration) (*entities.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.LastFailedAt.Before(now.Add(-window)) {
+		attempt = entities.LoginAttempt{Key: key, LockedUntil: attempt.LockedUntil}
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = now
+	attempt.UpdatedAt = now
+	s.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (s *memoryLoginAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt := s.attempts[key]
+	attempt.Key = key
+	attempt.LockedUntil = &until
+	s.attempts[key] = attempt
+	return nil
+}
+
+func (s *memoryLoginAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LockedOutError is returned while a username or client address is temporarily locked out
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// LoginThrottleConfig configures brute-force protection for logins
+type LoginThrottleConfig struct {
+	// MaxFailuresPerUser is the number of consecutive failures before a username is locked
+	MaxFailuresPerUser int
+	// MaxFailuresPerIP is the number of consecutive failures before a client address is locked
+	MaxFailuresPerIP int
+	// BaseLockout is the first lockout duration; it doubles with every further failure
+	BaseLockout time.Duration
+	// MaxLockout caps the lockout duration
+	MaxLockout time.Duration
+	// FailureWindow is how long failures are remembered without a new one
+	FailureWindow time.Duration
+}
+
+type LoginThrottleService interface {
+	Check(username, ip string) error
+	RecordFailure(username, ip string) error
+	RecordSuccess(username string) error
+	UnlockUser(username string) error
+	UnlockIP(ip string) error
+}
+
+type loginThrottleService struct {
+	store  LoginAttemptStore
+	config LoginThrottleConfig
+}
+
+func NewLoginThrottleService(store LoginAttemptStore, config LoginThrottleConfig) LoginThrottleService {
+	return &loginThrottleService{
+		store:  store,
+		config: config,
+	}
+}
+
+// Check returns a *LockedOutError if either the username or the client address is locked out
+func (ls *loginThrottleService) Check(username, ip string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range []string{userThrottleKey(username), ipThrottleKey(ip)} {
+		attempt, err := ls.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if attempt != nil && attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			if remaining := attempt.LockedUntil.Sub(now); remaining > retryAfter {
+				retryAfter = remaining
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedOutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login against both the username and the client address,
+// locking out whichever reached its threshold with an exponentially growing duration
+func (ls *loginThrottleService) RecordFailure(username, ip string) error {
+	limits := map[string]int{
+		userThrottleKey(username): ls.config.MaxFailuresPerUser,
+		ipThrottleKey(ip):         ls.config.MaxFailuresPerIP,
+	}
+
+	for key, threshold := range limits {
+		attempt, err := ls.store.RecordFailure(key, ls.config.FailureWindow)
+		if err != nil {
+			return err
+		}
+		if threshold <= 0 || attempt.Failures < threshold {
+			continue
+		}
+		if err := ls.store.Lock(key, time.Now().Add(ls.lockoutDuration(attempt.Failures-threshold))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSuccess clears the username counter. The address counter is left alone so one
+// valid account cannot be used to reset the throttle for an attacking address.
+func (ls *loginThrottleService) RecordSuccess(username string) error {
+	return ls.store.Reset(userThrottleKey(username))
+}
+
+func (ls *loginThrottleService) UnlockUser(username string) error {
+	return ls.store.Reset(userThrottleKey(username))
+}
+
+func (ls *loginThrottleService) UnlockIP(ip string) error {
+	return ls.store.Reset(ipThrottleKey(ip))
+}
+
+func (ls *loginThrottleService) lockoutDuration(excessFailures int) time.Duration {
+	lockout := ls.config.BaseLockout
+	for i := 0; i < excessFailures && lockout < ls.config.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > ls.config.MaxLockout {
+		lockout = ls.config.MaxLockout
+	}
+	return lockout
+}
+
+func userThrottleKey(username string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(username))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/notifiers"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidMagicLink is returned when a magic link is unknown, expired or already used
+	ErrInvalidMagicLink = errors.New("invalid or expired magic link")
+	// ErrMagicLinkWrongDevice is returned when a magic link is opened on another device than the one
+	// that requested it. The link is not consumed, so it still works on the right device.
+	ErrMagicLinkWrongDevice = errors.New("magic link must be opened on the device that requested it")
+)
+
+// MagicLinkConfig configures passwordless login by emailed link
+type MagicLinkConfig struct {
+	// TokenTTL is how long a magic link remains valid
+	TokenTTL time.Duration
+	// LoginURL is the page the emailed link points to; the token is appended as a query parameter
+	LoginURL string
+	// ResendInterval is the minimum time between two magic links for the same user
+	ResendInterval time.Duration
+	// MaxSendsPerHour caps the number of magic links sent to a user per hour
+	MaxSendsPerHour int
+}
+
+type MagicLinkService interface {
+	TokenTTL() time.Duration
+	RequestMagicLink(email, deviceSecret string) (string, error)
+	Login(token, deviceSecret string, client ClientInfo) (*LoginResult, error)
+}
+
+type magicLinkService struct {
+	userRepository      postgres.UserRepository
+	userTokenRepository postgres.UserTokenRepository
+	userService         UserService
+	notifier            notifiers.Notifier
+	config              MagicLinkConfig
+}
+
+func NewMagicLinkService(
+	userRepository postgres.UserRepository,
+	userTokenRepository postgres.UserTokenRepository,
+	userService UserService,
+	notifier notifiers.Notifier,
+	config MagicLinkConfig,
+) MagicLinkService {
+	return &magicLinkService{
+		userRepository:      userRepository,
+		userTokenRepository: userTokenRepository,
+		userService:         userService,
+		notifier:            notifier,
+		config:              config,
+	}
+}
+
+func (ms *magicLinkService) TokenTTL() time.Duration {
+	return ms.config.TokenTTL
+}
+
+// RequestMagicLink emails a single-use login link to the user owning email and returns the device
+// secret the link is bound to, which the caller keeps on the requesting device. deviceSecret is
+// reused when the device already holds one, so links requested earlier keep working there. A secret
+// is returned for unknown, disabled and throttled accounts too, so callers cannot tell which emails
+// are registered; no email is sent for them.
+func (ms *magicLinkService) RequestMagicLink(email, deviceSecret string) (string, error) {
+	if deviceSecret == "" {
+		var err error
+		deviceSecret, err = generateToken(32)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	user, err := ms.userRepository.GetByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Magic link requested for unknown email")
+		return deviceSecret, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if user.IsServiceAccount || user.DeactivatedAt != nil {
+		log.Printf("Magic link requested for user %d, who cannot log in", user.ID)
+		return deviceSecret, nil
+	}
+	throttled, err := ms.throttled(user.ID)
+	if err != nil {
+		return "", err
+	}
+	if throttled {
+		log.Printf("Magic link for user %d throttled", user.ID)
+		return deviceSecret, nil
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ms.userTokenRepository.Create(&postgres.PostgresUserToken{
+		UserToken: entities.UserToken{
+			UserID:      user.ID,
+			Purpose:     entities.TokenPurposeMagicLink,
+			TokenHash:   hashToken(token),
+			BindingHash: hashToken(deviceSecret),
+			ExpiresAt:   time.Now().Add(ms.config.TokenTTL),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sendInBackground(ms.notifier, notifiers.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to log in. It works once, only in the browser you requested it from:\n\n%s\n\nThe link expires in %s. If you did not request it, you can ignore this email.",
+			user.Username, buildTokenLink(ms.config.LoginURL, token), ms.config.TokenTTL,
+		),
+	})
+	return deviceSecret, nil
+}
+
+// Login redeems a magic link opened on the device holding deviceSecret and logs the user in like
+// UserService.Login, answering with an MFA challenge when the user needs a second factor. Following
+// the link proves the user owns the address, so it also verifies the email.
+func (ms *magicLinkService) Login(token, deviceSecret string, client ClientInfo) (*LoginResult, error) {
+	magicLink, err := ms.userTokenRepository.GetByHash(entities.TokenPurposeMagicLink, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	if magicLink.UsedAt != nil || time.Now().After(magicLink.ExpiresAt) {
+		return nil, ErrInvalidMagicLink
+	}
+	// Checked before consuming the link so email scanners prefetching it do not burn it
+	if deviceSecret == "" || subtle.ConstantTimeCompare([]byte(hashToken(deviceSecret)), []byte(magicLink.BindingHash)) != 1 {
+		return nil, ErrMagicLinkWrongDevice
+	}
+
+	consumed, err := ms.userTokenRepository.MarkUsed(magicLink.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidMagicLink
+	}
+	if err := ms.userTokenRepository.InvalidateForUser(magicLink.UserID, entities.TokenPurposeMagicLink); err != nil {
+		return nil, err
+	}
+
+	user, err := ms.userRepository.GetByID(magicLink.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified {
+		if err := ms.userRepository.MarkEmailVerified(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return ms.userService.CompleteLogin(user.ID, []string{AuthMethodEmail}, client)
+}
+
+// throttled reports whether a user was sent a magic link within the resend interval or reached
+// the hourly limit
+func (ms *magicLinkService) throttled(userID uint) (bool, error) {
+	now := time.Now()
+
+	recent, err := ms.userTokenRepository.CountSince(userID, entities.TokenPurposeMagicLink, now.Add(-ms.config.ResendInterval))
+	if err != nil {
+		return false, err
+	}
+	if recent > 0 {
+		return true, nil
+	}
+
+	lastHour, err := ms.userTokenRepository.CountSince(userID, entities.TokenPurposeMagicLink, now.Add(-time.Hour))
+	if err != nil {
+		return false, err
+	}
+	return ms.config.MaxSendsPerHour > 0 && lastHour >= int64(ms.config.MaxSendsPerHour), nil
+}
@@ -0,0 +1,518 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// Second factors a user can have enabled
+const (
+	MFAMethodTOTP     = "totp"
+	MFAMethodWebAuthn = "webauthn"
+)
+
+var (
+	// ErrInvalidMFACode is returned when a TOTP or recovery code does not match
+	ErrInvalidMFACode = errors.New("invalid verification code")
+	// ErrInvalidMFAChallenge is returned when an MFA challenge token is unknown, expired or used up
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	// ErrMFANotEnrolled is returned when an operation needs a confirmed TOTP credential
+	ErrMFANotEnrolled = errors.New("two-factor authentication is not enabled")
+	// ErrMFAAlreadyEnrolled is returned when enrolling a user who already has a confirmed credential
+	ErrMFAAlreadyEnrolled = errors.New("two-factor authentication is already enabled")
+	// ErrMFARequiredByRole is returned when disabling MFA for a user whose roles require it
+	ErrMFARequiredByRole = errors.New("two-factor authentication is required by one of the user's roles")
+)
+
+// MFAConfig configures two-factor authentication
+type MFAConfig struct {
+	// Issuer is the account issuer shown in authenticator apps
+	Issuer string
+	// EncryptionKey encrypts TOTP secrets at rest
+	EncryptionKey []byte
+	// ChallengeTTL is how long a user has to complete the second step of a login
+	ChallengeTTL time.Duration
+	// MaxChallengeAttempts is the number of wrong codes allowed per challenge
+	MaxChallengeAttempts int
+	// RecoveryCodeCount is the number of recovery codes generated per user
+	RecoveryCodeCount int
+}
+
+// TOTPEnrollment is returned when a user starts enrolling an authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI to render as a QR code
+	URI string `json:"uri"`
+}
+
+// MFAStatus describes a user's two-factor authentication state
+type MFAStatus struct {
+	TOTPEnabled            bool  `json:"totpEnabled"`
+	WebAuthnEnabled        bool  `json:"webauthnEnabled"`
+	Required               bool  `json:"required"`
+	RemainingRecoveryCodes int64 `json:"remainingRecoveryCodes"`
+}
+
+// MFAChallenge is a login waiting for its second factor, or for the user to enroll one
+type MFAChallenge struct {
+	UserID uint
+	// AuthMethods are the methods of the first factor, e.g. "pwd" or "email"
+	AuthMethods []string
+}
+
+// AuthMethodsWith returns the methods of the login once it passed secondFactor
+func (c *MFAChallenge) AuthMethodsWith(secondFactor string) []string {
+	return withSecondFactor(c.AuthMethods, secondFactor)
+}
+
+type MFAService interface {
+	GetStatus(userID uint) (*MFAStatus, error)
+	EnabledMethods(userID uint) ([]string, error)
+	IsTOTPEnabled(userID uint) (bool, error)
+	IsMFARequired(userID uint) (bool, error)
+	BeginTOTPEnrollment(userID uint) (*TOTPEnrollment, error)
+	ConfirmTOTPEnrollment(userID uint, code string) ([]string, error)
+	DisableTOTP(userID uint, code string) error
+	RegenerateRecoveryCodes(userID uint, code string) ([]string, error)
+	VerifyCode(userID uint, code string) (string, error)
+	CreateChallenge(userID uint, enrollment bool, authMethods []string) (string, error)
+	ResolveChallenge(challengeToken string) (*MFAChallenge, error)
+	VerifyChallenge(challengeToken, code string) (*MFAChallenge, string, error)
+	ConsumeChallenge(challengeToken string) error
+	ResolveEnrollmentChallenge(challengeToken string) (*MFAChallenge, error)
+	ConsumeEnrollmentChallenge(challengeToken string) error
+}
+
+type mfaService struct {
+	userRepository      postgres.UserRepository
+	roleRepository      postgres.RoleRepository
+	mfaRepository       postgres.MFARepository
+	webAuthnRepository  postgres.WebAuthnRepository
+	userTokenRepository postgres.UserTokenRepository
+	config              MFAConfig
+}
+
+func NewMFAService(
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	mfaRepository postgres.MFARepository,
+	webAuthnRepository postgres.WebAuthnRepository,
+	userTokenRepository postgres.UserTokenRepository,
+	config MFAConfig,
+) MFAService {
+	return &mfaService{
+		userRepository:      userRepository,
+		roleRepository:      roleRepository,
+		mfaRepository:       mfaRepository,
+		webAuthnRepository:  webAuthnRepository,
+		userTokenRepository: userTokenRepository,
+		config:              config,
+	}
+}
+
+func (ms *mfaService) GetStatus(userID uint) (*MFAStatus, error) {
+	methods, err := ms.EnabledMethods(userID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := ms.IsMFARequired(userID)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := ms.mfaRepository.CountUnusedRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAStatus{
+		TOTPEnabled:            containsMethod(methods, MFAMethodTOTP),
+		WebAuthnEnabled:        containsMethod(methods, MFAMethodWebAuthn),
+		Required:               required,
+		RemainingRecoveryCodes: remaining,
+	}, nil
+}
+
+// EnabledMethods lists the second factors the user can currently complete a login with
+func (ms *mfaService) EnabledMethods(userID uint) ([]string, error) {
+	methods := []string{}
+
+	totpEnabled, err := ms.IsTOTPEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		methods = append(methods, MFAMethodTOTP)
+	}
+
+	webAuthnCredentials, err := ms.webAuthnRepository.CountCredentialsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if webAuthnCredentials > 0 {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+func (ms *mfaService) IsTOTPEnabled(userID uint) (bool, error) {
+	credential, err := ms.mfaRepository.GetTOTPCredential(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return credential.ConfirmedAt != nil, nil
+}
+
+// IsMFARequired reports whether any of the user's roles enforces two-factor authentication
+func (ms *mfaService) IsMFARequired(userID uint) (bool, error) {
+	roles, err := ms.roleRepository.GetRolesForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role.RequireMFA {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BeginTOTPEnrollment generates a new secret. It replaces any unconfirmed secret
+// and only becomes active once ConfirmTOTPEnrollment succeeds.
+func (ms *mfaService) BeginTOTPEnrollment(userID uint) (*TOTPEnrollment, error) {
+	enabled, err := ms.IsTOTPEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	user, err := ms.userRepository.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptSecret(ms.config.EncryptionKey, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ms.mfaRepository.SaveTOTPCredential(&postgres.PostgresTOTPCredential{
+		TOTPCredential: entities.TOTPCredential{
+			UserID:          userID,
+			SecretEncrypted: encrypted,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(ms.config.Issuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment activates the pending secret and returns fresh recovery codes
+func (ms *mfaService) ConfirmTOTPEnrollment(userID uint, code string) ([]string, error) {
+	credential, err := ms.mfaRepository.GetTOTPCredential(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if credential.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	if err := ms.verifyTOTP(credential, code); err != nil {
+		return nil, err
+	}
+
+	// Reload so the step recorded by verifyTOTP is not overwritten
+	credential, err = ms.mfaRepository.GetTOTPCredential(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	credential.ConfirmedAt = &now
+	if err := ms.mfaRepository.SaveTOTPCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return ms.replaceRecoveryCodes(userID)
+}
+
+func (ms *mfaService) DisableTOTP(userID uint, code string) error {
+	required, err := ms.IsMFARequired(userID)
+	if err != nil {
+		return err
+	}
+	methods, err := ms.EnabledMethods(userID)
+	if err != nil {
+		return err
+	}
+	// A user whose roles require MFA may only drop TOTP if another factor remains
+	if required && !containsMethod(methods, MFAMethodWebAuthn) {
+		return ErrMFARequiredByRole
+	}
+
+	if _, err := ms.VerifyCode(userID, code); err != nil {
+		return err
+	}
+
+	return ms.mfaRepository.DeleteTOTPCredential(userID)
+}
+
+func (ms *mfaService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	if _, err := ms.VerifyCode(userID, code); err != nil {
+		return nil, err
+	}
+	return ms.replaceRecoveryCodes(userID)
+}
+
+// VerifyCode accepts either a current TOTP code or an unused recovery code and returns the
+// authentication method it was, AuthMethodOTP or AuthMethodRecoveryCode
+func (ms *mfaService) VerifyCode(userID uint, code string) (string, error) {
+	credential, err := ms.mfaRepository.GetTOTPCredential(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrMFANotEnrolled
+	}
+	if err != nil {
+		return "", err
+	}
+	if credential.ConfirmedAt == nil {
+		return "", ErrMFANotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		if err := ms.verifyTOTP(credential, code); err != nil {
+			return "", err
+		}
+		return AuthMethodOTP, nil
+	}
+
+	used, err := ms.mfaRepository.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return "", err
+	}
+	if !used {
+		return "", ErrInvalidMFACode
+	}
+	return AuthMethodRecoveryCode, nil
+}
+
+// CreateChallenge issues the short-lived token returned by Login in place of a JWT when the user
+// still has to pass a second factor or, with enrollment, to enroll in one. The two kinds are not
+// interchangeable: only an enrollment challenge can enroll, so a user's password alone never
+// replaces a second factor they already have. authMethods are the methods of the first factor,
+// which the completed login reports along with the second.
+func (ms *mfaService) CreateChallenge(userID uint, enrollment bool, authMethods []string) (string, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ms.userTokenRepository.Create(&postgres.PostgresUserToken{
+		UserToken: entities.UserToken{
+			UserID:      userID,
+			Purpose:     challengePurpose(enrollment),
+			TokenHash:   hashToken(token),
+			ExpiresAt:   time.Now().Add(ms.config.ChallengeTTL),
+			AuthMethods: strings.Join(authMethods, " "),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResolveChallenge returns a valid challenge without consuming it
+func (ms *mfaService) ResolveChallenge(challengeToken string) (*MFAChallenge, error) {
+	challenge, err := ms.getChallenge(entities.TokenPurposeMFAChallenge, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	return newMFAChallenge(challenge), nil
+}
+
+// VerifyChallenge checks the second factor for a challenge and consumes it on success, returning
+// the challenge and the method of the code as VerifyCode does. Each challenge tolerates a limited
+// number of wrong codes.
+func (ms *mfaService) VerifyChallenge(challengeToken, code string) (*MFAChallenge, string, error) {
+	challenge, err := ms.getChallenge(entities.TokenPurposeMFAChallenge, challengeToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	method, err := ms.VerifyCode(challenge.UserID, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			attempts, incErr := ms.userTokenRepository.IncrementAttempts(challenge.ID)
+			if incErr != nil {
+				return nil, "", incErr
+			}
+			if attempts >= ms.config.MaxChallengeAttempts {
+				if _, err := ms.userTokenRepository.MarkUsed(challenge.ID); err != nil {
+					return nil, "", err
+				}
+			}
+		}
+		return nil, "", err
+	}
+
+	consumed, err := ms.userTokenRepository.MarkUsed(challenge.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !consumed {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+	return newMFAChallenge(challenge), method, nil
+}
+
+func (ms *mfaService) ConsumeChallenge(challengeToken string) error {
+	return ms.consumeChallenge(entities.TokenPurposeMFAChallenge, challengeToken)
+}
+
+// ResolveEnrollmentChallenge returns the user an enrollment challenge belongs to without consuming
+// it. It fails once the user has any second factor, e.g. enrolled since the login.
+func (ms *mfaService) ResolveEnrollmentChallenge(challengeToken string) (*MFAChallenge, error) {
+	challenge, err := ms.getChallenge(entities.TokenPurposeMFAEnrollment, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	methods, err := ms.EnabledMethods(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) > 0 {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+	return newMFAChallenge(challenge), nil
+}
+
+func (ms *mfaService) ConsumeEnrollmentChallenge(challengeToken string) error {
+	return ms.consumeChallenge(entities.TokenPurposeMFAEnrollment, challengeToken)
+}
+
+func (ms *mfaService) consumeChallenge(purpose, challengeToken string) error {
+	challenge, err := ms.getChallenge(purpose, challengeToken)
+	if err != nil {
+		return err
+	}
+	consumed, err := ms.userTokenRepository.MarkUsed(challenge.ID)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+func (ms *mfaService) getChallenge(purpose, challengeToken string) (*postgres.PostgresUserToken, error) {
+	challenge, err := ms.userTokenRepository.GetByHash(purpose, hashToken(challengeToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	return challenge, nil
+}
+
+func newMFAChallenge(challenge *postgres.PostgresUserToken) *MFAChallenge {
+	return &MFAChallenge{
+		UserID:      challenge.UserID,
+		AuthMethods: strings.Fields(challenge.AuthMethods),
+	}
+}
+
+func challengePurpose(enrollment bool) string {
+	if enrollment {
+		return entities.TokenPurposeMFAEnrollment
+	}
+	return entities.TokenPurposeMFAChallenge
+}
+
+func (ms *mfaService) verifyTOTP(credential *postgres.PostgresTOTPCredential, code string) error {
+	secret, err := decryptSecret(ms.config.EncryptionKey, credential.SecretEncrypted)
+	if err != nil {
+		return err
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	fresh, err := ms.mfaRepository.UpdateLastUsedStep(credential.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (ms *mfaService) replaceRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, ms.config.RecoveryCodeCount)
+	hashes := make([]string, ms.config.RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+
+	if err := ms.mfaRepository.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns an 80-bit code formatted as xxxx-xxxx-xxxx-xxxx
+func generateRecoveryCode() (string, error) {
+	raw, err := generateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(raw[:16])
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+const testMFAUserID = 3
+
+func newTestMFAService(mfaRepository *memoryMFARepository) MFAService {
+	return NewMFAService(
+		newMemoryUserRepository(entities.User{ID: testMFAUserID, Username: "carol"}),
+		newMemoryRoleRepository(),
+		mfaRepository,
+		&memoryWebAuthnRepository{},
+		&memoryUserTokenRepository{},
+		MFAConfig{ChallengeTTL: 5 * time.Minute, MaxChallengeAttempts: 5},
+	)
+}
+
+// A login challenge of a user who already has a second factor must not let them enroll another
+// one in its place, e.g. through /auth/mfa/totp/enroll
+func TestEnrollmentRejectsMFAChallenge(t *testing.T) {
+	service := newTestMFAService(&memoryMFARepository{})
+
+	challenge, err := service.CreateChallenge(testMFAUserID, false, []string{AuthMethodPassword})
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	if _, err := service.ResolveEnrollmentChallenge(challenge); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("ResolveEnrollmentChallenge of an MFA challenge error = %v, want ErrInvalidMFAChallenge", err)
+	}
+	if err := service.ConsumeEnrollmentChallenge(challenge); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("ConsumeEnrollmentChallenge of an MFA challenge error = %v, want ErrInvalidMFAChallenge", err)
+	}
+	// The challenge is still good for the second factor it was issued for
+	if _, err := service.ResolveChallenge(challenge); err != nil {
+		t.Fatalf("ResolveChallenge: %v", err)
+	}
+
+	enrollment, err := service.CreateChallenge(testMFAUserID, true, []string{AuthMethodPassword})
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	if _, err := service.ResolveChallenge(enrollment); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Fatalf("ResolveChallenge of an enrollment challenge error = %v, want ErrInvalidMFAChallenge", err)
+	}
+	resolved, err := service.ResolveEnrollmentChallenge(enrollment)
+	if err != nil {
+		t.Fatalf("ResolveEnrollmentChallenge: %v", err)
+	}
+	if resolved.UserID != testMFAUserID || len(resolved.AuthMethods) != 1 || resolved.AuthMethods[0] != AuthMethodPassword {
+		t.Fatalf("enrollment challenge = %+v", resolved)
+	}
+}
+
+func TestEnrollmentChallengeExpiresOnceEnrolled(t *testing.T) {
+	mfaRepository := &memoryMFARepository{credentials: map[uint]*postgres.PostgresTOTPCredential{}}
+	service := newTestMFAService(mfaRepository)
+
+	enrollment, err := service.CreateChallenge(testMFAUserID, true, []string{AuthMethodPassword})
+	if err != nil {
+		t.Fatalf("CreateChallenge: %v", err)
+	}
+	now := time.Now()
+	mfaRepository.credentials[testMFAUserID] = &postgres.PostgresTOTPCredential{
+		TOTPCredential: entities.TOTPCredential{UserID: testMFAUserID, ConfirmedAt: &now},
+	}
+	if _, err := service.ResolveEnrollmentChallenge(enrollment); !errors.Is(err, ErrMFAAlreadyEnrolled) {
+		t.Fatalf("ResolveEnrollmentChallenge after enrolling error = %v, want ErrMFAAlreadyEnrolled", err)
+	}
+}
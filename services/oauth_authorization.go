@@ -0,0 +1,278 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// authorizationCodeLifetime is how long a client has to exchange an authorization code
+const authorizationCodeLifetime = time.Minute
+
+// CodeChallengeMethodS256 is the only PKCE method accepted; "plain" offers no protection
+// against an attacker who can read the authorization request
+const CodeChallengeMethodS256 = "S256"
+
+// ErrInvalidAuthorizationRequest is returned for an authorization request naming an unknown client
+// or an unregistered redirect URI. It must be shown to the user, never redirected.
+var ErrInvalidAuthorizationRequest = errors.New("invalid authorization request")
+
+// AuthorizationRequest is a request to the authorization endpoint
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce is echoed in the ID token (OpenID Connect)
+	Nonce string
+	// Prompt "none" asks for the authorization to complete without showing any page
+	Prompt string
+}
+
+// Authorization is a validated authorization request awaiting the user's decision
+type Authorization struct {
+	Client        *entities.OAuthClient
+	RedirectURI   string
+	State         string
+	CodeChallenge string
+	Nonce         string
+	// PromptNone means the user must not be shown a login or consent page
+	PromptNone bool
+	// Scopes are the scopes the client will be granted; nil means the client is limited
+	// only by the user's permissions
+	Scopes []string
+	issuer string
+}
+
+// PrepareAuthorization validates an authorization request (RFC 6749 section 4.1.1). Once the client
+// and redirect URI are known to be valid, errors are *OAuthError values to be delivered to the
+// client through Authorization.ErrorRedirect; before that ErrInvalidAuthorizationRequest is returned.
+func (oas *oauthService) PrepareAuthorization(request *AuthorizationRequest) (*Authorization, error) {
+	client, err := oas.oauthClientService.GetClient(request.ClientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, fmt.Errorf("%w: unknown client", ErrInvalidAuthorizationRequest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Redirect URIs are compared exactly; a client registering several must name one
+	redirectURIs := parseScopes(client.RedirectURIs)
+	redirectURI := request.RedirectURI
+	if redirectURI == "" && len(redirectURIs) == 1 {
+		redirectURI = redirectURIs[0]
+	}
+	if !hasScope(redirectURIs, redirectURI) {
+		return nil, fmt.Errorf("%w: redirect_uri is not registered for the client", ErrInvalidAuthorizationRequest)
+	}
+
+	authorization := &Authorization{
+		Client:      client,
+		RedirectURI: redirectURI,
+		State:       request.State,
+		issuer:      oas.config.Issuer,
+	}
+
+	if request.ResponseType != "code" {
+		return authorization, errUnsupportedResponseType("response_type must be code")
+	}
+	if !hasScope(parseScopes(client.GrantTypes), entities.GrantTypeAuthorizationCode) {
+		return authorization, errUnauthorizedClient("the client may not use the authorization_code grant")
+	}
+	if request.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return authorization, errInvalidRequest("PKCE with code_challenge_method S256 is required")
+	}
+	if !isBase64URLSHA256(request.CodeChallenge) {
+		return authorization, errInvalidRequest("code_challenge must be a base64url encoded SHA-256 digest")
+	}
+	authorization.CodeChallenge = request.CodeChallenge
+	authorization.Nonce = request.Nonce
+	authorization.PromptNone = request.Prompt == "none"
+
+	scopes, err := grantScopes(client, request.Scope)
+	if err != nil {
+		return authorization, err
+	}
+	authorization.Scopes = scopes
+	return authorization, nil
+}
+
+// ConsentRequired reports whether the user has yet to agree to every scope of the authorization
+func (oas *oauthService) ConsentRequired(userID uint, authorization *Authorization) (bool, error) {
+	consent, err := oas.oauthConsentRepository.Get(userID, authorization.Client.ClientID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	granted := storedScopes(consent.Scopes)
+	if granted == nil {
+		return false, nil
+	}
+	if authorization.Scopes == nil {
+		return true, nil
+	}
+	for _, scope := range authorization.Scopes {
+		if !hasScope(granted, scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Approve records the consent of the signed-in user and issues an authorization code, returning
+// the URL the user agent must be redirected to
+func (oas *oauthService) Approve(principal *Principal, authorization *Authorization) (string, error) {
+	userID := principal.User.ID
+	if err := oas.rememberConsent(userID, authorization); err != nil {
+		return "", err
+	}
+
+	code, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = oas.oauthAuthorizationCodeRepository.Create(&postgres.PostgresOAuthAuthorizationCode{
+		OAuthAuthorizationCode: entities.OAuthAuthorizationCode{
+			CodeHash:      hashToken(code),
+			ClientID:      authorization.Client.ClientID,
+			UserID:        userID,
+			RedirectURI:   authorization.RedirectURI,
+			Scopes:        strings.Join(authorization.Scopes, " "),
+			CodeChallenge: authorization.CodeChallenge,
+			Nonce:         authorization.Nonce,
+			AuthTime:      principal.AuthTime,
+			ExpiresAt:     time.Now().Add(authorizationCodeLifetime),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return authorization.redirect(url.Values{"code": {code}}), nil
+}
+
+// rememberConsent adds the scopes of an authorization to those the user already granted the client
+func (oas *oauthService) rememberConsent(userID uint, authorization *Authorization) error {
+	scopes := authorization.Scopes
+	if scopes != nil {
+		consent, err := oas.oauthConsentRepository.Get(userID, authorization.Client.ClientID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if consent != nil {
+			granted := storedScopes(consent.Scopes)
+			if granted == nil {
+				scopes = nil
+			} else {
+				scopes = parseScopes(consent.Scopes + " " + strings.Join(scopes, " "))
+			}
+		}
+	}
+	return oas.oauthConsentRepository.Save(userID, authorization.Client.ClientID, strings.Join(scopes, " "))
+}
+
+// LoginURL returns the login page to send a user without a session to, or "" when none is configured.
+// After logging in the user returns to path, e.g. the authorization endpoint with its query.
+func (oas *oauthService) LoginURL(path string) string {
+	if oas.config.LoginURL == "" {
+		return ""
+	}
+	loginURL, err := url.Parse(oas.config.LoginURL)
+	if err != nil {
+		return ""
+	}
+	query := loginURL.Query()
+	query.Set("return_to", strings.TrimSuffix(oas.config.Issuer, "/")+path)
+	loginURL.RawQuery = query.Encode()
+	return loginURL.String()
+}
+
+// ErrorRedirect returns the URL delivering an error to the client (RFC 6749 section 4.1.2.1)
+func (a *Authorization) ErrorRedirect(err *OAuthError) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return a.redirect(params)
+}
+
+// Deny returns the URL telling the client the user refused the authorization
+func (a *Authorization) Deny() string {
+	return a.ErrorRedirect(errAccessDenied("the user denied the request"))
+}
+
+// LoginRequired returns the URL telling a client that asked for prompt=none that the user
+// must log in first (OpenID Connect Core section 3.1.2.6)
+func (a *Authorization) LoginRequired() string {
+	return a.ErrorRedirect(oauthError(http.StatusBadRequest, "login_required", "the user is not logged in"))
+}
+
+// ConsentRequired returns the URL telling a client that asked for prompt=none that the user
+// must consent first
+func (a *Authorization) ConsentRequired() string {
+	return a.ErrorRedirect(oauthError(http.StatusBadRequest, "consent_required", "the user has not consented to the requested scopes"))
+}
+
+// redirect adds params, the state and the issuer (RFC 9207) to the redirect URI
+func (a *Authorization) redirect(params url.Values) string {
+	redirectURL, err := url.Parse(a.RedirectURI)
+	if err != nil {
+		// Registered redirect URIs are validated, so this cannot happen
+		return a.RedirectURI
+	}
+
+	query := redirectURL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if a.State != "" {
+		query.Set("state", a.State)
+	}
+	if a.issuer != "" {
+		query.Set("iss", a.issuer)
+	}
+	redirectURL.RawQuery = query.Encode()
+	return redirectURL.String()
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the S256 challenge it was derived from
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		if !isUnreservedChar(c) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func isBase64URLSHA256(value string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// isUnreservedChar reports whether c may appear in a code verifier (RFC 7636 section 4.1)
+func isUnreservedChar(c rune) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// ClientAssertionTypeJWTBearer is the only client_assertion_type accepted for private_key_jwt
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxClientAssertionLifetime bounds how far in the future a client assertion may expire,
+// which also bounds how long its jti has to be remembered
+const maxClientAssertionLifetime = 10 * time.Minute
+
+var (
+	// ErrOAuthClientNotFound is returned for an unknown client ID
+	ErrOAuthClientNotFound = errors.New("OAuth client not found")
+	// ErrInvalidOAuthClient is returned when a client registration is inconsistent
+	ErrInvalidOAuthClient = errors.New("invalid OAuth client")
+)
+
+// ClientCredentials are the credentials a client presented at the token endpoint
+type ClientCredentials struct {
+	ClientID            string
+	ClientSecret        string
+	ClientAssertionType string
+	ClientAssertion     string
+	// UsedBasicAuth is set when the credentials came from an HTTP Basic Authorization header
+	UsedBasicAuth bool
+}
+
+type OAuthClientService interface {
+	CreateClient(creatorID uint, client *entities.OAuthClient) (*entities.OAuthClient, string, error)
+	GetClients() ([]*entities.OAuthClient, error)
+	GetClient(clientID string) (*entities.OAuthClient, error)
+	DeleteClient(clientID string) error
+	AuthenticateClient(credentials ClientCredentials, audience string) (*entities.OAuthClient, error)
+}
+
+type oauthClientService struct {
+	oauthClientRepository postgres.OAuthClientRepository
+	userRepository        postgres.UserRepository
+	permissionRepository  postgres.PermissionRepository
+	sessionRepository     postgres.SessionRepository
+	replayCacheRepository postgres.ReplayCacheRepository
+}
+
+func NewOAuthClientService(
+	oauthClientRepository postgres.OAuthClientRepository,
+	userRepository postgres.UserRepository,
+	permissionRepository postgres.PermissionRepository,
+	sessionRepository postgres.SessionRepository,
+	replayCacheRepository postgres.ReplayCacheRepository,
+) OAuthClientService {
+	return &oauthClientService{
+		oauthClientRepository: oauthClientRepository,
+		userRepository:        userRepository,
+		permissionRepository:  permissionRepository,
+		sessionRepository:     sessionRepository,
+		replayCacheRepository: replayCacheRepository,
+	}
+}
+
+// CreateClient registers a client. For secret based clients the generated secret is returned;
+// it is only ever available here. Public clients get no secret. The creator must hold the scopes
+// of the client and every permission of its service account.
+func (ocs *oauthClientService) CreateClient(creatorID uint, client *entities.OAuthClient) (*entities.OAuthClient, string, error) {
+	if err := ocs.validateClient(client); err != nil {
+		return nil, "", err
+	}
+	if err := checkScopesDelegable(ocs.permissionRepository, creatorID, parseScopes(client.Scopes)); err != nil {
+		return nil, "", err
+	}
+	if client.ServiceAccountID != nil {
+		if err := checkAccountDelegable(ocs.permissionRepository, creatorID, *client.ServiceAccountID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	clientID, err := generateToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var secret, secretHash string
+	if client.AuthMethod == entities.ClientAuthMethodSecretBasic || client.AuthMethod == entities.ClientAuthMethodSecretPost {
+		secret, err = generateToken(32)
+		if err != nil {
+			return nil, "", err
+		}
+		secretHash = hashToken(secret)
+	}
+
+	created, err := ocs.oauthClientRepository.Create(&postgres.PostgresOAuthClient{
+		OAuthClient: entities.OAuthClient{
+			ClientID:               clientID,
+			Name:                   client.Name,
+			SecretHash:             secretHash,
+			AuthMethod:             client.AuthMethod,
+			PublicKey:              client.PublicKey,
+			GrantTypes:             strings.Join(parseScopes(client.GrantTypes), " "),
+			Scopes:                 strings.Join(parseScopes(client.Scopes), " "),
+			RedirectURIs:           strings.Join(parseScopes(client.RedirectURIs), " "),
+			PostLogoutRedirectURIs: strings.Join(parseScopes(client.PostLogoutRedirectURIs), " "),
+			TokenExchangeAudiences: strings.Join(parseScopes(client.TokenExchangeAudiences), " "),
+			ServiceAccountID:       client.ServiceAccountID,
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &created.OAuthClient, secret, nil
+}
+
+func (ocs *oauthClientService) validateClient(client *entities.OAuthClient) error {
+	switch client.AuthMethod {
+	case entities.ClientAuthMethodSecretBasic, entities.ClientAuthMethodSecretPost, entities.ClientAuthMethodNone:
+	case entities.ClientAuthMethodPrivateKeyJWT:
+		if _, err := parseClientPublicKey(client.PublicKey); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOAuthClient, err)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported token endpoint auth method %q", ErrInvalidOAuthClient, client.AuthMethod)
+	}
+
+	grantTypes := parseScopes(client.GrantTypes)
+	if len(grantTypes) == 0 {
+		return fmt.Errorf("%w: at least one grant type is required", ErrInvalidOAuthClient)
+	}
+	for _, grantType := range grantTypes {
+		switch grantType {
+		case entities.GrantTypeClientCredentials:
+			if client.AuthMethod == entities.ClientAuthMethodNone {
+				return fmt.Errorf("%w: public clients cannot use the client_credentials grant", ErrInvalidOAuthClient)
+			}
+			if err := ocs.validateServiceAccount(client.ServiceAccountID); err != nil {
+				return err
+			}
+		case entities.GrantTypeAuthorizationCode:
+			if len(parseScopes(client.RedirectURIs)) == 0 {
+				return fmt.Errorf("%w: the authorization_code grant requires at least one redirect URI", ErrInvalidOAuthClient)
+			}
+		case entities.GrantTypeDeviceCode:
+		case entities.GrantTypeTokenExchange:
+			if client.AuthMethod == entities.ClientAuthMethodNone {
+				return fmt.Errorf("%w: public clients cannot use the token exchange grant", ErrInvalidOAuthClient)
+			}
+			if len(parseScopes(client.TokenExchangeAudiences)) == 0 {
+				return fmt.Errorf("%w: the token exchange grant requires at least one audience", ErrInvalidOAuthClient)
+			}
+		case entities.GrantTypeRefreshToken:
+			if !hasScope(grantTypes, entities.GrantTypeAuthorizationCode) && !hasScope(grantTypes, entities.GrantTypeDeviceCode) {
+				return fmt.Errorf("%w: the refresh_token grant requires the authorization_code or device_code grant", ErrInvalidOAuthClient)
+			}
+		default:
+			return fmt.Errorf("%w: unsupported grant type %q", ErrInvalidOAuthClient, grantType)
+		}
+	}
+
+	if err := validateRedirectURIs(client.RedirectURIs); err != nil {
+		return err
+	}
+	if err := validateRedirectURIs(client.PostLogoutRedirectURIs); err != nil {
+		return err
+	}
+
+	// Scopes are permissions, so a client can never be granted more than its users hold.
+	// The OpenID Connect scopes only release identity claims.
+	for _, scope := range parseScopes(client.Scopes) {
+		if hasScope(oidcScopes, scope) {
+			continue
+		}
+		resource, action, found := strings.Cut(scope, ":")
+		if !found || resource == "" || action == "" {
+			return fmt.Errorf("%w: scope %q is not of the form resource:action", ErrInvalidOAuthClient, scope)
+		}
+		_, err := ocs.permissionRepository.GetByResourceAndAction(resource, action)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: scope %q does not match any permission", ErrInvalidOAuthClient, scope)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRedirectURIs requires absolute URIs without fragments (RFC 6749 section 3.1.2). Plain http
+// is only allowed for loopback addresses, as used by native apps (RFC 8252 section 7.3).
+func validateRedirectURIs(redirectURIs string) error {
+	for _, uri := range parseScopes(redirectURIs) {
+		parsed, err := url.Parse(uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return fmt.Errorf("%w: redirect URI %q must be absolute and have no fragment", ErrInvalidOAuthClient, uri)
+		}
+		if parsed.Scheme == "http" && !isLoopbackHost(parsed.Hostname()) {
+			return fmt.Errorf("%w: redirect URI %q must use https", ErrInvalidOAuthClient, uri)
+		}
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+func (ocs *oauthClientService) validateServiceAccount(serviceAccountID *uint) error {
+	if serviceAccountID == nil {
+		return fmt.Errorf("%w: the client_credentials grant requires a service account", ErrInvalidOAuthClient)
+	}
+	account, err := ocs.userRepository.GetByID(*serviceAccountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: service account %d does not exist", ErrInvalidOAuthClient, *serviceAccountID)
+	}
+	if err != nil {
+		return err
+	}
+	if !account.IsServiceAccount {
+		return fmt.Errorf("%w: user %d is not a service account", ErrInvalidOAuthClient, *serviceAccountID)
+	}
+	return nil
+}
+
+func (ocs *oauthClientService) GetClients() ([]*entities.OAuthClient, error) {
+	postgresClients, err := ocs.oauthClientRepository.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]*entities.OAuthClient, 0, len(postgresClients))
+	for _, postgresClient := range postgresClients {
+		clients = append(clients, &postgresClient.OAuthClient)
+	}
+	return clients, nil
+}
+
+func (ocs *oauthClientService) GetClient(clientID string) (*entities.OAuthClient, error) {
+	client, err := ocs.oauthClientRepository.GetByClientID(clientID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client.OAuthClient, nil
+}
+
+// DeleteClient removes a client; tokens it obtained stop working immediately
+func (ocs *oauthClientService) DeleteClient(clientID string) error {
+	deleted, err := ocs.oauthClientRepository.Delete(clientID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrOAuthClientNotFound
+	}
+	return ocs.sessionRepository.RevokeAllForClient(clientID)
+}
+
+// AuthenticateClient checks the credentials of a client calling an endpoint identified by audience,
+// using its registered method. Failures are returned as an *OAuthError.
+func (ocs *oauthClientService) AuthenticateClient(credentials ClientCredentials, audience string) (*entities.OAuthClient, error) {
+	if credentials.ClientAssertion != "" {
+		return ocs.authenticateWithAssertion(credentials, audience)
+	}
+	if credentials.ClientID == "" {
+		return nil, errInvalidClient("client authentication is required")
+	}
+
+	client, err := ocs.getClient(credentials.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	// Public clients only identify themselves; PKCE binds their codes instead of a secret
+	if client.AuthMethod == entities.ClientAuthMethodNone {
+		if credentials.ClientSecret != "" {
+			return nil, errInvalidClient("public clients must not send a client secret")
+		}
+		return client, nil
+	}
+	if client.AuthMethod == entities.ClientAuthMethodPrivateKeyJWT || client.SecretHash == "" {
+		return nil, errInvalidClient("the client must authenticate with " + client.AuthMethod)
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(credentials.ClientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, errInvalidClient("invalid client credentials")
+	}
+	return client, nil
+}
+
+// authenticateWithAssertion verifies a private_key_jwt client assertion (RFC 7523 section 2.2)
+func (ocs *oauthClientService) authenticateWithAssertion(credentials ClientCredentials, audience string) (*entities.OAuthClient, error) {
+	if credentials.ClientAssertionType != ClientAssertionTypeJWTBearer {
+		return nil, errInvalidClient("unsupported client_assertion_type")
+	}
+
+	// The issuer names the client whose key verifies the assertion
+	unverified, _, err := new(jwt.Parser).ParseUnverified(credentials.ClientAssertion, jwt.MapClaims{})
+	if err != nil {
+		return nil, errInvalidClient("malformed client assertion")
+	}
+	issuer, _ := unverified.Claims.(jwt.MapClaims)["iss"].(string)
+	if issuer == "" || (credentials.ClientID != "" && credentials.ClientID != issuer) {
+		return nil, errInvalidClient("client assertion issuer does not match the client")
+	}
+
+	client, err := ocs.getClient(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if client.AuthMethod != entities.ClientAuthMethodPrivateKeyJWT {
+		return nil, errInvalidClient("the client must authenticate with " + client.AuthMethod)
+	}
+	publicKey, err := parseClientPublicKey(client.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(credentials.ClientAssertion, func(t *jwt.Token) (interface{}, error) {
+		if !signingMethodMatchesKey(t.Method, publicKey) {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return publicKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errInvalidClient("invalid client assertion signature")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	subject, _ := claims["sub"].(string)
+	tokenID, _ := claims["jti"].(string)
+	expiresAt, hasExpiry := claims["exp"].(float64)
+	switch {
+	case subject != client.ClientID:
+		return nil, errInvalidClient("client assertion subject does not match the client")
+	case !claims.VerifyAudience(audience, true):
+		return nil, errInvalidClient("client assertion audience does not match " + audience)
+	case !hasExpiry || time.Unix(int64(expiresAt), 0).After(time.Now().Add(maxClientAssertionLifetime)):
+		return nil, errInvalidClient("client assertion must expire within " + maxClientAssertionLifetime.String())
+	case tokenID == "":
+		return nil, errInvalidClient("client assertion has no jti")
+	}
+
+	fresh, err := ocs.replayCacheRepository.Remember("client_assertion:"+client.ClientID+":"+tokenID, time.Unix(int64(expiresAt), 0))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, errInvalidClient("client assertion has already been used")
+	}
+	return client, nil
+}
+
+// getClient looks up the client presenting credentials, failing with invalid_client when unknown
+func (ocs *oauthClientService) getClient(clientID string) (*entities.OAuthClient, error) {
+	client, err := ocs.GetClient(clientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, errInvalidClient("unknown client")
+	}
+	return client, err
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	// deviceCodeLifetime is how long the user has to approve a device
+	deviceCodeLifetime = 10 * time.Minute
+	// devicePollInterval is the initial minimum number of seconds between token requests of a device
+	devicePollInterval = 5
+	// userCodeAlphabet avoids vowels, so codes never spell words, and characters that are easily confused
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// ErrDeviceCodeNotFound is returned for a user code that is unknown, expired or already decided
+var ErrDeviceCodeNotFound = errors.New("device code not found")
+
+// DeviceAuthorizationRequest is a request to the device authorization endpoint
+type DeviceAuthorizationRequest struct {
+	Scope  string
+	Client ClientCredentials
+}
+
+// DeviceAuthorizationResponse tells a device how to let its user approve it (RFC 8628 section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceAuthorization is a pending device request, shown to the user for approval
+type DeviceAuthorization struct {
+	UserCode string
+	Client   *entities.OAuthClient
+	// Scopes are the scopes the device will be granted; nil means it is limited only by the user's permissions
+	Scopes []string
+}
+
+// DeviceAuthorization starts the device flow for a client (RFC 8628 section 3.1).
+// Protocol failures are returned as an *OAuthError.
+func (oas *oauthService) DeviceAuthorization(request *DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error) {
+	client, err := oas.oauthClientService.AuthenticateClient(request.Client, oas.tokenEndpoint())
+	if err != nil {
+		return nil, err
+	}
+	if !hasScope(parseScopes(client.GrantTypes), entities.GrantTypeDeviceCode) {
+		return nil, errUnauthorizedClient("the client may not use the device_code grant")
+	}
+	scopes, err := grantScopes(client, request.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := generateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = oas.oauthDeviceCodeRepository.Create(&postgres.PostgresOAuthDeviceCode{
+		OAuthDeviceCode: entities.OAuthDeviceCode{
+			DeviceCodeHash: hashToken(deviceCode),
+			UserCodeHash:   hashToken(userCode),
+			ClientID:       client.ClientID,
+			Scopes:         strings.Join(scopes, " "),
+			Status:         entities.DeviceCodeStatusPending,
+			PollInterval:   devicePollInterval,
+			ExpiresAt:      time.Now().Add(deviceCodeLifetime),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	displayCode := formatUserCode(userCode)
+	verificationURI := strings.TrimSuffix(oas.config.Issuer, "/") + "/oauth/device"
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                displayCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(displayCode),
+		ExpiresIn:               int64(deviceCodeLifetime.Seconds()),
+		Interval:                devicePollInterval,
+	}, nil
+}
+
+// GetDeviceAuthorization returns the pending request a user code belongs to
+func (oas *oauthService) GetDeviceAuthorization(userCode string) (*DeviceAuthorization, error) {
+	deviceCode, err := oas.pendingDeviceCode(userCode)
+	if err != nil {
+		return nil, err
+	}
+	client, err := oas.oauthClientService.GetClient(deviceCode.ClientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, ErrDeviceCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceAuthorization{
+		UserCode: formatUserCode(normalizeUserCode(userCode)),
+		Client:   client,
+		Scopes:   storedScopes(deviceCode.Scopes),
+	}, nil
+}
+
+// DecideDevice records whether the signed-in user approved the device a user code belongs to.
+// Approving also records the user's consent to the requested scopes.
+func (oas *oauthService) DecideDevice(principal *Principal, userCode string, approved bool) error {
+	deviceCode, err := oas.pendingDeviceCode(userCode)
+	if err != nil {
+		return err
+	}
+
+	status := entities.DeviceCodeStatusDenied
+	if approved {
+		client, err := oas.oauthClientService.GetClient(deviceCode.ClientID)
+		if errors.Is(err, ErrOAuthClientNotFound) {
+			return ErrDeviceCodeNotFound
+		}
+		if err != nil {
+			return err
+		}
+		err = oas.rememberConsent(principal.User.ID, &Authorization{Client: client, Scopes: storedScopes(deviceCode.Scopes)})
+		if err != nil {
+			return err
+		}
+		status = entities.DeviceCodeStatusApproved
+	}
+
+	decided, err := oas.oauthDeviceCodeRepository.Decide(deviceCode.ID, status, principal.User.ID, principal.AuthTime)
+	if err != nil {
+		return err
+	}
+	if !decided {
+		return ErrDeviceCodeNotFound
+	}
+	return nil
+}
+
+func (oas *oauthService) pendingDeviceCode(userCode string) (*postgres.PostgresOAuthDeviceCode, error) {
+	deviceCode, err := oas.oauthDeviceCodeRepository.GetPendingByUserCodeHash(hashToken(normalizeUserCode(userCode)))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeviceCodeNotFound
+	}
+	return deviceCode, err
+}
+
+// deviceCodeGrant answers a polling device (RFC 8628 section 3.4): pending until the user decides,
+// then tokens bound to a new session or access_denied
+func (oas *oauthService) deviceCodeGrant(client *entities.OAuthClient, request *TokenRequest) (*TokenResponse, error) {
+	if request.DeviceCode == "" {
+		return nil, errInvalidRequest("device_code is required")
+	}
+
+	deviceCode, err := oas.oauthDeviceCodeRepository.GetByDeviceCodeHash(hashToken(request.DeviceCode))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("invalid device code")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deviceCode.ClientID != client.ClientID {
+		return nil, errInvalidGrant("invalid device code")
+	}
+
+	now := time.Now()
+	if deviceCode.UsedAt != nil {
+		return nil, errInvalidGrant("device code has already been used")
+	}
+	if now.After(deviceCode.ExpiresAt) {
+		return nil, errExpiredToken("the device code has expired")
+	}
+
+	// Devices polling faster than the interval must back off by five seconds (section 3.5)
+	pollInterval := deviceCode.PollInterval
+	tooFast := deviceCode.LastPolledAt != nil && now.Sub(*deviceCode.LastPolledAt) < time.Duration(pollInterval)*time.Second
+	if tooFast {
+		pollInterval += 5
+	}
+	if err := oas.oauthDeviceCodeRepository.RecordPoll(deviceCode.ID, now, pollInterval); err != nil {
+		return nil, err
+	}
+	if tooFast {
+		return nil, errSlowDown("poll at most every " + (time.Duration(pollInterval) * time.Second).String())
+	}
+
+	switch deviceCode.Status {
+	case entities.DeviceCodeStatusPending:
+		return nil, errAuthorizationPending("the user has not approved the device yet")
+	case entities.DeviceCodeStatusDenied:
+		return nil, errAccessDenied("the user denied the request")
+	}
+
+	used, err := oas.oauthDeviceCodeRepository.MarkUsed(deviceCode.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !used || deviceCode.UserID == nil {
+		return nil, errInvalidGrant("device code has already been used")
+	}
+
+	user, err := oas.userRepository.GetByID(*deviceCode.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("the user no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, errInvalidGrant("the user is deactivated")
+	}
+
+	session, err := oas.sessionService.CreateForClient(user.ID, client.ClientID, request.ClientInfo, oas.config.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	authTime := now
+	if deviceCode.AuthTime != nil {
+		authTime = *deviceCode.AuthTime
+	}
+	return oas.issueUserTokens(client, &user.User, session, storedScopes(deviceCode.Scopes), authTime, "")
+}
+
+// generateUserCode returns a random user code of userCodeLength characters from userCodeAlphabet
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits a user code in two halves for readability, e.g. BCDF-GHJK
+func formatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// normalizeUserCode makes user codes case-insensitive and ignores the dash and spaces users may type
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
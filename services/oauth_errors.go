@@ -0,0 +1,69 @@
+package services
+
+import "net/http"
+
+// OAuthError is an error response of the OAuth endpoints as defined in RFC 6749 section 5.2
+type OAuthError struct {
+	Code        string
+	Description string
+	StatusCode  int
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(statusCode int, code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description, StatusCode: statusCode}
+}
+
+func errInvalidClient(description string) *OAuthError {
+	return oauthError(http.StatusUnauthorized, "invalid_client", description)
+}
+
+func errInvalidRequest(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "invalid_request", description)
+}
+
+func errInvalidGrant(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "invalid_grant", description)
+}
+
+func errUnauthorizedClient(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "unauthorized_client", description)
+}
+
+func errUnsupportedGrantType(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "unsupported_grant_type", description)
+}
+
+func errInvalidScope(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "invalid_scope", description)
+}
+
+func errAccessDenied(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "access_denied", description)
+}
+
+func errUnsupportedResponseType(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "unsupported_response_type", description)
+}
+
+func errAuthorizationPending(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "authorization_pending", description)
+}
+
+func errSlowDown(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "slow_down", description)
+}
+
+func errExpiredToken(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "expired_token", description)
+}
+
+func errInvalidTarget(description string) *OAuthError {
+	return oauthError(http.StatusBadRequest, "invalid_target", description)
+}
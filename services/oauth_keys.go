@@ -0,0 +1,90 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// parseClientPublicKey parses the PEM encoded RSA, ECDSA or Ed25519 public key of a client
+func parseClientPublicKey(publicKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+// signingMethodMatchesKey prevents algorithm confusion by only accepting the algorithm family of the key
+func signingMethodMatchesKey(method jwt.SigningMethod, publicKey interface{}) bool {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		_, isRSA := method.(*jwt.SigningMethodRSA)
+		_, isPSS := method.(*jwt.SigningMethodRSAPSS)
+		return isRSA || isPSS
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	default:
+		return false
+	}
+}
+
+// LoadSigningKey reads the PEM encoded RSA private key (PKCS #1 or PKCS #8) signing ID tokens.
+// Without a path an ephemeral key is generated; tokens it signs stop verifying on restart and
+// are not accepted by other replicas.
+func LoadSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key must be an RSA key, got %T", key)
+	}
+	return rsaKey, nil
+}
+
+// keyID derives a stable key ID from a public key, so relying parties can pick it from the JWKS
+func keyID(publicKey *rsa.PublicKey) string {
+	der := x509.MarshalPKCS1PublicKey(publicKey)
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// OAuthConfig configures the OAuth 2.0 authorization server
+type OAuthConfig struct {
+	// Issuer is the public base URL of this service, e.g. https://auth.example.com
+	Issuer         string
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a user's authorization of a client lasts without logging in again
+	RefreshTokenTTL time.Duration
+	// TokenExchangeTTL is the longest an exchanged token lasts; it never outlives the token it replaced
+	TokenExchangeTTL time.Duration
+	// LoginURL is the page users without a session are sent to from the authorization endpoint;
+	// it receives the URL to return to in the return_to parameter
+	LoginURL string
+}
+
+// TokenRequest is a request to the token endpoint
+type TokenRequest struct {
+	GrantType    string
+	Scope        string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	DeviceCode   string
+	// Token exchange parameters (RFC 8693 section 2.1)
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	RequestedTokenType string
+	Audiences          []string
+	Client             ClientCredentials
+	// ClientInfo describes the device making the request, recorded on sessions it creates
+	ClientInfo ClientInfo
+}
+
+// TokenResponse is a successful token endpoint response (RFC 6749 section 5.1)
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	// IssuedTokenType is only set for the token exchange grant (RFC 8693 section 2.2.1)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+type OAuthService interface {
+	PrepareAuthorization(request *AuthorizationRequest) (*Authorization, error)
+	ConsentRequired(userID uint, authorization *Authorization) (bool, error)
+	Approve(principal *Principal, authorization *Authorization) (string, error)
+	LoginURL(path string) string
+	DeviceAuthorization(request *DeviceAuthorizationRequest) (*DeviceAuthorizationResponse, error)
+	GetDeviceAuthorization(userCode string) (*DeviceAuthorization, error)
+	DecideDevice(principal *Principal, userCode string, approved bool) error
+	Token(request *TokenRequest) (*TokenResponse, error)
+	AuthenticateClientToken(claims jwt.MapClaims) (*Principal, error)
+}
+
+type oauthService struct {
+	oauthClientService               OAuthClientService
+	sessionService                   SessionService
+	oidcService                      OIDCService
+	userRepository                   postgres.UserRepository
+	roleRepository                   postgres.RoleRepository
+	sessionRepository                postgres.SessionRepository
+	oauthAuthorizationCodeRepository postgres.OAuthAuthorizationCodeRepository
+	oauthRefreshTokenRepository      postgres.OAuthRefreshTokenRepository
+	oauthConsentRepository           postgres.OAuthConsentRepository
+	oauthDeviceCodeRepository        postgres.OAuthDeviceCodeRepository
+	revokedTokenRepository           postgres.RevokedTokenRepository
+	tokenService                     TokenService
+	config                           OAuthConfig
+}
+
+func NewOAuthService(
+	oauthClientService OAuthClientService,
+	sessionService SessionService,
+	oidcService OIDCService,
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	sessionRepository postgres.SessionRepository,
+	oauthAuthorizationCodeRepository postgres.OAuthAuthorizationCodeRepository,
+	oauthRefreshTokenRepository postgres.OAuthRefreshTokenRepository,
+	oauthConsentRepository postgres.OAuthConsentRepository,
+	oauthDeviceCodeRepository postgres.OAuthDeviceCodeRepository,
+	revokedTokenRepository postgres.RevokedTokenRepository,
+	tokenService TokenService,
+	config OAuthConfig,
+) OAuthService {
+	return &oauthService{
+		oauthClientService:               oauthClientService,
+		sessionService:                   sessionService,
+		oidcService:                      oidcService,
+		userRepository:                   userRepository,
+		roleRepository:                   roleRepository,
+		sessionRepository:                sessionRepository,
+		oauthAuthorizationCodeRepository: oauthAuthorizationCodeRepository,
+		oauthRefreshTokenRepository:      oauthRefreshTokenRepository,
+		oauthConsentRepository:           oauthConsentRepository,
+		oauthDeviceCodeRepository:        oauthDeviceCodeRepository,
+		revokedTokenRepository:           revokedTokenRepository,
+		tokenService:                     tokenService,
+		config:                           config,
+	}
+}
+
+// Token handles the token endpoint. Protocol failures are returned as an *OAuthError.
+func (oas *oauthService) Token(request *TokenRequest) (*TokenResponse, error) {
+	if request.GrantType == "" {
+		return nil, errInvalidRequest("grant_type is required")
+	}
+
+	client, err := oas.oauthClientService.AuthenticateClient(request.Client, oas.tokenEndpoint())
+	if err != nil {
+		return nil, err
+	}
+	if !hasScope(parseScopes(client.GrantTypes), request.GrantType) {
+		return nil, errUnauthorizedClient("the client may not use the " + request.GrantType + " grant")
+	}
+
+	switch request.GrantType {
+	case entities.GrantTypeClientCredentials:
+		return oas.clientCredentialsGrant(client, request.Scope)
+	case entities.GrantTypeAuthorizationCode:
+		return oas.authorizationCodeGrant(client, request)
+	case entities.GrantTypeRefreshToken:
+		return oas.refreshTokenGrant(client, request)
+	case entities.GrantTypeDeviceCode:
+		return oas.deviceCodeGrant(client, request)
+	case entities.GrantTypeTokenExchange:
+		return oas.tokenExchangeGrant(client, request)
+	default:
+		return nil, errUnsupportedGrantType("unsupported grant_type " + request.GrantType)
+	}
+}
+
+// clientCredentialsGrant issues a token for the service account bound to the client (RFC 6749 section 4.4)
+func (oas *oauthService) clientCredentialsGrant(client *entities.OAuthClient, requestedScope string) (*TokenResponse, error) {
+	if client.ServiceAccountID == nil {
+		return nil, errUnauthorizedClient("the client has no service account")
+	}
+
+	scopes, err := grantScopes(client, requestedScope)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := oas.userRepository.GetByID(*client.ServiceAccountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("the service account no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	roleNames, err := oas.roleNames(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := oas.tokenService.IssueClientToken(&account.User, roleNames, "", client.ClientID, scopes, time.Now().Add(oas.config.AccessTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(oas.config.AccessTokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// authorizationCodeGrant exchanges an authorization code and its PKCE verifier for tokens
+// (RFC 6749 section 4.1.3, RFC 7636 section 4.6). The tokens are bound to a new session.
+func (oas *oauthService) authorizationCodeGrant(client *entities.OAuthClient, request *TokenRequest) (*TokenResponse, error) {
+	if request.Code == "" || request.CodeVerifier == "" {
+		return nil, errInvalidRequest("code and code_verifier are required")
+	}
+
+	code, err := oas.oauthAuthorizationCodeRepository.GetByHash(hashToken(request.Code))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("invalid authorization code")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code.ClientID != client.ClientID {
+		return nil, errInvalidGrant("invalid authorization code")
+	}
+
+	used, err := oas.oauthAuthorizationCodeRepository.MarkUsed(code.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		// A replayed code may have been stolen, so the tokens issued for it are revoked too
+		if code.SessionID != "" {
+			if _, err := oas.sessionRepository.Revoke(code.UserID, code.SessionID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, errInvalidGrant("authorization code has already been used")
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return nil, errInvalidGrant("authorization code has expired")
+	}
+	if request.RedirectURI != "" && request.RedirectURI != code.RedirectURI {
+		return nil, errInvalidGrant("redirect_uri does not match the authorization request")
+	}
+	if !verifyCodeChallenge(request.CodeVerifier, code.CodeChallenge) {
+		return nil, errInvalidGrant("code_verifier does not match the code challenge")
+	}
+
+	user, err := oas.userRepository.GetByID(code.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("the user no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The user may have been deactivated between consenting and the code being redeemed
+	if user.DeactivatedAt != nil {
+		return nil, errInvalidGrant("the user is deactivated")
+	}
+
+	session, err := oas.sessionService.CreateForClient(user.ID, client.ClientID, request.ClientInfo, oas.config.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := oas.oauthAuthorizationCodeRepository.SetSessionID(code.ID, session.ID); err != nil {
+		return nil, err
+	}
+
+	return oas.issueUserTokens(client, &user.User, session, storedScopes(code.Scopes), code.AuthTime, code.Nonce)
+}
+
+// refreshTokenGrant rotates a refresh token for a new access and refresh token (RFC 6749 section 6).
+// Presenting a token that was already rotated revokes the whole session, as one of the two
+// parties holding it is not the legitimate client.
+func (oas *oauthService) refreshTokenGrant(client *entities.OAuthClient, request *TokenRequest) (*TokenResponse, error) {
+	if request.RefreshToken == "" {
+		return nil, errInvalidRequest("refresh_token is required")
+	}
+
+	refreshToken, err := oas.oauthRefreshTokenRepository.GetByHash(hashToken(request.RefreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("invalid refresh token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken.ClientID != client.ClientID {
+		return nil, errInvalidGrant("invalid refresh token")
+	}
+
+	rotated, err := oas.oauthRefreshTokenRepository.MarkRotated(refreshToken.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		if _, err := oas.sessionRepository.Revoke(refreshToken.UserID, refreshToken.SessionID); err != nil {
+			return nil, err
+		}
+		return nil, errInvalidGrant("refresh token has already been used")
+	}
+
+	session, err := oas.sessionRepository.GetByID(refreshToken.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("invalid refresh token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) || time.Now().After(refreshToken.ExpiresAt) {
+		return nil, errInvalidGrant("refresh token has expired or been revoked")
+	}
+
+	// A refresh may narrow the scopes of the authorization but never widen them
+	scopes := storedScopes(refreshToken.Scopes)
+	if requested := parseScopes(request.Scope); len(requested) > 0 {
+		for _, scope := range requested {
+			if scopes != nil && !hasScope(scopes, scope) {
+				return nil, errInvalidScope("the authorization does not include " + scope)
+			}
+		}
+		scopes = requested
+	}
+
+	user, err := oas.userRepository.GetByID(refreshToken.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInvalidGrant("the user no longer exists")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DeactivatedAt != nil {
+		return nil, errInvalidGrant("the user is deactivated")
+	}
+
+	return oas.issueUserTokens(client, &user.User, &session.Session, scopes, refreshToken.AuthTime, "")
+}
+
+// issueUserTokens issues an access token for session, an ID token when the openid scope was granted
+// and, when the client may refresh them, a refresh token. None outlives the session.
+func (oas *oauthService) issueUserTokens(client *entities.OAuthClient, user *entities.User, session *entities.Session, scopes []string, authTime time.Time, nonce string) (*TokenResponse, error) {
+	roleNames, err := oas.roleNames(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(oas.config.AccessTokenTTL)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	accessToken, err := oas.tokenService.IssueClientToken(user, roleNames, session.ID, client.ClientID, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if hasScope(scopes, ScopeOpenID) {
+		response.IDToken, err = oas.oidcService.IssueIDToken(user, roleNames, client.ClientID, session.ID, scopes, authTime, nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if hasScope(parseScopes(client.GrantTypes), entities.GrantTypeRefreshToken) {
+		refreshToken, err := generateToken(32)
+		if err != nil {
+			return nil, err
+		}
+		_, err = oas.oauthRefreshTokenRepository.Create(&postgres.PostgresOAuthRefreshToken{
+			OAuthRefreshToken: entities.OAuthRefreshToken{
+				TokenHash: hashToken(refreshToken),
+				ClientID:  client.ClientID,
+				UserID:    user.ID,
+				SessionID: session.ID,
+				Scopes:    strings.Join(scopes, " "),
+				AuthTime:  authTime,
+				ExpiresAt: session.ExpiresAt,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		response.RefreshToken = refreshToken
+	}
+	return response, nil
+}
+
+// AuthenticateClientToken resolves the claims of a client_credentials token to its service account.
+// The token stops working as soon as its client is deleted or rebound.
+func (oas *oauthService) AuthenticateClientToken(claims jwt.MapClaims) (*Principal, error) {
+	clientID, _ := claims["client_id"].(string)
+	userID, ok := claims["id"].(float64)
+	if clientID == "" || !ok {
+		return nil, ErrInvalidToken
+	}
+
+	client, err := oas.oauthClientService.GetClient(clientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if client.ServiceAccountID == nil || *client.ServiceAccountID != uint(userID) {
+		return nil, ErrInvalidToken
+	}
+
+	account, err := oas.userRepository.GetByID(*client.ServiceAccountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{
+		User:     account.User,
+		ClientID: clientID,
+		Claims:   claims,
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = parseScopes(scope)
+	}
+	return principal, nil
+}
+
+func (oas *oauthService) tokenEndpoint() string {
+	return tokenEndpoint(oas.config.Issuer)
+}
+
+func (oas *oauthService) roleNames(userID uint) ([]string, error) {
+	return roleNamesForUser(oas.roleRepository, userID)
+}
+
+// tokenEndpoint is the URL of the token endpoint, the audience of client assertions for every endpoint
+// clients authenticate at
+func tokenEndpoint(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + "/oauth/token"
+}
+
+func roleNamesForUser(roleRepository postgres.RoleRepository, userID uint) ([]string, error) {
+	roles, err := roleRepository.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+	return roleNames, nil
+}
+
+// storedScopes reads scopes saved by grantScopes: an empty list means the grant is not scope-limited
+func storedScopes(scopes string) []string {
+	if parsed := parseScopes(scopes); len(parsed) > 0 {
+		return parsed
+	}
+	return nil
+}
+
+// grantScopes resolves the scopes of a token. Without a request the client's registered scopes
+// are granted; a request must stay within them. nil means the token is not scope-limited.
+func grantScopes(client *entities.OAuthClient, requestedScope string) ([]string, error) {
+	allowed := parseScopes(client.Scopes)
+	requested := parseScopes(requestedScope)
+
+	if len(requested) == 0 {
+		if len(allowed) == 0 {
+			return nil, nil
+		}
+		return allowed, nil
+	}
+	if len(allowed) > 0 {
+		for _, scope := range requested {
+			if !hasScope(allowed, scope) {
+				return nil, errInvalidScope("the client may not request " + scope)
+			}
+		}
+	}
+	return requested, nil
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+// authenticatedClientService accepts any credentials as those of one client
+type authenticatedClientService struct {
+	OAuthClientService
+	client *entities.OAuthClient
+}
+
+func (cs *authenticatedClientService) AuthenticateClient(credentials ClientCredentials, audience string) (*entities.OAuthClient, error) {
+	return cs.client, nil
+}
+
+// refusingSessionService fails the creation of any session, which a grant refused earlier never asks for
+type refusingSessionService struct {
+	SessionService
+}
+
+func (ss *refusingSessionService) CreateForClient(userID uint, clientID string, client ClientInfo, ttl time.Duration) (*entities.Session, error) {
+	return nil, errors.New("a session was created")
+}
+
+func TestAuthorizationCodeGrantRejectsDeactivatedUser(t *testing.T) {
+	deactivatedAt := time.Now().Add(-time.Minute)
+	users := newMemoryUserRepository(entities.User{ID: 5, Username: "dave", DeactivatedAt: &deactivatedAt})
+	verifier := strings.Repeat("v", 43)
+	challenge := sha256.Sum256([]byte(verifier))
+	codes := &memoryAuthorizationCodeRepository{}
+	_, _ = codes.Create(&postgres.PostgresOAuthAuthorizationCode{OAuthAuthorizationCode: entities.OAuthAuthorizationCode{
+		CodeHash:      hashToken("code"),
+		ClientID:      "app",
+		UserID:        5,
+		RedirectURI:   "https://app.example.org/callback",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(challenge[:]),
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}})
+	clients := &authenticatedClientService{client: &entities.OAuthClient{
+		ClientID:   "app",
+		GrantTypes: entities.GrantTypeAuthorizationCode,
+	}}
+	service := NewOAuthService(clients, &refusingSessionService{}, nil, users, nil, nil, codes, nil, nil, nil, nil, nil, OAuthConfig{})
+
+	_, err := service.Token(&TokenRequest{
+		GrantType:    entities.GrantTypeAuthorizationCode,
+		Code:         "code",
+		RedirectURI:  "https://app.example.org/callback",
+		CodeVerifier: verifier,
+	})
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("Token error = %v, want invalid_grant", err)
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// TokenTypeAccessToken identifies access tokens in the token exchange grant (RFC 8693 section 3)
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// tokenExchangeGrant trades a user's access token for a token only meant for one service, with at
+// most the scopes of the original (RFC 8693). The client must be allowed to exchange tokens for the
+// audience. The new token is bound to the user's session, so it ends with it, and its act claim
+// names the client, nesting any act claim of the original when a delegated token is exchanged again.
+func (oas *oauthService) tokenExchangeGrant(client *entities.OAuthClient, request *TokenRequest) (*TokenResponse, error) {
+	if request.SubjectToken == "" || request.SubjectTokenType == "" {
+		return nil, errInvalidRequest("subject_token and subject_token_type are required")
+	}
+	if request.SubjectTokenType != TokenTypeAccessToken {
+		return nil, errInvalidRequest("subject_token_type must be " + TokenTypeAccessToken)
+	}
+	if request.RequestedTokenType != "" && request.RequestedTokenType != TokenTypeAccessToken {
+		return nil, errInvalidRequest("only access tokens can be requested")
+	}
+	if request.ActorToken != "" {
+		return nil, errInvalidRequest("actor_token is not supported; the authenticated client is the actor")
+	}
+	// Each exchanged token is meant for exactly one service
+	if len(request.Audiences) != 1 || request.Audiences[0] == "" {
+		return nil, errInvalidRequest("exactly one audience is required")
+	}
+	audience := request.Audiences[0]
+	if !hasScope(parseScopes(client.TokenExchangeAudiences), audience) {
+		return nil, errInvalidTarget("the client may not exchange tokens for " + audience)
+	}
+
+	subject, err := oas.authenticateSubjectToken(request.SubjectToken)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, err := grantScopes(client, request.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if subject.Scopes != nil {
+		switch {
+		case scopes == nil:
+			scopes = subject.Scopes
+		case request.Scope == "":
+			// Without a request the token gets what both the client and the subject token allow
+			var common []string
+			for _, scope := range scopes {
+				if hasScope(subject.Scopes, scope) {
+					common = append(common, scope)
+				}
+			}
+			if len(common) == 0 {
+				return nil, errInvalidScope("the subject token has none of the client's scopes")
+			}
+			scopes = common
+		default:
+			for _, scope := range scopes {
+				if !hasScope(subject.Scopes, scope) {
+					return nil, errInvalidScope("the subject token does not include " + scope)
+				}
+			}
+		}
+	}
+	// An exchanged token is always narrower than a session, never unlimited
+	if scopes == nil {
+		return nil, errInvalidScope("scope is required")
+	}
+
+	roleNames, err := oas.roleNames(subject.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(oas.config.TokenExchangeTTL)
+	if subject.ExpiresAt != nil && expiresAt.After(*subject.ExpiresAt) {
+		expiresAt = *subject.ExpiresAt
+	}
+	actor := map[string]interface{}{"client_id": client.ClientID}
+	if previousActor, ok := subject.Claims["act"]; ok {
+		actor["act"] = previousActor
+	}
+
+	accessToken, err := oas.tokenService.IssueDelegatedToken(&subject.User, roleNames, subject.SessionID, client.ClientID, audience, actor, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(expiresAt).Seconds()),
+		Scope:           strings.Join(scopes, " "),
+	}, nil
+}
+
+// authenticateSubjectToken validates the user token presented for exchange like any protected
+// endpoint would. Only tokens backed by a user's session can be exchanged; API keys and
+// client_credentials tokens already belong to a service.
+func (oas *oauthService) authenticateSubjectToken(subjectToken string) (*Principal, error) {
+	claims, err := oas.tokenService.Parse(subjectToken)
+	if errors.Is(err, ErrInvalidToken) {
+		return nil, errInvalidGrant("invalid subject token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := tokenRevoked(oas.revokedTokenRepository, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errInvalidGrant("invalid subject token")
+	}
+	if sessionID, _ := claims["sid"].(string); sessionID == "" {
+		return nil, errInvalidGrant("the subject token does not belong to a user session")
+	}
+
+	principal, err := oas.sessionService.Authenticate(claims)
+	if errors.Is(err, ErrInvalidSession) {
+		return nil, errInvalidGrant("the session of the subject token has ended")
+	}
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Unix(int64(claims["exp"].(float64)), 0)
+	principal.ExpiresAt = &expiresAt
+	return principal, nil
+}
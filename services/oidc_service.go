@@ -0,0 +1,269 @@
+package services
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+// OpenID Connect scopes. They select identity claims rather than permissions.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+	// ScopeRoles adds the user's role names as the "roles" claim, for tools mapping roles to their own
+	ScopeRoles = "roles"
+)
+
+var oidcScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeRoles}
+
+var (
+	// ErrInsufficientScope is returned when a token was not granted the openid scope
+	ErrInsufficientScope = errors.New("the token does not grant access to user info")
+	// ErrInvalidLogoutRequest is returned for a logout request with a missing or invalid id_token_hint
+	// or an unregistered post_logout_redirect_uri
+	ErrInvalidLogoutRequest = errors.New("invalid logout request")
+)
+
+// OIDCConfig configures the OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer is the public base URL of this service, the iss claim of ID tokens
+	Issuer     string
+	SigningKey *rsa.PrivateKey
+	IDTokenTTL time.Duration
+}
+
+// LogoutRequest is an RP-initiated logout request (OpenID Connect RP-Initiated Logout 1.0)
+type LogoutRequest struct {
+	IDTokenHint           string
+	PostLogoutRedirectURI string
+	State                 string
+}
+
+type OIDCService interface {
+	Discovery() map[string]any
+	JWKS() map[string]any
+	IssueIDToken(user *entities.User, roleNames []string, clientID, sessionID string, scopes []string, authTime time.Time, nonce string) (string, error)
+	UserInfo(principal *Principal) (map[string]any, error)
+	EndSession(request *LogoutRequest, browserSession *Principal) (string, bool, error)
+}
+
+type oidcService struct {
+	userService        UserService
+	oauthClientService OAuthClientService
+	sessionRepository  postgres.SessionRepository
+	config             OIDCConfig
+	keyID              string
+}
+
+func NewOIDCService(
+	userService UserService,
+	oauthClientService OAuthClientService,
+	sessionRepository postgres.SessionRepository,
+	config OIDCConfig,
+) OIDCService {
+	return &oidcService{
+		userService:        userService,
+		oauthClientService: oauthClientService,
+		sessionRepository:  sessionRepository,
+		config:             config,
+		keyID:              keyID(&config.SigningKey.PublicKey),
+	}
+}
+
+// Discovery returns the provider metadata served at /.well-known/openid-configuration
+func (ois *oidcService) Discovery() map[string]any {
+	issuer := strings.TrimSuffix(ois.config.Issuer, "/")
+	return map[string]any{
+		"issuer":                                         issuer,
+		"authorization_endpoint":                         issuer + "/oauth/authorize",
+		"token_endpoint":                                 issuer + "/oauth/token",
+		"userinfo_endpoint":                              issuer + "/userinfo",
+		"jwks_uri":                                       issuer + "/.well-known/jwks.json",
+		"end_session_endpoint":                           issuer + "/oauth/logout",
+		"response_types_supported":                       []string{"code"},
+		"response_modes_supported":                       []string{"query"},
+		"grant_types_supported":                          []string{entities.GrantTypeAuthorizationCode, entities.GrantTypeRefreshToken, entities.GrantTypeClientCredentials, entities.GrantTypeDeviceCode, entities.GrantTypeTokenExchange},
+		"subject_types_supported":                        []string{"public"},
+		"id_token_signing_alg_values_supported":          []string{jwt.SigningMethodRS256.Alg()},
+		"scopes_supported":                               oidcScopes,
+		"token_endpoint_auth_methods_supported":          []string{entities.ClientAuthMethodSecretBasic, entities.ClientAuthMethodSecretPost, entities.ClientAuthMethodPrivateKeyJWT, entities.ClientAuthMethodNone},
+		"code_challenge_methods_supported":               []string{CodeChallengeMethodS256},
+		"claims_supported":                               []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "sid", "email", "email_verified", "preferred_username", "roles"},
+		"authorization_response_iss_parameter_supported": true,
+	}
+}
+
+// JWKS returns the public key verifying ID tokens as a JSON Web Key Set
+func (ois *oidcService) JWKS() map[string]any {
+	publicKey := ois.config.SigningKey.PublicKey
+	return map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": jwt.SigningMethodRS256.Alg(),
+			"kid": ois.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}},
+	}
+}
+
+// IssueIDToken signs an ID token for a client (OpenID Connect Core section 2). The identity claims
+// included depend on the granted scopes.
+func (ois *oidcService) IssueIDToken(user *entities.User, roleNames []string, clientID, sessionID string, scopes []string, authTime time.Time, nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":       strings.TrimSuffix(ois.config.Issuer, "/"),
+		"sub":       strconv.FormatUint(uint64(user.ID), 10),
+		"aud":       clientID,
+		"exp":       now.Add(ois.config.IDTokenTTL).Unix(),
+		"iat":       now.Unix(),
+		"auth_time": authTime.Unix(),
+		"sid":       sessionID,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for name, value := range identityClaims(user, roleNames, scopes) {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ois.keyID
+	return token.SignedString(ois.config.SigningKey)
+}
+
+// UserInfo returns the claims about the caller its token's scopes allow (OpenID Connect Core section 5.3)
+func (ois *oidcService) UserInfo(principal *Principal) (map[string]any, error) {
+	if principal.Scopes != nil && !hasScope(principal.Scopes, ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+
+	user, err := ois.userService.GetUserByID(principal.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]any{
+		"sub": strconv.FormatUint(uint64(user.ID), 10),
+	}
+	scopes := principal.Scopes
+	if scopes == nil {
+		scopes = oidcScopes
+	}
+	roleNames := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roleNames = append(roleNames, role.Name)
+	}
+	for name, value := range identityClaims(user, roleNames, scopes) {
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+// EndSession handles RP-initiated logout. The id_token_hint identifies the user and the client:
+// the client's session ends, as does browserSession when it belongs to the same user. It returns
+// the URL to send the user to, if the client asked for one, and whether browserSession ended.
+func (ois *oidcService) EndSession(request *LogoutRequest, browserSession *Principal) (string, bool, error) {
+	if request.IDTokenHint == "" {
+		return "", false, fmt.Errorf("%w: id_token_hint is required", ErrInvalidLogoutRequest)
+	}
+	claims, err := ois.parseIDTokenHint(request.IDTokenHint)
+	if err != nil {
+		return "", false, err
+	}
+
+	clientID, _ := claims["aud"].(string)
+	subject, _ := claims["sub"].(string)
+	sessionID, _ := claims["sid"].(string)
+	userID, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: invalid id_token_hint", ErrInvalidLogoutRequest)
+	}
+
+	client, err := ois.oauthClientService.GetClient(clientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return "", false, fmt.Errorf("%w: unknown client", ErrInvalidLogoutRequest)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if request.PostLogoutRedirectURI != "" && !hasScope(parseScopes(client.PostLogoutRedirectURIs), request.PostLogoutRedirectURI) {
+		return "", false, fmt.Errorf("%w: post_logout_redirect_uri is not registered for the client", ErrInvalidLogoutRequest)
+	}
+
+	if sessionID != "" {
+		if _, err := ois.sessionRepository.Revoke(uint(userID), sessionID); err != nil {
+			return "", false, err
+		}
+	}
+	endedBrowserSession := false
+	if browserSession != nil && browserSession.User.ID == uint(userID) {
+		if _, err := ois.sessionRepository.Revoke(browserSession.User.ID, browserSession.SessionID); err != nil {
+			return "", false, err
+		}
+		endedBrowserSession = true
+	}
+
+	if request.PostLogoutRedirectURI == "" {
+		return "", endedBrowserSession, nil
+	}
+	redirectURL, err := url.Parse(request.PostLogoutRedirectURI)
+	if err != nil {
+		return "", endedBrowserSession, nil
+	}
+	if request.State != "" {
+		query := redirectURL.Query()
+		query.Set("state", request.State)
+		redirectURL.RawQuery = query.Encode()
+	}
+	return redirectURL.String(), endedBrowserSession, nil
+}
+
+// parseIDTokenHint verifies an ID token this provider issued. Expired tokens are accepted,
+// since relying parties commonly log out after the ID token has expired.
+func (ois *oidcService) parseIDTokenHint(idToken string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(idToken, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return &ois.config.SigningKey.PublicKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: invalid id_token_hint", ErrInvalidLogoutRequest)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyIssuer(strings.TrimSuffix(ois.config.Issuer, "/"), true) {
+		return nil, fmt.Errorf("%w: id_token_hint was issued by another provider", ErrInvalidLogoutRequest)
+	}
+	return claims, nil
+}
+
+// identityClaims returns the standard claims about user released by scopes
+func identityClaims(user *entities.User, roleNames []string, scopes []string) map[string]any {
+	claims := map[string]any{}
+	if hasScope(scopes, ScopeProfile) {
+		claims["preferred_username"] = user.Username
+	}
+	if hasScope(scopes, ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+	if hasScope(scopes, ScopeRoles) {
+		claims["roles"] = roleNames
+	}
+	return claims
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+)
+
+// PasswordPolicyError lists every rule a candidate password breaks
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, "; ")
+}
+
+// PasswordPolicyConfig configures the rules passwords must satisfy
+type PasswordPolicyConfig struct {
+	MinLength        int
+	MaxLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// DisallowUserInfo rejects passwords containing the username or the local part of the email
+	DisallowUserInfo bool
+	// HistorySize is how many previous passwords cannot be reused; 0 disables the check
+	HistorySize int
+}
+
+type PasswordPolicyService interface {
+	Validate(user *entities.User, password string) error
+	Remember(userID uint, passwordHash string) error
+}
+
+type passwordPolicyService struct {
+	userRepository            postgres.UserRepository
+	passwordHistoryRepository postgres.PasswordHistoryRepository
+	breachedPasswordChecker   BreachedPasswordChecker
+	passwordHasher            PasswordHasher
+	config                    PasswordPolicyConfig
+}
+
+// NewPasswordPolicyService creates the policy engine. breachedPasswordChecker may be nil to skip breach screening.
+func NewPasswordPolicyService(
+	userRepository postgres.UserRepository,
+	passwordHistoryRepository postgres.PasswordHistoryRepository,
+	breachedPasswordChecker BreachedPasswordChecker,
+	passwordHasher PasswordHasher,
+	config PasswordPolicyConfig,
+) PasswordPolicyService {
+	return &passwordPolicyService{
+		userRepository:            userRepository,
+		passwordHistoryRepository: passwordHistoryRepository,
+		breachedPasswordChecker:   breachedPasswordChecker,
+		passwordHasher:            passwordHasher,
+		config:                    config,
+	}
+}
+
+// Validate checks password against every rule and returns a *PasswordPolicyError listing all violations.
+// Reuse is only checked for existing users, i.e. when user.ID is set.
+func (ps *passwordPolicyService) Validate(user *entities.User, password string) error {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if ps.config.MinLength > 0 && length < ps.config.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", ps.config.MinLength))
+	}
+	if ps.config.MaxLength > 0 && length > ps.config.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters long", ps.config.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if ps.config.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if ps.config.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if ps.config.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if ps.config.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if ps.config.DisallowUserInfo && containsUserInfo(user, password) {
+		violations = append(violations, "must not contain your username or email")
+	}
+
+	if ps.breachedPasswordChecker != nil {
+		breached, err := ps.breachedPasswordChecker.IsBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, "has appeared in a data breach, choose a different one")
+		}
+	}
+
+	// Reuse is checked last because comparing against hashes is the expensive part
+	if len(violations) == 0 && user.ID != 0 {
+		reused, err := ps.isReused(user.ID, password)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, "must not match a recently used password")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Remember records a newly set password hash so it cannot be reused later
+func (ps *passwordPolicyService) Remember(userID uint, passwordHash string) error {
+	if ps.config.HistorySize <= 0 {
+		return nil
+	}
+	return ps.passwordHistoryRepository.Add(userID, passwordHash, ps.config.HistorySize)
+}
+
+func (ps *passwordPolicyService) isReused(userID uint, password string) (bool, error) {
+	if ps.config.HistorySize <= 0 {
+		return false, nil
+	}
+
+	// The current password always counts, even for accounts created before history was kept
+	user, err := ps.userRepository.GetByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if ps.passwordHasher.Verify(user.Password, password) {
+		return true, nil
+	}
+
+	history, err := ps.passwordHistoryRepository.GetRecent(userID, ps.config.HistorySize)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range history {
+		if entry.PasswordHash == user.Password {
+			continue
+		}
+		if ps.passwordHasher.Verify(entry.PasswordHash, password) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// containsUserInfo reports whether password contains the username or email local part.
+// Very short values are ignored since they would match too many unrelated passwords.
+func containsUserInfo(user *entities.User, password string) bool {
+	lowered := strings.ToLower(password)
+
+	candidates := []string{user.Username}
+	if localPart, _, found := strings.Cut(user.Email, "@"); found {
+		candidates = append(candidates, localPart)
+	}
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(candidate)
+		if utf8.RuneCountInString(candidate) >= 3 && strings.Contains(lowered, candidate) {
+			return true
+		}
+	}
+	return false
+}
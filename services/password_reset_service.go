@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/notifiers"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidResetToken is returned when a reset token is unknown, expired or already used
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordResetConfig configures the password reset flow
+type PasswordResetConfig struct {
+	// TokenTTL is how long a reset token remains valid after it is issued
+	TokenTTL time.Duration
+	// ResetURL is the page the emailed link points to; the token is appended as a query parameter
+	ResetURL string
+}
+
+type PasswordResetService interface {
+	RequestPasswordReset(email string) error
+	ResetPassword(token, newPassword string) error
+}
+
+type passwordResetService struct {
+	userRepository        postgres.UserRepository
+	userTokenRepository   postgres.UserTokenRepository
+	passwordPolicyService PasswordPolicyService
+	passwordHasher        PasswordHasher
+	sessionService        SessionService
+	notifier              notifiers.Notifier
+	config                PasswordResetConfig
+}
+
+func NewPasswordResetService(
+	userRepository postgres.UserRepository,
+	userTokenRepository postgres.UserTokenRepository,
+	passwordPolicyService PasswordPolicyService,
+	passwordHasher PasswordHasher,
+	sessionService SessionService,
+	notifier notifiers.Notifier,
+	config PasswordResetConfig,
+) PasswordResetService {
+	return &passwordResetService{
+		userRepository:        userRepository,
+		userTokenRepository:   userTokenRepository,
+		passwordPolicyService: passwordPolicyService,
+		passwordHasher:        passwordHasher,
+		sessionService:        sessionService,
+		notifier:              notifier,
+		config:                config,
+	}
+}
+
+// RequestPasswordReset emails a reset link to the user owning email, without waiting for the email
+// to be sent. Unknown addresses are ignored so callers cannot tell which emails are registered.
+func (ps *passwordResetService) RequestPasswordReset(email string) error {
+	user, err := ps.userRepository.GetByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		return err
+	}
+
+	_, err = ps.userTokenRepository.Create(&postgres.PostgresUserToken{
+		UserToken: entities.UserToken{
+			UserID:    user.ID,
+			Purpose:   entities.TokenPurposePasswordReset,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(ps.config.TokenTTL),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	sendInBackground(ps.notifier, notifiers.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nWe received a request to reset your password. Use the link below to choose a new one:\n\n%s\n\nThe link expires in %s. If you did not request a reset, you can ignore this email.",
+			user.Username, buildTokenLink(ps.config.ResetURL, token), ps.config.TokenTTL,
+		),
+	})
+	return nil
+}
+
+func (ps *passwordResetService) ResetPassword(token, newPassword string) error {
+	resetToken, err := ps.userTokenRepository.GetByHash(entities.TokenPurposePasswordReset, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	// Validate before consuming the token so the user can retry with a better password
+	user, err := ps.userRepository.GetByID(resetToken.UserID)
+	if err != nil {
+		return err
+	}
+	if err := ps.passwordPolicyService.Validate(&user.User, newPassword); err != nil {
+		return err
+	}
+
+	// Consume the token before changing the password so it cannot be replayed concurrently
+	consumed, err := ps.userTokenRepository.MarkUsed(resetToken.ID)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidResetToken
+	}
+
+	passwordHash, err := ps.passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := ps.userRepository.UpdatePassword(resetToken.UserID, passwordHash); err != nil {
+		return err
+	}
+	if err := ps.passwordPolicyService.Remember(resetToken.UserID, passwordHash); err != nil {
+		log.Printf("Error recording password history for user %d: %v", resetToken.UserID, err)
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := ps.sessionService.RevokeAll(resetToken.UserID, ""); err != nil {
+		return err
+	}
+
+	// Any other outstanding reset links for this user are now stale
+	return ps.userTokenRepository.InvalidateForUser(resetToken.UserID, entities.TokenPurposePasswordReset)
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// ErrUnsupportedPasswordHash is returned when the configured hash algorithm is unknown
+var ErrUnsupportedPasswordHash = errors.New("unsupported password hash algorithm")
+
+// PasswordHashConfig selects the algorithm and parameters used for new password hashes.
+// Existing hashes in any supported format keep verifying; they are upgraded on next login.
+type PasswordHashConfig struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is the memory cost in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	Argon2SaltLength  uint32
+	Argon2KeyLength   uint32
+}
+
+// PasswordHasher hashes passwords into self-describing strings: bcrypt's "$2a$<cost>$..."
+// or the PHC format "$argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>".
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(passwordHash, password string) bool
+	// NeedsRehash reports whether passwordHash was made with another algorithm or other parameters
+	NeedsRehash(passwordHash string) bool
+}
+
+type passwordHasher struct {
+	config PasswordHashConfig
+}
+
+func NewPasswordHasher(config PasswordHashConfig) (PasswordHasher, error) {
+	switch config.Algorithm {
+	case PasswordHashArgon2id, PasswordHashBcrypt:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedPasswordHash, config.Algorithm)
+	}
+	return &passwordHasher{config: config}, nil
+}
+
+func (ph *passwordHasher) Hash(password string) (string, error) {
+	if ph.config.Algorithm == PasswordHashBcrypt {
+		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), ph.config.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(passwordHash), nil
+	}
+
+	params := argon2Params{
+		memory:      ph.config.Argon2Memory,
+		iterations:  ph.config.Argon2Iterations,
+		parallelism: ph.config.Argon2Parallelism,
+	}
+	salt := make([]byte, ph.config.Argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, ph.config.Argon2KeyLength)
+	return params.encode(salt, key), nil
+}
+
+func (ph *passwordHasher) Verify(passwordHash, password string) bool {
+	if strings.HasPrefix(passwordHash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2Hash(passwordHash)
+		if err != nil {
+			return false
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(candidate, key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
+
+func (ph *passwordHasher) NeedsRehash(passwordHash string) bool {
+	if ph.config.Algorithm == PasswordHashBcrypt {
+		cost, err := bcrypt.Cost([]byte(passwordHash))
+		return err != nil || cost != ph.config.BcryptCost
+	}
+
+	params, salt, key, err := decodeArgon2Hash(passwordHash)
+	if err != nil {
+		return true
+	}
+	return params.memory != ph.config.Argon2Memory ||
+		params.iterations != ph.config.Argon2Iterations ||
+		params.parallelism != ph.config.Argon2Parallelism ||
+		uint32(len(salt)) != ph.config.Argon2SaltLength ||
+		uint32(len(key)) != ph.config.Argon2KeyLength
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2Hash(passwordHash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if len(key) == 0 {
+		return params, nil, nil, errors.New("malformed argon2id hash")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	User entities.User
+	// SessionID is set when the caller presented a session token
+	SessionID string
+	// AuthTime is when the user last authenticated in the session, at login or by stepping up
+	AuthTime time.Time
+	// AuthMethods are the methods of that authentication, e.g. "pwd", "otp" and "mfa"
+	AuthMethods []string
+	// APIKeyID is set when the caller presented an API key
+	APIKeyID uint
+	// ClientID is set when the caller presented a token obtained by an OAuth client
+	ClientID string
+	// Scopes limits the caller to these "resource:action" permissions; nil means no limit
+	// beyond the user's own permissions
+	Scopes []string
+	// ImpersonatorID is set when an administrator is acting as User
+	ImpersonatorID uint
+	// Audience is set for exchanged tokens only meant for one service
+	Audience string
+	// ExpiresAt is when the credential expires; nil when it does not
+	ExpiresAt *time.Time
+	Claims    jwt.MapClaims
+}
+
+// Allows reports whether the credential the caller used permits resource and action.
+// The user must still hold the permission themselves.
+func (p *Principal) Allows(resource, action string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	return hasScope(p.Scopes, resource+":"+action)
+}
+
+// AuthenticatedWithMFAWithin reports whether the user passed a second factor within maxAge
+func (p *Principal) AuthenticatedWithMFAWithin(maxAge time.Duration) bool {
+	return hasScope(p.AuthMethods, AuthMethodMFA) && time.Since(p.AuthTime) <= maxAge
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, candidate := range scopes {
+		if candidate == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes splits a space-separated scope list, dropping duplicates
+func parseScopes(scopes string) []string {
+	parsed := []string{}
+	for _, scope := range strings.Fields(scopes) {
+		if !hasScope(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	return parsed
+}
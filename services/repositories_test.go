@@ -0,0 +1,441 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// In-memory repositories for service tests. They embed the repository interface, so calling a
+// method a test does not expect panics instead of silently succeeding.
+
+type memoryUserRepository struct {
+	postgres.UserRepository
+	mu     sync.Mutex
+	users  map[uint]*postgres.PostgresUser
+	nextID uint
+	// roles receives the roles of users created with roles
+	roles *memoryRoleRepository
+}
+
+func newMemoryUserRepository(users ...entities.User) *memoryUserRepository {
+	r := &memoryUserRepository{users: make(map[uint]*postgres.PostgresUser)}
+	for _, user := range users {
+		_, _ = r.Create(&postgres.PostgresUser{User: user})
+	}
+	return r
+}
+
+func (r *memoryUserRepository) find(match func(user *entities.User) bool) (*postgres.PostgresUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if match(&user.User) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryUserRepository) GetByUsername(username string) (*postgres.PostgresUser, error) {
+	return r.find(func(user *entities.User) bool { return user.Username == username })
+}
+
+func (r *memoryUserRepository) GetByEmail(email string) (*postgres.PostgresUser, error) {
+	return r.find(func(user *entities.User) bool { return strings.EqualFold(user.Email, email) })
+}
+
+func (r *memoryUserRepository) GetByID(id uint) (*postgres.PostgresUser, error) {
+	return r.find(func(user *entities.User) bool { return user.ID == id })
+}
+
+func (r *memoryUserRepository) Create(user *postgres.PostgresUser) (*postgres.PostgresUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if user.ID == 0 {
+		r.nextID++
+		user.ID = r.nextID
+	} else if user.ID > r.nextID {
+		r.nextID = user.ID
+	}
+	stored := *user
+	r.users[user.ID] = &stored
+	return user, nil
+}
+
+func (r *memoryUserRepository) CreateWithRoles(user *postgres.PostgresUser, roleIDs []uint) (*postgres.PostgresUser, error) {
+	created, err := r.Create(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, roleID := range roleIDs {
+		if err := r.roles.AssignRoleToUser(created.ID, roleID); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+func (r *memoryUserRepository) Update(user *postgres.PostgresUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.users[user.ID] == nil {
+		return gorm.ErrRecordNotFound
+	}
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *memoryUserRepository) Find(query postgres.UserQuery) ([]*postgres.PostgresUser, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	matches := []*postgres.PostgresUser{}
+	for id := uint(1); id <= r.nextID; id++ {
+		user := r.users[id]
+		if user == nil || user.IsServiceAccount ||
+			(query.Username != "" && !strings.EqualFold(user.Username, query.Username)) ||
+			(query.Email != "" && !strings.EqualFold(user.Email, query.Email)) ||
+			(query.ExternalID != "" && user.ExternalID != query.ExternalID) {
+			continue
+		}
+		found := *user
+		matches = append(matches, &found)
+	}
+	total := int64(len(matches))
+	if query.Offset >= len(matches) {
+		return []*postgres.PostgresUser{}, total, nil
+	}
+	matches = matches[query.Offset:]
+	if len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+	return matches, total, nil
+}
+
+func (r *memoryUserRepository) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.users)
+}
+
+type memoryRoleRepository struct {
+	postgres.RoleRepository
+	mu        sync.Mutex
+	roles     []entities.Role
+	userRoles map[uint]map[uint]bool
+}
+
+func newMemoryRoleRepository(roles ...entities.Role) *memoryRoleRepository {
+	return &memoryRoleRepository{roles: roles, userRoles: make(map[uint]map[uint]bool)}
+}
+
+func (r *memoryRoleRepository) GetByName(name string) (*postgres.PostgresRole, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, role := range r.roles {
+		if role.Name == name {
+			return &postgres.PostgresRole{Role: role}, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryRoleRepository) GetRolesForUser(userID uint) ([]*postgres.PostgresRole, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	roles := []*postgres.PostgresRole{}
+	for _, role := range r.roles {
+		if r.userRoles[userID][role.ID] {
+			roles = append(roles, &postgres.PostgresRole{Role: role})
+		}
+	}
+	return roles, nil
+}
+
+func (r *memoryRoleRepository) AssignRoleToUser(userID, roleID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.userRoles[userID] == nil {
+		r.userRoles[userID] = make(map[uint]bool)
+	}
+	r.userRoles[userID][roleID] = true
+	return nil
+}
+
+func (r *memoryRoleRepository) RemoveRoleFromUser(userID, roleID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.userRoles[userID], roleID)
+	return nil
+}
+
+// roleNames returns the names of the roles of a user in the order the roles were created
+func (r *memoryRoleRepository) roleNames(userID uint) []string {
+	roles, _ := r.GetRolesForUser(userID)
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+type memoryFederationRepository struct {
+	postgres.FederationRepository
+	mu         sync.Mutex
+	states     []*postgres.PostgresFederationLoginState
+	identities []*postgres.PostgresFederatedIdentity
+}
+
+func (r *memoryFederationRepository) CreateLoginState(state *postgres.PostgresFederationLoginState) (*postgres.PostgresFederationLoginState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state.ID = uint(len(r.states) + 1)
+	r.states = append(r.states, state)
+	return state, nil
+}
+
+func (r *memoryFederationRepository) ConsumeLoginState(provider, stateHash string) (*postgres.PostgresFederationLoginState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, state := range r.states {
+		if state.Provider == provider && state.StateHash == stateHash && state.UsedAt == nil && state.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			state.UsedAt = &now
+			return state, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryFederationRepository) GetIdentity(provider, subject string) (*postgres.PostgresFederatedIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryFederationRepository) CreateIdentity(identity *postgres.PostgresFederatedIdentity) (*postgres.PostgresFederatedIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, identity)
+	return identity, nil
+}
+
+func (r *memoryFederationRepository) TouchIdentity(id uint, email string, loginAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.ID == id {
+			identity.Email = email
+			identity.LastLoginAt = loginAt
+		}
+	}
+	return nil
+}
+
+// memoryPermissionRepository holds the permissions of each user directly, without roles
+type memoryPermissionRepository struct {
+	postgres.PermissionRepository
+	mu          sync.Mutex
+	permissions []entities.Permission
+	userScopes  map[uint][]string
+}
+
+// newMemoryPermissionRepository creates the permissions named by resource:action scopes
+func newMemoryPermissionRepository(scopes ...string) *memoryPermissionRepository {
+	r := &memoryPermissionRepository{userScopes: make(map[uint][]string)}
+	for i, scope := range scopes {
+		resource, action, _ := strings.Cut(scope, ":")
+		r.permissions = append(r.permissions, entities.Permission{ID: uint(i + 1), Resource: resource, Action: action})
+	}
+	return r
+}
+
+// grant gives a user the permissions named by scopes
+func (r *memoryPermissionRepository) grant(userID uint, scopes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.userScopes[userID] = append(r.userScopes[userID], scopes...)
+}
+
+func (r *memoryPermissionRepository) GetByResourceAndAction(resource, action string) (*postgres.PostgresPermission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, permission := range r.permissions {
+		if permission.Resource == resource && permission.Action == action {
+			return &postgres.PostgresPermission{Permission: permission}, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryPermissionRepository) GetPermissionsForUser(userID uint) ([]*postgres.PostgresPermission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	permissions := []*postgres.PostgresPermission{}
+	for _, permission := range r.permissions {
+		for _, scope := range r.userScopes[userID] {
+			if scope == permission.Resource+":"+permission.Action {
+				permissions = append(permissions, &postgres.PostgresPermission{Permission: permission})
+				break
+			}
+		}
+	}
+	return permissions, nil
+}
+
+func (r *memoryPermissionRepository) CheckUserPermission(userID uint, resource, action string) (bool, error) {
+	permissions, err := r.GetPermissionsForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, permission := range permissions {
+		if permission.Resource == resource && permission.Action == action {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type memoryOAuthClientRepository struct {
+	postgres.OAuthClientRepository
+	mu      sync.Mutex
+	clients []*postgres.PostgresOAuthClient
+}
+
+func (r *memoryOAuthClientRepository) Create(client *postgres.PostgresOAuthClient) (*postgres.PostgresOAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients = append(r.clients, client)
+	return client, nil
+}
+
+type memoryUserTokenRepository struct {
+	postgres.UserTokenRepository
+	mu     sync.Mutex
+	tokens []*postgres.PostgresUserToken
+}
+
+func (r *memoryUserTokenRepository) Create(token *postgres.PostgresUserToken) (*postgres.PostgresUserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token.ID = uint(len(r.tokens) + 1)
+	token.CreatedAt = time.Now()
+	stored := *token
+	r.tokens = append(r.tokens, &stored)
+	return token, nil
+}
+
+func (r *memoryUserTokenRepository) GetByHash(purpose, tokenHash string) (*postgres.PostgresUserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.Purpose == purpose && token.TokenHash == tokenHash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryUserTokenRepository) MarkUsed(id uint) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.ID == id && token.UsedAt == nil {
+			now := time.Now()
+			token.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUserTokenRepository) CountSince(userID uint, purpose string, since time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.Purpose == purpose && !token.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// memoryMFARepository holds TOTP credentials only
+type memoryMFARepository struct {
+	postgres.MFARepository
+	mu          sync.Mutex
+	credentials map[uint]*postgres.PostgresTOTPCredential
+}
+
+func (r *memoryMFARepository) GetTOTPCredential(userID uint) (*postgres.PostgresTOTPCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *credential
+	return &found, nil
+}
+
+type memoryWebAuthnRepository struct {
+	postgres.WebAuthnRepository
+	credentials map[uint]int64
+}
+
+func (r *memoryWebAuthnRepository) CountCredentialsForUser(userID uint) (int64, error) {
+	return r.credentials[userID], nil
+}
+
+type memoryAuthorizationCodeRepository struct {
+	postgres.OAuthAuthorizationCodeRepository
+	mu    sync.Mutex
+	codes []*postgres.PostgresOAuthAuthorizationCode
+}
+
+func (r *memoryAuthorizationCodeRepository) Create(code *postgres.PostgresOAuthAuthorizationCode) (*postgres.PostgresOAuthAuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	code.ID = uint(len(r.codes) + 1)
+	stored := *code
+	r.codes = append(r.codes, &stored)
+	return code, nil
+}
+
+func (r *memoryAuthorizationCodeRepository) GetByHash(codeHash string) (*postgres.PostgresOAuthAuthorizationCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, code := range r.codes {
+		if code.CodeHash == codeHash {
+			found := *code
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryAuthorizationCodeRepository) MarkUsed(id uint, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, code := range r.codes {
+		if code.ID == id && code.UsedAt == nil {
+			code.UsedAt = &usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
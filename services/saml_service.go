@@ -0,0 +1,253 @@
+package services
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"github.com/vladimirteddy/go-authentication/saml"
+	"gorm.io/gorm"
+)
+
+// SAMLProviderConfig configures a SAML 2.0 identity provider users can log in with
+type SAMLProviderConfig struct {
+	// Name identifies the provider in URLs, e.g. "okta"
+	Name             string
+	IdentityProvider *saml.IdentityProvider
+	// NameIDFormat is requested from the provider; persistent when empty. Transient NameIDs are
+	// rejected since they cannot identify a returning user.
+	NameIDFormat string
+	// AutoProvision creates an account on the first login of an identity that matches no user
+	AutoProvision bool
+	// TrustEmail treats the email attribute as verified, which allows linking and provisioning.
+	// SAML has no equivalent of email_verified, so only set it for providers that own the addresses.
+	TrustEmail bool
+	// EmailAttribute and UsernameAttribute name the attributes holding the user's email and preferred
+	// username. An emailAddress NameID is used when the email attribute is missing.
+	EmailAttribute    string
+	UsernameAttribute string
+	// RoleAttribute is the attribute whose values are mapped to roles by AttributeRoles. On every
+	// login the user gets exactly the mapped roles of their values; roles that appear in no mapping
+	// are left untouched.
+	RoleAttribute  string
+	AttributeRoles map[string][]string
+}
+
+// SAMLConfig configures login through SAML identity providers
+type SAMLConfig struct {
+	Providers []SAMLProviderConfig
+	// BaseURL is the public base URL of this service. The entity ID of the service provider for
+	// provider {name} is BaseURL/auth/saml/{name}/metadata, its assertion consumer service
+	// BaseURL/auth/saml/{name}/acs.
+	BaseURL string
+	// SigningKey signs AuthnRequests; Certificate carries its public key in the metadata
+	SigningKey  *rsa.PrivateKey
+	Certificate *x509.Certificate
+	// StateTTL is how long the user has to log in at the provider
+	StateTTL time.Duration
+	// DefaultReturnURL is where users go after logging in when the login did not name a return URL
+	DefaultReturnURL string
+	// ReturnOrigins are the origins, besides BaseURL, return URLs may point to
+	ReturnOrigins []string
+}
+
+// SAMLService logs users in with SAML 2.0 identity providers, as a service provider
+type SAMLService interface {
+	Providers() []string
+	Metadata(providerName string) ([]byte, error)
+	BeginLogin(providerName, returnTo string) (*FederationLoginStart, error)
+	CompleteLogin(providerName, relayState, samlResponse string, client ClientInfo) (*FederatedLogin, error)
+}
+
+type samlProvider struct {
+	config          SAMLProviderConfig
+	serviceProvider *saml.ServiceProvider
+}
+
+type samlService struct {
+	userService          UserService
+	federationRepository postgres.FederationRepository
+	identities           *externalIdentities
+	providers            map[string]*samlProvider
+	config               SAMLConfig
+}
+
+func NewSAMLService(
+	userService UserService,
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	federationRepository postgres.FederationRepository,
+	replayCacheRepository postgres.ReplayCacheRepository,
+	signupConfig SignupConfig,
+	config SAMLConfig,
+) SAMLService {
+	providers := make(map[string]*samlProvider, len(config.Providers))
+	for _, providerConfig := range config.Providers {
+		endpoint := strings.TrimSuffix(config.BaseURL, "/") + "/auth/saml/" + url.PathEscape(providerConfig.Name)
+		providers[providerConfig.Name] = &samlProvider{
+			config: providerConfig,
+			serviceProvider: &saml.ServiceProvider{
+				EntityID:     endpoint + "/metadata",
+				ACSURL:       endpoint + "/acs",
+				Key:          config.SigningKey,
+				Certificate:  config.Certificate,
+				NameIDFormat: providerConfig.NameIDFormat,
+				ReplayCache:  samlReplayCache{repository: replayCacheRepository, source: samlSource(providerConfig.Name)},
+			},
+		}
+	}
+
+	return &samlService{
+		userService:          userService,
+		federationRepository: federationRepository,
+		identities: &externalIdentities{
+			userRepository:       userRepository,
+			roleRepository:       roleRepository,
+			federationRepository: federationRepository,
+			signupPolicy:         newSignupPolicy(roleRepository, signupConfig),
+		},
+		providers: providers,
+		config:    config,
+	}
+}
+
+// Providers lists the names of the configured providers
+func (ss *samlService) Providers() []string {
+	names := make([]string, 0, len(ss.providers))
+	for name := range ss.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Metadata returns the service provider metadata a provider is configured with
+func (ss *samlService) Metadata(providerName string) ([]byte, error) {
+	provider, ok := ss.providers[providerName]
+	if !ok {
+		return nil, ErrFederationProviderNotFound
+	}
+	return provider.serviceProvider.Metadata()
+}
+
+// BeginLogin starts a login at a provider with a signed AuthnRequest, to return to returnTo once
+// completed. The state travels as the RelayState.
+func (ss *samlService) BeginLogin(providerName, returnTo string) (*FederationLoginStart, error) {
+	provider, ok := ss.providers[providerName]
+	if !ok {
+		return nil, ErrFederationProviderNotFound
+	}
+	if returnTo == "" {
+		returnTo = ss.config.DefaultReturnURL
+	}
+	if !allowedReturnURL(returnTo, ss.config.BaseURL, ss.config.ReturnOrigins) {
+		return nil, ErrInvalidReturnURL
+	}
+
+	state, err := generateToken(24)
+	if err != nil {
+		return nil, err
+	}
+	requestID, err := saml.NewRequestID()
+	if err != nil {
+		return nil, err
+	}
+	authURL, err := provider.serviceProvider.AuthnRequestURL(provider.config.IdentityProvider, requestID, state)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(ss.config.StateTTL)
+	_, err = ss.federationRepository.CreateLoginState(&postgres.PostgresFederationLoginState{
+		FederationLoginState: entities.FederationLoginState{
+			Provider:  samlSource(providerName),
+			StateHash: hashToken(state),
+			Nonce:     requestID,
+			ReturnTo:  returnTo,
+			ExpiresAt: expiresAt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &FederationLoginStart{AuthURL: authURL, State: state, ExpiresAt: expiresAt}, nil
+}
+
+// CompleteLogin validates the response a provider posted to the assertion consumer service and logs
+// in the user the assertion is about, linking or provisioning an account on the first login
+func (ss *samlService) CompleteLogin(providerName, relayState, samlResponse string, client ClientInfo) (*FederatedLogin, error) {
+	provider, ok := ss.providers[providerName]
+	if !ok {
+		return nil, ErrFederationProviderNotFound
+	}
+	if relayState == "" || samlResponse == "" {
+		return nil, ErrInvalidFederationState
+	}
+
+	loginState, err := ss.federationRepository.ConsumeLoginState(samlSource(providerName), hashToken(relayState))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidFederationState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, err := provider.serviceProvider.ParseResponse(provider.config.IdentityProvider, samlResponse, loginState.Nonce, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	if assertion.NameIDFormat == saml.NameIDFormatTransient {
+		return nil, fmt.Errorf("%w: transient NameIDs cannot identify a user", ErrFederationFailed)
+	}
+
+	email := assertion.Attribute(provider.config.EmailAttribute)
+	if email == "" && assertion.NameIDFormat == saml.NameIDFormatEmailAddress {
+		email = assertion.NameID
+	}
+	userID, err := ss.identities.resolve(externalIdentity{
+		Source:        samlSource(providerName),
+		Subject:       assertion.NameID,
+		Email:         email,
+		EmailVerified: provider.config.TrustEmail,
+		Username:      assertion.Attribute(provider.config.UsernameAttribute),
+	}, provider.config.AutoProvision)
+	if err != nil {
+		return nil, err
+	}
+	if provider.config.RoleAttribute != "" {
+		values := assertion.Attributes[provider.config.RoleAttribute]
+		if err := ss.identities.syncRoles("SAML provider "+providerName, userID, values, provider.config.AttributeRoles); err != nil {
+			return nil, err
+		}
+	}
+
+	// The provider is responsible for the strength of the login, including any second factor
+	token, err := ss.userService.IssueToken(userID, []string{AuthMethodFederated}, client)
+	if err != nil {
+		return nil, err
+	}
+	return &FederatedLogin{Token: token, ReturnTo: loginState.ReturnTo}, nil
+}
+
+// samlSource is the name identities and pending logins of a SAML provider are stored under, kept
+// apart from OpenID Connect providers of the same name
+func samlSource(providerName string) string {
+	return "saml:" + providerName
+}
+
+// samlReplayCache keeps the assertion IDs of a provider in the shared replay cache
+type samlReplayCache struct {
+	repository postgres.ReplayCacheRepository
+	source     string
+}
+
+func (c samlReplayCache) Remember(assertionID string, expiresAt time.Time) (bool, error) {
+	return c.repository.Remember(c.source+":"+hashToken(assertionID), expiresAt)
+}
@@ -0,0 +1,932 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"github.com/vladimirteddy/go-authentication/scim"
+	"gorm.io/gorm"
+)
+
+// SCIMConfig configures provisioning through SCIM
+type SCIMConfig struct {
+	// BaseURL is the public base URL of this service; resources live under BaseURL/scim/v2
+	BaseURL string
+	// TrustEmail marks the emails of provisioned users verified, so they can log in under a blocking
+	// verification policy and be linked to federated identities
+	TrustEmail bool
+	// MaxResults caps the number of resources a query returns
+	MaxResults int
+}
+
+// SCIMService provisions users and groups for an identity provider. Groups are roles: the members
+// of a group are the users holding the role. Service accounts are neither listed as users nor as
+// members, and provisioning never takes roles away from them.
+//
+// Errors the provider should see are *scim.Error; version is the If-Match precondition of a request,
+// "" when there is none.
+type SCIMService interface {
+	MaxResults() int
+	GetUser(id string) (*scim.User, error)
+	ListUsers(filter string, startIndex, count int) (*scim.ListResponse, error)
+	CreateUser(user *scim.User) (*scim.User, error)
+	ReplaceUser(id, version string, user *scim.User) (*scim.User, error)
+	PatchUser(id, version string, patch *scim.PatchRequest) (*scim.User, error)
+	DeleteUser(id, version string) error
+	GetGroup(id string) (*scim.Group, error)
+	ListGroups(filter string, startIndex, count int) (*scim.ListResponse, error)
+	CreateGroup(group *scim.Group) (*scim.Group, error)
+	ReplaceGroup(id, version string, group *scim.Group) (*scim.Group, error)
+	PatchGroup(id, version string, patch *scim.PatchRequest) (*scim.Group, error)
+	DeleteGroup(id, version string) error
+}
+
+type scimService struct {
+	userRepository        postgres.UserRepository
+	roleRepository        postgres.RoleRepository
+	passwordPolicyService PasswordPolicyService
+	passwordHasher        PasswordHasher
+	sessionService        SessionService
+	signupPolicy          *signupPolicy
+	config                SCIMConfig
+}
+
+func NewSCIMService(
+	userRepository postgres.UserRepository,
+	roleRepository postgres.RoleRepository,
+	passwordPolicyService PasswordPolicyService,
+	passwordHasher PasswordHasher,
+	sessionService SessionService,
+	signupConfig SignupConfig,
+	config SCIMConfig,
+) SCIMService {
+	return &scimService{
+		userRepository:        userRepository,
+		roleRepository:        roleRepository,
+		passwordPolicyService: passwordPolicyService,
+		passwordHasher:        passwordHasher,
+		sessionService:        sessionService,
+		signupPolicy:          newSignupPolicy(roleRepository, signupConfig),
+		config:                config,
+	}
+}
+
+var (
+	errSCIMUserNotFound  = scim.NewError(http.StatusNotFound, "", "user not found")
+	errSCIMGroupNotFound = scim.NewError(http.StatusNotFound, "", "group not found")
+	errSCIMVersion       = scim.NewError(http.StatusPreconditionFailed, "", "the resource was modified")
+)
+
+func (ss *scimService) MaxResults() int {
+	return ss.config.MaxResults
+}
+
+func (ss *scimService) GetUser(id string) (*scim.User, error) {
+	user, err := ss.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	return ss.userResource(user), nil
+}
+
+// ListUsers returns a page of the users matching filter, which may compare userName, externalId,
+// emails and id
+func (ss *scimService) ListUsers(filter string, startIndex, count int) (*scim.ListResponse, error) {
+	startIndex, count = ss.page(startIndex, count)
+
+	var query postgres.UserQuery
+	if filter != "" {
+		parsed, err := scim.ParseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, comparison := range parsed {
+			switch comparison.Attribute {
+			case "username":
+				query.Username = comparison.Value
+			case "externalid":
+				query.ExternalID = comparison.Value
+			case "emails", "emails.value":
+				query.Email = comparison.Value
+			case "id":
+				return ss.listUserByID(comparison.Value, parsed, startIndex, count)
+			default:
+				return nil, scim.BadRequest(scim.ErrorInvalidFilter, "filtering users by %q is not supported", comparison.Attribute)
+			}
+		}
+	}
+
+	query.Offset = startIndex - 1
+	query.Limit = count
+	users, total, err := ss.userRepository.Find(query)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]any, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, ss.userResource(user))
+	}
+	return scim.NewListResponse(resources, total, startIndex), nil
+}
+
+// listUserByID answers a filter on id, which matches at most one user
+func (ss *scimService) listUserByID(id string, filter scim.Filter, startIndex, count int) (*scim.ListResponse, error) {
+	user, err := ss.getUser(id)
+	if errors.Is(err, errSCIMUserNotFound) {
+		return scim.NewListResponse(nil, 0, startIndex), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resource := ss.userResource(user)
+	for _, comparison := range filter {
+		var matches bool
+		switch comparison.Attribute {
+		case "id":
+			matches = comparison.Value == resource.ID
+		case "username":
+			matches = strings.EqualFold(comparison.Value, resource.UserName)
+		case "externalid":
+			matches = comparison.Value == resource.ExternalID
+		case "emails", "emails.value":
+			matches = strings.EqualFold(comparison.Value, resource.PrimaryEmail())
+		default:
+			return nil, scim.BadRequest(scim.ErrorInvalidFilter, "filtering users by %q is not supported", comparison.Attribute)
+		}
+		if !matches {
+			return scim.NewListResponse(nil, 0, startIndex), nil
+		}
+	}
+	if startIndex > 1 || count == 0 {
+		return scim.NewListResponse(nil, 1, startIndex), nil
+	}
+	return scim.NewListResponse([]any{resource}, 1, startIndex), nil
+}
+
+// CreateUser provisions a user, with the default roles and the signup hooks of a self-service
+// signup; a hook vetoing it is a 403. Without a password the user can only log in through an
+// identity provider until they set one with a password reset.
+func (ss *scimService) CreateUser(resource *scim.User) (*scim.User, error) {
+	user := &postgres.PostgresUser{}
+	if err := ss.applyUser(user, resource); err != nil {
+		return nil, err
+	}
+
+	signup, roles, err := ss.signupPolicy.begin(user.Username, user.Email)
+	var rejectedErr *SignupRejectedError
+	if errors.As(err, &rejectedErr) {
+		return nil, scim.NewError(http.StatusForbidden, "", rejectedErr.Reason)
+	}
+	if errors.Is(err, ErrInvalidEmail) {
+		return nil, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Tenant = signup.Tenant
+
+	passwordHash := user.Password
+	created, err := ss.userRepository.CreateWithRoles(user, roleIDs(roles))
+	if err != nil {
+		return nil, err
+	}
+	if passwordHash != "" {
+		if err := ss.passwordPolicyService.Remember(created.ID, passwordHash); err != nil {
+			return nil, err
+		}
+	}
+	ss.signupPolicy.finish(&entities.User{
+		ID:       created.ID,
+		Username: created.Username,
+		Email:    created.Email,
+		Tenant:   created.Tenant,
+		Roles:    roles,
+	}, signup)
+	return ss.userResource(created), nil
+}
+
+func (ss *scimService) ReplaceUser(id, version string, resource *scim.User) (*scim.User, error) {
+	user, err := ss.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(ss.userResource(user).Meta.Version, version); err != nil {
+		return nil, err
+	}
+	return ss.updateUser(user, resource)
+}
+
+// PatchUser applies a PATCH request to a user. Attributes this service does not store, like name
+// or the enterprise extension, are ignored.
+func (ss *scimService) PatchUser(id, version string, patch *scim.PatchRequest) (*scim.User, error) {
+	user, err := ss.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	resource := ss.userResource(user)
+	if err := checkSCIMVersion(resource.Meta.Version, version); err != nil {
+		return nil, err
+	}
+
+	for _, operation := range patch.Operations {
+		err := applySCIMOperation(operation, func(op string, path *scim.Path, value json.RawMessage) error {
+			return patchUserAttribute(resource, op, path, value)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ss.updateUser(user, resource)
+}
+
+// DeleteUser deletes a user along with their sessions, credentials and role assignments
+func (ss *scimService) DeleteUser(id, version string) error {
+	user, err := ss.getUser(id)
+	if err != nil {
+		return err
+	}
+	if err := checkSCIMVersion(ss.userResource(user).Meta.Version, version); err != nil {
+		return err
+	}
+	return ss.userRepository.Delete(user.ID)
+}
+
+// updateUser stores the attributes of resource on user. Deactivating a user, or setting their
+// password, ends every session of the user.
+func (ss *scimService) updateUser(user *postgres.PostgresUser, resource *scim.User) (*scim.User, error) {
+	wasActive := user.DeactivatedAt == nil
+	previousPassword := user.Password
+	if err := ss.applyUser(user, resource); err != nil {
+		return nil, err
+	}
+
+	if err := ss.userRepository.Update(user); err != nil {
+		return nil, err
+	}
+	passwordChanged := user.Password != previousPassword
+	if passwordChanged {
+		if err := ss.passwordPolicyService.Remember(user.ID, user.Password); err != nil {
+			return nil, err
+		}
+	}
+	if (wasActive && user.DeactivatedAt != nil) || passwordChanged {
+		if err := ss.sessionService.RevokeAll(user.ID, ""); err != nil {
+			return nil, err
+		}
+	}
+	return ss.userResource(user), nil
+}
+
+// applyUser validates the attributes of a resource and sets them on user, hashing a new password
+func (ss *scimService) applyUser(user *postgres.PostgresUser, resource *scim.User) error {
+	username := strings.TrimSpace(resource.UserName)
+	email := strings.ToLower(strings.TrimSpace(resource.PrimaryEmail()))
+	if username == "" {
+		return scim.BadRequest(scim.ErrorInvalidValue, "userName is required")
+	}
+	if email == "" {
+		return scim.BadRequest(scim.ErrorInvalidValue, "an email is required")
+	}
+
+	if !strings.EqualFold(username, user.Username) {
+		if err := ss.checkUserUnique(postgres.UserQuery{Username: username}, user.ID, "userName"); err != nil {
+			return err
+		}
+	}
+	if email != strings.ToLower(user.Email) {
+		if err := ss.checkUserUnique(postgres.UserQuery{Email: email}, user.ID, "email"); err != nil {
+			return err
+		}
+		user.Email = email
+		user.EmailVerified = ss.config.TrustEmail
+		user.EmailVerifiedAt = nil
+		if ss.config.TrustEmail {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+	}
+	user.Username = username
+	user.ExternalID = resource.ExternalID
+
+	switch {
+	case resource.IsActive():
+		user.DeactivatedAt = nil
+	case user.DeactivatedAt == nil:
+		now := time.Now()
+		user.DeactivatedAt = &now
+	}
+
+	if resource.Password != "" {
+		if err := ss.passwordPolicyService.Validate(&user.User, resource.Password); err != nil {
+			var policyErr *PasswordPolicyError
+			if errors.As(err, &policyErr) {
+				return scim.BadRequest(scim.ErrorInvalidValue, "%s", policyErr.Error())
+			}
+			return err
+		}
+		passwordHash, err := ss.passwordHasher.Hash(resource.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = passwordHash
+	}
+	return nil
+}
+
+// checkUserUnique fails with a uniqueness error when another user, service accounts included,
+// matches query
+func (ss *scimService) checkUserUnique(query postgres.UserQuery, userID uint, attribute string) error {
+	var existing *postgres.PostgresUser
+	var err error
+	if query.Username != "" {
+		existing, err = ss.userRepository.GetByUsername(query.Username)
+	} else {
+		existing, err = ss.userRepository.GetByEmail(query.Email)
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil && existing.ID != userID {
+		return scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "a user with this "+attribute+" already exists")
+	}
+
+	// Usernames and emails are compared case-insensitively, like identity providers do
+	query.Limit = 2
+	users, _, err := ss.userRepository.Find(query)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.ID != userID {
+			return scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "a user with this "+attribute+" already exists")
+		}
+	}
+	return nil
+}
+
+// getUser loads the user with a SCIM ID; service accounts are not found
+func (ss *scimService) getUser(id string) (*postgres.PostgresUser, error) {
+	userID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, errSCIMUserNotFound
+	}
+	user, err := ss.userRepository.GetByID(uint(userID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errSCIMUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.IsServiceAccount {
+		return nil, errSCIMUserNotFound
+	}
+	return user, nil
+}
+
+func (ss *scimService) userResource(user *postgres.PostgresUser) *scim.User {
+	active := user.DeactivatedAt == nil
+	resource := &scim.User{
+		Schemas:    []string{scim.SchemaUser},
+		ID:         strconv.FormatUint(uint64(user.ID), 10),
+		ExternalID: user.ExternalID,
+		UserName:   user.Username,
+		Emails:     []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:     &active,
+	}
+	createdAt, updatedAt := user.CreatedAt, user.UpdatedAt
+	resource.Meta = &scim.Meta{
+		ResourceType: "User",
+		Created:      &createdAt,
+		LastModified: &updatedAt,
+		Location:     ss.location("Users", resource.ID),
+		Version:      scimVersion(resource.ID, resource.ExternalID, resource.UserName, user.Email, strconv.FormatBool(active), user.Password),
+	}
+	return resource
+}
+
+func patchUserAttribute(resource *scim.User, op string, path *scim.Path, value json.RawMessage) error {
+	if op == scim.OpRemove {
+		switch path.Attribute {
+		case "externalid":
+			resource.ExternalID = ""
+		case "username", "emails", "active":
+			return scim.BadRequest(scim.ErrorMutability, "%s cannot be removed", path.Attribute)
+		}
+		return nil
+	}
+
+	switch path.Attribute {
+	case "username":
+		return decodeSCIMValue(value, &resource.UserName)
+	case "externalid":
+		return decodeSCIMValue(value, &resource.ExternalID)
+	case "password":
+		return decodeSCIMValue(value, &resource.Password)
+	case "active":
+		active, err := decodeSCIMBool(value)
+		if err != nil {
+			return err
+		}
+		resource.Active = &active
+	case "emails":
+		// Users have a single email, which any email written becomes
+		if path.SubAttribute == "value" {
+			var email string
+			if err := decodeSCIMValue(value, &email); err != nil {
+				return err
+			}
+			resource.Emails = []scim.Email{{Value: email, Primary: true}}
+			return nil
+		}
+		if path.SubAttribute != "" {
+			return nil
+		}
+		var emails []scim.Email
+		if err := decodeSCIMValue(value, &emails); err != nil {
+			return err
+		}
+		if len(emails) > 0 {
+			resource.Emails = emails
+		}
+	}
+	return nil
+}
+
+func (ss *scimService) GetGroup(id string) (*scim.Group, error) {
+	role, err := ss.getRole(id)
+	if err != nil {
+		return nil, err
+	}
+	return ss.groupResource(role)
+}
+
+// ListGroups returns a page of the groups matching filter, which may compare displayName, id and
+// members.value
+func (ss *scimService) ListGroups(filter string, startIndex, count int) (*scim.ListResponse, error) {
+	startIndex, count = ss.page(startIndex, count)
+
+	var parsed scim.Filter
+	if filter != "" {
+		var err error
+		if parsed, err = scim.ParseFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+	for _, comparison := range parsed {
+		switch comparison.Attribute {
+		case "displayname", "id", "members", "members.value":
+		default:
+			return nil, scim.BadRequest(scim.ErrorInvalidFilter, "filtering groups by %q is not supported", comparison.Attribute)
+		}
+	}
+
+	roles, err := ss.roleRepository.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+
+	var matches []*scim.Group
+	for _, role := range roles {
+		group, err := ss.groupResource(role)
+		if err != nil {
+			return nil, err
+		}
+		if groupMatches(group, parsed) {
+			matches = append(matches, group)
+		}
+	}
+
+	resources := []any{}
+	for i := startIndex - 1; i < len(matches) && len(resources) < count; i++ {
+		resources = append(resources, matches[i])
+	}
+	return scim.NewListResponse(resources, int64(len(matches)), startIndex), nil
+}
+
+func groupMatches(group *scim.Group, filter scim.Filter) bool {
+	for _, comparison := range filter {
+		switch comparison.Attribute {
+		case "displayname":
+			if !strings.EqualFold(comparison.Value, group.DisplayName) {
+				return false
+			}
+		case "id":
+			if comparison.Value != group.ID {
+				return false
+			}
+		case "members", "members.value":
+			member := false
+			for _, candidate := range group.Members {
+				member = member || candidate.Value == comparison.Value
+			}
+			if !member {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CreateGroup creates the role a group stands for. A role of the same name must not exist yet.
+func (ss *scimService) CreateGroup(resource *scim.Group) (*scim.Group, error) {
+	name := strings.TrimSpace(resource.DisplayName)
+	if name == "" {
+		return nil, scim.BadRequest(scim.ErrorInvalidValue, "displayName is required")
+	}
+	if err := ss.checkRoleUnique(name, 0); err != nil {
+		return nil, err
+	}
+	added, err := ss.newMembers(resource.Members, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := ss.roleRepository.Create(&postgres.PostgresRole{
+		Role: entities.Role{Name: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range added {
+		if err := ss.roleRepository.AssignRoleToUser(userID, role.ID); err != nil {
+			return nil, err
+		}
+	}
+	return ss.groupResource(role)
+}
+
+func (ss *scimService) ReplaceGroup(id, version string, resource *scim.Group) (*scim.Group, error) {
+	role, err := ss.getRole(id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := ss.groupResource(role)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(current.Meta.Version, version); err != nil {
+		return nil, err
+	}
+	return ss.updateGroup(role, resource)
+}
+
+// PatchGroup applies a PATCH request to a group, typically adding or removing members
+func (ss *scimService) PatchGroup(id, version string, patch *scim.PatchRequest) (*scim.Group, error) {
+	role, err := ss.getRole(id)
+	if err != nil {
+		return nil, err
+	}
+	resource, err := ss.groupResource(role)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(resource.Meta.Version, version); err != nil {
+		return nil, err
+	}
+
+	for _, operation := range patch.Operations {
+		err := applySCIMOperation(operation, func(op string, path *scim.Path, value json.RawMessage) error {
+			return patchGroupAttribute(resource, op, path, value)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ss.updateGroup(role, resource)
+}
+
+// DeleteGroup deletes the role a group stands for, taking it away from everyone holding it
+func (ss *scimService) DeleteGroup(id, version string) error {
+	role, err := ss.getRole(id)
+	if err != nil {
+		return err
+	}
+	current, err := ss.groupResource(role)
+	if err != nil {
+		return err
+	}
+	if err := checkSCIMVersion(current.Meta.Version, version); err != nil {
+		return err
+	}
+	return ss.roleRepository.Delete(role.ID)
+}
+
+// updateGroup renames a role and makes exactly the members of resource hold it. Service accounts
+// holding the role keep it.
+func (ss *scimService) updateGroup(role *postgres.PostgresRole, resource *scim.Group) (*scim.Group, error) {
+	name := strings.TrimSpace(resource.DisplayName)
+	if name == "" {
+		return nil, scim.BadRequest(scim.ErrorInvalidValue, "displayName is required")
+	}
+	if name != role.Name {
+		if err := ss.checkRoleUnique(name, role.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	holders, err := ss.roleRepository.GetUsersForRole(role.ID)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(holders))
+	for _, holder := range holders {
+		held[strconv.FormatUint(uint64(holder.ID), 10)] = true
+	}
+	added, err := ss.newMembers(resource.Members, held)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != role.Name {
+		role.Name = name
+		if err := ss.roleRepository.Update(role); err != nil {
+			return nil, err
+		}
+	}
+	for _, holder := range holders {
+		if !holder.IsServiceAccount && !hasSCIMMember(resource.Members, strconv.FormatUint(uint64(holder.ID), 10)) {
+			if err := ss.roleRepository.RemoveRoleFromUser(holder.ID, role.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, userID := range added {
+		if err := ss.roleRepository.AssignRoleToUser(userID, role.ID); err != nil {
+			return nil, err
+		}
+	}
+	return ss.groupResource(role)
+}
+
+func (ss *scimService) checkRoleUnique(name string, roleID uint) error {
+	existing, err := ss.roleRepository.GetByName(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != roleID {
+		return scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "a group with this displayName already exists")
+	}
+	return nil
+}
+
+// newMembers returns the IDs of the members not in held, checking each is a provisionable user
+func (ss *scimService) newMembers(members []scim.Member, held map[string]bool) ([]uint, error) {
+	var added []uint
+	seen := make(map[string]bool, len(members))
+	for _, member := range members {
+		if held[member.Value] || seen[member.Value] {
+			continue
+		}
+		seen[member.Value] = true
+
+		user, err := ss.getUser(member.Value)
+		if errors.Is(err, errSCIMUserNotFound) {
+			return nil, scim.BadRequest(scim.ErrorInvalidValue, "member %q is not a user", member.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, user.ID)
+	}
+	return added, nil
+}
+
+func (ss *scimService) getRole(id string) (*postgres.PostgresRole, error) {
+	roleID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, errSCIMGroupNotFound
+	}
+	role, err := ss.roleRepository.GetByID(uint(roleID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errSCIMGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (ss *scimService) groupResource(role *postgres.PostgresRole) (*scim.Group, error) {
+	holders, err := ss.roleRepository.GetUsersForRole(role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          strconv.FormatUint(uint64(role.ID), 10),
+		DisplayName: role.Name,
+		Members:     []scim.Member{},
+	}
+	versioned := []string{resource.ID, resource.DisplayName}
+	for _, holder := range holders {
+		if holder.IsServiceAccount {
+			continue
+		}
+		memberID := strconv.FormatUint(uint64(holder.ID), 10)
+		resource.Members = append(resource.Members, scim.Member{
+			Value:   memberID,
+			Display: holder.Username,
+			Ref:     ss.location("Users", memberID),
+		})
+		versioned = append(versioned, memberID)
+	}
+	createdAt, updatedAt := role.CreatedAt, role.UpdatedAt
+	resource.Meta = &scim.Meta{
+		ResourceType: "Group",
+		Created:      &createdAt,
+		LastModified: &updatedAt,
+		Location:     ss.location("Groups", resource.ID),
+		Version:      scimVersion(versioned...),
+	}
+	return resource, nil
+}
+
+func patchGroupAttribute(resource *scim.Group, op string, path *scim.Path, value json.RawMessage) error {
+	switch path.Attribute {
+	case "displayname":
+		if op == scim.OpRemove {
+			return scim.BadRequest(scim.ErrorMutability, "displayName cannot be removed")
+		}
+		return decodeSCIMValue(value, &resource.DisplayName)
+	case "members":
+	default:
+		return nil
+	}
+
+	if path.SubAttribute != "" {
+		return scim.BadRequest(scim.ErrorInvalidPath, "members can only be modified as a whole")
+	}
+	var members []scim.Member
+	if len(value) > 0 {
+		if err := decodeSCIMValue(value, &members); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case scim.OpAdd:
+		if path.Filter != nil {
+			return scim.BadRequest(scim.ErrorInvalidPath, "members cannot be added with a filter")
+		}
+		for _, member := range members {
+			if !hasSCIMMember(resource.Members, member.Value) {
+				resource.Members = append(resource.Members, member)
+			}
+		}
+	case scim.OpReplace:
+		if path.Filter != nil {
+			return scim.BadRequest(scim.ErrorInvalidPath, "members cannot be replaced with a filter")
+		}
+		resource.Members = members
+	case scim.OpRemove:
+		// Members to remove are selected by the filter, e.g. members[value eq "9"], or listed in the
+		// value; with neither every member is removed
+		var kept []scim.Member
+		for _, member := range resource.Members {
+			removed := path.Filter == nil && len(members) == 0
+			if path.Filter != nil {
+				memberID, ok := path.Filter.Get("value")
+				if !ok || len(path.Filter) != 1 {
+					return scim.BadRequest(scim.ErrorInvalidFilter, "members can only be selected by value")
+				}
+				removed = memberID == member.Value
+			}
+			removed = removed || hasSCIMMember(members, member.Value)
+			if !removed {
+				kept = append(kept, member)
+			}
+		}
+		resource.Members = kept
+	}
+	return nil
+}
+
+func hasSCIMMember(members []scim.Member, value string) bool {
+	for _, member := range members {
+		if member.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// applySCIMOperation resolves the targets of a PATCH operation and passes each with its value to
+// apply. An operation without a path carries an object of the attributes to modify.
+func applySCIMOperation(operation scim.PatchOperation, apply func(op string, path *scim.Path, value json.RawMessage) error) error {
+	op := strings.ToLower(operation.Op)
+	if op != scim.OpAdd && op != scim.OpReplace && op != scim.OpRemove {
+		return scim.BadRequest(scim.ErrorInvalidSyntax, "unsupported operation %q", operation.Op)
+	}
+
+	if operation.Path != "" {
+		path, err := scim.ParsePath(operation.Path)
+		if err != nil {
+			return err
+		}
+		if op != scim.OpRemove && len(operation.Value) == 0 {
+			return scim.BadRequest(scim.ErrorInvalidValue, "operation %q needs a value", operation.Op)
+		}
+		return apply(op, path, operation.Value)
+	}
+
+	if op == scim.OpRemove {
+		return scim.BadRequest(scim.ErrorNoTarget, "remove needs a path")
+	}
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+		return scim.BadRequest(scim.ErrorInvalidValue, "operation without a path needs an object value")
+	}
+	// Apply in a stable order so the outcome does not depend on map iteration
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path, err := scim.ParsePath(name)
+		if err != nil {
+			return err
+		}
+		if err := apply(op, path, attributes[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeSCIMValue(value json.RawMessage, target any) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return scim.BadRequest(scim.ErrorInvalidValue, "invalid value %s", string(value))
+	}
+	return nil
+}
+
+// decodeSCIMBool reads a boolean, which some providers send as the string "True" or "False"
+func decodeSCIMBool(value json.RawMessage) (bool, error) {
+	var parsed bool
+	if err := json.Unmarshal(value, &parsed); err == nil {
+		return parsed, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		if parsed, err := strconv.ParseBool(text); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, scim.BadRequest(scim.ErrorInvalidValue, "invalid boolean %s", string(value))
+}
+
+// page normalizes the 1-based start index and the page size of a query
+func (ss *scimService) page(startIndex, count int) (int, int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 || count > ss.config.MaxResults {
+		count = ss.config.MaxResults
+	}
+	return startIndex, count
+}
+
+func (ss *scimService) location(resourceType, id string) string {
+	return strings.TrimSuffix(ss.config.BaseURL, "/") + "/scim/v2/" + resourceType + "/" + id
+}
+
+// scimVersion derives the weak entity tag of a resource from the values it is built from
+func scimVersion(values ...string) string {
+	hash := sha256.New()
+	for _, value := range values {
+		hash.Write([]byte(value))
+		hash.Write([]byte{0})
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil))[:16] + `"`
+}
+
+// checkSCIMVersion checks the If-Match precondition of a request against the current version of
+// a resource
+func checkSCIMVersion(current, version string) error {
+	if version == "" || version == "*" {
+		return nil
+	}
+	for _, candidate := range strings.Split(version, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(current, "W/") {
+			return nil
+		}
+	}
+	return errSCIMVersion
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/scim"
+)
+
+const testSCIMUserID = 7
+
+// recordingSessionService records the users whose sessions were all revoked
+type recordingSessionService struct {
+	SessionService
+	revoked []uint
+}
+
+func (ss *recordingSessionService) RevokeAll(userID uint, exceptSessionID string) error {
+	ss.revoked = append(ss.revoked, userID)
+	return nil
+}
+
+type scimFixture struct {
+	service  SCIMService
+	users    *memoryUserRepository
+	roles    *memoryRoleRepository
+	sessions *recordingSessionService
+	signups  *recordingSignupHook
+}
+
+func newSCIMFixture() *scimFixture {
+	users := newMemoryUserRepository(entities.User{
+		ID:       testSCIMUserID,
+		Username: "bjensen",
+		Email:    "bjensen@example.com",
+		Password: "hash",
+	})
+	roles := newMemoryRoleRepository(entities.Role{ID: 1, Name: "user"})
+	users.roles = roles
+	sessions := &recordingSessionService{}
+	signups := &recordingSignupHook{}
+	// The tests never set a password, so there is no password policy or hasher to call
+	service := NewSCIMService(users, roles, nil, nil, sessions, testSignupConfig(signups), SCIMConfig{
+		BaseURL:    "https://auth.example.com",
+		MaxResults: 100,
+	})
+	return &scimFixture{service: service, users: users, roles: roles, sessions: sessions, signups: signups}
+}
+
+func (f *scimFixture) version(t *testing.T) string {
+	t.Helper()
+	resource, err := f.service.GetUser("7")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	return resource.Meta.Version
+}
+
+func patchRequest(operations ...scim.PatchOperation) *scim.PatchRequest {
+	return &scim.PatchRequest{Schemas: []string{scim.MessagePatchOp}, Operations: operations}
+}
+
+func TestCreateUserIsASignup(t *testing.T) {
+	fixture := newSCIMFixture()
+
+	created, err := fixture.service.CreateUser(&scim.User{
+		UserName: "alice",
+		Emails:   []scim.Email{{Value: "alice@example.com", Primary: true}},
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	stored, err := fixture.users.GetByUsername("alice")
+	if err != nil || created.ID != "8" {
+		t.Fatalf("created user %s not stored: %v", created.ID, err)
+	}
+	if roles := fixture.roles.roleNames(stored.ID); !reflect.DeepEqual(roles, []string{"user"}) || stored.Tenant != "acme" {
+		t.Fatalf("created user has roles %v and tenant %q, want [user] and acme", roles, stored.Tenant)
+	}
+	if len(fixture.signups.created) != 1 || fixture.signups.created[0].ID != stored.ID {
+		t.Fatalf("post-signup hooks saw %+v, want the created user", fixture.signups.created)
+	}
+
+	fixture.signups.rejectEmail = "mallory@example.com"
+	_, err = fixture.service.CreateUser(&scim.User{
+		UserName: "mallory",
+		Emails:   []scim.Email{{Value: "mallory@example.com", Primary: true}},
+	})
+	var scimError *scim.Error
+	if !errors.As(err, &scimError) || scimError.StatusCode() != http.StatusForbidden {
+		t.Fatalf("CreateUser vetoed by a hook error = %v, want 403", err)
+	}
+	if fixture.users.count() != 2 {
+		t.Fatal("a vetoed user was created")
+	}
+}
+
+func TestPatchUserDeactivationRevokesSessions(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation scim.PatchOperation
+	}{
+		{
+			name:      "path",
+			operation: scim.PatchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`false`)},
+		},
+		{
+			name:      "attribute object with a string boolean",
+			operation: scim.PatchOperation{Op: "Replace", Value: json.RawMessage(`{"active":"False"}`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := newSCIMFixture()
+
+			resource, err := fixture.service.PatchUser("7", fixture.version(t), patchRequest(tt.operation))
+			if err != nil {
+				t.Fatalf("PatchUser: %v", err)
+			}
+			if resource.IsActive() {
+				t.Fatal("the returned resource is still active")
+			}
+			stored, _ := fixture.users.GetByID(testSCIMUserID)
+			if stored.DeactivatedAt == nil {
+				t.Fatal("the user was not deactivated")
+			}
+			if len(fixture.sessions.revoked) != 1 || fixture.sessions.revoked[0] != testSCIMUserID {
+				t.Fatalf("revoked sessions of %v, want [%d]", fixture.sessions.revoked, testSCIMUserID)
+			}
+
+			// Deactivating an inactive user again ends no further sessions
+			_, err = fixture.service.PatchUser("7", "", patchRequest(tt.operation))
+			if err != nil {
+				t.Fatalf("second PatchUser: %v", err)
+			}
+			if len(fixture.sessions.revoked) != 1 {
+				t.Fatalf("sessions were revoked %d times, want 1", len(fixture.sessions.revoked))
+			}
+		})
+	}
+}
+
+func TestPatchUserChecksVersion(t *testing.T) {
+	deactivate := scim.PatchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`false`)}
+
+	fixture := newSCIMFixture()
+	stale := fixture.version(t)
+	_, err := fixture.service.PatchUser("7", "", patchRequest(
+		scim.PatchOperation{Op: "replace", Path: "externalId", Value: json.RawMessage(`"42"`)},
+	))
+	if err != nil {
+		t.Fatalf("PatchUser: %v", err)
+	}
+
+	for _, version := range []string{stale, `W/"0000000000000000"`, `"other", ` + stale} {
+		t.Run(version, func(t *testing.T) {
+			_, err := fixture.service.PatchUser("7", version, patchRequest(deactivate))
+			var scimError *scim.Error
+			if !errors.As(err, &scimError) || scimError.StatusCode() != http.StatusPreconditionFailed {
+				t.Fatalf("PatchUser with a stale version error = %v, want 412", err)
+			}
+			stored, _ := fixture.users.GetByID(testSCIMUserID)
+			if stored.DeactivatedAt != nil || len(fixture.sessions.revoked) != 0 {
+				t.Fatal("a request with a stale version modified the user")
+			}
+		})
+	}
+
+	// The strong form of the current version, or one of several, matches too
+	accepted := map[string]func(current string) string{
+		"strong":   func(current string) string { return current[len("W/"):] },
+		"list":     func(current string) string { return `"other", ` + current },
+		"wildcard": func(string) string { return "*" },
+	}
+	for name, version := range accepted {
+		t.Run(name, func(t *testing.T) {
+			fixture := newSCIMFixture()
+			if _, err := fixture.service.PatchUser("7", version(fixture.version(t)), patchRequest(deactivate)); err != nil {
+				t.Fatalf("PatchUser with the current version: %v", err)
+			}
+		})
+	}
+}
+
+func TestListUsersRejectsUnsupportedFilters(t *testing.T) {
+	fixture := newSCIMFixture()
+
+	list, err := fixture.service.ListUsers(`userName eq "BJensen"`, 1, 10)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if list.TotalResults != 1 {
+		t.Fatalf("ListUsers found %d users, want 1", list.TotalResults)
+	}
+
+	for _, filter := range []string{
+		`name.givenName eq "Barbara"`,
+		`userName eq "bjensen" and title eq "Tour Guide"`,
+		`emails[type eq "work"]`,
+		`userName eq "bjensen" or userName eq "other"`,
+	} {
+		t.Run(filter, func(t *testing.T) {
+			_, err := fixture.service.ListUsers(filter, 1, 10)
+			var scimError *scim.Error
+			if !errors.As(err, &scimError) || scimError.ScimType != scim.ErrorInvalidFilter {
+				t.Fatalf("ListUsers error = %v, want invalidFilter", err)
+			}
+		})
+	}
+}
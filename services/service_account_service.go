@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// serviceAccountEmailDomain is a reserved domain (RFC 2606) used for the placeholder emails
+// of service accounts, which have no mailbox
+const serviceAccountEmailDomain = "service-accounts.invalid"
+
+var (
+	// ErrServiceAccountNotFound is returned for an ID that is not the ID of a service account
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	// ErrPermissionNotDelegable is returned when an administrator hands a service account credential
+	// a permission they do not hold themselves
+	ErrPermissionNotDelegable = errors.New("you cannot delegate a permission you do not hold")
+)
+
+type ServiceAccountService interface {
+	CreateServiceAccount(name string) (*entities.User, error)
+	GetServiceAccounts() ([]*entities.User, error)
+	CreateAPIKey(callerID, accountID uint, name string, scopes []string, expiresAt *time.Time) (*entities.APIKey, string, error)
+	GetAPIKeys(accountID uint) ([]*entities.APIKey, error)
+	RevokeAPIKey(accountID, id uint) error
+}
+
+type serviceAccountService struct {
+	userRepository       postgres.UserRepository
+	permissionRepository postgres.PermissionRepository
+	apiKeyService        APIKeyService
+}
+
+func NewServiceAccountService(
+	userRepository postgres.UserRepository,
+	permissionRepository postgres.PermissionRepository,
+	apiKeyService APIKeyService,
+) ServiceAccountService {
+	return &serviceAccountService{
+		userRepository:       userRepository,
+		permissionRepository: permissionRepository,
+		apiKeyService:        apiKeyService,
+	}
+}
+
+// CreateServiceAccount creates a non-human account. It has no password; roles are assigned
+// like for any user, and it authenticates through OAuth clients bound to it.
+func (ss *serviceAccountService) CreateServiceAccount(name string) (*entities.User, error) {
+	existing, err := ss.userRepository.GetByUsername(name)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if existing != nil && existing.ID != 0 {
+		return nil, ErrUserAlreadyExists
+	}
+
+	created, err := ss.userRepository.Create(&postgres.PostgresUser{
+		User: entities.User{
+			Username:         name,
+			Email:            name + "@" + serviceAccountEmailDomain,
+			IsServiceAccount: true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created.User, nil
+}
+
+func (ss *serviceAccountService) GetServiceAccounts() ([]*entities.User, error) {
+	postgresUsers, err := ss.userRepository.GetServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*entities.User, 0, len(postgresUsers))
+	for _, postgresUser := range postgresUsers {
+		users = append(users, &postgresUser.User)
+	}
+	return users, nil
+}
+
+// CreateAPIKey creates an API key for a service account, for callers that can only be configured
+// with a static Bearer token, such as identity providers provisioning users through SCIM. The
+// caller must hold every scope, so an administrator cannot mint a key more powerful than themselves.
+func (ss *serviceAccountService) CreateAPIKey(callerID, accountID uint, name string, scopes []string, expiresAt *time.Time) (*entities.APIKey, string, error) {
+	if err := ss.checkServiceAccount(accountID); err != nil {
+		return nil, "", err
+	}
+	if err := checkScopesDelegable(ss.permissionRepository, callerID, scopes); err != nil {
+		return nil, "", err
+	}
+	return ss.apiKeyService.CreateAPIKey(accountID, name, scopes, expiresAt)
+}
+
+func (ss *serviceAccountService) GetAPIKeys(accountID uint) ([]*entities.APIKey, error) {
+	if err := ss.checkServiceAccount(accountID); err != nil {
+		return nil, err
+	}
+	return ss.apiKeyService.GetAPIKeysForUser(accountID)
+}
+
+func (ss *serviceAccountService) RevokeAPIKey(accountID, id uint) error {
+	if err := ss.checkServiceAccount(accountID); err != nil {
+		return err
+	}
+	return ss.apiKeyService.RevokeAPIKey(accountID, id)
+}
+
+func (ss *serviceAccountService) checkServiceAccount(accountID uint) error {
+	account, err := ss.userRepository.GetByID(accountID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrServiceAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !account.IsServiceAccount {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+// checkScopesDelegable rejects resource:action scopes the caller does not hold. Other scopes are
+// left to the validation of the credential they are requested for.
+func checkScopesDelegable(permissionRepository postgres.PermissionRepository, callerID uint, scopes []string) error {
+	held, err := heldScopes(permissionRepository, callerID)
+	if err != nil {
+		return err
+	}
+	for _, scope := range parseScopes(strings.Join(scopes, " ")) {
+		resource, action, found := strings.Cut(scope, ":")
+		if found && resource != "" && action != "" && !held[scope] {
+			return fmt.Errorf("%w: %s", ErrPermissionNotDelegable, scope)
+		}
+	}
+	return nil
+}
+
+// checkAccountDelegable rejects service accounts holding a permission the caller does not hold,
+// as a credential of the account acts with all of its permissions
+func checkAccountDelegable(permissionRepository postgres.PermissionRepository, callerID, accountID uint) error {
+	held, err := heldScopes(permissionRepository, callerID)
+	if err != nil {
+		return err
+	}
+	accountPermissions, err := permissionRepository.GetPermissionsForUser(accountID)
+	if err != nil {
+		return err
+	}
+	for _, permission := range accountPermissions {
+		if scope := permission.Resource + ":" + permission.Action; !held[scope] {
+			return fmt.Errorf("%w: the service account holds %s", ErrPermissionNotDelegable, scope)
+		}
+	}
+	return nil
+}
+
+// heldScopes returns the permissions of a user as resource:action scopes
+func heldScopes(permissionRepository postgres.PermissionRepository, userID uint) (map[string]bool, error) {
+	permissions, err := permissionRepository.GetPermissionsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(permissions))
+	for _, permission := range permissions {
+		held[permission.Resource+":"+permission.Action] = true
+	}
+	return held, nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+const (
+	testAdminID   = 1
+	testAccountID = 2
+)
+
+// recordingAPIKeyService records the keys the service account service creates
+type recordingAPIKeyService struct {
+	APIKeyService
+	created [][]string
+}
+
+func (as *recordingAPIKeyService) CreateAPIKey(userID uint, name string, scopes []string, expiresAt *time.Time) (*entities.APIKey, string, error) {
+	as.created = append(as.created, scopes)
+	return &entities.APIKey{UserID: userID, Name: name}, "key", nil
+}
+
+// newDelegationFixture returns an administrator holding users:update and invoices:read, and a
+// service account holding the given scopes
+func newDelegationFixture(accountScopes ...string) (*memoryUserRepository, *memoryPermissionRepository) {
+	users := newMemoryUserRepository(
+		entities.User{ID: testAdminID, Username: "admin", Email: "admin@example.org"},
+		entities.User{ID: testAccountID, Username: "billing", Email: "billing@" + serviceAccountEmailDomain, IsServiceAccount: true},
+	)
+	permissions := newMemoryPermissionRepository("users:update", "invoices:read", "invoices:delete", "roles:update")
+	permissions.grant(testAdminID, "users:update", "invoices:read")
+	permissions.grant(testAccountID, accountScopes...)
+	return users, permissions
+}
+
+func TestCreateAPIKeyRequiresCallerScopes(t *testing.T) {
+	users, permissions := newDelegationFixture("invoices:read", "roles:update")
+	apiKeys := &recordingAPIKeyService{}
+	service := NewServiceAccountService(users, permissions, apiKeys)
+
+	if _, _, err := service.CreateAPIKey(testAdminID, testAccountID, "worker", []string{"invoices:read"}, nil); err != nil {
+		t.Fatalf("CreateAPIKey with a held scope: %v", err)
+	}
+	_, _, err := service.CreateAPIKey(testAdminID, testAccountID, "escalate", []string{"invoices:read", "roles:update"}, nil)
+	if !errors.Is(err, ErrPermissionNotDelegable) {
+		t.Fatalf("CreateAPIKey with a scope the caller lacks error = %v, want ErrPermissionNotDelegable", err)
+	}
+	if len(apiKeys.created) != 1 {
+		t.Fatalf("%d keys were created, want 1", len(apiKeys.created))
+	}
+}
+
+func TestCreateClientRequiresCallerPermissions(t *testing.T) {
+	accountID := uint(testAccountID)
+	tests := []struct {
+		name          string
+		accountScopes []string
+		scopes        string
+		wantErr       error
+	}{
+		{"held scopes and account permissions", []string{"invoices:read"}, "invoices:read", nil},
+		{"scope the creator lacks", []string{"invoices:read", "invoices:delete"}, "invoices:delete", ErrPermissionNotDelegable},
+		{"account more privileged than the creator", []string{"invoices:read", "roles:update"}, "invoices:read", ErrPermissionNotDelegable},
+		{"unknown scope", []string{"invoices:read"}, "invoices:write", ErrInvalidOAuthClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, permissions := newDelegationFixture(tt.accountScopes...)
+			clients := &memoryOAuthClientRepository{}
+			service := NewOAuthClientService(clients, users, permissions, nil, nil)
+
+			_, _, err := service.CreateClient(testAdminID, &entities.OAuthClient{
+				Name:             "billing",
+				AuthMethod:       entities.ClientAuthMethodSecretBasic,
+				GrantTypes:       entities.GrantTypeClientCredentials,
+				Scopes:           tt.scopes,
+				ServiceAccountID: &accountID,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateClient error = %v, want %v", err, tt.wantErr)
+			}
+			if created := len(clients.clients); (tt.wantErr == nil) != (created == 1) {
+				t.Fatalf("%d clients were created", created)
+			}
+		})
+	}
+}
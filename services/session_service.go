@@ -0,0 +1,251 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// maxUserAgentLength bounds the user agent stored with a session
+const maxUserAgentLength = 512
+
+var (
+	// ErrSessionNotFound is returned when revoking a session that does not exist or is not active
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidSession is returned when a token belongs to a revoked, expired or unknown session
+	ErrInvalidSession = errors.New("session is no longer valid")
+)
+
+// ClientInfo describes the device a login comes from
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+// SessionConfig configures server-side sessions
+type SessionConfig struct {
+	// TTL is how long a session, and the access token issued for it, stays valid
+	TTL time.Duration
+	// TouchInterval limits how often last-seen timestamps are written
+	TouchInterval time.Duration
+}
+
+type SessionService interface {
+	Create(userID uint, authMethods []string, client ClientInfo) (*entities.Session, error)
+	CreateForClient(userID uint, clientID string, client ClientInfo, ttl time.Duration) (*entities.Session, error)
+	CreateImpersonation(userID, impersonatorID uint, client ClientInfo, ttl time.Duration) (*entities.Session, error)
+	Authenticate(claims jwt.MapClaims) (*Principal, error)
+	StepUp(userID uint, sessionID string, authMethods []string) (*entities.Session, error)
+	GetSessionsForUser(userID uint, currentSessionID string) ([]entities.Session, error)
+	Revoke(userID uint, sessionID string) error
+	RevokeAll(userID uint, exceptSessionID string) error
+}
+
+type sessionService struct {
+	sessionRepository postgres.SessionRepository
+	userRepository    postgres.UserRepository
+	config            SessionConfig
+}
+
+func NewSessionService(
+	sessionRepository postgres.SessionRepository,
+	userRepository postgres.UserRepository,
+	config SessionConfig,
+) SessionService {
+	return &sessionService{
+		sessionRepository: sessionRepository,
+		userRepository:    userRepository,
+		config:            config,
+	}
+}
+
+// Create starts the session of a login that authenticated the user with authMethods
+func (ss *sessionService) Create(userID uint, authMethods []string, client ClientInfo) (*entities.Session, error) {
+	return ss.create(entities.Session{UserID: userID, AuthMethods: strings.Join(authMethods, " ")}, client, ss.config.TTL)
+}
+
+// CreateForClient starts the session backing the tokens of an OAuth client a user authorized.
+// It lasts ttl, the lifetime of the client's refresh tokens.
+func (ss *sessionService) CreateForClient(userID uint, clientID string, client ClientInfo, ttl time.Duration) (*entities.Session, error) {
+	return ss.create(entities.Session{UserID: userID, ClientID: clientID}, client, ttl)
+}
+
+// CreateImpersonation starts a session of a user for an administrator acting as them. client is
+// the administrator's device. The session ends after ttl or as soon as the administrator is
+// deactivated.
+func (ss *sessionService) CreateImpersonation(userID, impersonatorID uint, client ClientInfo, ttl time.Duration) (*entities.Session, error) {
+	return ss.create(entities.Session{UserID: userID, ImpersonatorID: &impersonatorID}, client, ttl)
+}
+
+// create starts a session with the owner and origin set in session
+func (ss *sessionService) create(session entities.Session, client ClientInfo, ttl time.Duration) (*entities.Session, error) {
+	sessionID, err := generateToken(24)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	now := time.Now()
+	session.ID = sessionID
+	session.UserAgent = userAgent
+	session.IPAddress = client.IPAddress
+	session.LastSeenAt = now
+	session.AuthenticatedAt = now
+	session.ExpiresAt = now.Add(ttl)
+	created, err := ss.sessionRepository.Create(&postgres.PostgresSession{Session: session})
+	if err != nil {
+		return nil, err
+	}
+	return &created.Session, nil
+}
+
+// Authenticate validates the session an access token was issued for, given the verified
+// claims of the token, and returns the user it belongs to
+func (ss *sessionService) Authenticate(claims jwt.MapClaims) (*Principal, error) {
+	// Tokens issued before sessions existed carry no session and are rejected
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		return nil, ErrInvalidSession
+	}
+	session, err := ss.sessionRepository.GetByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return nil, ErrInvalidSession
+	}
+	if userID, ok := claims["id"].(float64); !ok || uint(userID) != session.UserID {
+		return nil, ErrInvalidSession
+	}
+
+	user, err := ss.userRepository.GetByID(session.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Deactivation revokes the sessions of a user; this covers a deactivation racing a login
+	if user.DeactivatedAt != nil {
+		return nil, ErrInvalidSession
+	}
+	if session.ImpersonatorID != nil {
+		impersonator, err := ss.userRepository.GetByID(*session.ImpersonatorID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSession
+		}
+		if err != nil {
+			return nil, err
+		}
+		if impersonator.DeactivatedAt != nil {
+			return nil, ErrInvalidSession
+		}
+	}
+
+	if now.Sub(session.LastSeenAt) >= ss.config.TouchInterval {
+		if err := ss.sessionRepository.Touch(session.ID, now); err != nil {
+			log.Printf("Error updating last seen time of session: %v", err)
+		}
+	}
+
+	principal := &Principal{
+		User:        user.User,
+		SessionID:   session.ID,
+		AuthTime:    session.AuthenticatedAt,
+		AuthMethods: strings.Fields(session.AuthMethods),
+		Claims:      claims,
+	}
+	if session.ImpersonatorID != nil {
+		principal.ImpersonatorID = *session.ImpersonatorID
+	}
+	// Tokens of an authorized OAuth client are limited to the scopes the user granted it. A token
+	// exchanged by another client names that client instead, and its act claim says so.
+	clientID, hasClient := claims["client_id"].(string)
+	if hasClient || session.ClientID != "" {
+		if _, delegated := claims["act"]; !delegated && clientID != session.ClientID {
+			return nil, ErrInvalidSession
+		}
+		principal.ClientID = clientID
+		principal.Audience, _ = claims["aud"].(string)
+		if scope, ok := claims["scope"].(string); ok {
+			principal.Scopes = parseScopes(scope)
+		}
+	}
+	return principal, nil
+}
+
+// StepUp records that the user of a session authenticated again with authMethods, e.g. to use a
+// permission requiring a recent second factor. The session keeps the methods of its login too.
+func (ss *sessionService) StepUp(userID uint, sessionID string, authMethods []string) (*entities.Session, error) {
+	postgresSession, err := ss.sessionRepository.GetByID(sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &postgresSession.Session
+	now := time.Now()
+	if session.UserID != userID || session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return nil, ErrInvalidSession
+	}
+
+	methods := strings.Fields(session.AuthMethods)
+	for _, method := range authMethods {
+		if !hasScope(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	session.AuthMethods = strings.Join(methods, " ")
+	session.AuthenticatedAt = now
+	if err := ss.sessionRepository.StepUp(session.ID, session.AuthMethods, now); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetSessionsForUser lists the active sessions of a user, flagging currentSessionID
+func (ss *sessionService) GetSessionsForUser(userID uint, currentSessionID string) ([]entities.Session, error) {
+	postgresSessions, err := ss.sessionRepository.GetActiveForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]entities.Session, 0, len(postgresSessions))
+	for _, postgresSession := range postgresSessions {
+		session := postgresSession.Session
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (ss *sessionService) Revoke(userID uint, sessionID string) error {
+	revoked, err := ss.sessionRepository.Revoke(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (ss *sessionService) RevokeAll(userID uint, exceptSessionID string) error {
+	return ss.sessionRepository.RevokeAllForUser(userID, exceptSessionID)
+}
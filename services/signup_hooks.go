@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// Signup is an account about to be created for a person, by self-service signup or provisioning,
+// which hooks may veto or enrich
+type Signup struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	// Tenant is recorded on the user
+	Tenant string `json:"tenant,omitempty"`
+	// Roles are the names of the roles the user receives, starting with the default roles
+	Roles []string `json:"roles"`
+}
+
+// ErrInvalidEmail is returned by a hook that cannot read the email of a signup
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// SignupRejectedError is returned by a hook vetoing a signup; Reason is shown to the user
+type SignupRejectedError struct {
+	Reason string
+}
+
+func (e *SignupRejectedError) Error() string {
+	return "signup rejected: " + e.Reason
+}
+
+// SignupHook is called around every signup, in the order configured. Accounts provisioned at a
+// first federated, SAML or LDAP login or over SCIM are signups too; invitations are not.
+type SignupHook interface {
+	// BeforeSignup may change signup, e.g. to add roles, or veto it with a *SignupRejectedError.
+	// Any other error fails the signup too.
+	BeforeSignup(signup *Signup) error
+	// AfterSignup is told about the created user. Failures are only logged since the user exists.
+	AfterSignup(user *entities.User, signup *Signup) error
+}
+
+type emailDomainHook struct {
+	domains map[string]bool
+}
+
+// NewEmailDomainHook rejects signups whose email is not at one of domains
+func NewEmailDomainHook(domains []string) SignupHook {
+	allowed := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		allowed[strings.ToLower(strings.TrimPrefix(domain, "@"))] = true
+	}
+	return &emailDomainHook{
+		domains: allowed,
+	}
+}
+
+func (eh *emailDomainHook) BeforeSignup(signup *Signup) error {
+	at := strings.LastIndex(signup.Email, "@")
+	if at < 0 {
+		return ErrInvalidEmail
+	}
+	if !eh.domains[strings.ToLower(signup.Email[at+1:])] {
+		return &SignupRejectedError{Reason: fmt.Sprintf("email addresses at %q cannot sign up", signup.Email[at+1:])}
+	}
+	return nil
+}
+
+func (eh *emailDomainHook) AfterSignup(user *entities.User, signup *Signup) error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// signupPolicy applies a SignupConfig to every account created for a person on their own behalf:
+// self-service signups, accounts provisioned at a first federated, SAML or LDAP login and users
+// provisioned over SCIM. Invitations and service accounts get the roles an administrator chose.
+type signupPolicy struct {
+	roleRepository postgres.RoleRepository
+	config         SignupConfig
+}
+
+func newSignupPolicy(roleRepository postgres.RoleRepository, config SignupConfig) *signupPolicy {
+	return &signupPolicy{
+		roleRepository: roleRepository,
+		config:         config,
+	}
+}
+
+// begin starts a signup with the default roles, lets the hooks veto or enrich it and looks up the
+// roles it grants
+func (sp *signupPolicy) begin(username, email string) (*Signup, []entities.Role, error) {
+	signup := &Signup{
+		Username: username,
+		Email:    email,
+		Roles:    append([]string(nil), sp.config.DefaultRoles...),
+	}
+	for _, hook := range sp.config.Hooks {
+		if err := hook.BeforeSignup(signup); err != nil {
+			return nil, nil, err
+		}
+	}
+	roles, err := sp.roles(signup.Roles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signup, roles, nil
+}
+
+// finish tells the hooks about the created user. Failures are only logged since the user exists.
+func (sp *signupPolicy) finish(user *entities.User, signup *Signup) {
+	for _, hook := range sp.config.Hooks {
+		if err := hook.AfterSignup(user, signup); err != nil {
+			log.Printf("Error running post-signup hook for user %d: %v", user.ID, err)
+		}
+	}
+}
+
+// roles looks up the roles a signup grants by name, skipping duplicates
+func (sp *signupPolicy) roles(names []string) ([]entities.Role, error) {
+	seen := make(map[string]bool, len(names))
+	roles := make([]entities.Role, 0, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		role, err := sp.roleRepository.GetByName(name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("signup role %q does not exist", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role.Role)
+	}
+	return roles, nil
+}
+
+func roleIDs(roles []entities.Role) []uint {
+	ids := make([]uint, 0, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+	}
+	return ids
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// recordingSignupHook puts every signup in the acme tenant, vetoes the signups of rejectEmail and
+// records the users created
+type recordingSignupHook struct {
+	rejectEmail string
+	created     []entities.User
+}
+
+func (sh *recordingSignupHook) BeforeSignup(signup *Signup) error {
+	if signup.Email == sh.rejectEmail {
+		return &SignupRejectedError{Reason: "not on the guest list"}
+	}
+	signup.Tenant = "acme"
+	return nil
+}
+
+func (sh *recordingSignupHook) AfterSignup(user *entities.User, signup *Signup) error {
+	sh.created = append(sh.created, *user)
+	return nil
+}
+
+// testSignupConfig grants the user role to every signup and runs hook around it
+func testSignupConfig(hook SignupHook) SignupConfig {
+	return SignupConfig{DefaultRoles: []string{"user"}, Hooks: []SignupHook{hook}}
+}
+
+func TestSignupPolicyBegin(t *testing.T) {
+	roles := newMemoryRoleRepository(entities.Role{ID: 1, Name: "admin"}, entities.Role{ID: 2, Name: "user"})
+	enrich := &addRolesHook{roles: []string{"admin", "user"}}
+	policy := newSignupPolicy(roles, SignupConfig{
+		DefaultRoles: []string{"user"},
+		Hooks:        []SignupHook{enrich, &recordingSignupHook{}},
+	})
+
+	signup, granted, err := policy.begin("bob", "bob@example.org")
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if signup.Tenant != "acme" || !reflect.DeepEqual(signup.Roles, []string{"user", "admin", "user"}) {
+		t.Fatalf("signup = %+v, want the acme tenant and the added roles", signup)
+	}
+	if !reflect.DeepEqual(roleIDs(granted), []uint{2, 1}) {
+		t.Fatalf("granted roles %v, want [2 1] without duplicates", roleIDs(granted))
+	}
+
+	policy = newSignupPolicy(roles, SignupConfig{Hooks: []SignupHook{&addRolesHook{roles: []string{"owner"}}}})
+	if _, _, err := policy.begin("bob", "bob@example.org"); err == nil {
+		t.Fatal("begin granted a role that does not exist")
+	}
+
+	policy = newSignupPolicy(roles, SignupConfig{Hooks: []SignupHook{&recordingSignupHook{rejectEmail: "bob@example.org"}, enrich}})
+	_, _, err = policy.begin("bob", "bob@example.org")
+	var rejectedErr *SignupRejectedError
+	if !errors.As(err, &rejectedErr) {
+		t.Fatalf("begin error = %v, want *SignupRejectedError", err)
+	}
+}
+
+type addRolesHook struct {
+	roles []string
+}
+
+func (ah *addRolesHook) BeforeSignup(signup *Signup) error {
+	signup.Roles = append(signup.Roles, ah.roles...)
+	return nil
+}
+
+func (ah *addRolesHook) AfterSignup(user *entities.User, signup *Signup) error {
+	return nil
+}
+
+func TestEmailDomainHook(t *testing.T) {
+	hook := NewEmailDomainHook([]string{"@Example.org"})
+	tests := []struct {
+		email string
+		want  func(err error) bool
+	}{
+		{email: "bob@example.org", want: func(err error) bool { return err == nil }},
+		{email: "Bob@EXAMPLE.org", want: func(err error) bool { return err == nil }},
+		{email: "bob@example.com", want: func(err error) bool {
+			var rejectedErr *SignupRejectedError
+			return errors.As(err, &rejectedErr)
+		}},
+		{email: "bob", want: func(err error) bool { return errors.Is(err, ErrInvalidEmail) }},
+		{email: "", want: func(err error) bool { return errors.Is(err, ErrInvalidEmail) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if err := hook.BeforeSignup(&Signup{Username: "bob", Email: tt.email}); !tt.want(err) {
+				t.Fatalf("BeforeSignup(%q) error = %v", tt.email, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// Events posted to signup webhooks
+const (
+	SignupEventBefore = "signup.before"
+	SignupEventAfter  = "signup.after"
+)
+
+// maxWebhookResponseSize bounds the answer read from a signup webhook
+const maxWebhookResponseSize = 64 << 10
+
+// SignupWebhookConfig configures the outbound HTTP signup hook
+type SignupWebhookConfig struct {
+	URL string
+	// Secret keys the HMAC-SHA256 signature of each request; without it requests are unsigned
+	Secret  string
+	Timeout time.Duration
+}
+
+// signupWebhookRequest is the body posted to the webhook
+type signupWebhookRequest struct {
+	Event  string             `json:"event"`
+	Signup *Signup            `json:"signup"`
+	User   *signupWebhookUser `json:"user,omitempty"`
+}
+
+// signupWebhookUser is the created user, without its password hash
+type signupWebhookUser struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Tenant   string `json:"tenant,omitempty"`
+}
+
+// signupWebhookResponse is the optional answer to signup.before. Allow defaults to true; Tenant
+// replaces the tenant when set and Roles are added to the roles of the signup.
+type signupWebhookResponse struct {
+	Allow  *bool    `json:"allow"`
+	Reason string   `json:"reason"`
+	Tenant string   `json:"tenant"`
+	Roles  []string `json:"roles"`
+}
+
+type signupWebhook struct {
+	config     SignupWebhookConfig
+	httpClient *http.Client
+}
+
+// NewSignupWebhook posts every signup to an HTTP endpoint, which can veto or enrich it before the
+// user is created and is told about the user afterwards. Requests carry an X-Signup-Timestamp
+// header and, with a secret, an X-Signup-Signature of "sha256=" and the hex HMAC of the timestamp,
+// a dot and the body. An unreachable endpoint or an answer other than 2xx fails the signup.
+func NewSignupWebhook(config SignupWebhookConfig, httpClient *http.Client) SignupHook {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: config.Timeout}
+	}
+	return &signupWebhook{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+func (sw *signupWebhook) BeforeSignup(signup *Signup) error {
+	body, err := sw.post(&signupWebhookRequest{Event: SignupEventBefore, Signup: signup})
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var answer signupWebhookResponse
+	if err := json.Unmarshal(body, &answer); err != nil {
+		return fmt.Errorf("signup webhook: invalid response: %w", err)
+	}
+	if answer.Allow != nil && !*answer.Allow {
+		reason := answer.Reason
+		if reason == "" {
+			reason = "not allowed"
+		}
+		return &SignupRejectedError{Reason: reason}
+	}
+	if answer.Tenant != "" {
+		signup.Tenant = answer.Tenant
+	}
+	signup.Roles = append(signup.Roles, answer.Roles...)
+	return nil
+}
+
+func (sw *signupWebhook) AfterSignup(user *entities.User, signup *Signup) error {
+	_, err := sw.post(&signupWebhookRequest{
+		Event:  SignupEventAfter,
+		Signup: signup,
+		User: &signupWebhookUser{
+			ID:       user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			Tenant:   user.Tenant,
+		},
+	})
+	return err
+}
+
+// post sends an event and returns the body of a 2xx answer
+func (sw *signupWebhook) post(event *signupWebhookRequest) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, sw.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Signup-Event", event.Event)
+	request.Header.Set("X-Signup-Timestamp", timestamp)
+	if sw.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(sw.config.Secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(payload)
+		request.Header.Set("X-Signup-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := sw.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("signup webhook: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxWebhookResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("signup webhook: %w", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("signup webhook: %s answered %d", event.Event, response.StatusCode)
+	}
+	return body, nil
+}
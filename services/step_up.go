@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// Authentication methods reported in the amr claim (RFC 8176)
+const (
+	AuthMethodPassword    = "pwd"
+	AuthMethodOTP         = "otp"
+	AuthMethodHardwareKey = "hwk"
+	AuthMethodFederated   = "fed"
+	// AuthMethodEmail is a one-time link sent by email; RFC 8176 registers no value for it
+	AuthMethodEmail = "email"
+	// AuthMethodRecoveryCode is a single-use MFA recovery code; RFC 8176 registers no value for it
+	AuthMethodRecoveryCode = "rc"
+	// AuthMethodMFA is added whenever the user passed more than one factor
+	AuthMethodMFA = "mfa"
+)
+
+// Authentication context classes reported in the acr claim and requested by step-up challenges
+const (
+	ACRSingleFactor = "aal1"
+	ACRMultiFactor  = "aal2"
+)
+
+// StepUpRequiredError is returned when a permission requires a second factor more recent than the
+// caller's (RFC 9470). Clients recover by stepping up and retrying.
+type StepUpRequiredError struct {
+	Resource string
+	Action   string
+	MaxAge   time.Duration
+	// Impossible is set for credentials that can never step up, like API keys
+	Impossible bool
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return fmt.Sprintf("%s:%s requires a two-factor authentication within %s", e.Resource, e.Action, e.MaxAge)
+}
+
+// authContextClass returns the acr of an authentication with the given methods
+func authContextClass(methods []string) string {
+	if hasScope(methods, AuthMethodMFA) {
+		return ACRMultiFactor
+	}
+	return ACRSingleFactor
+}
+
+// withSecondFactor returns the methods of an authentication that passed firstFactor and then
+// secondFactor, marked as multi-factor
+func withSecondFactor(firstFactor []string, secondFactor string) []string {
+	methods := make([]string, 0, len(firstFactor)+2)
+	for _, method := range firstFactor {
+		if !hasScope(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	for _, method := range []string{secondFactor, AuthMethodMFA} {
+		if !hasScope(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// checkStepUp returns a StepUpRequiredError when the permission for resource and action requires a
+// recent second factor the principal has not passed. Unknown permissions have no requirement.
+func checkStepUp(permissionRepository postgres.PermissionRepository, principal *Principal, resource, action string) error {
+	permission, err := permissionRepository.GetByResourceAndAction(resource, action)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if permission.MFAMaxAgeMinutes <= 0 {
+		return nil
+	}
+
+	maxAge := time.Duration(permission.MFAMaxAgeMinutes) * time.Minute
+	if principal.AuthenticatedWithMFAWithin(maxAge) {
+		return nil
+	}
+	return &StepUpRequiredError{
+		Resource: resource,
+		Action:   action,
+		MaxAge:   maxAge,
+		// Only the user's own interactive sessions can step up
+		Impossible: principal.SessionID == "" || principal.ClientID != "" || principal.ImpersonatorID != 0,
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"gorm.io/gorm"
+)
+
+// Token type hints accepted by the introspection and revocation endpoints
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// TokenIntrospectionConfig configures the introspection and revocation endpoints
+type TokenIntrospectionConfig struct {
+	// Issuer is the public base URL of this service
+	Issuer string
+}
+
+// TokenIntrospectionRequest is a request to the introspection or revocation endpoint
+type TokenIntrospectionRequest struct {
+	Token         string
+	TokenTypeHint string
+	Client        ClientCredentials
+}
+
+// IntrospectionResponse describes a token (RFC 7662 section 2.2). Inactive tokens only report Active.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	// Aud and Act describe exchanged tokens: the service they are meant for and the client acting for the user
+	Aud string      `json:"aud,omitempty"`
+	Act interface{} `json:"act,omitempty"`
+	// AuthTime, Amr and Acr describe the latest authentication in the session of the token
+	AuthTime int64    `json:"auth_time,omitempty"`
+	Amr      []string `json:"amr,omitempty"`
+	Acr      string   `json:"acr,omitempty"`
+}
+
+// TokenIntrospectionService lets OAuth clients, such as API gateways, check and revoke tokens
+// without verifying them themselves
+type TokenIntrospectionService interface {
+	Introspect(request *TokenIntrospectionRequest) (*IntrospectionResponse, error)
+	Revoke(request *TokenIntrospectionRequest) error
+}
+
+type tokenIntrospectionService struct {
+	authenticationService       AuthenticationService
+	oauthClientService          OAuthClientService
+	tokenService                TokenService
+	roleRepository              postgres.RoleRepository
+	sessionRepository           postgres.SessionRepository
+	oauthRefreshTokenRepository postgres.OAuthRefreshTokenRepository
+	revokedTokenRepository      postgres.RevokedTokenRepository
+	config                      TokenIntrospectionConfig
+}
+
+func NewTokenIntrospectionService(
+	authenticationService AuthenticationService,
+	oauthClientService OAuthClientService,
+	tokenService TokenService,
+	roleRepository postgres.RoleRepository,
+	sessionRepository postgres.SessionRepository,
+	oauthRefreshTokenRepository postgres.OAuthRefreshTokenRepository,
+	revokedTokenRepository postgres.RevokedTokenRepository,
+	config TokenIntrospectionConfig,
+) TokenIntrospectionService {
+	return &tokenIntrospectionService{
+		authenticationService:       authenticationService,
+		oauthClientService:          oauthClientService,
+		tokenService:                tokenService,
+		roleRepository:              roleRepository,
+		sessionRepository:           sessionRepository,
+		oauthRefreshTokenRepository: oauthRefreshTokenRepository,
+		revokedTokenRepository:      revokedTokenRepository,
+		config:                      config,
+	}
+}
+
+// Introspect reports whether a token is active and what it grants. Access tokens and API keys can be
+// introspected by any confidential client; refresh tokens only by the client they were issued to.
+// Protocol failures are returned as an *OAuthError.
+func (tis *tokenIntrospectionService) Introspect(request *TokenIntrospectionRequest) (*IntrospectionResponse, error) {
+	client, err := tis.oauthClientService.AuthenticateClient(request.Client, tokenEndpoint(tis.config.Issuer))
+	if err != nil {
+		return nil, err
+	}
+	if client.AuthMethod == entities.ClientAuthMethodNone {
+		return nil, errUnauthorizedClient("public clients may not introspect tokens")
+	}
+	if request.Token == "" {
+		return nil, errInvalidRequest("token is required")
+	}
+
+	// The hint only decides which kind of token is looked up first
+	if request.TokenTypeHint == TokenTypeHintRefreshToken {
+		response, err := tis.introspectRefreshToken(client, request.Token)
+		if err != nil || response.Active {
+			return response, err
+		}
+		return tis.introspectAccessToken(request.Token)
+	}
+	response, err := tis.introspectAccessToken(request.Token)
+	if err != nil || response.Active {
+		return response, err
+	}
+	return tis.introspectRefreshToken(client, request.Token)
+}
+
+// introspectAccessToken describes an access token or API key, using the same checks as every
+// protected endpoint, so revoked sessions and keys are reported inactive
+func (tis *tokenIntrospectionService) introspectAccessToken(token string) (*IntrospectionResponse, error) {
+	principal, err := tis.authenticationService.Authenticate(token)
+	if errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrInvalidSession) || errors.Is(err, ErrInvalidAPIKey) {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	roleNames, err := roleNamesForUser(tis.roleRepository, principal.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(principal.Scopes, " "),
+		ClientID:  principal.ClientID,
+		Username:  principal.User.Username,
+		TokenType: "Bearer",
+		Sub:       strconv.FormatUint(uint64(principal.User.ID), 10),
+		Iss:       strings.TrimSuffix(tis.config.Issuer, "/"),
+		Roles:     roleNames,
+		Aud:       principal.Audience,
+		Act:       principal.Claims["act"],
+	}
+	if principal.ExpiresAt != nil {
+		response.Exp = principal.ExpiresAt.Unix()
+	}
+	if principal.SessionID != "" {
+		response.AuthTime = principal.AuthTime.Unix()
+		response.Amr = principal.AuthMethods
+		response.Acr = authContextClass(principal.AuthMethods)
+	}
+	if issuedAt, ok := principal.Claims["iat"].(float64); ok {
+		response.Iat = int64(issuedAt)
+	}
+	return response, nil
+}
+
+func (tis *tokenIntrospectionService) introspectRefreshToken(client *entities.OAuthClient, token string) (*IntrospectionResponse, error) {
+	refreshToken, active, err := tis.activeRefreshToken(client, token)
+	if err != nil || !active {
+		return &IntrospectionResponse{Active: false}, err
+	}
+
+	roleNames, err := roleNamesForUser(tis.roleRepository, refreshToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IntrospectionResponse{
+		Active:   true,
+		Scope:    refreshToken.Scopes,
+		ClientID: refreshToken.ClientID,
+		Exp:      refreshToken.ExpiresAt.Unix(),
+		Iat:      refreshToken.CreatedAt.Unix(),
+		Sub:      strconv.FormatUint(uint64(refreshToken.UserID), 10),
+		Iss:      strings.TrimSuffix(tis.config.Issuer, "/"),
+		Roles:    roleNames,
+	}, nil
+}
+
+// activeRefreshToken looks up a refresh token of client that has not been rotated, expired or revoked
+func (tis *tokenIntrospectionService) activeRefreshToken(client *entities.OAuthClient, token string) (*postgres.PostgresOAuthRefreshToken, bool, error) {
+	refreshToken, err := tis.oauthRefreshTokenRepository.GetByHash(hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if refreshToken.ClientID != client.ClientID || refreshToken.RotatedAt != nil || time.Now().After(refreshToken.ExpiresAt) {
+		return refreshToken, false, nil
+	}
+
+	session, err := tis.sessionRepository.GetByID(refreshToken.SessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return refreshToken, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return refreshToken, false, nil
+	}
+	return refreshToken, true, nil
+}
+
+// Revoke revokes a token the client obtained (RFC 7009). Revoking a refresh token, or an access token
+// issued for a user, ends the whole authorization; client_credentials tokens and exchanged tokens,
+// which share the session of another authorization, are revoked individually.
+// Unknown tokens and tokens of other clients are ignored, as the RFC requires a success response for them.
+func (tis *tokenIntrospectionService) Revoke(request *TokenIntrospectionRequest) error {
+	client, err := tis.oauthClientService.AuthenticateClient(request.Client, tokenEndpoint(tis.config.Issuer))
+	if err != nil {
+		return err
+	}
+	if request.Token == "" {
+		return errInvalidRequest("token is required")
+	}
+
+	refreshToken, err := tis.oauthRefreshTokenRepository.GetByHash(hashToken(request.Token))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if refreshToken != nil {
+		if refreshToken.ClientID != client.ClientID {
+			return nil
+		}
+		_, err := tis.sessionRepository.Revoke(refreshToken.UserID, refreshToken.SessionID)
+		return err
+	}
+
+	claims, err := tis.tokenService.Parse(request.Token)
+	if err != nil {
+		return nil
+	}
+	if clientID, _ := claims["client_id"].(string); clientID != client.ClientID {
+		return nil
+	}
+	_, delegated := claims["act"]
+	if sessionID, _ := claims["sid"].(string); sessionID != "" && !delegated {
+		userID, _ := claims["id"].(float64)
+		_, err := tis.sessionRepository.Revoke(uint(userID), sessionID)
+		return err
+	}
+	if tokenID, _ := claims["jti"].(string); tokenID != "" {
+		return tis.revokedTokenRepository.Revoke(tokenID, time.Unix(int64(claims["exp"].(float64)), 0))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vladimirteddy/go-authentication/entities"
+)
+
+// ErrInvalidToken is returned when an access token is malformed, badly signed or expired
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// TokenConfig configures access token signing
+type TokenConfig struct {
+	Secret []byte
+}
+
+// TokenService signs and verifies the JWT access tokens handed to clients
+type TokenService interface {
+	Issue(user *entities.User, roleNames []string, session *entities.Session) (string, error)
+	IssueClientToken(user *entities.User, roleNames []string, sessionID, clientID string, scopes []string, expiresAt time.Time) (string, error)
+	IssueImpersonationToken(user *entities.User, roleNames []string, session *entities.Session, impersonator *entities.User) (string, error)
+	IssueDelegatedToken(user *entities.User, roleNames []string, sessionID, clientID, audience string, actor map[string]interface{}, scopes []string, expiresAt time.Time) (string, error)
+	Parse(tokenString string) (jwt.MapClaims, error)
+}
+
+type tokenService struct {
+	config TokenConfig
+}
+
+func NewTokenService(config TokenConfig) TokenService {
+	return &tokenService{
+		config: config,
+	}
+}
+
+// Issue creates the token of a session. It lasts as long as the session and reports how and when
+// the user last authenticated in it (auth_time, amr and acr).
+func (ts *tokenService) Issue(user *entities.User, roleNames []string, session *entities.Session) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, sessionTokenClaims(user, roleNames, session)).SignedString(ts.config.Secret)
+}
+
+// IssueImpersonationToken creates a token for an administrator acting as user. Its act claim
+// names the administrator (RFC 8693 section 4.1), so every service receiving it can tell.
+func (ts *tokenService) IssueImpersonationToken(user *entities.User, roleNames []string, session *entities.Session, impersonator *entities.User) (string, error) {
+	claims := sessionTokenClaims(user, roleNames, session)
+	claims["act"] = map[string]interface{}{
+		"sub":      strconv.FormatUint(uint64(impersonator.ID), 10),
+		"username": impersonator.Username,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ts.config.Secret)
+}
+
+// IssueClientToken creates a token obtained by an OAuth client. Tokens for a user who authorized
+// the client carry the session the authorization created; client_credentials tokens have no
+// session and stay valid while the client exists.
+func (ts *tokenService) IssueClientToken(user *entities.User, roleNames []string, sessionID, clientID string, scopes []string, expiresAt time.Time) (string, error) {
+	claims, err := clientTokenClaims(user, roleNames, sessionID, clientID, scopes, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ts.config.Secret)
+}
+
+// IssueDelegatedToken creates a token a client obtained by exchanging a user's token. It is only
+// meant for audience, and its act claim names the client acting for the user (RFC 8693 section 4.1).
+func (ts *tokenService) IssueDelegatedToken(user *entities.User, roleNames []string, sessionID, clientID, audience string, actor map[string]interface{}, scopes []string, expiresAt time.Time) (string, error) {
+	claims, err := clientTokenClaims(user, roleNames, sessionID, clientID, scopes, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	claims["aud"] = audience
+	claims["act"] = actor
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ts.config.Secret)
+}
+
+// Parse verifies the signature and expiry of an access token and returns its claims
+func (ts *tokenService) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return ts.config.Secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.Join(ErrInvalidToken, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	// jwt.Parse only validates exp when present; tokens without one are never accepted
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func sessionTokenClaims(user *entities.User, roleNames []string, session *entities.Session) jwt.MapClaims {
+	authMethods := strings.Fields(session.AuthMethods)
+	claims := jwt.MapClaims{
+		"id":             user.ID,
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"roles":          roleNames,
+		"sid":            session.ID,
+		"auth_time":      session.AuthenticatedAt.Unix(),
+		"acr":            authContextClass(authMethods),
+		"exp":            session.ExpiresAt.Unix(),
+		"iat":            time.Now().Unix(),
+	}
+	if len(authMethods) > 0 {
+		claims["amr"] = authMethods
+	}
+	return claims
+}
+
+func clientTokenClaims(user *entities.User, roleNames []string, sessionID, clientID string, scopes []string, expiresAt time.Time) (jwt.MapClaims, error) {
+	tokenID, err := generateToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{
+		"id":        user.ID,
+		"username":  user.Username,
+		"roles":     roleNames,
+		"client_id": clientID,
+		"jti":       tokenID,
+		"exp":       expiresAt.Unix(),
+		"iat":       time.Now().Unix(),
+	}
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
+	if scopes != nil {
+		claims["scope"] = strings.Join(scopes, " ")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/url"
+
+	"github.com/vladimirteddy/go-authentication/notifiers"
+)
+
+// generateToken returns a URL-safe random token built from size random bytes
+func generateToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex-encoded SHA-256 digest used to store tokens at rest
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// buildTokenLink appends token as a query parameter to baseURL.
+// When no usable base URL is configured the bare token is returned.
+func buildTokenLink(baseURL, token string) string {
+	link, err := url.Parse(baseURL)
+	if err != nil || baseURL == "" {
+		return token
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// sendInBackground delivers a message without waiting for the mail server, so a request answers
+// as fast for a registered email as for an unknown one. Failures are only logged.
+func sendInBackground(notifier notifiers.Notifier, message notifiers.Message) {
+	go func() {
+		if err := notifier.Send(message); err != nil {
+			log.Printf("Error sending %q email: %v", message.Subject, err)
+		}
+	}()
+}
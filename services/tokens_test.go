@@ -0,0 +1,96 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/notifiers"
+)
+
+// blockingNotifier holds every message until released, like a mail server that takes its time
+type blockingNotifier struct {
+	release chan struct{}
+	sent    chan notifiers.Message
+}
+
+func newBlockingNotifier() *blockingNotifier {
+	return &blockingNotifier{release: make(chan struct{}), sent: make(chan notifiers.Message, 1)}
+}
+
+func (n *blockingNotifier) Send(message notifiers.Message) error {
+	<-n.release
+	n.sent <- message
+	return nil
+}
+
+// expectSent releases the notifier and waits for the message it was holding
+func (n *blockingNotifier) expectSent(t *testing.T, subject string) {
+	t.Helper()
+	close(n.release)
+	select {
+	case message := <-n.sent:
+		if message.Subject != subject {
+			t.Fatalf("sent %q, want %q", message.Subject, subject)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email was sent")
+	}
+}
+
+// returnsBefore fails unless request returns while the notifier is still holding the email, which
+// is what keeps registered emails from answering slower than unknown ones
+func returnsBefore(t *testing.T, request func() error) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- request() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request waited for the email to be sent")
+	}
+}
+
+func newTokenEmailUsers() *memoryUserRepository {
+	return newMemoryUserRepository(entities.User{ID: 9, Username: "erin", Email: "erin@example.org"})
+}
+
+func TestRequestPasswordResetSendsInBackground(t *testing.T) {
+	notifier := newBlockingNotifier()
+	service := NewPasswordResetService(newTokenEmailUsers(), &memoryUserTokenRepository{}, nil, nil, nil, notifier, PasswordResetConfig{
+		TokenTTL: time.Hour,
+	})
+
+	returnsBefore(t, func() error { return service.RequestPasswordReset("erin@example.org") })
+	notifier.expectSent(t, "Reset your password")
+}
+
+func TestRequestMagicLinkSendsInBackground(t *testing.T) {
+	notifier := newBlockingNotifier()
+	service := NewMagicLinkService(newTokenEmailUsers(), &memoryUserTokenRepository{}, nil, notifier, MagicLinkConfig{
+		TokenTTL:        time.Hour,
+		ResendInterval:  time.Minute,
+		MaxSendsPerHour: 5,
+	})
+
+	returnsBefore(t, func() error {
+		_, err := service.RequestMagicLink("erin@example.org", "")
+		return err
+	})
+	notifier.expectSent(t, "Your login link")
+}
+
+func TestResendVerificationSendsInBackground(t *testing.T) {
+	notifier := newBlockingNotifier()
+	service := NewEmailVerificationService(newTokenEmailUsers(), &memoryUserTokenRepository{}, notifier, EmailVerificationConfig{
+		TokenTTL:        time.Hour,
+		ResendInterval:  time.Minute,
+		MaxSendsPerHour: 5,
+	})
+
+	returnsBefore(t, func() error { return service.ResendVerification("erin@example.org") })
+	notifier.expectSent(t, "Verify your email address")
+}
@@ -3,53 +3,136 @@ package services
 import (
 	"errors"
 	"log"
-	"os"
-	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/vladimirteddy/go-authentication/entities"
 	"github.com/vladimirteddy/go-authentication/repositories/postgres"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+var (
+	// ErrUserAlreadyExists is returned when signing up with a taken username or email
+	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrInvalidCredentials is returned by Login for an unknown username or a wrong password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrEmailNotVerified is returned by Login when the verification policy blocks unverified users
+	ErrEmailNotVerified = errors.New("email not verified")
+	// ErrAccountDeactivated is returned by logins of a deactivated user
+	ErrAccountDeactivated = errors.New("account deactivated")
+	// ErrSignupDisabled is returned when signing up while open signup is turned off
+	ErrSignupDisabled = errors.New("signup is disabled, an invitation is required")
+)
+
+// SignupConfig configures signup. Enabled only concerns self-service signup; the default roles and
+// hooks apply to provisioned accounts too, but not to invitations or service accounts.
+type SignupConfig struct {
+	// Enabled lets anyone create an account; invited users can sign up regardless
+	Enabled bool
+	// DefaultRoles are the names of the roles every signed up or provisioned user receives
+	DefaultRoles []string
+	// Hooks may veto or enrich each signup, in order
+	Hooks []SignupHook
+}
+
+// LoginResult is the outcome of a login. Either Token is set, or the user
+// must complete a second step identified by ChallengeToken.
+type LoginResult struct {
+	Token                 string   `json:"token,omitempty"`
+	MFARequired           bool     `json:"mfaRequired,omitempty"`
+	MFAEnrollmentRequired bool     `json:"mfaEnrollmentRequired,omitempty"`
+	MFAMethods            []string `json:"mfaMethods,omitempty"`
+	ChallengeToken        string   `json:"challengeToken,omitempty"`
+}
+
 type UserService interface {
 	CreateUser(user *entities.User) (*entities.User, error)
-	Login(user *entities.User) (string, error)
+	Login(user *entities.User, client ClientInfo) (*LoginResult, error)
+	CompleteLogin(userID uint, authMethods []string, client ClientInfo) (*LoginResult, error)
+	CompleteMFALogin(challengeToken, code string, client ClientInfo) (string, error)
+	CompleteMFAEnrollment(challengeToken, code string, client ClientInfo) (string, []string, error)
+	IssueToken(userID uint, authMethods []string, client ClientInfo) (string, error)
+	StepUp(userID uint, sessionID string, authMethods []string) (string, error)
+	ChangePassword(userID uint, sessionID, currentPassword, newPassword string) error
 	GetUserByID(id uint) (*entities.User, error)
 	GetUserRoles(id uint) ([]string, error)
 	HasPermission(userID uint, resource, action string) (bool, error)
+	CheckStepUp(principal *Principal, resource, action string) error
 	AssignRoleToUser(userID, roleID uint) error
 	RemoveRoleFromUser(userID, roleID uint) error
 }
 
 type userService struct {
-	userRepository       postgres.UserRepository
-	roleRepository       postgres.RoleRepository
-	permissionRepository postgres.PermissionRepository
+	userRepository           postgres.UserRepository
+	roleRepository           postgres.RoleRepository
+	permissionRepository     postgres.PermissionRepository
+	emailVerificationService EmailVerificationService
+	mfaService               MFAService
+	passwordPolicyService    PasswordPolicyService
+	passwordHasher           PasswordHasher
+	sessionService           SessionService
+	tokenService             TokenService
+	authenticators           []Authenticator
+	signupPolicy             *signupPolicy
 }
 
 func NewUserService(
 	userRepository postgres.UserRepository,
 	roleRepository postgres.RoleRepository,
 	permissionRepository postgres.PermissionRepository,
+	emailVerificationService EmailVerificationService,
+	mfaService MFAService,
+	passwordPolicyService PasswordPolicyService,
+	passwordHasher PasswordHasher,
+	sessionService SessionService,
+	tokenService TokenService,
+	authenticators []Authenticator,
+	signupConfig SignupConfig,
 ) UserService {
 	return &userService{
-		userRepository:       userRepository,
-		roleRepository:       roleRepository,
-		permissionRepository: permissionRepository,
+		userRepository:           userRepository,
+		roleRepository:           roleRepository,
+		permissionRepository:     permissionRepository,
+		emailVerificationService: emailVerificationService,
+		mfaService:               mfaService,
+		passwordPolicyService:    passwordPolicyService,
+		passwordHasher:           passwordHasher,
+		sessionService:           sessionService,
+		tokenService:             tokenService,
+		authenticators:           authenticators,
+		signupPolicy:             newSignupPolicy(roleRepository, signupConfig),
 	}
 }
 
 func (us *userService) CreateUser(user *entities.User) (*entities.User, error) {
+	if !us.signupPolicy.config.Enabled {
+		return nil, ErrSignupDisabled
+	}
 	userFound, err := us.userRepository.GetByUsername(user.Username)
-	if err != nil {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
-	if userFound.ID != 0 {
+	if userFound != nil && userFound.ID != 0 {
 		log.Println("user Info", userFound)
-		return nil, errors.New("user already exists")
+		return nil, ErrUserAlreadyExists
+	}
+	if user.Email != "" {
+		emailFound, err := us.userRepository.GetByEmail(user.Email)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if emailFound != nil && emailFound.ID != 0 {
+			return nil, ErrUserAlreadyExists
+		}
+	}
+	if err := us.passwordPolicyService.Validate(user, user.Password); err != nil {
+		return nil, err
+	}
+
+	signup, roles, err := us.signupPolicy.begin(user.Username, user.Email)
+	if err != nil {
+		return nil, err
 	}
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+
+	passwordHash, err := us.passwordHasher.Hash(user.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -57,65 +140,246 @@ func (us *userService) CreateUser(user *entities.User) (*entities.User, error) {
 	postgresUser := &postgres.PostgresUser{
 		User: entities.User{
 			Username: user.Username,
-			Password: string(passwordHash),
+			Password: passwordHash,
 			Email:    user.Email,
+			Tenant:   signup.Tenant,
 		},
 	}
 
-	userCreated, err := us.userRepository.Create(postgresUser)
+	userCreated, err := us.userRepository.CreateWithRoles(postgresUser, roleIDs(roles))
 	if err != nil {
 		return nil, err
 	}
 
-	return &entities.User{
+	if err := us.passwordPolicyService.Remember(userCreated.ID, passwordHash); err != nil {
+		log.Printf("Error recording password history for user %d: %v", userCreated.ID, err)
+	}
+
+	createdUser := &entities.User{
 		ID:       userCreated.ID,
 		Username: userCreated.Username,
 		Email:    userCreated.Email,
-	}, nil
+		Tenant:   userCreated.Tenant,
+		Roles:    roles,
+	}
+
+	// The account is usable even if no email is sent; the user can ask for a resend
+	if err := us.emailVerificationService.SendVerification(createdUser); err != nil {
+		log.Printf("Error sending verification email to user %d: %v", createdUser.ID, err)
+	}
+
+	us.signupPolicy.finish(createdUser, signup)
+
+	return createdUser, nil
 }
 
-func (us *userService) Login(user *entities.User) (string, error) {
-	userFound, err := us.userRepository.GetByUsername(user.Username)
+// Login checks the credentials with each authenticator in turn; the first one that knows the
+// username and accepts the password decides who logs in
+func (us *userService) Login(user *entities.User, client ClientInfo) (*LoginResult, error) {
+	userFound, err := us.authenticate(user.Username, user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return us.finishLogin(userFound, []string{AuthMethodPassword}, client)
+}
+
+// CompleteLogin finishes the login of a user authenticated by other means, such as a magic link,
+// with authMethods. Like Login it answers with an MFA challenge when the user needs a second factor.
+func (us *userService) CompleteLogin(userID uint, authMethods []string, client ClientInfo) (*LoginResult, error) {
+	postgresUser, err := us.userRepository.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return us.finishLogin(&postgresUser.User, authMethods, client)
+}
+
+// finishLogin applies the login policies to an authenticated user, then either issues the token
+// or starts the MFA challenge
+func (us *userService) finishLogin(user *entities.User, authMethods []string, client ClientInfo) (*LoginResult, error) {
+	if err := us.checkLoginAllowed(user); err != nil {
+		return nil, err
+	}
+
+	roles, err := us.roleRepository.GetRolesForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users with a second factor, or whose roles demand one, get a challenge instead of a token
+	mfaMethods, err := us.mfaService.EnabledMethods(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	mfaRequired := false
+	for _, role := range roles {
+		if role.RequireMFA {
+			mfaRequired = true
+			break
+		}
+	}
+	if len(mfaMethods) > 0 || mfaRequired {
+		// Users without any second factor may only enroll one with the challenge
+		challengeToken, err := us.mfaService.CreateChallenge(user.ID, len(mfaMethods) == 0, authMethods)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{
+			MFARequired:           len(mfaMethods) > 0,
+			MFAEnrollmentRequired: len(mfaMethods) == 0,
+			MFAMethods:            mfaMethods,
+			ChallengeToken:        challengeToken,
+		}, nil
+	}
+
+	tokenString, err := us.issueToken(user, roles, authMethods, client)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: tokenString}, nil
+}
+
+// authenticate runs the authenticator chain. An authenticator that fails hands over to the next.
+// If none accepts the credentials the attempt counts as invalid credentials even when a backend
+// was unreachable, so it is throttled like any other failure; the backend errors are only logged.
+func (us *userService) authenticate(username, password string) (*entities.User, error) {
+	for _, authenticator := range us.authenticators {
+		user, err := authenticator.Authenticate(username, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("Error authenticating %q: %v", username, err)
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// CompleteMFALogin finishes a login that was answered with an MFA challenge
+func (us *userService) CompleteMFALogin(challengeToken, code string, client ClientInfo) (string, error) {
+	challenge, secondFactor, err := us.mfaService.VerifyChallenge(challengeToken, code)
 	if err != nil {
 		return "", err
 	}
+	return us.issueTokenForUser(challenge.UserID, challenge.AuthMethodsWith(secondFactor), client)
+}
 
-	// Check if the user exists
-	if userFound.ID == 0 {
-		return "", errors.New("user not found")
+// CompleteMFAEnrollment confirms the authenticator app of a user who was required to enroll
+// during login, then finishes the login. It returns the token and the new recovery codes.
+func (us *userService) CompleteMFAEnrollment(challengeToken, code string, client ClientInfo) (string, []string, error) {
+	challenge, err := us.mfaService.ResolveEnrollmentChallenge(challengeToken)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(userFound.Password), []byte(user.Password)); err != nil {
-		return "", errors.New("invalid password")
+	recoveryCodes, err := us.mfaService.ConfirmTOTPEnrollment(challenge.UserID, code)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := us.mfaService.ConsumeEnrollmentChallenge(challengeToken); err != nil {
+		return "", nil, err
 	}
 
-	// Get user roles as strings for the JWT token
-	var roleNames []string
-	roles, err := us.roleRepository.GetRolesForUser(userFound.ID)
+	tokenString, err := us.issueTokenForUser(challenge.UserID, challenge.AuthMethodsWith(AuthMethodOTP), client)
+	if err != nil {
+		return "", nil, err
+	}
+	return tokenString, recoveryCodes, nil
+}
+
+// IssueToken issues the same token as Login for a user authenticated by other means, such as a
+// passkey, with authMethods, after checking the user is still allowed to log in
+func (us *userService) IssueToken(userID uint, authMethods []string, client ClientInfo) (string, error) {
+	return us.issueTokenForUser(userID, authMethods, client)
+}
+
+// StepUp records that the user of a session authenticated again with authMethods and returns a new
+// token of the session reporting it
+func (us *userService) StepUp(userID uint, sessionID string, authMethods []string) (string, error) {
+	session, err := us.sessionService.StepUp(userID, sessionID, authMethods)
+	if err != nil {
+		return "", err
+	}
+	postgresUser, err := us.userRepository.GetByID(userID)
+	if err != nil {
+		return "", err
+	}
+	roleNames, err := us.GetUserRoles(userID)
+	if err != nil {
+		return "", err
+	}
+	return us.tokenService.Issue(&postgresUser.User, roleNames, session)
+}
+
+// ChangePassword replaces the password of a logged-in user after confirming the current one.
+// Every other session of the user is terminated, keeping only sessionID.
+func (us *userService) ChangePassword(userID uint, sessionID, currentPassword, newPassword string) error {
+	postgresUser, err := us.userRepository.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if !us.passwordHasher.Verify(postgresUser.Password, currentPassword) {
+		return ErrInvalidCredentials
+	}
+
+	if err := us.passwordPolicyService.Validate(&postgresUser.User, newPassword); err != nil {
+		return err
+	}
+	passwordHash, err := us.passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := us.userRepository.UpdatePassword(userID, passwordHash); err != nil {
+		return err
+	}
+	if err := us.passwordPolicyService.Remember(userID, passwordHash); err != nil {
+		log.Printf("Error recording password history for user %d: %v", userID, err)
+	}
+	return us.sessionService.RevokeAll(userID, sessionID)
+}
+
+// checkLoginAllowed applies account-level policies shared by every login method
+func (us *userService) checkLoginAllowed(user *entities.User) error {
+	if user.DeactivatedAt != nil {
+		return ErrAccountDeactivated
+	}
+	if !user.EmailVerified && us.emailVerificationService.Policy() == EmailVerificationBlockLogin {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// issueTokenForUser issues the token of a user who completed a login, checking again the user is
+// allowed to log in since they may have been deactivated in the meantime
+func (us *userService) issueTokenForUser(userID uint, authMethods []string, client ClientInfo) (string, error) {
+	postgresUser, err := us.userRepository.GetByID(userID)
 	if err != nil {
 		return "", err
 	}
+	if err := us.checkLoginAllowed(&postgresUser.User); err != nil {
+		return "", err
+	}
+	roles, err := us.roleRepository.GetRolesForUser(userID)
+	if err != nil {
+		return "", err
+	}
+	return us.issueToken(&postgresUser.User, roles, authMethods, client)
+}
+
+// issueToken starts a session and creates the signed JWT handed to clients after a successful login
+func (us *userService) issueToken(user *entities.User, roles []*postgres.PostgresRole, authMethods []string, client ClientInfo) (string, error) {
+	// Get user roles as strings for the JWT token
+	var roleNames []string
 	for _, role := range roles {
 		roleNames = append(roleNames, role.Name)
 	}
 
-	// Create token with user ID, username, and roles
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":       userFound.ID,
-		"username": userFound.Username,
-		"email":    userFound.Email,
-		"roles":    roleNames,
-		"exp":      time.Now().Add(time.Hour * 4).Unix(),
-		"iat":      time.Now().Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(os.Getenv("SECRET_JWT")))
+	session, err := us.sessionService.Create(user.ID, authMethods, client)
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	return us.tokenService.Issue(user, roleNames, session)
 }
 
 func (us *userService) GetUserByID(id uint) (*entities.User, error) {
@@ -137,10 +401,12 @@ func (us *userService) GetUserByID(id uint) (*entities.User, error) {
 	}
 
 	user := &entities.User{
-		ID:       postgresUser.ID,
-		Username: postgresUser.Username,
-		Email:    postgresUser.Email,
-		Roles:    userRoles,
+		ID:              postgresUser.ID,
+		Username:        postgresUser.Username,
+		Email:           postgresUser.Email,
+		EmailVerified:   postgresUser.EmailVerified,
+		EmailVerifiedAt: postgresUser.EmailVerifiedAt,
+		Roles:           userRoles,
 	}
 
 	return user, nil
@@ -161,9 +427,25 @@ func (us *userService) GetUserRoles(id uint) ([]string, error) {
 }
 
 func (us *userService) HasPermission(userID uint, resource, action string) (bool, error) {
+	if us.emailVerificationService.Policy() == EmailVerificationRestrict {
+		user, err := us.userRepository.GetByID(userID)
+		if err != nil {
+			return false, err
+		}
+		if !user.EmailVerified && !user.IsServiceAccount {
+			return false, nil
+		}
+	}
+
 	return us.permissionRepository.CheckUserPermission(userID, resource, action)
 }
 
+// CheckStepUp returns a StepUpRequiredError when the permission for resource and action requires a
+// more recent second factor than the principal passed. It complements HasPermission.
+func (us *userService) CheckStepUp(principal *Principal, resource, action string) error {
+	return checkStepUp(us.permissionRepository, principal, resource, action)
+}
+
 func (us *userService) AssignRoleToUser(userID, roleID uint) error {
 	return us.roleRepository.AssignRoleToUser(userID, roleID)
 }
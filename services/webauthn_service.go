@@ -0,0 +1,347 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/vladimirteddy/go-authentication/entities"
+	"github.com/vladimirteddy/go-authentication/repositories/postgres"
+	"github.com/vladimirteddy/go-authentication/webauthn"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidWebAuthnChallenge is returned when a ceremony answers an unknown, expired or used challenge
+	ErrInvalidWebAuthnChallenge = errors.New("invalid or expired WebAuthn challenge")
+	// ErrWebAuthnCredentialNotFound is returned when the asserted credential is not registered
+	ErrWebAuthnCredentialNotFound = errors.New("WebAuthn credential not found")
+	// ErrWebAuthnVerificationFailed wraps ceremony verification failures
+	ErrWebAuthnVerificationFailed = errors.New("WebAuthn verification failed")
+)
+
+// WebAuthnConfig configures passkey and security key support
+type WebAuthnConfig struct {
+	RelyingParty webauthn.RelyingParty
+	// ChallengeTTL is how long a ceremony may take; it is also sent to the browser as the timeout
+	ChallengeTTL time.Duration
+}
+
+// WebAuthnAssertion is the decoded response of navigator.credentials.get()
+type WebAuthnAssertion struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+}
+
+type WebAuthnService interface {
+	BeginRegistration(userID uint) (*webauthn.CreationOptions, error)
+	FinishRegistration(userID uint, name string, clientDataJSON, attestationObject []byte) (*entities.WebAuthnCredential, error)
+	BeginLogin(username string) (*webauthn.RequestOptions, error)
+	FinishLogin(assertion *WebAuthnAssertion) (uint, error)
+	BeginSecondFactor(userID uint) (*webauthn.RequestOptions, error)
+	FinishSecondFactor(userID uint, assertion *WebAuthnAssertion) error
+	GetCredentialsForUser(userID uint) ([]*entities.WebAuthnCredential, error)
+	DeleteCredential(userID, id uint) error
+}
+
+type webAuthnService struct {
+	userRepository     postgres.UserRepository
+	webAuthnRepository postgres.WebAuthnRepository
+	mfaService         MFAService
+	config             WebAuthnConfig
+}
+
+func NewWebAuthnService(
+	userRepository postgres.UserRepository,
+	webAuthnRepository postgres.WebAuthnRepository,
+	mfaService MFAService,
+	config WebAuthnConfig,
+) WebAuthnService {
+	config.RelyingParty.Timeout = config.ChallengeTTL
+	return &webAuthnService{
+		userRepository:     userRepository,
+		webAuthnRepository: webAuthnRepository,
+		mfaService:         mfaService,
+		config:             config,
+	}
+}
+
+func (ws *webAuthnService) BeginRegistration(userID uint) (*webauthn.CreationOptions, error) {
+	user, err := ws.userRepository.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := ws.webAuthnRepository.GetCredentialsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := ws.createChallenge(entities.WebAuthnCeremonyRegistration, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	userEntity := webauthn.UserEntity{
+		ID:          webauthn.Base64.EncodeToString(userHandle(userID)),
+		Name:        user.Username,
+		DisplayName: user.Username,
+	}
+	return ws.config.RelyingParty.CreationOptions(challenge, userEntity, credentialIDs(existing)), nil
+}
+
+func (ws *webAuthnService) FinishRegistration(userID uint, name string, clientDataJSON, attestationObject []byte) (*entities.WebAuthnCredential, error) {
+	challenge, err := ws.consumeChallenge(entities.WebAuthnCeremonyRegistration, clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != userID {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+
+	credential, err := ws.config.RelyingParty.VerifyRegistration(challenge.challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		return nil, errors.Join(ErrWebAuthnVerificationFailed, err)
+	}
+
+	if name == "" {
+		name = "Security key"
+	}
+	created, err := ws.webAuthnRepository.CreateCredential(&postgres.PostgresWebAuthnCredential{
+		WebAuthnCredential: entities.WebAuthnCredential{
+			CredentialID:      webauthn.Base64.EncodeToString(credential.ID),
+			UserID:            userID,
+			Name:              name,
+			PublicKey:         credential.PublicKey,
+			SignCount:         int64(credential.SignCount),
+			AAGUID:            hex.EncodeToString(credential.AAGUID),
+			AttestationFormat: credential.AttestationFormat,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &created.WebAuthnCredential, nil
+}
+
+// BeginLogin starts a passwordless login. With a username the browser is limited to that
+// user's credentials; without one any discoverable credential (passkey) may answer.
+func (ws *webAuthnService) BeginLogin(username string) (*webauthn.RequestOptions, error) {
+	var userID *uint
+	var allowed [][]byte
+	if username != "" {
+		user, err := ws.userRepository.GetByUsername(username)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		// Unknown users get an empty allow list rather than an error to avoid account enumeration
+		if user != nil {
+			userID = &user.ID
+			credentials, err := ws.webAuthnRepository.GetCredentialsForUser(user.ID)
+			if err != nil {
+				return nil, err
+			}
+			allowed = credentialIDs(credentials)
+		}
+	}
+
+	challenge, err := ws.createChallenge(entities.WebAuthnCeremonyAuthentication, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Passwordless login replaces both factors, so the authenticator must verify the user
+	return ws.config.RelyingParty.RequestOptions(challenge, allowed, webauthn.UserVerificationRequired), nil
+}
+
+// FinishLogin verifies a passwordless assertion and returns the authenticated user ID
+func (ws *webAuthnService) FinishLogin(assertion *WebAuthnAssertion) (uint, error) {
+	challenge, err := ws.consumeChallenge(entities.WebAuthnCeremonyAuthentication, assertion.ClientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+
+	credential, err := ws.verifyAssertion(challenge, assertion, true)
+	if err != nil {
+		return 0, err
+	}
+	if challenge.UserID != nil && *challenge.UserID != credential.UserID {
+		return 0, ErrWebAuthnCredentialNotFound
+	}
+	if len(assertion.UserHandle) > 0 && string(assertion.UserHandle) != string(userHandle(credential.UserID)) {
+		return 0, ErrWebAuthnCredentialNotFound
+	}
+	return credential.UserID, nil
+}
+
+// BeginSecondFactor starts an assertion used as the second step of a password login
+func (ws *webAuthnService) BeginSecondFactor(userID uint) (*webauthn.RequestOptions, error) {
+	credentials, err := ws.webAuthnRepository.GetCredentialsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, ErrMFANotEnrolled
+	}
+
+	challenge, err := ws.createChallenge(entities.WebAuthnCeremonyAuthentication, &userID)
+	if err != nil {
+		return nil, err
+	}
+	return ws.config.RelyingParty.RequestOptions(challenge, credentialIDs(credentials), ws.config.RelyingParty.UserVerification), nil
+}
+
+func (ws *webAuthnService) FinishSecondFactor(userID uint, assertion *WebAuthnAssertion) error {
+	challenge, err := ws.consumeChallenge(entities.WebAuthnCeremonyAuthentication, assertion.ClientDataJSON)
+	if err != nil {
+		return err
+	}
+	if challenge.UserID == nil || *challenge.UserID != userID {
+		return ErrInvalidWebAuthnChallenge
+	}
+
+	credential, err := ws.verifyAssertion(challenge, assertion, ws.config.RelyingParty.UserVerification == webauthn.UserVerificationRequired)
+	if err != nil {
+		return err
+	}
+	if credential.UserID != userID {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+func (ws *webAuthnService) GetCredentialsForUser(userID uint) ([]*entities.WebAuthnCredential, error) {
+	postgresCredentials, err := ws.webAuthnRepository.GetCredentialsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]*entities.WebAuthnCredential, len(postgresCredentials))
+	for i, postgresCredential := range postgresCredentials {
+		credentials[i] = &postgresCredential.WebAuthnCredential
+	}
+	return credentials, nil
+}
+
+// DeleteCredential removes a credential, refusing to remove the last second factor
+// of a user whose roles require MFA
+func (ws *webAuthnService) DeleteCredential(userID, id uint) error {
+	methods, err := ws.mfaService.EnabledMethods(userID)
+	if err != nil {
+		return err
+	}
+	required, err := ws.mfaService.IsMFARequired(userID)
+	if err != nil {
+		return err
+	}
+	if required && len(methods) == 1 && methods[0] == MFAMethodWebAuthn {
+		count, err := ws.webAuthnRepository.CountCredentialsForUser(userID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrMFARequiredByRole
+		}
+	}
+
+	deleted, err := ws.webAuthnRepository.DeleteCredential(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// pendingChallenge is a consumed challenge together with its plain value
+type pendingChallenge struct {
+	*postgres.PostgresWebAuthnChallenge
+	challenge string
+}
+
+func (ws *webAuthnService) createChallenge(ceremony string, userID *uint) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = ws.webAuthnRepository.CreateChallenge(&postgres.PostgresWebAuthnChallenge{
+		WebAuthnChallenge: entities.WebAuthnChallenge{
+			UserID:        userID,
+			Ceremony:      ceremony,
+			ChallengeHash: hashToken(challenge),
+			ExpiresAt:     time.Now().Add(ws.config.ChallengeTTL),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// consumeChallenge finds the challenge echoed in clientDataJSON and marks it used
+func (ws *webAuthnService) consumeChallenge(ceremony string, clientDataJSON []byte) (*pendingChallenge, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, errors.Join(ErrWebAuthnVerificationFailed, err)
+	}
+
+	challenge, err := ws.webAuthnRepository.ConsumeChallenge(ceremony, hashToken(clientData.Challenge))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidWebAuthnChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pendingChallenge{PostgresWebAuthnChallenge: challenge, challenge: clientData.Challenge}, nil
+}
+
+func (ws *webAuthnService) verifyAssertion(challenge *pendingChallenge, assertion *WebAuthnAssertion, requireUserVerification bool) (*postgres.PostgresWebAuthnCredential, error) {
+	credential, err := ws.webAuthnRepository.GetCredentialByCredentialID(webauthn.Base64.EncodeToString(assertion.CredentialID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ws.config.RelyingParty.VerifyAssertion(
+		challenge.challenge,
+		credential.PublicKey,
+		uint32(credential.SignCount),
+		assertion.ClientDataJSON,
+		assertion.AuthenticatorData,
+		assertion.Signature,
+		requireUserVerification,
+	)
+	if err != nil {
+		return nil, errors.Join(ErrWebAuthnVerificationFailed, err)
+	}
+
+	if err := ws.webAuthnRepository.UpdateSignCount(credential.ID, int64(result.SignCount)); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// userHandle is the opaque user ID stored on discoverable credentials
+func userHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+func credentialIDs(credentials []*postgres.PostgresWebAuthnCredential) [][]byte {
+	var ids [][]byte
+	for _, credential := range credentials {
+		id, err := webauthn.Base64.DecodeString(credential.CredentialID)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,150 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// Attestation statement formats accepted during registration
+const (
+	AttestationFormatNone   = "none"
+	AttestationFormatPacked = "packed"
+)
+
+// oidAAGUID is the id-fido-gen-ce-aaguid certificate extension
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+type attestationObject struct {
+	Format      string
+	Statement   map[interface{}]interface{}
+	AuthData    *AuthenticatorData
+	RawAuthData []byte
+}
+
+func parseAttestationObject(data []byte) (*attestationObject, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+
+	format, _ := fields["fmt"].(string)
+	statement, _ := fields["attStmt"].(map[interface{}]interface{})
+	rawAuthData, ok := fields["authData"].([]byte)
+	if format == "" || statement == nil || !ok {
+		return nil, errors.New("webauthn: malformed attestation object")
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attestationObject{
+		Format:      format,
+		Statement:   statement,
+		AuthData:    authData,
+		RawAuthData: rawAuthData,
+	}, nil
+}
+
+// verify checks the attestation statement against the authenticator data and client data hash
+func (ao *attestationObject) verify(clientDataHash []byte, credentialKey *PublicKey) error {
+	switch ao.Format {
+	case AttestationFormatNone:
+		if len(ao.Statement) != 0 {
+			return errors.New("webauthn: none attestation must have an empty statement")
+		}
+		return nil
+	case AttestationFormatPacked:
+		return ao.verifyPacked(clientDataHash, credentialKey)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedAttestation, ao.Format)
+}
+
+// verifyPacked implements the packed attestation verification procedure (WebAuthn section 8.2).
+// Both self attestation and basic attestation with an x5c chain are accepted; the chain
+// is not validated against a trust anchor since no metadata service is configured.
+func (ao *attestationObject) verifyPacked(clientDataHash []byte, credentialKey *PublicKey) error {
+	algorithm, ok := cborInt(ao.Statement["alg"])
+	if !ok {
+		return errors.New("webauthn: packed attestation is missing alg")
+	}
+	signature, ok := ao.Statement["sig"].([]byte)
+	if !ok {
+		return errors.New("webauthn: packed attestation is missing sig")
+	}
+	signedData := append(append([]byte{}, ao.RawAuthData...), clientDataHash...)
+
+	x5c, hasX5C := ao.Statement["x5c"].([]interface{})
+	if !hasX5C {
+		// Self attestation: signed with the credential key itself
+		if algorithm != credentialKey.Algorithm {
+			return errors.New("webauthn: self attestation algorithm does not match credential key")
+		}
+		return credentialKey.Verify(signedData, signature)
+	}
+
+	if len(x5c) == 0 {
+		return errors.New("webauthn: packed attestation has an empty x5c")
+	}
+	rawCert, ok := x5c[0].([]byte)
+	if !ok {
+		return errors.New("webauthn: invalid attestation certificate")
+	}
+	certificate, err := x509.ParseCertificate(rawCert)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(algorithm, certificate.PublicKey, signedData, signature); err != nil {
+		return err
+	}
+
+	// Attestation certificate requirements (WebAuthn section 8.2.1)
+	if certificate.Version != 3 {
+		return errors.New("webauthn: attestation certificate must be version 3")
+	}
+	if certificate.IsCA {
+		return errors.New("webauthn: attestation certificate must not be a CA")
+	}
+	if !containsString(certificate.Subject.OrganizationalUnit, "Authenticator Attestation") {
+		return errors.New("webauthn: attestation certificate has an invalid subject OU")
+	}
+	for _, extension := range certificate.Extensions {
+		if !extension.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if extension.Critical {
+			return errors.New("webauthn: AAGUID extension must not be critical")
+		}
+		var aaguid []byte
+		if _, err := asn1.Unmarshal(extension.Value, &aaguid); err != nil {
+			return err
+		}
+		if !bytes.Equal(aaguid, ao.AuthData.AAGUID) {
+			return errors.New("webauthn: attestation certificate AAGUID does not match")
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
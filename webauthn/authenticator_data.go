@@ -0,0 +1,89 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Authenticator data flags (WebAuthn Level 2, section 6.1)
+const (
+	FlagUserPresent            byte = 0x01
+	FlagUserVerified           byte = 0x04
+	FlagBackupEligible         byte = 0x08
+	FlagBackupState            byte = 0x10
+	FlagAttestedCredentialData byte = 0x40
+	FlagExtensionData          byte = 0x80
+)
+
+// AuthenticatorData is the parsed authenticatorData structure signed by the authenticator
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// The following fields are only present during registration
+	AAGUID              []byte
+	CredentialID        []byte
+	CredentialPublicKey []byte
+	// Raw is the exact byte sequence that was signed
+	Raw []byte
+}
+
+// ParseAuthenticatorData decodes authenticator data as returned by the browser
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data is too short")
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+		Raw:       data,
+	}
+
+	rest := data[37:]
+	if authData.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data is too short")
+		}
+		authData.AAGUID = rest[:16]
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, errors.New("webauthn: invalid credential ID length")
+		}
+		authData.CredentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		// The COSE key is followed by optional extensions, so decode it to learn its length
+		_, afterKey, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		authData.CredentialPublicKey = rest[:len(rest)-len(afterKey)]
+		rest = afterKey
+	}
+
+	if authData.Flags&FlagExtensionData != 0 {
+		_, afterExtensions, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = afterExtensions
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+	return authData, nil
+}
+
+// UserPresent reports whether the user touched the authenticator
+func (ad *AuthenticatorData) UserPresent() bool {
+	return ad.Flags&FlagUserPresent != 0
+}
+
+// UserVerified reports whether the authenticator verified the user (PIN, biometrics)
+func (ad *AuthenticatorData) UserVerified() bool {
+	return ad.Flags&FlagUserVerified != 0
+}
@@ -0,0 +1,232 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// softAuthenticator is a software ES256 authenticator producing the same structures as a security
+// key, for exercising the relying party checks end to end
+
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	aaguid       []byte
+	credentialID []byte
+	signCount    uint32
+	// flags are set in the authenticator data; UP and UV by default
+	flags byte
+	// rpID is hashed into the authenticator data, which lets tests answer for another relying party
+	rpID string
+}
+
+func newSoftAuthenticator(t *testing.T, rpID string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		t:            t,
+		key:          key,
+		aaguid:       []byte("soft-authn-aguid"),
+		credentialID: credentialID,
+		flags:        FlagUserPresent | FlagUserVerified,
+		rpID:         rpID,
+	}
+}
+
+// coseKey encodes the credential public key as an EC2 COSE_Key
+func (a *softAuthenticator) coseKey() []byte {
+	size := (a.key.Curve.Params().BitSize + 7) / 8
+	return encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeEC2},
+		{coseKeyAlgorithm, AlgES256},
+		{coseKeyCurve, coseCurveP256},
+		{coseKeyX, a.key.PublicKey.X.FillBytes(make([]byte, size))},
+		{coseKeyY, a.key.PublicKey.Y.FillBytes(make([]byte, size))},
+	})
+}
+
+// authenticatorData builds authenticator data, with the attested credential for registrations
+func (a *softAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= FlagAttestedCredentialData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, a.aaguid...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) sign(key *ecdsa.PrivateKey, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return signature
+}
+
+// register answers navigator.credentials.create() with the given attestation statement format:
+// "none", "packed" (self attestation) or "packed-x5c" (basic attestation with a certificate)
+func (a *softAuthenticator) register(challenge, origin, format string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = clientData("webauthn.create", challenge, origin)
+	authData := a.authenticatorData(true)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	statement := cborMap{}
+	switch format {
+	case AttestationFormatPacked:
+		statement = cborMap{
+			{"alg", AlgES256},
+			{"sig", a.sign(a.key, signedData)},
+		}
+	case "packed-x5c":
+		format = AttestationFormatPacked
+		attestationKey, certificate := a.attestationCertificate()
+		statement = cborMap{
+			{"alg", AlgES256},
+			{"sig", a.sign(attestationKey, signedData)},
+			{"x5c", []interface{}{certificate}},
+		}
+	}
+
+	attestationObject = encodeCBOR(cborMap{
+		{"fmt", format},
+		{"attStmt", statement},
+		{"authData", authData},
+	})
+	return clientDataJSON, attestationObject
+}
+
+// attestationCertificate returns a batch attestation key and its certificate, meeting the packed
+// attestation certificate requirements
+func (a *softAuthenticator) attestationCertificate() (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	aaguid, err := asn1.Marshal(a.aaguid)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Soft Authenticators"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Soft Authenticator Batch 1",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidAAGUID, Value: aaguid}},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return key, certificate
+}
+
+// assert answers navigator.credentials.get(), advancing the signature counter first
+func (a *softAuthenticator) assert(challenge, origin string) (clientDataJSON, authData, signature []byte) {
+	a.signCount++
+	clientDataJSON = clientData("webauthn.get", challenge, origin)
+	authData = a.authenticatorData(false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signature = a.sign(a.key, append(append([]byte{}, authData...), clientDataHash[:]...))
+	return clientDataJSON, authData, signature
+}
+
+func clientData(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(ClientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return data
+}
+
+// cborMap is a CBOR map encoded in the order of its entries, as CTAP2 canonical encoding requires
+type cborMap []struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeCBOR encodes the subset of CBOR the WebAuthn structures use
+func encodeCBOR(value interface{}) []byte {
+	header := func(major byte, argument uint64) []byte {
+		switch {
+		case argument < 24:
+			return []byte{major<<5 | byte(argument)}
+		case argument <= 0xff:
+			return []byte{major<<5 | 24, byte(argument)}
+		case argument <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+		case argument <= 0xffffffff:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+		default:
+			return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+		}
+	}
+
+	switch v := value.(type) {
+	case int:
+		return encodeCBOR(int64(v))
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case uint64:
+		return header(0, v)
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case []interface{}:
+		encoded := header(4, uint64(len(v)))
+		for _, item := range v {
+			encoded = append(encoded, encodeCBOR(item)...)
+		}
+		return encoded
+	case cborMap:
+		encoded := header(5, uint64(len(v)))
+		for _, entry := range v {
+			encoded = append(encoded, encodeCBOR(entry.key)...)
+			encoded = append(encoded, encodeCBOR(entry.value)...)
+		}
+		return encoded
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic(fmt.Sprintf("encodeCBOR: unsupported type %T", value))
+}
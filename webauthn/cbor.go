@@ -0,0 +1,184 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds nesting so hostile input cannot exhaust the stack
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR data item in data and returns it together with
+// the remaining bytes. Only definite-length items are supported, which is all that
+// CTAP2 canonical encoding produces.
+//
+// Decoded values use these Go types: uint64 and int64 for integers, []byte, string,
+// []interface{}, map[interface{}]interface{} (integer keys are int64), bool, float64 and nil.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nesting too deep")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if major == 7 {
+		return decodeCBORSimple(data, info)
+	}
+
+	argument, rest, err := readCBORArgument(data[1:], info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		return argument, rest, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: negative integer overflow")
+		}
+		return -1 - int64(argument), rest, nil
+	case 2, 3:
+		if uint64(len(rest)) < argument {
+			return nil, nil, errCBORTruncated
+		}
+		value := rest[:argument]
+		if major == 3 {
+			return string(value), rest[argument:], nil
+		}
+		return append([]byte(nil), value...), rest[argument:], nil
+	case 4:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			item, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			key, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			value, rest, err = decodeCBORItem(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k := key.(type) {
+			case uint64:
+				if k > math.MaxInt64 {
+					return nil, nil, errors.New("cbor: map key overflow")
+				}
+				key = int64(k)
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn structures; return the tagged item
+		return decodeCBORItem(rest, depth+1)
+	}
+
+	return nil, nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
+
+func readCBORArgument(data []byte, info byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+	return 0, nil, errors.New("cbor: indefinite-length items are not supported")
+}
+
+func decodeCBORSimple(data []byte, info byte) (interface{}, []byte, error) {
+	rest := data[1:]
+	switch info {
+	case 20:
+		return false, rest, nil
+	case 21:
+		return true, rest, nil
+	case 22, 23:
+		return nil, rest, nil
+	case 25:
+		if len(rest) < 2 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(decodeHalfFloat(binary.BigEndian.Uint16(rest))), rest[2:], nil
+	case 26:
+		if len(rest) < 4 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(rest))), rest[4:], nil
+	case 27:
+		if len(rest) < 8 {
+			return nil, nil, errCBORTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(rest)), rest[8:], nil
+	}
+	return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+func decodeHalfFloat(bits uint16) float32 {
+	sign := uint32(bits>>15) << 31
+	exponent := uint32(bits>>10) & 0x1f
+	mantissa := uint32(bits) & 0x3ff
+
+	switch exponent {
+	case 0:
+		value := float32(mantissa) / 1024 * float32(math.Pow(2, -14))
+		if sign != 0 {
+			return -value
+		}
+		return value
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	}
+	return math.Float32frombits(sign | (exponent+112)<<23 | mantissa<<13)
+}
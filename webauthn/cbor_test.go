@@ -0,0 +1,98 @@
+package webauthn
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	encoded := encodeCBOR(cborMap{
+		{1, 2},
+		{-1, int64(-300)},
+		{"bytes", []byte{0xde, 0xad}},
+		{"text", "value"},
+		{"list", []interface{}{uint64(1 << 40), true, nil}},
+	})
+	trailing := []byte{0x01}
+
+	decoded, rest, err := decodeCBOR(append(encoded, trailing...))
+	if err != nil {
+		t.Fatalf("decodeCBOR: %v", err)
+	}
+	want := map[interface{}]interface{}{
+		int64(1):  uint64(2),
+		int64(-1): int64(-300),
+		"bytes":   []byte{0xde, 0xad},
+		"text":    "value",
+		"list":    []interface{}{uint64(1 << 40), true, nil},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded = %#v, want %#v", decoded, want)
+	}
+	if !bytes.Equal(rest, trailing) {
+		t.Errorf("rest = %x, want %x", rest, trailing)
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	deep := []byte{}
+	for i := 0; i <= maxCBORDepth+1; i++ {
+		deep = append(deep, 0x81)
+	}
+	deep = append(deep, 0x00)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated byte string", []byte{0x44, 0x01, 0x02}},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"array longer than input", []byte{0x9a, 0xff, 0xff, 0xff, 0xff}},
+		{"map longer than input", []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"unsupported map key", []byte{0xa1, 0x80, 0x00}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"nested too deep", deep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); err == nil {
+				t.Fatalf("decodeCBOR(%x) succeeded", tt.data)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeyRejectsMismatchedAlgorithm(t *testing.T) {
+	authenticator := newSoftAuthenticator(t, testRPID)
+	decoded, _, err := decodeCBOR(authenticator.coseKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := decoded.(map[interface{}]interface{})
+
+	// An EC2 key claiming RS256 must not be accepted
+	key := encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeEC2},
+		{coseKeyAlgorithm, AlgRS256},
+		{coseKeyCurve, coseCurveP256},
+		{coseKeyX, fields[coseKeyX]},
+		{coseKeyY, fields[coseKeyY]},
+	})
+	if _, err := ParsePublicKey(key); err == nil {
+		t.Fatal("ParsePublicKey accepted an EC2 key with algorithm RS256")
+	}
+
+	// A point that is not on the curve
+	key = encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeEC2},
+		{coseKeyAlgorithm, AlgES256},
+		{coseKeyCurve, coseCurveP256},
+		{coseKeyX, make([]byte, 32)},
+		{coseKeyY, make([]byte, 32)},
+	})
+	if _, err := ParsePublicKey(key); err == nil {
+		t.Fatal("ParsePublicKey accepted a point that is not on the curve")
+	}
+}
@@ -0,0 +1,155 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (https://www.iana.org/assignments/cose)
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// SupportedAlgorithms lists the algorithms offered in pubKeyCredParams, in order of preference
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters
+const (
+	coseKeyType      int64 = 1
+	coseKeyAlgorithm int64 = 3
+	coseKeyCurve     int64 = -1
+	coseKeyX         int64 = -2
+	coseKeyY         int64 = -3
+	coseKeyRSAN      int64 = -1
+	coseKeyRSAE      int64 = -2
+
+	coseKeyTypeOKP int64 = 1
+	coseKeyTypeEC2 int64 = 2
+	coseKeyTypeRSA int64 = 3
+
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+)
+
+// PublicKey is a credential public key parsed from its COSE_Key encoding
+type PublicKey struct {
+	Algorithm int64
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key as stored with a credential
+func ParsePublicKey(coseKey []byte) (*PublicKey, error) {
+	decoded, _, err := decodeCBOR(coseKey)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("cose: key is not a map")
+	}
+
+	keyType, ok := cborInt(fields[coseKeyType])
+	if !ok {
+		return nil, errors.New("cose: missing key type")
+	}
+	algorithm, ok := cborInt(fields[coseKeyAlgorithm])
+	if !ok {
+		return nil, errors.New("cose: missing algorithm")
+	}
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgES256:
+		curve, _ := cborInt(fields[coseKeyCurve])
+		x, xOK := fields[coseKeyX].([]byte)
+		y, yOK := fields[coseKeyY].([]byte)
+		if curve != coseCurveP256 || !xOK || !yOK || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("cose: invalid P-256 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("cose: point is not on curve")
+		}
+		return &PublicKey{Algorithm: algorithm, Key: key}, nil
+
+	case keyType == coseKeyTypeOKP && algorithm == AlgEdDSA:
+		curve, _ := cborInt(fields[coseKeyCurve])
+		x, xOK := fields[coseKeyX].([]byte)
+		if curve != coseCurveEd25519 || !xOK || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("cose: invalid Ed25519 key")
+		}
+		return &PublicKey{Algorithm: algorithm, Key: ed25519.PublicKey(x)}, nil
+
+	case keyType == coseKeyTypeRSA && algorithm == AlgRS256:
+		n, nOK := fields[coseKeyRSAN].([]byte)
+		e, eOK := fields[coseKeyRSAE].([]byte)
+		if !nOK || !eOK || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("cose: invalid RSA key")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return &PublicKey{Algorithm: algorithm, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+
+	return nil, fmt.Errorf("cose: unsupported key type %d with algorithm %d", keyType, algorithm)
+}
+
+// Verify checks signature over data using the key's algorithm
+func (pk *PublicKey) Verify(data, signature []byte) error {
+	return verifySignature(pk.Algorithm, pk.Key, data, signature)
+}
+
+func verifySignature(algorithm int64, key crypto.PublicKey, data, signature []byte) error {
+	switch algorithm {
+	case AlgES256:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("webauthn: key does not match ES256")
+		}
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(ecKey, digest[:], signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgEdDSA:
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("webauthn: key does not match EdDSA")
+		}
+		if !ed25519.Verify(edKey, data, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgRS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("webauthn: key does not match RS256")
+		}
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("webauthn: unsupported algorithm %d", algorithm)
+}
+
+func cborInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		if v > 1<<62 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
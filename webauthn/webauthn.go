@@ -0,0 +1,286 @@
+// Package webauthn implements the relying party side of the WebAuthn registration
+// and authentication ceremonies for "none" and "packed" attestation.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// User verification requirements
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+var (
+	ErrInvalidSignature       = errors.New("webauthn: invalid signature")
+	ErrChallengeMismatch      = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch         = errors.New("webauthn: origin not allowed")
+	ErrRPIDMismatch           = errors.New("webauthn: relying party ID hash mismatch")
+	ErrUserNotPresent         = errors.New("webauthn: user presence flag not set")
+	ErrUserNotVerified        = errors.New("webauthn: user verification required")
+	ErrSignCountRegression    = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+	ErrUnsupportedAttestation = errors.New("webauthn: unsupported attestation format")
+)
+
+// Base64 is the unpadded base64url encoding WebAuthn uses for binary values in JSON
+var Base64 = base64.RawURLEncoding
+
+// RelyingParty holds the settings that bind credentials to this service
+type RelyingParty struct {
+	// ID is the effective domain credentials are scoped to, e.g. "example.com"
+	ID   string
+	Name string
+	// Origins lists the allowed origins, e.g. "https://app.example.com"
+	Origins          []string
+	UserVerification string
+	Timeout          time.Duration
+}
+
+// UserEntity describes the account a credential is created for
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is the publicKey argument for navigator.credentials.create().
+// Binary values are base64url encoded and must be decoded by the client.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the publicKey argument for navigator.credentials.get()
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// ClientData is the parsed clientDataJSON collected by the browser
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Credential is a newly registered credential to be stored for the user
+type Credential struct {
+	ID                []byte
+	PublicKey         []byte
+	SignCount         uint32
+	AAGUID            []byte
+	AttestationFormat string
+	UserVerified      bool
+	BackupEligible    bool
+}
+
+// AssertionResult is the outcome of a successful authentication ceremony
+type AssertionResult struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// NewChallenge returns a fresh base64url encoded 32-byte challenge
+func NewChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return Base64.EncodeToString(challenge), nil
+}
+
+// ParseClientData decodes clientDataJSON, e.g. to look up the challenge it answers
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	var clientData ClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, fmt.Errorf("webauthn: invalid client data: %w", err)
+	}
+	return &clientData, nil
+}
+
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, excludeCredentialIDs [][]byte) *CreationOptions {
+	params := make([]CredentialParameter, len(SupportedAlgorithms))
+	for i, algorithm := range SupportedAlgorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: algorithm}
+	}
+
+	return &CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            rp.Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(excludeCredentialIDs),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: rp.UserVerification,
+		},
+		Attestation: "direct",
+	}
+}
+
+// RequestOptions builds assertion options. An empty allow list lets the authenticator
+// offer any discoverable credential for this relying party (passwordless login).
+func (rp *RelyingParty) RequestOptions(challenge string, allowCredentialIDs [][]byte, userVerification string) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allowCredentialIDs),
+		UserVerification: userVerification,
+	}
+}
+
+// VerifyRegistration runs the registration ceremony checks (WebAuthn section 7.1)
+func (rp *RelyingParty) VerifyRegistration(expectedChallenge string, clientDataJSON, attestationObjectData []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", expectedChallenge); err != nil {
+		return nil, err
+	}
+
+	attestation, err := parseAttestationObject(attestationObjectData)
+	if err != nil {
+		return nil, err
+	}
+	authData := attestation.AuthData
+	if err := rp.verifyAuthenticatorData(authData, rp.UserVerification == UserVerificationRequired); err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, errors.New("webauthn: attested credential data missing")
+	}
+
+	credentialKey, err := ParsePublicKey(authData.CredentialPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := attestation.verify(sha256Sum(clientDataJSON), credentialKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:                append([]byte(nil), authData.CredentialID...),
+		PublicKey:         append([]byte(nil), authData.CredentialPublicKey...),
+		SignCount:         authData.SignCount,
+		AAGUID:            append([]byte(nil), authData.AAGUID...),
+		AttestationFormat: attestation.Format,
+		UserVerified:      authData.UserVerified(),
+		BackupEligible:    authData.Flags&FlagBackupEligible != 0,
+	}, nil
+}
+
+// VerifyAssertion runs the authentication ceremony checks (WebAuthn section 7.2) against a stored credential
+func (rp *RelyingParty) VerifyAssertion(
+	expectedChallenge string,
+	publicKey []byte,
+	storedSignCount uint32,
+	clientDataJSON, authenticatorData, signature []byte,
+	requireUserVerification bool,
+) (*AssertionResult, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", expectedChallenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := ParseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUserVerification); err != nil {
+		return nil, err
+	}
+
+	credentialKey, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	signedData := append(append([]byte{}, authenticatorData...), sha256Sum(clientDataJSON)...)
+	if err := credentialKey.Verify(signedData, signature); err != nil {
+		return nil, err
+	}
+
+	// Authenticators that do not implement counters always report zero
+	if (authData.SignCount != 0 || storedSignCount != 0) && authData.SignCount <= storedSignCount {
+		return nil, ErrSignCountRegression
+	}
+
+	return &AssertionResult{
+		SignCount:    authData.SignCount,
+		UserVerified: authData.UserVerified(),
+	}, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, expectedType, expectedChallenge string) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != expectedType {
+		return fmt.Errorf("webauthn: unexpected client data type %q", clientData.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(expectedChallenge)) != 1 {
+		return ErrChallengeMismatch
+	}
+	if !containsString(rp.Origins, clientData.Origin) {
+		return ErrOriginMismatch
+	}
+	return nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *AuthenticatorData, requireUserVerification bool) error {
+	if !bytes.Equal(authData.RPIDHash, sha256Sum([]byte(rp.ID))) {
+		return ErrRPIDMismatch
+	}
+	if !authData.UserPresent() {
+		return ErrUserNotPresent
+	}
+	if requireUserVerification && !authData.UserVerified() {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+func descriptors(credentialIDs [][]byte) []CredentialDescriptor {
+	result := make([]CredentialDescriptor, len(credentialIDs))
+	for i, id := range credentialIDs {
+		result[i] = CredentialDescriptor{Type: "public-key", ID: Base64.EncodeToString(id)}
+	}
+	return result
+}
@@ -0,0 +1,337 @@
+package webauthn
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://app.example.com"
+)
+
+func testRelyingParty(userVerification string) *RelyingParty {
+	return &RelyingParty{
+		ID:               testRPID,
+		Name:             "Example",
+		Origins:          []string{testOrigin},
+		UserVerification: userVerification,
+	}
+}
+
+func newTestChallenge(t *testing.T) string {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+// registered returns an authenticator with a credential registered at the test relying party
+func registered(t *testing.T, rp *RelyingParty) (*softAuthenticator, *Credential) {
+	t.Helper()
+	authenticator := newSoftAuthenticator(t, testRPID)
+	challenge := newTestChallenge(t)
+	clientDataJSON, attestationObject := authenticator.register(challenge, testOrigin, AttestationFormatNone)
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return authenticator, credential
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	for _, format := range []string{AttestationFormatNone, AttestationFormatPacked, "packed-x5c"} {
+		t.Run(format, func(t *testing.T) {
+			rp := testRelyingParty(UserVerificationPreferred)
+			authenticator := newSoftAuthenticator(t, testRPID)
+			challenge := newTestChallenge(t)
+
+			clientDataJSON, attestationObject := authenticator.register(challenge, testOrigin, format)
+			credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if err != nil {
+				t.Fatalf("VerifyRegistration: %v", err)
+			}
+
+			if !bytes.Equal(credential.ID, authenticator.credentialID) {
+				t.Errorf("credential ID = %x, want %x", credential.ID, authenticator.credentialID)
+			}
+			if !bytes.Equal(credential.AAGUID, authenticator.aaguid) {
+				t.Errorf("AAGUID = %x, want %x", credential.AAGUID, authenticator.aaguid)
+			}
+			if !credential.UserVerified {
+				t.Error("UserVerified = false, want true")
+			}
+			wantFormat := format
+			if format == "packed-x5c" {
+				wantFormat = AttestationFormatPacked
+			}
+			if credential.AttestationFormat != wantFormat {
+				t.Errorf("AttestationFormat = %q, want %q", credential.AttestationFormat, wantFormat)
+			}
+
+			key, err := ParsePublicKey(credential.PublicKey)
+			if err != nil {
+				t.Fatalf("ParsePublicKey: %v", err)
+			}
+			if key.Algorithm != AlgES256 || !authenticator.key.PublicKey.Equal(key.Key) {
+				t.Errorf("stored public key does not match the authenticator key")
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	challenge := "expected-challenge"
+
+	tests := []struct {
+		name string
+		// prepare adjusts the authenticator and returns its answer
+		prepare func(a *softAuthenticator) ([]byte, []byte)
+		rp      *RelyingParty
+		wantErr error
+	}{
+		{
+			name: "challenge mismatch",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				return a.register("other-challenge", testOrigin, AttestationFormatNone)
+			},
+			wantErr: ErrChallengeMismatch,
+		},
+		{
+			name: "origin mismatch",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				return a.register(challenge, "https://evil.example.net", AttestationFormatNone)
+			},
+			wantErr: ErrOriginMismatch,
+		},
+		{
+			name: "rpIdHash mismatch",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				a.rpID = "evil.example.net"
+				return a.register(challenge, testOrigin, AttestationFormatNone)
+			},
+			wantErr: ErrRPIDMismatch,
+		},
+		{
+			name: "user not present",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				a.flags = FlagUserVerified
+				return a.register(challenge, testOrigin, AttestationFormatNone)
+			},
+			wantErr: ErrUserNotPresent,
+		},
+		{
+			name: "user verification required",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				a.flags = FlagUserPresent
+				return a.register(challenge, testOrigin, AttestationFormatNone)
+			},
+			rp:      testRelyingParty(UserVerificationRequired),
+			wantErr: ErrUserNotVerified,
+		},
+		{
+			name: "packed signature over other data",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				_, attestationObject := a.register(challenge, testOrigin, AttestationFormatPacked)
+				// The signature covers the hash of different client data
+				return clientData("webauthn.create", challenge, testOrigin+"/"), attestationObject
+			},
+			rp:      &RelyingParty{ID: testRPID, Origins: []string{testOrigin, testOrigin + "/"}},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "unsupported attestation",
+			prepare: func(a *softAuthenticator) ([]byte, []byte) {
+				return a.register(challenge, testOrigin, "fido-u2f")
+			},
+			wantErr: ErrUnsupportedAttestation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := tt.rp
+			if rp == nil {
+				rp = testRelyingParty(UserVerificationPreferred)
+			}
+			clientDataJSON, attestationObject := tt.prepare(newSoftAuthenticator(t, testRPID))
+
+			_, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyRegistration error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejectsNoneWithStatement(t *testing.T) {
+	rp := testRelyingParty(UserVerificationPreferred)
+	authenticator := newSoftAuthenticator(t, testRPID)
+	challenge := newTestChallenge(t)
+	clientDataJSON, _ := authenticator.register(challenge, testOrigin, AttestationFormatNone)
+	attestationObject := encodeCBOR(cborMap{
+		{"fmt", AttestationFormatNone},
+		{"attStmt", cborMap{{"sig", []byte{1}}}},
+		{"authData", authenticator.authenticatorData(true)},
+	})
+
+	if _, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject); err == nil {
+		t.Fatal("VerifyRegistration accepted none attestation with a statement")
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	rp := testRelyingParty(UserVerificationPreferred)
+	authenticator, credential := registered(t, rp)
+
+	storedSignCount := credential.SignCount
+	for i := 0; i < 3; i++ {
+		challenge := newTestChallenge(t)
+		clientDataJSON, authData, signature := authenticator.assert(challenge, testOrigin)
+		result, err := rp.VerifyAssertion(challenge, credential.PublicKey, storedSignCount, clientDataJSON, authData, signature, true)
+		if err != nil {
+			t.Fatalf("VerifyAssertion %d: %v", i, err)
+		}
+		if result.SignCount != authenticator.signCount || !result.UserVerified {
+			t.Fatalf("result = %+v, want sign count %d and user verified", result, authenticator.signCount)
+		}
+		storedSignCount = result.SignCount
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte)
+		// requireUserVerification is passed to VerifyAssertion
+		requireUserVerification bool
+		wantErr                 error
+	}{
+		{
+			name: "challenge mismatch",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				return a.assert(challenge+"x", testOrigin)
+			},
+			wantErr: ErrChallengeMismatch,
+		},
+		{
+			name: "origin mismatch",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				return a.assert(challenge, "https://app.example.com.evil.net")
+			},
+			wantErr: ErrOriginMismatch,
+		},
+		{
+			name: "rpIdHash mismatch",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				a.rpID = "evil.example.net"
+				return a.assert(challenge, testOrigin)
+			},
+			wantErr: ErrRPIDMismatch,
+		},
+		{
+			name: "user not present",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				a.flags = FlagUserVerified
+				return a.assert(challenge, testOrigin)
+			},
+			wantErr: ErrUserNotPresent,
+		},
+		{
+			name: "user not verified",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				a.flags = FlagUserPresent
+				return a.assert(challenge, testOrigin)
+			},
+			requireUserVerification: true,
+			wantErr:                 ErrUserNotVerified,
+		},
+		{
+			name: "tampered authenticator data",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				a.flags = FlagUserPresent
+				clientDataJSON, authData, signature := a.assert(challenge, testOrigin)
+				// Claim user verification the signature does not cover
+				authData[32] |= FlagUserVerified
+				return clientDataJSON, authData, signature
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "other credential",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				other := newSoftAuthenticator(a.t, testRPID)
+				other.signCount = a.signCount
+				return other.assert(challenge, testOrigin)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "sign count not increased",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				a.signCount--
+				return a.assert(challenge, testOrigin)
+			},
+			wantErr: ErrSignCountRegression,
+		},
+		{
+			name: "sign count decreased",
+			answer: func(a *softAuthenticator, challenge string) ([]byte, []byte, []byte) {
+				a.signCount = 2
+				return a.assert(challenge, testOrigin)
+			},
+			wantErr: ErrSignCountRegression,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := testRelyingParty(UserVerificationPreferred)
+			authenticator, credential := registered(t, rp)
+			// The credential has been used before, so the stored counter is ahead of registration
+			authenticator.signCount = 10
+			const storedSignCount = 10
+
+			challenge := newTestChallenge(t)
+			clientDataJSON, authData, signature := tt.answer(authenticator, challenge)
+			_, err := rp.VerifyAssertion(challenge, credential.PublicKey, storedSignCount, clientDataJSON, authData, signature, tt.requireUserVerification)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyAssertion error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionWithoutCounter(t *testing.T) {
+	rp := testRelyingParty(UserVerificationPreferred)
+	authenticator, credential := registered(t, rp)
+
+	// Authenticators without a counter always report zero, which is not a regression
+	for i := 0; i < 2; i++ {
+		challenge := newTestChallenge(t)
+		authenticator.signCount = 0
+		clientDataJSON, authData, signature := authenticator.assert(challenge, testOrigin)
+		authData[33], authData[34], authData[35], authData[36] = 0, 0, 0, 0
+		// Re-sign the zeroed counter
+		signature = authenticator.sign(authenticator.key, append(append([]byte{}, authData...), sha256Sum(clientDataJSON)...))
+
+		if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 0, clientDataJSON, authData, signature, false); err != nil {
+			t.Fatalf("VerifyAssertion %d: %v", i, err)
+		}
+	}
+}
+
+func TestVerifyAssertionRejectsRegistrationClientData(t *testing.T) {
+	rp := testRelyingParty(UserVerificationPreferred)
+	authenticator, credential := registered(t, rp)
+
+	challenge := newTestChallenge(t)
+	_, authData, _ := authenticator.assert(challenge, testOrigin)
+	clientDataJSON := clientData("webauthn.create", challenge, testOrigin)
+	signature := authenticator.sign(authenticator.key, append(append([]byte{}, authData...), sha256Sum(clientDataJSON)...))
+
+	if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 0, clientDataJSON, authData, signature, false); err == nil {
+		t.Fatal("VerifyAssertion accepted client data of a registration")
+	}
+}